@@ -0,0 +1,301 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Access control helpers
+ * Roles are carried by the invoker's enrollment certificate in the "role" attribute,
+ * as a comma separated list (e.g. "planner,registrar"), granted by an admin to
+ * every member of an MSP (e.g. all identities of the court organisation), or
+ * assigned by an admin to the identity on the ledger
+ */
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Certificate attribute holding the roles of the invoker
+const roleAttribute = "role"
+
+// Roles known by the Smart Contract
+const (
+	roleAdmin        = "admin"
+	rolePlanner      = "planner"
+	roleCourt        = "court"
+	roleRegistrar    = "registrar"
+	roleNotary       = "notary"
+	roleCompliance   = "compliance"
+	roleArbitrator   = "arbitrator"
+	roleUtility      = "utility"
+	roleCustodian    = "custodian"
+	roleTaxAuthority = "taxAuthority"
+	roleGrantor      = "grantor"
+	roleInspector    = "inspector"
+	roleInsurer      = "insurer"
+	roleSubscriber   = "subscriber"
+)
+
+const mspRolesObjectType = "mspRoles"
+
+// getInvokerID returns the enrollment ID (certificate common name) of the identity submitting the transaction,
+// or the owner name its certificate was rebound to. This is the name recorded as owner of a house
+func getInvokerID(APIstub shim.ChaincodeStubInterface) (string, error) {
+	cert, err := getInvokerCertificate(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return boundIdentity(APIstub, cert)
+}
+
+func getInvokerCertificate(APIstub shim.ChaincodeStubInterface) (*x509.Certificate, error) {
+	cert, err := cid.GetX509Certificate(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("No certificate found for the invoker")
+	}
+	return cert, nil
+}
+
+// getInvokerUniqueID returns an ID of the invoker unique across certificate authorities (subject and issuer)
+func getInvokerUniqueID(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetID(APIstub)
+}
+
+// getInvokerMSP returns the MSP ID of the identity submitting the transaction
+func getInvokerMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(APIstub)
+}
+
+// getInvokerRoles returns the roles granted to the identity submitting the transaction
+func getInvokerRoles(APIstub shim.ChaincodeStubInterface) ([]string, error) {
+	value, found, err := cid.GetAttributeValue(APIstub, roleAttribute)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	if found {
+		roles = splitList(value)
+	}
+
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	mspRoles, err := getMSPRoles(APIstub, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	assignedRoles, err := getAssignedRoles(APIstub, identity)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(roles, mspRoles...), assignedRoles...), nil
+}
+
+// splitList splits a comma separated argument, dropping blank entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getMSPRoles returns the roles granted to every member of the given MSP
+func getMSPRoles(APIstub shim.ChaincodeStubInterface, mspID string) ([]string, error) {
+	mspRolesKey, err := APIstub.CreateCompositeKey(mspRolesObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	rolesAsBytes, err := APIstub.GetState(mspRolesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	if rolesAsBytes == nil {
+		return roles, nil
+	}
+	err = json.Unmarshal(rolesAsBytes, &roles)
+	return roles, err
+}
+
+// requireRole returns an error unless the invoker holds at least one of the given roles
+func requireRole(APIstub shim.ChaincodeStubInterface, roles ...string) error {
+	invokerRoles, err := getInvokerRoles(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, invokerRole := range invokerRoles {
+		for _, role := range roles {
+			if invokerRole == role {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Access denied. Requires one of the roles: %s", strings.Join(roles, ", "))
+}
+
+// requireOwner returns an error unless the invoker is the owner of the house, a signatory of the legal entity
+// owning it, or the attorney of the owner for the function invoked
+func requireOwner(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invokerID == house.Owner {
+		return nil
+	}
+	// The signatories of a legal entity act as the entity
+	if isLegalEntity(house.Owner) {
+		signatory, err := isEntitySignatory(APIstub, house.Owner, invokerID)
+		if err != nil || signatory {
+			return err
+		}
+	}
+	attorney, err := actsAsAttorney(APIstub, key, house.Owner, invokerID)
+	if err != nil {
+		return err
+	}
+	if !attorney {
+		return fmt.Errorf("Access denied. Only the owner of house %s can do this", key)
+	}
+	return nil
+}
+
+/*
+ * setMSPRoles grants roles to every member of an MSP, replacing the previous grant
+ * args: MSP ID, roles as a comma separated list (empty to revoke all)
+ */
+func (s *SmartContract) setMSPRoles(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mspRolesKey, err := APIstub.CreateCompositeKey(mspRolesObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	roles := splitList(args[1])
+	if len(roles) == 0 {
+		err = APIstub.DelState(mspRolesKey)
+	} else {
+		rolesAsBytes, _ := json.Marshal(roles)
+		err = APIstub.PutState(mspRolesKey, rolesAsBytes)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryMSPRoles(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	roles, err := getMSPRoles(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rolesAsBytes, _ := json.Marshal(roles)
+	return shim.Success(rolesAsBytes)
+}
+
+// Define the identity structure, the invoker as seen by the contract
+type Identity struct {
+	MSPID            string            `json:"mspid"`
+	ID               string            `json:"id"`
+	EnrollmentID     string            `json:"enrollmentid"`
+	OwnerID          string            `json:"ownerid"`
+	Attributes       map[string]string `json:"attributes"`
+	CertificateRoles []string          `json:"certificateroles"`
+	MSPRoles         []string          `json:"msproles"`
+	AssignedRoles    []string          `json:"assignedroles"`
+	Roles            []string          `json:"roles"`
+}
+
+// whoAmI returns the identity of the invoker with its certificate attributes and the roles the contract resolves for it
+func (s *SmartContract) whoAmI(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	cert, err := getInvokerCertificate(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	identity := Identity{EnrollmentID: cert.Subject.CommonName, Attributes: map[string]string{}, CertificateRoles: []string{}}
+	if identity.MSPID, err = getInvokerMSP(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if identity.ID, err = getInvokerUniqueID(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	// The owner ID differs from the enrollment ID once the certificate was rebound
+	if identity.OwnerID, err = getInvokerID(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attributes, err := attrmgr.New().GetAttributesFromCert(cert)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if attributes != nil {
+		identity.Attributes = attributes.Attrs
+	}
+	if value, found := identity.Attributes[roleAttribute]; found {
+		identity.CertificateRoles = splitList(value)
+	}
+	if identity.MSPRoles, err = getMSPRoles(APIstub, identity.MSPID); err != nil {
+		return shim.Error(err.Error())
+	}
+	if identity.AssignedRoles, err = getAssignedRoles(APIstub, identity.ID); err != nil {
+		return shim.Error(err.Error())
+	}
+	if identity.Roles, err = getInvokerRoles(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	identityAsBytes, _ := json.Marshal(identity)
+	return shim.Success(identityAsBytes)
+}
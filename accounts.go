@@ -0,0 +1,414 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Accounts
+ * Every identity has an on-ledger account whose balance is credited and debited by the functions
+ * settling money, each movement being journaled as an entry of the account. The later postings of a
+ * transaction build on the balances its earlier postings wrote, read back from its write cache.
+ * Money enters and leaves the ledger through the custodian, which records the deposits it received
+ * and pays the withdrawals out of the ledger.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	accountObjectType      = "account"
+	accountEntryObjectType = "accountEntry"
+)
+
+// Kinds of account entries
+const (
+	entryRentIncome = "rentIncome"
+	entryDeposit    = "deposit"
+	entryWithdrawal = "withdrawal"
+	entryTransfer   = "transfer"
+	entrySale       = "sale"
+	entryPurchase   = "purchase"
+	entryFee        = "fee"
+)
+
+// Define the account structure, the balance of an identity
+type Account struct {
+	ID      string `json:"id"`
+	Balance int64  `json:"balance"`
+}
+
+// Define the account entry structure, one credit (positive amount) or debit of an account
+type AccountEntry struct {
+	Account   string `json:"account"`
+	Amount    int64  `json:"amount"`
+	Kind      string `json:"kind"`
+	Reference string `json:"reference"`
+	Operation string `json:"operation,omitempty"`
+	Period    string `json:"period"`
+	PostedAt  string `json:"postedat"`
+	TxID      string `json:"txid"`
+	Sequence  int    `json:"sequence"`
+}
+
+func getAccount(APIstub shim.ChaincodeStubInterface, id string) (Account, error) {
+	accountKey, err := APIstub.CreateCompositeKey(accountObjectType, []string{id})
+	if err != nil {
+		return Account{}, err
+	}
+	accountAsBytes, err := APIstub.GetState(accountKey)
+	if err != nil || accountAsBytes == nil {
+		return Account{ID: id}, err
+	}
+	account := Account{}
+	err = json.Unmarshal(accountAsBytes, &account)
+	return account, err
+}
+
+// postEntries journals the entries and updates the balances of their accounts, failing when a
+// balance would become negative. Entries are stamped with the transaction and their position in it,
+// and with the month of the transaction unless they are for another period
+func postEntries(APIstub shim.ChaincodeStubInterface, entries []AccountEntry) error {
+	postedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+
+	// Balances are all checked before anything is written, a failed posting leaves the accounts untouched
+	accounts, totals := []string{}, map[string]int64{}
+	for _, entry := range entries {
+		if _, found := totals[entry.Account]; !found {
+			accounts = append(accounts, entry.Account)
+		}
+		totals[entry.Account] += entry.Amount
+	}
+	balances := map[string]int64{}
+	for _, id := range accounts {
+		account, err := getAccount(APIstub, id)
+		if err != nil {
+			return err
+		}
+		balances[id] = account.Balance + totals[id]
+		if balances[id] < 0 {
+			return fmt.Errorf("Insufficient balance on the account of %s: %d missing", id, -balances[id])
+		}
+	}
+
+	// Entries are numbered from the last one the transaction posted
+	sequence := 0
+	if transaction := transactionOf(APIstub); transaction != nil {
+		sequence = transaction.postedEntries
+		transaction.postedEntries += len(entries)
+	}
+
+	for i := range entries {
+		entries[i].PostedAt = postedAt.Format(timeLayout)
+		if entries[i].Period == "" {
+			entries[i].Period = postedAt.Format(periodLayout)
+		}
+		entries[i].TxID = APIstub.GetTxID()
+		entries[i].Sequence = sequence + i
+
+		entryKey, err := APIstub.CreateCompositeKey(accountEntryObjectType, []string{entries[i].Account, entries[i].TxID, strconv.Itoa(entries[i].Sequence)})
+		if err != nil {
+			return err
+		}
+		entryAsBytes, _ := json.Marshal(entries[i])
+		if err := APIstub.PutState(entryKey, entryAsBytes); err != nil {
+			return err
+		}
+	}
+	for _, id := range accounts {
+		accountKey, err := APIstub.CreateCompositeKey(accountObjectType, []string{id})
+		if err != nil {
+			return err
+		}
+		accountAsBytes, _ := json.Marshal(Account{ID: id, Balance: balances[id]})
+		if err := APIstub.PutState(accountKey, accountAsBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireAccountAccess returns an error unless the invoker is the holder of the account, an admin or the custodian
+func requireAccountAccess(APIstub shim.ChaincodeStubInterface, id string) error {
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invokerID == id {
+		return nil
+	}
+	if err := requireRole(APIstub, roleAdmin, roleCustodian); err != nil {
+		return fmt.Errorf("Access denied. Only the holder can read the account of %s", id)
+	}
+	return nil
+}
+
+func parseAmount(value string) (int64, error) {
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("Amount must be a positive number")
+	}
+	return amount, nil
+}
+
+// settleSale debits the price from the buyer and credits it to the holders of the house, in proportion of their shares
+func settleSale(APIstub shim.ChaincodeStubInterface, key string, house House, buyer string, price int64) error {
+	entries := []AccountEntry{{Account: buyer, Amount: -price, Kind: entryPurchase, Reference: key}}
+	shares := houseShares(house)
+	for i, part := range allocateProRata(shares, price) {
+		entries = append(entries, AccountEntry{Account: shares[i].Owner, Amount: part, Kind: entrySale, Reference: key})
+	}
+	return postEntries(APIstub, entries)
+}
+
+// getBalance returns the account of an identity, for the holder, admins and the custodian. args: identity
+func (s *SmartContract) getBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireAccountAccess(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	account, err := getAccount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accountAsBytes, _ := json.Marshal(account)
+	return shim.Success(accountAsBytes)
+}
+
+/*
+ * depositBalance credits the account of an identity with money received off the ledger, for the custodian
+ * args: identity, amount, reference of the payment
+ */
+func (s *SmartContract) depositBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleCustodian); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkMoneyAccount(args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := postEntries(APIstub, []AccountEntry{{Account: args[0], Amount: amount, Kind: entryDeposit, Reference: args[2]}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * withdrawBalance debits the account of the invoker with an amount the custodian pays out of the ledger
+ * args: amount, reference of the payout (bank account)
+ */
+func (s *SmartContract) withdrawBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := parseAmount(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var entry = AccountEntry{Account: invokerID, Amount: -amount, Kind: entryWithdrawal, Reference: args[1]}
+	if err := postEntries(APIstub, []AccountEntry{entry}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entryAsBytes, _ := json.Marshal(entry)
+	emitEvent(APIstub, "balanceWithdrawn", entryAsBytes)
+	return shim.Success(nil)
+}
+
+/*
+ * transferBalance moves an amount from the account of the invoker to the account of another identity
+ * args: recipient, amount, memo
+ */
+func (s *SmartContract) transferBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == invokerID {
+		return shim.Error("Recipient must not be the invoker")
+	}
+	if err := checkMoneyAccount(args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entries := []AccountEntry{
+		{Account: invokerID, Amount: -amount, Kind: entryTransfer, Reference: args[0] + ": " + args[2]},
+		{Account: args[0], Amount: amount, Kind: entryTransfer, Reference: invokerID + ": " + args[2]},
+	}
+	if err := postEntries(APIstub, entries); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * buyHouse buys a listed house at its asking price, settled on the accounts of the buyer and the holders.
+ * A purchase subject to pre-emption rights returns the pre-emption notice, the price being settled once the house is bought
+ * args: house key
+ */
+func (s *SmartContract) buyHouse(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.AskingPrice == 0 {
+		return shim.Error("House " + args[0] + " is not listed for sale")
+	}
+	// The price is settled as the house changes hands, a purchase cannot wait for co-signatures or its tax
+	if err := checkTransferPath(APIstub, args[0], house, reasonSale, house.AskingPrice); err != nil {
+		return shim.Error(err.Error())
+	}
+	if holder := multisigHolder(house); holder != "" {
+		return shim.Error("House " + args[0] + " is held by the multi-signature account " + holder + ", use proposeMultisigTransfer")
+	}
+	noticeAsBytes, err := queueOrExecuteTransfer(APIstub, args[0], house, invokerID, reasonSale, house.AskingPrice)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if noticeAsBytes != nil {
+		if noticeAsBytes, err = settleNoticeOnAccounts(APIstub, noticeAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(noticeAsBytes)
+	}
+	if err := settleSale(APIstub, args[0], house, invokerID, house.AskingPrice); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * exportStatement returns the entries of the account of an identity over a range of months, in the
+ * order they were posted, with the opening and closing balances, for the holder, admins and the custodian
+ * args: identity, first period, last period (YYYY-MM, inclusive)
+ */
+func (s *SmartContract) exportStatement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireAccountAccess(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, period := range args[1:] {
+		if _, err := time.Parse(periodLayout, period); err != nil {
+			return shim.Error("Periods must be formatted YYYY-MM")
+		}
+	}
+	if args[2] < args[1] {
+		return shim.Error("Last period must not be before the first period")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(accountEntryObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var statement = struct {
+		Identity       string         `json:"identity"`
+		FirstPeriod    string         `json:"firstperiod"`
+		LastPeriod     string         `json:"lastperiod"`
+		OpeningBalance int64          `json:"openingbalance"`
+		ClosingBalance int64          `json:"closingbalance"`
+		Entries        []AccountEntry `json:"entries"`
+	}{Identity: args[0], FirstPeriod: args[1], LastPeriod: args[2], Entries: []AccountEntry{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entry := AccountEntry{}
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return shim.Error(err.Error())
+		}
+		// The period of an entry may differ from its posting month, the statement goes by posting time
+		posted := entry.PostedAt[:len(periodLayout)]
+		if posted < args[1] {
+			statement.OpeningBalance += entry.Amount
+		} else if posted <= args[2] {
+			statement.Entries = append(statement.Entries, entry)
+		}
+	}
+
+	sort.SliceStable(statement.Entries, func(i, j int) bool {
+		a, b := statement.Entries[i], statement.Entries[j]
+		if a.PostedAt != b.PostedAt {
+			return a.PostedAt < b.PostedAt
+		}
+		if a.TxID != b.TxID {
+			return a.TxID < b.TxID
+		}
+		return a.Sequence < b.Sequence
+	})
+	statement.ClosingBalance = statement.OpeningBalance
+	for _, entry := range statement.Entries {
+		statement.ClosingBalance += entry.Amount
+	}
+
+	statementAsBytes, _ := json.Marshal(statement)
+	return shim.Success(statementAsBytes)
+}
@@ -0,0 +1,316 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Structured addresses
+ * Houses are located by a structured address: street, number, postal code, city and country.
+ * The city of the address is the location of the house, on which location authorities, zoning,
+ * caps and taxes depend. Houses registered with a free-form location keep it until
+ * migrateLegacyLocations parses it into an address. Postal codes are indexed by country in the
+ * "postalcode~key" index.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const postalCodeIndex = "postalcode~key"
+
+// Define the address structure. Country is an ISO 3166-1 alpha-2 code
+type Address struct {
+	Street     string `json:"street" protobuf:"1"`
+	Number     string `json:"number,omitempty" protobuf:"2"`
+	PostalCode string `json:"postalcode,omitempty" protobuf:"3"`
+	City       string `json:"city" protobuf:"4"`
+	Country    string `json:"country" protobuf:"5"`
+}
+
+// Formats of the postal codes of the countries checked, other countries only need a short alphanumeric code
+var postalCodePatterns = map[string]*regexp.Regexp{
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"JP": regexp.MustCompile(`^\d{3}-\d{4}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+var postalCodeFallback = regexp.MustCompile(`^[A-Z\d][A-Z\d -]{1,9}$`)
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// normalizeAddress collapses the white space of every field, and uppercases the country and postal code
+func normalizeAddress(address Address) Address {
+	collapse := func(value string) string { return strings.Join(strings.Fields(value), " ") }
+	return Address{
+		Street:     collapse(address.Street),
+		Number:     collapse(address.Number),
+		PostalCode: strings.ToUpper(collapse(address.PostalCode)),
+		City:       collapse(address.City),
+		Country:    strings.ToUpper(collapse(address.Country)),
+	}
+}
+
+// validateAddress checks a normalized address. Legacy addresses may lack their street
+func validateAddress(address Address, legacy bool) error {
+	if address.Street == "" && !legacy {
+		return fmt.Errorf("Street must not be empty")
+	}
+	if address.City == "" {
+		return fmt.Errorf("City must not be empty")
+	}
+	if !countryCode.MatchString(address.Country) {
+		return fmt.Errorf("Country must be an ISO 3166-1 alpha-2 code")
+	}
+	if address.PostalCode == "" {
+		if legacy {
+			return nil
+		}
+		return fmt.Errorf("Postal code must not be empty")
+	}
+	pattern, checked := postalCodePatterns[address.Country]
+	if !checked {
+		pattern = postalCodeFallback
+	}
+	if !pattern.MatchString(address.PostalCode) {
+		return fmt.Errorf("Invalid postal code %q for country %s", address.PostalCode, address.Country)
+	}
+	return nil
+}
+
+// formatAddress returns the address on one line, empty for no address
+func formatAddress(address *Address) string {
+	if address == nil {
+		return ""
+	}
+	lines := []string{}
+	if street := strings.TrimSpace(address.Number + " " + address.Street); street != "" {
+		lines = append(lines, street)
+	}
+	lines = append(lines, strings.TrimSpace(address.PostalCode+" "+address.City), address.Country)
+	return strings.Join(lines, ", ")
+}
+
+// isAddressArgument tells a structured address, given as a JSON object, from a free-form location
+func isAddressArgument(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "{")
+}
+
+// parseAddress reads, normalizes and validates an address given as a JSON object. The city is resolved to its canonical name
+func parseAddress(APIstub shim.ChaincodeStubInterface, value string) (Address, error) {
+	address := Address{}
+	if err := json.Unmarshal([]byte(value), &address); err != nil {
+		return Address{}, fmt.Errorf("Address must be a JSON object with street, number, postalcode, city and country")
+	}
+	return canonicalAddress(APIstub, address, false)
+}
+
+func canonicalAddress(APIstub shim.ChaincodeStubInterface, address Address, legacy bool) (Address, error) {
+	address = normalizeAddress(address)
+	if err := validateAddress(address, legacy); err != nil {
+		return Address{}, err
+	}
+	city, err := resolveLocationAlias(APIstub, address.City)
+	if err != nil {
+		return Address{}, err
+	}
+	address.City = city
+	return address, nil
+}
+
+// parseLegacyLocation splits a free-form location such as "12 rue des Arènes, 64100 Bayonne" into an address:
+// the first part is the street with its number, the last part the city with its postal code
+func parseLegacyLocation(location string, country string) Address {
+	parts := splitList(location)
+	if len(parts) == 0 {
+		return Address{Country: country}
+	}
+	address := Address{Country: country}
+	if len(parts) > 1 {
+		street := strings.Fields(parts[0])
+		if len(street) > 1 && unicode.IsDigit([]rune(street[0])[0]) {
+			address.Number, street = street[0], street[1:]
+		}
+		address.Street = strings.Join(street, " ")
+	}
+	city, postalCode := []string{}, []string{}
+	for _, token := range strings.Fields(parts[len(parts)-1]) {
+		if strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			postalCode = append(postalCode, token)
+		} else {
+			city = append(city, token)
+		}
+	}
+	address.City = strings.Join(city, " ")
+	address.PostalCode = strings.Join(postalCode, " ")
+	return address
+}
+
+func postalCodeEntries(house House) [][]string {
+	if house.Address == nil || house.Address.PostalCode == "" {
+		return [][]string{}
+	}
+	return [][]string{{house.Address.Country, house.Address.PostalCode}}
+}
+
+/*
+ * setHouseAddress sets the structured address of a house, for registrars. The city of the address must be the location of the house
+ * args: house key, address as a JSON object with street, number, postalcode, city and country
+ */
+func (s *SmartContract) setHouseAddress(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkLocationAuthority(APIstub, house.Location); err != nil {
+		return shim.Error(err.Error())
+	}
+	address, err := parseAddress(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if normalizeLocation(address.City) != normalizeLocation(house.Location) {
+		return shim.Error("House " + args[0] + " is located in " + house.Location + ", not " + address.City)
+	}
+
+	house.Address = &address
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	addressAsBytes, _ := json.Marshal(address)
+	return shim.Success(addressAsBytes)
+}
+
+/*
+ * queryHousesByPostalCode returns the houses of a postal code
+ * args: country code, postal code
+ */
+func (s *SmartContract) queryHousesByPostalCode(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	address := normalizeAddress(Address{Country: args[0], PostalCode: args[1]})
+	keys, err := queryIndexedHouseKeys(APIstub, postalCodeIndex, []string{address.Country, address.PostalCode})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
+
+/*
+ * migrateLegacyLocations parses the free-form location of a chunk of houses into a structured address, for admins.
+ * Locations that cannot be parsed are left as they are and listed
+ * args: first key to migrate (empty to start from the first house), maximum number of houses to scan, country code
+ */
+func (s *SmartContract) migrateLegacyLocations(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+	startKey := args[0]
+	if startKey == "" {
+		startKey = houseStartKey
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(startKey, houseEndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result = struct {
+		Scanned  int      `json:"scanned"`
+		Migrated int      `json:"migrated"`
+		Unparsed []string `json:"unparsed"`
+		NextKey  string   `json:"nextkey"`
+	}{Unparsed: []string{}}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// The first house beyond the chunk is where the next chunk starts
+		if result.Scanned == pageSize {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		house, err := decodeHouse(queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if house.Address != nil {
+			continue
+		}
+		address, err := canonicalAddress(APIstub, parseLegacyLocation(house.Location, args[2]), true)
+		if err != nil {
+			result.Unparsed = append(result.Unparsed, queryResponse.Key)
+			continue
+		}
+		house.Address, house.Location = &address, address.City
+		if err := putHouse(APIstub, queryResponse.Key, house); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
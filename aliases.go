@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Location aliases
+ * Locations are matched regardless of case, accents and spelling. Accents are folded by
+ * normalizeLocation and tokenize, and admins maintain a table mapping the aliases of a location
+ * (e.g. "Baiona" for "Bayonne") to its canonical name. Locations are canonicalized when houses are
+ * written, and the locations and terms of the queries are resolved in the same table.
+ * Index entries written before accents were folded must be rebuilt with rebuildIndexes.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const locationAliasObjectType = "locationAlias"
+
+// Define the location alias structure, one spelling of a location and its canonical name
+type LocationAlias struct {
+	Alias     string `json:"alias"`
+	Canonical string `json:"canonical"`
+}
+
+// Letters folded to their unaccented spelling, for the lowercased latin scripts
+var accentFolding = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'ß': "ss",
+}
+
+// foldAccents replaces the accented letters of a lowercased text by their unaccented spelling
+func foldAccents(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		if folded, found := accentFolding[r]; found {
+			builder.WriteString(folded)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// resolveLocationAlias returns the canonical name of the location, the location itself when it is no alias
+func resolveLocationAlias(APIstub shim.ChaincodeStubInterface, location string) (string, error) {
+	normalized := normalizeLocation(location)
+	if normalized == "" {
+		return location, nil
+	}
+	aliasKey, err := APIstub.CreateCompositeKey(locationAliasObjectType, []string{normalized})
+	if err != nil {
+		return "", err
+	}
+	aliasAsBytes, err := APIstub.GetState(aliasKey)
+	if err != nil || aliasAsBytes == nil {
+		return location, err
+	}
+	alias := LocationAlias{}
+	err = json.Unmarshal(aliasAsBytes, &alias)
+	return alias.Canonical, err
+}
+
+// resolveSearchTerms replaces the terms which are aliases by the terms of their canonical name
+func resolveSearchTerms(APIstub shim.ChaincodeStubInterface, terms []string) ([]string, error) {
+	resolved, seen := []string{}, map[string]bool{}
+	for _, term := range terms {
+		canonical, err := resolveLocationAlias(APIstub, term)
+		if err != nil {
+			return nil, err
+		}
+		for _, canonicalTerm := range tokenize(canonical) {
+			if !seen[canonicalTerm] {
+				seen[canonicalTerm] = true
+				resolved = append(resolved, canonicalTerm)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+/*
+ * setLocationAlias maps a spelling of a location to its canonical name, for admins
+ * args: alias, canonical name (empty to remove the alias)
+ */
+func (s *SmartContract) setLocationAlias(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	alias := normalizeLocation(args[0])
+	if alias == "" {
+		return shim.Error("Alias must not be empty")
+	}
+
+	aliasKey, err := APIstub.CreateCompositeKey(locationAliasObjectType, []string{alias})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		if err := APIstub.DelState(aliasKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	if normalizeLocation(args[1]) == alias {
+		return shim.Error("A location cannot be its own alias")
+	}
+	// Aliases are resolved once, so a canonical name cannot be an alias itself
+	canonical, err := resolveLocationAlias(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if canonical != args[1] {
+		return shim.Error(fmt.Sprintf("%s is an alias of %s, which must be used instead", args[1], canonical))
+	}
+
+	aliasAsBytes, _ := json.Marshal(LocationAlias{Alias: alias, Canonical: args[1]})
+	if err := APIstub.PutState(aliasKey, aliasAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aliasAsBytes)
+}
+
+// queryLocationAliases returns the table of the location aliases
+func (s *SmartContract) queryLocationAliases(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(locationAliasObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	aliases := []LocationAlias{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		alias := LocationAlias{}
+		if err := json.Unmarshal(queryResponse.Value, &alias); err != nil {
+			return shim.Error(err.Error())
+		}
+		aliases = append(aliases, alias)
+	}
+
+	aliasesAsBytes, _ := json.Marshal(aliases)
+	return shim.Success(aliasesAsBytes)
+}
@@ -0,0 +1,150 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Document anchors
+ * Off-chain documents (sale contract, survey...) are notarized by anchoring a salted hash of
+ * their content against an entity of the ledger and a purpose. Whoever holds the document and
+ * its salt can later prove it is the notarized one, without the ledger ever revealing its content.
+ */
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const documentAnchorObjectType = "documentAnchor"
+
+// Define the document anchor structure, the salted hash of a document notarized once per entity and purpose
+type DocumentAnchor struct {
+	EntityKey  string `json:"entitykey"`
+	Purpose    string `json:"purpose"`
+	Hash       string `json:"hash"`
+	AnchoredBy string `json:"anchoredby"`
+	AnchoredAt string `json:"anchoredat"`
+	TxID       string `json:"txid"`
+}
+
+func validateSHA256(hash string) error {
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return fmt.Errorf("Hash must be a hex encoded sha256")
+	}
+	return nil
+}
+
+func getDocumentAnchor(APIstub shim.ChaincodeStubInterface, entityKey string, purpose string) ([]byte, error) {
+	anchorKey, err := APIstub.CreateCompositeKey(documentAnchorObjectType, []string{entityKey, purpose})
+	if err != nil {
+		return nil, err
+	}
+	return APIstub.GetState(anchorKey)
+}
+
+/*
+ * anchorHash notarizes the salted hash of a document. An anchor cannot be replaced
+ * args: entity key, purpose, salted hash (hex sha256)
+ */
+func (s *SmartContract) anchorHash(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("Entity key and purpose must not be empty")
+	}
+	if err := validateSHA256(args[2]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existingAsBytes, err := getDocumentAnchor(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("A " + args[1] + " document is already anchored to " + args[0])
+	}
+
+	anchoredBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	anchoredAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var anchor = DocumentAnchor{
+		EntityKey:  args[0],
+		Purpose:    args[1],
+		Hash:       strings.ToLower(args[2]),
+		AnchoredBy: anchoredBy,
+		AnchoredAt: anchoredAt.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+
+	anchorKey, err := APIstub.CreateCompositeKey(documentAnchorObjectType, []string{anchor.EntityKey, anchor.Purpose})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	anchorAsBytes, _ := json.Marshal(anchor)
+	if err := APIstub.PutState(anchorKey, anchorAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(anchorAsBytes)
+}
+
+/*
+ * verifyAgainstAnchor tells whether a salted hash matches the anchored document
+ * args: entity key, purpose, candidate hash (hex sha256)
+ */
+func (s *SmartContract) verifyAgainstAnchor(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := validateSHA256(args[2]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	anchorAsBytes, err := getDocumentAnchor(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if anchorAsBytes == nil {
+		return shim.Error("No " + args[1] + " document is anchored to " + args[0])
+	}
+	anchor := DocumentAnchor{}
+	if err := json.Unmarshal(anchorAsBytes, &anchor); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var verification = struct {
+		Match  bool           `json:"match"`
+		Anchor DocumentAnchor `json:"anchor"`
+	}{Match: anchor.Hash == strings.ToLower(args[2]), Anchor: anchor}
+
+	verificationAsBytes, _ := json.Marshal(verification)
+	return shim.Success(verificationAsBytes)
+}
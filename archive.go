@@ -0,0 +1,381 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Archival
+ * Closed records (resolved disputes, ended leases along with their rent payments and refunded
+ * deposit) older than the retention period set by the admins are replaced by compact archive
+ * records. An archive record keeps the summary of the original and the sha256 of its stored
+ * value, so that a copy kept off-chain can still be checked against the ledger. The state is
+ * walked like verifyIntegrity, as a sequence of scans resumed by the returned bookmark. The
+ * records of a locked house are kept until its lock is released or expires.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	retentionDaysKey   = "CONFIG_RETENTIONDAYS"
+	archiveObjectType  = "archive"
+	houseArchiveIndex  = "housekey~archive"
+	maxArchivePageSize = 100
+)
+
+// Define the archive record structure, what is left of a closed record once archived
+type ArchiveRecord struct {
+	Kind       string            `json:"kind"`
+	ID         string            `json:"id"`
+	HouseKey   string            `json:"housekey"`
+	ClosedAt   string            `json:"closedat"`
+	ArchivedAt string            `json:"archivedat"`
+	Hash       string            `json:"hash"`
+	Summary    map[string]string `json:"summary"`
+}
+
+// Define the archiver structure. Archive deletes the record and returns its archive when it closed before the cutoff, nil otherwise
+type archiver struct {
+	objectType string
+	archive    func(APIstub shim.ChaincodeStubInterface, key string, value []byte, cutoff time.Time) (*ArchiveRecord, error)
+}
+
+// Scans walked by archiveExpired, in order
+var archivers = []archiver{
+	{objectType: disputeObjectType, archive: archiveDispute},
+	{objectType: leaseObjectType, archive: archiveLease},
+}
+
+// hasArchivedRecords tells whether records of the house were archived
+func hasArchivedRecords(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(houseArchiveIndex, []string{key})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
+
+func recordHash(value []byte) string {
+	hash := sha256.Sum256(value)
+	return hex.EncodeToString(hash[:])
+}
+
+func archiveDispute(APIstub shim.ChaincodeStubInterface, key string, value []byte, cutoff time.Time) (*ArchiveRecord, error) {
+	dispute := Dispute{}
+	if err := json.Unmarshal(value, &dispute); err != nil {
+		return nil, err
+	}
+	if dispute.Status != disputeResolved {
+		return nil, nil
+	}
+	// The records of a locked house are kept until its workflow completes
+	if lock, err := getHouseLock(APIstub, dispute.HouseKey); err != nil || lock != nil {
+		return nil, err
+	}
+	resolvedAt, err := time.Parse(timeLayout, dispute.ResolvedAt)
+	if err != nil || !resolvedAt.Before(cutoff) {
+		return nil, err
+	}
+
+	if err := APIstub.DelState(key); err != nil {
+		return nil, err
+	}
+	return &ArchiveRecord{
+		Kind:     disputeObjectType,
+		ID:       dispute.ID,
+		HouseKey: dispute.HouseKey,
+		ClosedAt: dispute.ResolvedAt,
+		Hash:     recordHash(value),
+		Summary:  map[string]string{"claimant": dispute.Claimant, "outcome": dispute.Outcome, "newowner": dispute.NewOwner},
+	}, nil
+}
+
+// A lease closes when it is terminated, or at the end of its last period. Its deposit must have been refunded
+func archiveLease(APIstub shim.ChaincodeStubInterface, key string, value []byte, cutoff time.Time) (*ArchiveRecord, error) {
+	lease := Lease{}
+	if err := json.Unmarshal(value, &lease); err != nil {
+		return nil, err
+	}
+	var closedAt time.Time
+	if lease.Status == leaseTerminated {
+		terminatedAt, err := time.Parse(timeLayout, lease.TerminatedAt)
+		if err != nil {
+			return nil, err
+		}
+		closedAt = terminatedAt
+	} else {
+		endPeriod, err := time.Parse(periodLayout, lease.EndPeriod)
+		if err != nil {
+			return nil, err
+		}
+		closedAt = endPeriod.AddDate(0, 1, 0)
+	}
+	if !closedAt.Before(cutoff) {
+		return nil, nil
+	}
+	if lock, err := getHouseLock(APIstub, lease.HouseKey); err != nil || lock != nil {
+		return nil, err
+	}
+
+	depositKey, err := APIstub.CreateCompositeKey(securityDepositObjectType, []string{lease.ID})
+	if err != nil {
+		return nil, err
+	}
+	depositAsBytes, err := APIstub.GetState(depositKey)
+	if err != nil {
+		return nil, err
+	}
+	depositRefund := "none"
+	if depositAsBytes != nil {
+		deposit := SecurityDeposit{}
+		if err := json.Unmarshal(depositAsBytes, &deposit); err != nil {
+			return nil, err
+		}
+		if deposit.Status != depositRefunded {
+			return nil, nil
+		}
+		depositRefund = strconv.FormatInt(deposit.Refunded, 10)
+		if err := APIstub.DelState(depositKey); err != nil {
+			return nil, err
+		}
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rentPaymentObjectType, []string{lease.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	payments, rentPaid := 0, int64(0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		payment := RentPayment{}
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		payments++
+		rentPaid += payment.Amount
+		if err := APIstub.DelState(queryResponse.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	indexKey, err := APIstub.CreateCompositeKey(houseLeaseIndex, []string{lease.HouseKey, lease.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := APIstub.DelState(indexKey); err != nil {
+		return nil, err
+	}
+	if err := APIstub.DelState(key); err != nil {
+		return nil, err
+	}
+	return &ArchiveRecord{
+		Kind:     leaseObjectType,
+		ID:       lease.ID,
+		HouseKey: lease.HouseKey,
+		ClosedAt: closedAt.Format(timeLayout),
+		Hash:     recordHash(value),
+		Summary: map[string]string{
+			"landlord":      lease.Landlord,
+			"tenant":        lease.Tenant,
+			"startperiod":   lease.StartPeriod,
+			"endperiod":     lease.EndPeriod,
+			"payments":      strconv.Itoa(payments),
+			"rentpaid":      strconv.FormatInt(rentPaid, 10),
+			"depositrefund": depositRefund,
+		},
+	}, nil
+}
+
+/*
+ * setRetentionPeriod sets the number of days closed records are kept before archival, for admins
+ * args: retention in days
+ */
+func (s *SmartContract) setRetentionPeriod(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days <= 0 {
+		return shim.Error("Retention must be a positive number of days")
+	}
+
+	daysAsBytes, _ := json.Marshal(days)
+	if err := APIstub.PutState(retentionDaysKey, daysAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * archiveExpired archives one page of the closed records older than the retention period, for admins.
+ * Pagination is not available to transactions writing to the ledger, the bookmark is the index of
+ * the scan followed by the hex encoded last record checked
+ * args: page size, bookmark (empty to start, then the bookmark returned by the previous page)
+ */
+func (s *SmartContract) archiveExpired(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize <= 0 || pageSize > maxArchivePageSize {
+		return shim.Error(fmt.Sprintf("Page size must be a number from 1 to %d", maxArchivePageSize))
+	}
+
+	daysAsBytes, err := APIstub.GetState(retentionDaysKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if daysAsBytes == nil {
+		return shim.Error("No retention period is set, see setRetentionPeriod")
+	}
+	days := 0
+	if err := json.Unmarshal(daysAsBytes, &days); err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cutoff := txTime.AddDate(0, 0, -days)
+
+	scanIndex, lastKey := 0, ""
+	if args[1] != "" {
+		parts := strings.SplitN(args[1], ":", 2)
+		scanIndex, err = strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || scanIndex < 0 || scanIndex >= len(archivers) {
+			return shim.Error("Invalid bookmark " + args[1])
+		}
+		lastKeyAsBytes, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return shim.Error("Invalid bookmark " + args[1])
+		}
+		lastKey = string(lastKeyAsBytes)
+	}
+	scan := archivers[scanIndex]
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(scan.objectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result = struct {
+		Scan     string          `json:"scan"`
+		Scanned  int             `json:"scanned"`
+		Archived []ArchiveRecord `json:"archived"`
+		Bookmark string          `json:"bookmark"`
+	}{Scan: scan.objectType, Archived: []ArchiveRecord{}}
+
+	// The scan done, the next page starts the following scan. An empty bookmark ends the walk
+	if scanIndex+1 < len(archivers) {
+		result.Bookmark = strconv.Itoa(scanIndex+1) + ":"
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// Composite keys cannot start a range, the records already checked are skipped
+		if queryResponse.Key <= lastKey {
+			continue
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = strconv.Itoa(scanIndex) + ":" + hex.EncodeToString([]byte(lastKey))
+			break
+		}
+		result.Scanned++
+		lastKey = queryResponse.Key
+
+		record, err := scan.archive(APIstub, queryResponse.Key, queryResponse.Value, cutoff)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if record == nil {
+			continue
+		}
+		record.ArchivedAt = txTime.Format(timeLayout)
+		archiveKey, err := APIstub.CreateCompositeKey(archiveObjectType, []string{record.Kind, record.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		recordAsBytes, _ := json.Marshal(record)
+		if err := APIstub.PutState(archiveKey, recordAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		// The keys of the houses with archived records are never reused
+		indexKey, err := APIstub.CreateCompositeKey(houseArchiveIndex, []string{record.HouseKey, record.Kind, record.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Archived = append(result.Archived, *record)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+/*
+ * queryArchive returns the archive record of a closed record
+ * args: kind (dispute or lease), ID of the original record
+ */
+func (s *SmartContract) queryArchive(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	archiveKey, err := APIstub.CreateCompositeKey(archiveObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recordAsBytes, err := APIstub.GetState(archiveKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if recordAsBytes == nil {
+		return shim.Error("No archive of " + args[0] + " " + args[1])
+	}
+
+	return shim.Success(recordAsBytes)
+}
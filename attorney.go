@@ -0,0 +1,304 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Powers of attorney
+ * An owner can register a power of attorney allowing an attorney to act on their behalf until an
+ * expiry date, for the sales or transfers of all their houses or of the listed ones. The owner
+ * functions accept the attorney in the owner's stead, and every invocation made under a power of
+ * attorney is logged with the identities of both the owner and the attorney.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	powerOfAttorneyObjectType    = "powerOfAttorney"
+	attorneyInvocationObjectType = "attorneyInvocation"
+)
+
+// Scopes of the powers of attorney and the functions they cover
+var attorneyScopes = map[string][]string{
+	"sales":     {"listHouseForSale", "withdrawHouseListing", "grantOption"},
+	"transfers": {"changeHouseOwner", "scheduleHouseTransfer", "cancelScheduledTransfer", "approveDeed", "transferDeedFrom"},
+}
+
+// Define the power of attorney structure. No house key covers every house of the owner
+type PowerOfAttorney struct {
+	Owner     string   `json:"owner"`
+	Attorney  string   `json:"attorney"`
+	Scopes    []string `json:"scopes"`
+	HouseKeys []string `json:"housekeys"`
+	Expiry    string   `json:"expiry"`
+	GrantedAt string   `json:"grantedat"`
+	RevokedAt string   `json:"revokedat,omitempty"`
+}
+
+// Define the attorney invocation structure, the log of a function invoked under a power of attorney
+type AttorneyInvocation struct {
+	Owner    string `json:"owner"`
+	Attorney string `json:"attorney"`
+	Function string `json:"function"`
+	HouseKey string `json:"housekey"`
+	TxID     string `json:"txid"`
+	At       string `json:"at"`
+}
+
+func getPowerOfAttorney(APIstub shim.ChaincodeStubInterface, owner string, attorney string) (PowerOfAttorney, bool, error) {
+	poaKey, err := APIstub.CreateCompositeKey(powerOfAttorneyObjectType, []string{owner, attorney})
+	if err != nil {
+		return PowerOfAttorney{}, false, err
+	}
+	poaAsBytes, err := APIstub.GetState(poaKey)
+	if err != nil || poaAsBytes == nil {
+		return PowerOfAttorney{}, false, err
+	}
+	poa := PowerOfAttorney{}
+	err = json.Unmarshal(poaAsBytes, &poa)
+	return poa, true, err
+}
+
+func putPowerOfAttorney(APIstub shim.ChaincodeStubInterface, poa PowerOfAttorney) ([]byte, error) {
+	poaKey, err := APIstub.CreateCompositeKey(powerOfAttorneyObjectType, []string{poa.Owner, poa.Attorney})
+	if err != nil {
+		return nil, err
+	}
+	poaAsBytes, _ := json.Marshal(poa)
+	return poaAsBytes, APIstub.PutState(poaKey, poaAsBytes)
+}
+
+// powerOfAttorneyCovers tells whether the power of attorney is in force for the function on the house
+func powerOfAttorneyCovers(poa PowerOfAttorney, function string, key string, at time.Time) bool {
+	expiry, err := time.Parse(timeLayout, poa.Expiry)
+	if err != nil || poa.RevokedAt != "" || !at.Before(expiry) {
+		return false
+	}
+	house := len(poa.HouseKeys) == 0
+	for _, houseKey := range poa.HouseKeys {
+		house = house || houseKey == key
+	}
+	for _, scope := range poa.Scopes {
+		for _, covered := range attorneyScopes[scope] {
+			if covered == function && house {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// actsAsAttorney tells whether the invoker holds a power of attorney of the owner for the function invoked
+// on the house, logging the invocation when it does
+func actsAsAttorney(APIstub shim.ChaincodeStubInterface, key string, owner string, invokerID string) (bool, error) {
+	poa, found, err := getPowerOfAttorney(APIstub, owner, invokerID)
+	if err != nil || !found {
+		return false, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return false, err
+	}
+	function := routedFunction(APIstub)
+	if !powerOfAttorneyCovers(poa, function, key, txTime) {
+		return false, nil
+	}
+
+	var invocation = AttorneyInvocation{
+		Owner:    owner,
+		Attorney: invokerID,
+		Function: function,
+		HouseKey: key,
+		TxID:     APIstub.GetTxID(),
+		At:       txTime.Format(timeLayout),
+	}
+	invocationKey, err := APIstub.CreateCompositeKey(attorneyInvocationObjectType, []string{owner, invocation.TxID, key})
+	if err != nil {
+		return false, err
+	}
+	invocationAsBytes, _ := json.Marshal(invocation)
+	if err := APIstub.PutState(invocationKey, invocationAsBytes); err != nil {
+		return false, err
+	}
+	emitEvent(APIstub, "attorneyInvocation", invocationAsBytes)
+	return true, nil
+}
+
+/*
+ * grantPowerOfAttorney registers a power of attorney of the invoker, replacing the previous one of the attorney
+ * args: attorney, scopes as a comma separated list (sales, transfers), house keys as a comma separated list (empty for all), expiry (RFC 3339)
+ */
+func (s *SmartContract) grantPowerOfAttorney(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[0] == owner {
+		return shim.Error("Attorney must not be empty nor the owner")
+	}
+	scopes := splitList(args[1])
+	if len(scopes) == 0 {
+		return shim.Error("Scopes must not be empty")
+	}
+	for _, scope := range scopes {
+		if _, known := attorneyScopes[scope]; !known {
+			return shim.Error(fmt.Sprintf("Unknown scope %q, expecting sales or transfers", scope))
+		}
+	}
+	houseKeys := splitList(args[2])
+	for _, key := range houseKeys {
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := requireOwner(APIstub, key, house); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("Expiry must be formatted as RFC 3339")
+	}
+	if !expiry.After(txTime) {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	var poa = PowerOfAttorney{
+		Owner:     owner,
+		Attorney:  args[0],
+		Scopes:    scopes,
+		HouseKeys: houseKeys,
+		Expiry:    expiry.UTC().Format(timeLayout),
+		GrantedAt: txTime.Format(timeLayout),
+	}
+	poaAsBytes, err := putPowerOfAttorney(APIstub, poa)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "powerOfAttorneyGranted", poaAsBytes)
+	return shim.Success(poaAsBytes)
+}
+
+// revokePowerOfAttorney revokes the power of attorney of the invoker held by an attorney. args: attorney
+func (s *SmartContract) revokePowerOfAttorney(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	poa, found, err := getPowerOfAttorney(APIstub, owner, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found || poa.RevokedAt != "" {
+		return shim.Error("No power of attorney of " + owner + " is held by " + args[0])
+	}
+	revokedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	poa.RevokedAt = revokedAt.Format(timeLayout)
+	poaAsBytes, err := putPowerOfAttorney(APIstub, poa)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "powerOfAttorneyRevoked", poaAsBytes)
+	return shim.Success(poaAsBytes)
+}
+
+// queryPowersOfAttorney returns the powers of attorney granted by an owner, revoked ones included. args: owner
+func (s *SmartContract) queryPowersOfAttorney(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(powerOfAttorneyObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	powers := []PowerOfAttorney{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		poa := PowerOfAttorney{}
+		if err := json.Unmarshal(queryResponse.Value, &poa); err != nil {
+			return shim.Error(err.Error())
+		}
+		powers = append(powers, poa)
+	}
+
+	powersAsBytes, _ := json.Marshal(powers)
+	return shim.Success(powersAsBytes)
+}
+
+// queryAttorneyInvocations returns the log of the functions invoked under the powers of attorney of an owner. args: owner
+func (s *SmartContract) queryAttorneyInvocations(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(attorneyInvocationObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	invocations := []AttorneyInvocation{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		invocation := AttorneyInvocation{}
+		if err := json.Unmarshal(queryResponse.Value, &invocation); err != nil {
+			return shim.Error(err.Error())
+		}
+		invocations = append(invocations, invocation)
+	}
+	sort.Slice(invocations, func(i, j int) bool { return invocations[i].At < invocations[j].At })
+
+	invocationsAsBytes, _ := json.Marshal(invocations)
+	return shim.Success(invocationsAsBytes)
+}
@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Location authorities
+ * In a federated registry each location (commune) is kept by its own registrar organization.
+ * Admins maintain the table of the MSP in charge of each location, and the houses of a location
+ * having an authority can only be created or changed by a registrar of that MSP. Locations
+ * without an authority remain open to the registrars of every organization.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const locationAuthorityObjectType = "locationAuthority"
+
+// Define the location authority structure, the MSP keeping the houses of a location
+type LocationAuthority struct {
+	Location string `json:"location"`
+	MSPID    string `json:"mspid"`
+}
+
+func getLocationAuthority(APIstub shim.ChaincodeStubInterface, location string) (string, error) {
+	location, err := resolveLocationAlias(APIstub, location)
+	if err != nil {
+		return "", err
+	}
+	authorityKey, err := APIstub.CreateCompositeKey(locationAuthorityObjectType, []string{normalizeLocation(location)})
+	if err != nil {
+		return "", err
+	}
+	authorityAsBytes, err := APIstub.GetState(authorityKey)
+	if err != nil || authorityAsBytes == nil {
+		return "", err
+	}
+	authority := LocationAuthority{}
+	err = json.Unmarshal(authorityAsBytes, &authority)
+	return authority.MSPID, err
+}
+
+// checkLocationAuthority returns an error unless the invoker is a registrar of the authority of the location,
+// of any organization when the location has none
+func checkLocationAuthority(APIstub shim.ChaincodeStubInterface, location string) error {
+	mspID, err := getLocationAuthority(APIstub, location)
+	if err != nil {
+		return err
+	}
+	if mspID == "" {
+		return requireRole(APIstub, roleRegistrar)
+	}
+	invokerMSP, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	if invokerMSP != mspID {
+		return fmt.Errorf("Access denied. Houses of %s are kept by the registrars of %s", location, mspID)
+	}
+	return requireRole(APIstub, roleRegistrar)
+}
+
+/*
+ * setLocationAuthority sets the MSP keeping the houses of a location, for admins
+ * args: location, MSP ID (empty to remove the authority)
+ */
+func (s *SmartContract) setLocationAuthority(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	canonical, err := resolveLocationAlias(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location := normalizeLocation(canonical)
+	if location == "" {
+		return shim.Error("Location must not be empty")
+	}
+
+	authorityKey, err := APIstub.CreateCompositeKey(locationAuthorityObjectType, []string{location})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		if err := APIstub.DelState(authorityKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	authorityAsBytes, _ := json.Marshal(LocationAuthority{Location: location, MSPID: args[1]})
+	if err := APIstub.PutState(authorityKey, authorityAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(authorityAsBytes)
+}
+
+// queryLocationAuthorities returns the table of the location authorities
+func (s *SmartContract) queryLocationAuthorities(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(locationAuthorityObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	authorities := []LocationAuthority{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		authority := LocationAuthority{}
+		if err := json.Unmarshal(queryResponse.Value, &authority); err != nil {
+			return shim.Error(err.Error())
+		}
+		authorities = append(authorities, authority)
+	}
+
+	authoritiesAsBytes, _ := json.Marshal(authorities)
+	return shim.Success(authoritiesAsBytes)
+}
@@ -0,0 +1,118 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Batches
+ * A batch runs an ordered list of operations in one transaction, all or nothing: the first
+ * failing operation fails the whole transaction, none of the writes of the batch being committed.
+ * Thanks to the write cache, every operation reads what the previous ones wrote. Only the
+ * functions listed below can be batched, the rules of the rules table restricted to a function
+ * being evaluated before each of its operations. Operations go through the pause and the
+ * idempotency checks as calls of their own would, each under the idempotency token of the
+ * transaction followed by "#" and the index of the operation.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Maximum number of operations of a batch
+const maxBatchOperations = 50
+
+// Functions which can be batched
+var batchFunctions = map[string]bool{
+	"createHouse":         true,
+	"renovateHouse":       true,
+	"setHouseDescription": true,
+	"setHouseTags":        true,
+	"setHouseAddress":     true,
+	"setCadastralRef":     true,
+	"changeHouseOwner":    true,
+	"registerMortgage":    true,
+	"executeCourtOrder":   true,
+}
+
+// Define the batch operation structure, a function and its arguments
+type BatchOperation struct {
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+}
+
+// Define the operation result structure, the response of an operation of a batch
+type OperationResult struct {
+	Function string `json:"function"`
+	Status   int32  `json:"status"`
+	Payload  []byte `json:"payload"`
+}
+
+/*
+ * batch runs the operations in order in one transaction, returning their results, or failing with the first failing operation
+ * args: operations as a JSON array of {function, args}
+ */
+func (s *SmartContract) batch(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	operations := []BatchOperation{}
+	if err := json.Unmarshal([]byte(args[0]), &operations); err != nil {
+		return shim.Error("Operations must be a JSON array of {function, args}")
+	}
+	if len(operations) == 0 || len(operations) > maxBatchOperations {
+		return shim.Error(fmt.Sprintf("A batch must have from 1 to %d operations", maxBatchOperations))
+	}
+	for i, operation := range operations {
+		if !batchFunctions[operation.Function] {
+			functions := []string{}
+			for function := range batchFunctions {
+				functions = append(functions, function)
+			}
+			sort.Strings(functions)
+			return shim.Error(fmt.Sprintf("Operation %d: %q cannot be batched, expecting one of %v", i, operation.Function, functions))
+		}
+	}
+
+	results := []OperationResult{}
+	transaction := transactionOf(APIstub)
+	if transaction != nil {
+		defer func() { transaction.operation = "" }()
+	}
+	for i, operation := range operations {
+		if transaction != nil {
+			transaction.operation = strconv.Itoa(i)
+		}
+		if err := evaluateRules(APIstub, ruleContext{function: operation.Function}); err != nil {
+			return shim.Error(fmt.Sprintf("Operation %d (%s) failed: %s", i, operation.Function, err))
+		}
+		response := s.invokeUnlessPaused(APIstub, operation.Function, operation.Args)
+		if response.Status >= shim.ERRORTHRESHOLD {
+			return shim.Error(fmt.Sprintf("Operation %d (%s) failed: %s", i, operation.Function, response.Message))
+		}
+		results = append(results, OperationResult{Function: operation.Function, Status: response.Status, Payload: response.Payload})
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
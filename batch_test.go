@@ -0,0 +1,45 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Batch tests
+ * Operations of a batch run under the idempotency token of their transaction.
+ */
+import (
+	"testing"
+)
+
+func TestBatchOperationsOfTheSameFunctionHaveTheirOwnToken(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.stub.TransientMap = map[string][]byte{idempotencyKeyField: []byte("import-1")}
+	defer func() { ledger.stub.TransientMap = nil }()
+	operations := `[{"function":"createHouse","args":["HOUSE1","2004","1200","Paris","alice"]},
+		{"function":"createHouse","args":["HOUSE2","1999","800","Paris","alice"]}]`
+
+	ledger.invoke(t, ledger.owner, "batch", operations)
+	for _, key := range []string{"HOUSE1", "HOUSE2"} {
+		if ledger.invoke(t, ledger.owner, "queryHouse", key) == nil {
+			t.Errorf("House %s was not created by its operation", key)
+		}
+	}
+
+	// A retry of the batch replays both operations instead of creating the houses again
+	ledger.invoke(t, ledger.owner, "batch", operations)
+}
@@ -0,0 +1,68 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Benchmarks
+ * The create, query and transfer functions measured by the Caliper campaign, invoked in process on a
+ * mock stub, complete with the invocation pipeline: write cache, rules, notifications and metrics.
+ * The mock stub walks all its keys for every range query, so that times grow with the number of
+ * houses created: compare runs of the same size, go test -run xxx -bench . -benchmem -benchtime 1000x
+ */
+import (
+	"fmt"
+	"testing"
+)
+
+func benchmarkHouseKey(index int) string {
+	return fmt.Sprintf("HOUSE5B%d", index)
+}
+
+func (ledger *mockLedger) createHouses(b *testing.B, count int) {
+	for i := 0; i < count; i++ {
+		ledger.invoke(b, ledger.owner, "createHouse", benchmarkHouseKey(i), "2004", "1200", "Paris", "alice")
+	}
+}
+
+func BenchmarkCreateHouse(b *testing.B) {
+	ledger := newMockLedger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	ledger.createHouses(b, b.N)
+}
+
+func BenchmarkQueryHouse(b *testing.B) {
+	ledger := newMockLedger(b)
+	ledger.createHouses(b, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ledger.invoke(b, ledger.owner, "queryHouse", benchmarkHouseKey(i%100))
+	}
+}
+
+func BenchmarkChangeHouseOwner(b *testing.B) {
+	ledger := newMockLedger(b)
+	ledger.createHouses(b, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ledger.invoke(b, ledger.owner, "changeHouseOwner", benchmarkHouseKey(i), "bob", reasonSale, "250000")
+	}
+}
@@ -0,0 +1,433 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Bookings
+ * Hosts take bookings of their licensed houses for short stays, from the arrival day to the
+ * departure day. A booking cannot overlap another booking or an active lease of the house, and its
+ * nights count against the night cap of the short-term rental license. The booking terms of a
+ * house set its nightly rate and cancellation policy; a guest cancelling pays the share of the
+ * price set by the policy for the number of days left before arrival.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	bookingObjectType      = "booking"
+	houseBookingIndex      = "key~booking"
+	bookingTermsObjectType = "bookingTerms"
+)
+
+// Booking statuses
+const (
+	bookingConfirmed = "confirmed"
+	bookingCancelled = "cancelled"
+)
+
+// Define the cancellation tier structure: a cancellation at least MinDaysBefore days before arrival costs Percent of the price
+type cancellationTier struct {
+	MinDaysBefore int   `json:"mindaysbefore"`
+	Percent       int64 `json:"percent"`
+}
+
+// Cancellation policies, their tiers in decreasing order of days
+var cancellationPolicies = map[string][]cancellationTier{
+	"flexible": {{MinDaysBefore: 1, Percent: 0}, {MinDaysBefore: 0, Percent: 50}},
+	"moderate": {{MinDaysBefore: 5, Percent: 0}, {MinDaysBefore: 1, Percent: 50}, {MinDaysBefore: 0, Percent: 100}},
+	"strict":   {{MinDaysBefore: 14, Percent: 0}, {MinDaysBefore: 7, Percent: 50}, {MinDaysBefore: 0, Percent: 100}},
+}
+
+// Define the booking terms structure, the nightly rate and cancellation policy of a house
+type BookingTerms struct {
+	HouseKey           string `json:"housekey"`
+	NightlyRate        int64  `json:"nightlyrate"`
+	CancellationPolicy string `json:"cancellationpolicy"`
+}
+
+// Define the booking structure, identified by the ID of the creating transaction. End is the departure day
+type Booking struct {
+	ID                 string `json:"id"`
+	HouseKey           string `json:"housekey"`
+	Guest              string `json:"guest"`
+	Start              string `json:"start"`
+	End                string `json:"end"`
+	Nights             int    `json:"nights"`
+	Price              int64  `json:"price"`
+	CancellationPolicy string `json:"cancellationpolicy"`
+	Status             string `json:"status"`
+	BookedBy           string `json:"bookedby"`
+	CancelledBy        string `json:"cancelledby,omitempty"`
+	CancelledAt        string `json:"cancelledat,omitempty"`
+	Penalty            int64  `json:"penalty"`
+}
+
+func getBooking(APIstub shim.ChaincodeStubInterface, bookingID string) (Booking, error) {
+	bookingKey, err := APIstub.CreateCompositeKey(bookingObjectType, []string{bookingID})
+	if err != nil {
+		return Booking{}, err
+	}
+	bookingAsBytes, err := APIstub.GetState(bookingKey)
+	if err != nil {
+		return Booking{}, err
+	}
+	if bookingAsBytes == nil {
+		return Booking{}, fmt.Errorf("Booking %s does not exist", bookingID)
+	}
+	booking := Booking{}
+	err = json.Unmarshal(bookingAsBytes, &booking)
+	return booking, err
+}
+
+func putBooking(APIstub shim.ChaincodeStubInterface, booking Booking) ([]byte, error) {
+	bookingKey, err := APIstub.CreateCompositeKey(bookingObjectType, []string{booking.ID})
+	if err != nil {
+		return nil, err
+	}
+	bookingAsBytes, _ := json.Marshal(booking)
+	return bookingAsBytes, APIstub.PutState(bookingKey, bookingAsBytes)
+}
+
+func getBookingTerms(APIstub shim.ChaincodeStubInterface, key string) (BookingTerms, error) {
+	termsKey, err := APIstub.CreateCompositeKey(bookingTermsObjectType, []string{key})
+	if err != nil {
+		return BookingTerms{}, err
+	}
+	termsAsBytes, err := APIstub.GetState(termsKey)
+	if err != nil {
+		return BookingTerms{}, err
+	}
+	if termsAsBytes == nil {
+		return BookingTerms{}, fmt.Errorf("House %s has no booking terms", key)
+	}
+	terms := BookingTerms{}
+	err = json.Unmarshal(termsAsBytes, &terms)
+	return terms, err
+}
+
+// parseStay parses the arrival and departure days of a stay
+func parseStay(start string, end string) (time.Time, time.Time, error) {
+	arrival, err := time.Parse(dayLayout, start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Arrival day must be formatted YYYY-MM-DD")
+	}
+	departure, err := time.Parse(dayLayout, end)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Departure day must be formatted YYYY-MM-DD")
+	}
+	if !departure.After(arrival) {
+		return time.Time{}, time.Time{}, fmt.Errorf("Departure day must be after the arrival day")
+	}
+	return arrival, departure, nil
+}
+
+// leaseDays returns the first day of an active lease and the day after its last day
+func leaseDays(lease Lease) (time.Time, time.Time) {
+	start, _ := time.Parse(periodLayout, lease.StartPeriod)
+	end, _ := time.Parse(periodLayout, lease.EndPeriod)
+	return start, end.AddDate(0, 1, 0)
+}
+
+// stayConflicts returns the confirmed bookings and active leases of the house overlapping the days from start to end
+func stayConflicts(APIstub shim.ChaincodeStubInterface, key string, start time.Time, end time.Time) ([]Booking, []Lease, error) {
+	bookings := []Booking{}
+	bookingsIterator, err := APIstub.GetStateByPartialCompositeKey(houseBookingIndex, []string{key})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bookingsIterator.Close()
+	for bookingsIterator.HasNext() {
+		queryResponse, err := bookingsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		booking, err := getBooking(APIstub, attributes[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		arrival, departure, _ := parseStay(booking.Start, booking.End)
+		if booking.Status == bookingConfirmed && arrival.Before(end) && start.Before(departure) {
+			bookings = append(bookings, booking)
+		}
+	}
+
+	leases := []Lease{}
+	leasesIterator, err := APIstub.GetStateByPartialCompositeKey(houseLeaseIndex, []string{key})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer leasesIterator.Close()
+	for leasesIterator.HasNext() {
+		queryResponse, err := leasesIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		lease, err := getLease(APIstub, attributes[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		leaseStart, leaseEnd := leaseDays(lease)
+		if lease.Status == leaseActive && leaseStart.Before(end) && start.Before(leaseEnd) {
+			leases = append(leases, lease)
+		}
+	}
+	return bookings, leases, nil
+}
+
+// cancellationPenalty returns the penalty of a cancellation of the booking by its guest at the given time
+func cancellationPenalty(booking Booking, at time.Time) int64 {
+	arrival, _ := time.Parse(dayLayout, booking.Start)
+	daysBefore := int(arrival.Sub(at).Hours() / 24)
+	for _, tier := range cancellationPolicies[booking.CancellationPolicy] {
+		if daysBefore >= tier.MinDaysBefore {
+			return booking.Price * tier.Percent / 100
+		}
+	}
+	return booking.Price
+}
+
+/*
+ * setBookingTerms sets the nightly rate and cancellation policy of the bookings of a house, for the owner or a manager with
+ * the leases permission. Bookings already taken keep their terms
+ * args: house key, nightly rate, cancellation policy (flexible, moderate or strict)
+ */
+func (s *SmartContract) setBookingTerms(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	rate, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || rate <= 0 {
+		return shim.Error("Nightly rate must be a positive number")
+	}
+	if _, known := cancellationPolicies[args[2]]; !known {
+		policies := []string{}
+		for policy := range cancellationPolicies {
+			policies = append(policies, policy)
+		}
+		sort.Strings(policies)
+		return shim.Error(fmt.Sprintf("Unknown cancellation policy %q, expecting one of %v", args[2], policies))
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	termsKey, err := APIstub.CreateCompositeKey(bookingTermsObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	termsAsBytes, _ := json.Marshal(BookingTerms{HouseKey: args[0], NightlyRate: rate, CancellationPolicy: args[2]})
+	if err := APIstub.PutState(termsKey, termsAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(termsAsBytes)
+}
+
+/*
+ * createBooking books the house for a guest, for the owner or a manager with the leases permission
+ * args: house key, arrival day, departure day (YYYY-MM-DD), guest
+ */
+func (s *SmartContract) createBooking(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	arrival, departure, err := parseStay(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[3] == "" {
+		return shim.Error("Guest must not be empty")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+	terms, err := getBookingTerms(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if arrival.Before(txTime.Truncate(24 * time.Hour)) {
+		return shim.Error("Arrival day must not be in the past")
+	}
+
+	bookings, leases, err := stayConflicts(APIstub, args[0], arrival, departure)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(bookings) > 0 {
+		return shim.Error(fmt.Sprintf("House %s is booked from %s to %s by booking %s", args[0], bookings[0].Start, bookings[0].End, bookings[0].ID))
+	}
+	if len(leases) > 0 {
+		return shim.Error(fmt.Sprintf("House %s is let from %s to %s by lease %s", args[0], leases[0].StartPeriod, leases[0].EndPeriod, leases[0].ID))
+	}
+
+	nights := int(departure.Sub(arrival).Hours() / 24)
+	if _, err := addRentalStay(APIstub, args[0], arrival, nights); err != nil {
+		return shim.Error(err.Error())
+	}
+	bookedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var booking = Booking{
+		ID:                 APIstub.GetTxID(),
+		HouseKey:           args[0],
+		Guest:              args[3],
+		Start:              args[1],
+		End:                args[2],
+		Nights:             nights,
+		Price:              terms.NightlyRate * int64(nights),
+		CancellationPolicy: terms.CancellationPolicy,
+		Status:             bookingConfirmed,
+		BookedBy:           bookedBy,
+	}
+	bookingAsBytes, err := putBooking(APIstub, booking)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(houseBookingIndex, []string{booking.HouseKey, booking.Start, booking.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "bookingCreated", bookingAsBytes)
+	return shim.Success(bookingAsBytes)
+}
+
+/*
+ * cancelBooking cancels a booking before arrival, for its guest, or the owner or a manager of the house with the leases
+ * permission. The guest pays the penalty of the cancellation policy, the host none
+ * args: booking ID
+ */
+func (s *SmartContract) cancelBooking(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	booking, err := getBooking(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if booking.Status != bookingConfirmed {
+		return shim.Error("Booking " + booking.ID + " is already " + booking.Status)
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != booking.Guest {
+		house, err := getHouse(APIstub, booking.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := requireOwnerOrManager(APIstub, booking.HouseKey, house, permissionLeases); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	arrival, _ := time.Parse(dayLayout, booking.Start)
+	if !txTime.Before(arrival) {
+		return shim.Error("Booking " + booking.ID + " cannot be cancelled after arrival")
+	}
+
+	if invoker == booking.Guest {
+		booking.Penalty = cancellationPenalty(booking, txTime)
+	}
+	booking.Status = bookingCancelled
+	booking.CancelledBy = invoker
+	booking.CancelledAt = txTime.Format(timeLayout)
+	if err := releaseRentalStay(APIstub, booking.HouseKey, arrival, booking.Nights, booking.ID); err != nil {
+		return shim.Error(err.Error())
+	}
+	bookingAsBytes, err := putBooking(APIstub, booking)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "bookingCancelled", bookingAsBytes)
+	return shim.Success(bookingAsBytes)
+}
+
+/*
+ * queryAvailability tells whether the house is free from the arrival day to the departure day, with the bookings and leases in the way
+ * args: house key, arrival day, departure day (YYYY-MM-DD)
+ */
+func (s *SmartContract) queryAvailability(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	arrival, departure, err := parseStay(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bookings, leases, err := stayConflicts(APIstub, args[0], arrival, departure)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var availability = struct {
+		Available bool      `json:"available"`
+		Bookings  []Booking `json:"bookings"`
+		Leases    []Lease   `json:"leases"`
+	}{Available: len(bookings) == 0 && len(leases) == 0, Bookings: bookings, Leases: leases}
+
+	availabilityAsBytes, _ := json.Marshal(availability)
+	return shim.Success(availabilityAsBytes)
+}
@@ -0,0 +1,391 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Condominium buildings
+ * A building groups the units of a condominium, each unit being a house. Every unit holds a quota
+ * of the shared areas of the building, in basis points, and the quotas of a building add up to the
+ * whole once all its units are recorded. The administrator of the building, the identity that
+ * created it, assesses the co-ownership charges, allocated to the units by quota, and records
+ * their payments so that arrears show per unit.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	buildingObjectType       = "building"
+	houseBuildingObjectType  = "houseBuilding"
+	buildingChargeObjectType = "buildingCharge"
+)
+
+// Define the building structure
+type Building struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Location      string         `json:"location"`
+	Administrator string         `json:"administrator"`
+	SharedAreas   []string       `json:"sharedareas"`
+	Units         []BuildingUnit `json:"units"`
+}
+
+// Define the building unit structure, a house and its quota of the shared areas in basis points
+type BuildingUnit struct {
+	HouseKey string `json:"housekey"`
+	Quota    int    `json:"quota"`
+}
+
+// Define the building charge structure, the part of an assessment due by a unit
+type BuildingCharge struct {
+	BuildingID   string `json:"buildingid"`
+	HouseKey     string `json:"housekey"`
+	AssessmentID string `json:"assessmentid"`
+	Period       string `json:"period"`
+	Purpose      string `json:"purpose"`
+	Amount       int64  `json:"amount"`
+	Paid         int64  `json:"paid"`
+}
+
+func getBuilding(APIstub shim.ChaincodeStubInterface, buildingID string) (Building, error) {
+	buildingKey, err := APIstub.CreateCompositeKey(buildingObjectType, []string{buildingID})
+	if err != nil {
+		return Building{}, err
+	}
+	buildingAsBytes, err := APIstub.GetState(buildingKey)
+	if err != nil {
+		return Building{}, err
+	}
+	if buildingAsBytes == nil {
+		return Building{}, fmt.Errorf("Building %s does not exist", buildingID)
+	}
+	building := Building{}
+	err = json.Unmarshal(buildingAsBytes, &building)
+	return building, err
+}
+
+func putBuilding(APIstub shim.ChaincodeStubInterface, building Building) ([]byte, error) {
+	buildingKey, err := APIstub.CreateCompositeKey(buildingObjectType, []string{building.ID})
+	if err != nil {
+		return nil, err
+	}
+	buildingAsBytes, _ := json.Marshal(building)
+	return buildingAsBytes, APIstub.PutState(buildingKey, buildingAsBytes)
+}
+
+// requireBuildingAdministrator reads the building and returns an error unless the invoker administers it
+func requireBuildingAdministrator(APIstub shim.ChaincodeStubInterface, buildingID string) (Building, error) {
+	building, err := getBuilding(APIstub, buildingID)
+	if err != nil {
+		return building, err
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return building, err
+	}
+	if invoker != building.Administrator {
+		return building, fmt.Errorf("Only the administrator of building %s can do this", buildingID)
+	}
+	return building, nil
+}
+
+func buildingQuotas(building Building) int {
+	total := 0
+	for _, unit := range building.Units {
+		total += unit.Quota
+	}
+	return total
+}
+
+/*
+ * createBuilding creates a condominium building administered by the invoker
+ * args: building ID, name, location, shared areas as a comma separated list
+ */
+func (s *SmartContract) createBuilding(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("Building ID and name must not be empty")
+	}
+	if _, err := getBuilding(APIstub, args[0]); err == nil {
+		return shim.Error("Building " + args[0] + " already exists")
+	}
+	location, err := resolveLocationAlias(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	administrator, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	buildingAsBytes, err := putBuilding(APIstub, Building{
+		ID:            args[0],
+		Name:          args[1],
+		Location:      location,
+		Administrator: administrator,
+		SharedAreas:   splitList(args[3]),
+		Units:         []BuildingUnit{},
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buildingAsBytes)
+}
+
+/*
+ * addBuildingUnit makes the house a unit of the building, for its administrator. A house is a unit of one building at most
+ * args: building ID, house key, quota of the shared areas in basis points
+ */
+func (s *SmartContract) addBuildingUnit(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	quota, err := strconv.Atoi(args[2])
+	if err != nil || quota <= 0 {
+		return shim.Error("Quota must be a positive number of basis points")
+	}
+
+	building, err := requireBuildingAdministrator(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	unitKey, err := APIstub.CreateCompositeKey(houseBuildingObjectType, []string{args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	memberOf, err := APIstub.GetState(unitKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if memberOf != nil {
+		return shim.Error("House " + args[1] + " is already a unit of building " + string(memberOf))
+	}
+	if buildingQuotas(building)+quota > wholeShare {
+		return shim.Error(fmt.Sprintf("Quotas of building %s would exceed %d basis points", building.ID, wholeShare))
+	}
+
+	building.Units = append(building.Units, BuildingUnit{HouseKey: args[1], Quota: quota})
+	buildingAsBytes, err := putBuilding(APIstub, building)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(unitKey, []byte(building.ID)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buildingAsBytes)
+}
+
+/*
+ * assessBuildingCharges allocates co-ownership charges to the units of the building by quota, for the administrator.
+ * The quotas of the building must add up to the whole
+ * args: building ID, period (YYYY-MM), total amount, purpose
+ */
+func (s *SmartContract) assessBuildingCharges(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if _, err := time.Parse(periodLayout, args[1]); err != nil {
+		return shim.Error("Period must be formatted YYYY-MM")
+	}
+	total, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	building, err := requireBuildingAdministrator(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if quotas := buildingQuotas(building); quotas != wholeShare {
+		return shim.Error(fmt.Sprintf("Quotas of building %s add up to %d basis points, not %d", building.ID, quotas, wholeShare))
+	}
+
+	quotas := []OwnershipShare{}
+	for _, unit := range building.Units {
+		quotas = append(quotas, OwnershipShare{Owner: unit.HouseKey, Share: unit.Quota})
+	}
+	charges := []BuildingCharge{}
+	for i, amount := range allocateProRata(quotas, total) {
+		var charge = BuildingCharge{
+			BuildingID:   building.ID,
+			HouseKey:     building.Units[i].HouseKey,
+			AssessmentID: APIstub.GetTxID(),
+			Period:       args[1],
+			Purpose:      args[3],
+			Amount:       amount,
+		}
+		chargeKey, err := APIstub.CreateCompositeKey(buildingChargeObjectType, []string{charge.BuildingID, charge.HouseKey, charge.AssessmentID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		chargeAsBytes, _ := json.Marshal(charge)
+		if err := APIstub.PutState(chargeKey, chargeAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		charges = append(charges, charge)
+	}
+
+	chargesAsBytes, _ := json.Marshal(charges)
+	emitEvent(APIstub, "buildingChargesAssessed", chargesAsBytes)
+	return shim.Success(chargesAsBytes)
+}
+
+/*
+ * recordBuildingChargePayment records a charge payment of a unit, for the administrator
+ * args: building ID, house key, assessment ID, amount
+ */
+func (s *SmartContract) recordBuildingChargePayment(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	amount, err := parseAmount(args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if _, err := requireBuildingAdministrator(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chargeKey, err := APIstub.CreateCompositeKey(buildingChargeObjectType, []string{args[0], args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	chargeAsBytes, err := APIstub.GetState(chargeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if chargeAsBytes == nil {
+		return shim.Error("No charge of assessment " + args[2] + " was allocated to house " + args[1])
+	}
+	charge := BuildingCharge{}
+	if err := json.Unmarshal(chargeAsBytes, &charge); err != nil {
+		return shim.Error(err.Error())
+	}
+	if charge.Paid+amount > charge.Amount {
+		return shim.Error(fmt.Sprintf("Payment exceeds the %d left due", charge.Amount-charge.Paid))
+	}
+
+	charge.Paid += amount
+	chargeAsBytes, _ = json.Marshal(charge)
+	if err := APIstub.PutState(chargeKey, chargeAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(chargeAsBytes)
+}
+
+/*
+ * queryBuildingUnits returns the units of a building with their quota and house record
+ * args: building ID
+ */
+func (s *SmartContract) queryBuildingUnits(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	building, err := getBuilding(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type unitResult struct {
+		Key    string `json:"Key"`
+		Quota  int    `json:"quota"`
+		Record House  `json:"Record"`
+	}
+	units := []unitResult{}
+	for _, unit := range building.Units {
+		house, err := getHouse(APIstub, unit.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		units = append(units, unitResult{Key: unit.HouseKey, Quota: unit.Quota, Record: house})
+	}
+
+	unitsAsBytes, _ := json.Marshal(units)
+	return shim.Success(unitsAsBytes)
+}
+
+/*
+ * queryBuildingArrears lists the charges left unpaid by each unit of a building
+ * args: building ID
+ */
+func (s *SmartContract) queryBuildingArrears(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(buildingChargeObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type unitArrears struct {
+		HouseKey string           `json:"housekey"`
+		Unpaid   []BuildingCharge `json:"unpaid"`
+		Total    int64            `json:"total"`
+	}
+
+	// Charges are keyed by unit, so that the arrears of a unit are contiguous
+	arrears := []unitArrears{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		charge := BuildingCharge{}
+		if err := json.Unmarshal(queryResponse.Value, &charge); err != nil {
+			return shim.Error(err.Error())
+		}
+		if charge.Paid >= charge.Amount {
+			continue
+		}
+		if len(arrears) == 0 || arrears[len(arrears)-1].HouseKey != charge.HouseKey {
+			arrears = append(arrears, unitArrears{HouseKey: charge.HouseKey, Unpaid: []BuildingCharge{}})
+		}
+		last := &arrears[len(arrears)-1]
+		last.Unpaid = append(last.Unpaid, charge)
+		last.Total += charge.Amount - charge.Paid
+	}
+
+	arrearsAsBytes, _ := json.Marshal(arrears)
+	return shim.Success(arrearsAsBytes)
+}
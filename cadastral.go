@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Cadastral references
+ * Every house can carry the reference of its parcel in the land register. The reference is unique:
+ * the "cadastral~key" index holds one entry per reference, and a house cannot be created or given
+ * a reference another house already holds. The reference is an alternate key to look up a house.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const cadastralIndex = "cadastral~key"
+
+var cadastralRefPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9/-]*$`)
+
+// normalizeCadastralRef uppercases a cadastral reference and removes its white space
+func normalizeCadastralRef(ref string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(ref), ""))
+}
+
+func cadastralEntries(house House) [][]string {
+	if house.CadastralRef == "" {
+		return [][]string{}
+	}
+	return [][]string{{house.CadastralRef}}
+}
+
+// cadastralHouseKey returns the key of the house holding the cadastral reference, empty if none
+func cadastralHouseKey(APIstub shim.ChaincodeStubInterface, ref string) (string, error) {
+	keys, err := queryIndexedHouseKeys(APIstub, cadastralIndex, []string{ref})
+	if err != nil || len(keys) == 0 {
+		return "", err
+	}
+	return keys[0], nil
+}
+
+// checkCadastralRef normalizes a cadastral reference and returns an error when it is malformed or held by another house
+func checkCadastralRef(APIstub shim.ChaincodeStubInterface, key string, ref string) (string, error) {
+	ref = normalizeCadastralRef(ref)
+	if !cadastralRefPattern.MatchString(ref) {
+		return "", fmt.Errorf("Invalid cadastral reference %q", ref)
+	}
+	holder, err := cadastralHouseKey(APIstub, ref)
+	if err != nil {
+		return "", err
+	}
+	if holder != "" && holder != key {
+		return "", fmt.Errorf("Cadastral reference %s is already held by house %s", ref, holder)
+	}
+	return ref, nil
+}
+
+/*
+ * setCadastralRef sets the cadastral reference of a house, for registrars
+ * args: house key, cadastral reference
+ */
+func (s *SmartContract) setCadastralRef(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkLocationAuthority(APIstub, house.Location); err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.CadastralRef, err = checkCadastralRef(APIstub, args[0], args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(house.CadastralRef))
+}
+
+/*
+ * queryHouseByCadastralRef returns the house holding a cadastral reference
+ * args: cadastral reference
+ */
+func (s *SmartContract) queryHouseByCadastralRef(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	ref := normalizeCadastralRef(args[0])
+	key, err := cadastralHouseKey(APIstub, ref)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if key == "" {
+		return shim.Error("No house holds cadastral reference " + ref)
+	}
+	results, err := getHouseResults(APIstub, []string{key})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(results[0])
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,28 @@
+'use strict';
+/*
+* SPDX-License-Identifier: Apache-2.0
+*/
+/*
+ * Gives the benchmark houses to a new owner, each house once: the round must not send more
+ * transactions per worker than there are houses
+ * round arguments: contractId, houses (per worker, as created), newOwner
+ */
+
+const { houseKey, HouseWorkload } = require('./houses');
+
+class ChangeHouseOwnerWorkload extends HouseWorkload {
+	constructor() {
+		super('changeHouseOwner');
+	}
+
+	async submitTransaction() {
+		const index = this.txIndex++ % this.houses;
+		await this.send('changeHouseOwner', [houseKey(this.workerIndex, index), this.roundArguments.newOwner, 'gift'], false);
+	}
+}
+
+function createWorkloadModule() {
+	return new ChangeHouseOwnerWorkload();
+}
+
+module.exports.createWorkloadModule = createWorkloadModule;
@@ -0,0 +1,31 @@
+'use strict';
+/*
+* SPDX-License-Identifier: Apache-2.0
+*/
+/*
+ * Creates the benchmark houses, owned by the identity of the benchmark so that changeHouseOwner can transfer them
+ * round arguments: contractId, houses (per worker), owner, locations
+ */
+
+const { houseKey, HouseWorkload } = require('./houses');
+
+class CreateHouseWorkload extends HouseWorkload {
+	constructor() {
+		super('createHouse');
+	}
+
+	async submitTransaction() {
+		const locations = this.roundArguments.locations || ['Bayonne', 'Anglet', 'Biarritz'];
+		const index = this.txIndex++;
+		const year = String(1900 + index % 120);
+		const squareFeets = String(50 + index % 400);
+		await this.send('createHouse', [houseKey(this.workerIndex, index), year, squareFeets,
+			locations[index % locations.length], this.roundArguments.owner], false);
+	}
+}
+
+function createWorkloadModule() {
+	return new CreateHouseWorkload();
+}
+
+module.exports.createWorkloadModule = createWorkloadModule;
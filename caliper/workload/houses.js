@@ -0,0 +1,58 @@
+'use strict';
+/*
+* SPDX-License-Identifier: Apache-2.0
+*/
+/*
+ * Shared helpers of the fabHouse workloads
+ */
+
+const { WorkloadModuleBase } = require('@hyperledger/caliper-core');
+const util = require('util');
+
+// Keys of the benchmark houses, in the range of the houses and distinct per worker
+function houseKey(workerIndex, index) {
+	return 'HOUSE5' + workerIndex + 'B' + index;
+}
+
+// Base of the workloads: counts the requests and sums the sizes of their arguments and responses
+class HouseWorkload extends WorkloadModuleBase {
+	constructor(name) {
+		super();
+		this.name = name;
+		this.txIndex = 0;
+		this.sizes = { requests: 0, args: 0, payloads: 0 };
+	}
+
+	async initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext) {
+		await super.initializeWorkloadModule(workerIndex, totalWorkers, roundIndex, roundArguments, sutAdapter, sutContext);
+		this.contractId = roundArguments.contractId || 'fabcar';
+		this.houses = roundArguments.houses || 100;
+	}
+
+	async send(contractFunction, contractArguments, readOnly) {
+		const result = await this.sutAdapter.sendRequests({
+			contractId: this.contractId,
+			contractFunction: contractFunction,
+			contractArguments: contractArguments,
+			readOnly: readOnly
+		});
+		this.sizes.requests++;
+		this.sizes.args += contractArguments.reduce((size, arg) => size + Buffer.byteLength(arg), 0);
+		const payload = result && result.GetResult ? result.GetResult() : null;
+		if (payload) {
+			this.sizes.payloads += payload.length;
+		}
+		return result;
+	}
+
+	async cleanupWorkloadModule() {
+		if (this.sizes.requests > 0) {
+			console.log(util.format('%s worker %d: %d requests, %d bytes of arguments and %d bytes of payload per request on average',
+				this.name, this.workerIndex, this.sizes.requests,
+				Math.round(this.sizes.args / this.sizes.requests), Math.round(this.sizes.payloads / this.sizes.requests)));
+		}
+	}
+}
+
+module.exports.houseKey = houseKey;
+module.exports.HouseWorkload = HouseWorkload;
@@ -0,0 +1,27 @@
+'use strict';
+/*
+* SPDX-License-Identifier: Apache-2.0
+*/
+/*
+ * Reads the benchmark houses one by one, measuring the decoding of the house records
+ * round arguments: contractId, houses (per worker, as created)
+ */
+
+const { houseKey, HouseWorkload } = require('./houses');
+
+class QueryHouseWorkload extends HouseWorkload {
+	constructor() {
+		super('queryHouse');
+	}
+
+	async submitTransaction() {
+		const index = this.txIndex++ % this.houses;
+		await this.send('queryHouse', [houseKey(this.workerIndex, index)], true);
+	}
+}
+
+function createWorkloadModule() {
+	return new QueryHouseWorkload();
+}
+
+module.exports.createWorkloadModule = createWorkloadModule;
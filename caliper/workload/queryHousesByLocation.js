@@ -0,0 +1,28 @@
+'use strict';
+/*
+* SPDX-License-Identifier: Apache-2.0
+*/
+/*
+ * Reads the houses of a location through the location index, measuring the index layer
+ * round arguments: contractId, locations
+ */
+
+const { HouseWorkload } = require('./houses');
+
+class QueryHousesByLocationWorkload extends HouseWorkload {
+	constructor() {
+		super('queryHousesByLocation');
+	}
+
+	async submitTransaction() {
+		const locations = this.roundArguments.locations || ['Bayonne', 'Anglet', 'Biarritz'];
+		const index = this.txIndex++;
+		await this.send('queryHousesByLocation', [locations[index % locations.length]], true);
+	}
+}
+
+function createWorkloadModule() {
+	return new QueryHousesByLocationWorkload();
+}
+
+module.exports.createWorkloadModule = createWorkloadModule;
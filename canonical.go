@@ -0,0 +1,160 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Input canonicalization
+ * Superficially different spellings of the same input would make endorsements diverge and
+ * fragment the indexes, so every argument is canonicalized before the handlers validate or store it:
+ * it is normalized to Unicode NFC and trimmed of its surrounding white space, and house keys are
+ * uppercased, as keys are in the range of the houses, wherever they are given: as arguments, in the
+ * fields of JSON arguments, or as the target of a tenant pre-emption right. A key a house was stored
+ * under before keys were canonicalized is kept as it is, as long as no house uses its uppercase form,
+ * so that the house stays reachable. The arguments of the functions verifying a signature or a hash
+ * computed on the exact bytes given are passed as they are.
+ */
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Parameters holding house keys, as named in the contract metadata
+var houseKeyParameters = map[string]bool{
+	"houseKey":         true,
+	"houseKeys":        true,
+	"keyOfTheNewHouse": true,
+}
+
+// Fields holding a house key in the JSON arguments, by parameter
+var houseKeyFields = map[string]string{
+	"units": "key",
+	"event": "housekey",
+}
+
+// Functions whose arguments are signed or hashed by the invoker
+var verbatimFunctions = map[string]bool{
+	"attestFact":    true,
+	"verifyReading": true,
+}
+
+// Parameters of every function, for the canonicalization of its arguments
+var functionParameters = func() map[string][]ParameterMetadata {
+	functionParameters := map[string][]ParameterMetadata{}
+	for _, function := range contractFunctions {
+		functionParameters[function.Name] = function.Parameters
+	}
+	return functionParameters
+}()
+
+func canonicalString(value string) string {
+	return strings.TrimSpace(norm.NFC.String(value))
+}
+
+// canonicalHouseKey returns the uppercase form of the key, or the key as it is when only a house stored
+// under it before keys were canonicalized exists
+func canonicalHouseKey(APIstub shim.ChaincodeStubInterface, key string) string {
+	upper := strings.ToUpper(key)
+	if upper == key {
+		return upper
+	}
+	if used, err := houseKeyUsed(APIstub, upper); err != nil || used {
+		return upper
+	}
+	if used, err := houseKeyUsed(APIstub, key); err == nil && used {
+		return key
+	}
+	return upper
+}
+
+// canonicalHouseKeyFields canonicalizes the house key field of the JSON object, or of every object of
+// the JSON array. A malformed argument is left to the handler to reject
+func canonicalHouseKeyFields(APIstub shim.ChaincodeStubInterface, arg string, field string) string {
+	objects := []map[string]json.RawMessage{}
+	isArray := strings.HasPrefix(arg, "[")
+	if isArray {
+		if err := json.Unmarshal([]byte(arg), &objects); err != nil {
+			return arg
+		}
+	} else {
+		object := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(arg), &object); err != nil {
+			return arg
+		}
+		objects = append(objects, object)
+	}
+	for _, object := range objects {
+		for name, value := range object {
+			var key string
+			if !strings.EqualFold(name, field) || json.Unmarshal(value, &key) != nil {
+				continue
+			}
+			object[name], _ = json.Marshal(canonicalHouseKey(APIstub, key))
+		}
+	}
+	var canonical []byte
+	if isArray {
+		canonical, _ = json.Marshal(objects)
+	} else {
+		canonical, _ = json.Marshal(objects[0])
+	}
+	return string(canonical)
+}
+
+// holdsHouseKey tells whether the parameter holds a house key for these arguments
+func holdsHouseKey(function string, parameter ParameterMetadata, args []string) bool {
+	if function == "registerPreemptionRight" && parameter.Name == "locationOrHouseKey" {
+		return canonicalString(args[0]) == preemptionTenant
+	}
+	return houseKeyParameters[parameter.Name]
+}
+
+// canonicalizeArgs returns the canonical form of the arguments of the function
+func canonicalizeArgs(APIstub shim.ChaincodeStubInterface, function string, args []string) []string {
+	if verbatimFunctions[function] {
+		return args
+	}
+	parameters := functionParameters[function]
+	canonical := make([]string, len(args))
+	for i, arg := range args {
+		canonical[i] = canonicalString(arg)
+		if i >= len(parameters) {
+			continue
+		}
+		if field, found := houseKeyFields[parameters[i].Name]; found && parameters[i].Type == "json" {
+			canonical[i] = canonicalHouseKeyFields(APIstub, canonical[i], field)
+			continue
+		}
+		if !holdsHouseKey(function, parameters[i], args) {
+			continue
+		}
+		if parameters[i].Type == "list" {
+			keys := []string{}
+			for _, key := range strings.Split(canonical[i], ",") {
+				keys = append(keys, canonicalHouseKey(APIstub, strings.TrimSpace(key)))
+			}
+			canonical[i] = strings.Join(keys, ",")
+		} else {
+			canonical[i] = canonicalHouseKey(APIstub, canonical[i])
+		}
+	}
+	return canonical
+}
@@ -0,0 +1,67 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Canonicalization tests
+ * House keys given under any parameter, and the houses stored under keys that are not canonical.
+ */
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHouseKeysAreCanonicalizedWhereverGiven(t *testing.T) {
+	ledger := newMockLedger(t)
+	for _, test := range []struct {
+		function string
+		args     []string
+		expected []string
+	}{
+		{"queryHouse", []string{" house1 "}, []string{"HOUSE1"}},
+		{"mergeHouses", []string{"house1, house2", "house3"}, []string{"HOUSE1,HOUSE2", "HOUSE3"}},
+		{"splitHouse", []string{"house1", `[{"key":"house2","squarefeets":"600"},{"Key":"house3","squarefeets":"600"}]`},
+			[]string{"HOUSE1", `[{"key":"HOUSE2","squarefeets":"600"},{"Key":"HOUSE3","squarefeets":"600"}]`}},
+		{"getSubscriptionsMatching", []string{`{"event":"houseCreated","housekey":"house1"}`}, []string{`{"event":"houseCreated","housekey":"HOUSE1"}`}},
+		{"registerPreemptionRight", []string{"tenant", "house1", "bob", "30"}, []string{"tenant", "HOUSE1", "bob", "30"}},
+		{"registerPreemptionRight", []string{"location", "Paris", "bob", "30"}, []string{"location", "Paris", "bob", "30"}},
+	} {
+		if canonical := canonicalizeArgs(ledger.stub, test.function, test.args); !reflect.DeepEqual(canonical, test.expected) {
+			t.Errorf("%s%q canonicalized to %q, expected %q", test.function, test.args, canonical, test.expected)
+		}
+	}
+}
+
+func TestHousesStoredUnderLowercaseKeysStayReachable(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE2", "2004", "1200", "Paris", "alice")
+	// House 2 as stored before keys were canonicalized
+	ledger.stub.State["house2"] = ledger.stub.State["HOUSE2"]
+	delete(ledger.stub.State, "HOUSE2")
+
+	for _, test := range []struct {
+		key   string
+		found bool
+	}{{"house1", true}, {"house2", true}, {"HOUSE2", false}} {
+		if found := ledger.invoke(t, ledger.owner, "queryHouse", test.key) != nil; found != test.found {
+			t.Errorf("queryHouse %s found a house: %v, expected %v", test.key, found, test.found)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Ownership caps
+ * Admins can cap the number of houses, or their total square feets, a single identity or entity
+ * may hold in a location, a share of a house counting as a house. The cap of the location applies,
+ * or the default cap. Caps are enforced when houses are created, renovated or transferred, court ordered
+ * transfers excepted, and the regulator can exempt an identity from the cap of a location.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	ownershipCapObjectType = "ownershipCap"
+	capOverrideObjectType  = "capOverride"
+)
+
+// Location of the cap applying to the locations without a cap of their own
+const defaultCapLocation = "*"
+
+// Define the ownership cap structure. A zero limit does not apply
+type OwnershipCap struct {
+	Location      string `json:"location"`
+	MaxHouses     int    `json:"maxhouses"`
+	MaxSquareFeet int    `json:"maxsquarefeet"`
+}
+
+// Define the holdings structure, what an identity holds in a location
+type Holdings struct {
+	Identity    string `json:"identity"`
+	Houses      int    `json:"houses"`
+	SquareFeets int    `json:"squarefeets"`
+}
+
+func getOwnershipCap(APIstub shim.ChaincodeStubInterface, location string) (OwnershipCap, bool, error) {
+	for _, capLocation := range []string{location, defaultCapLocation} {
+		capKey, err := APIstub.CreateCompositeKey(ownershipCapObjectType, []string{capLocation})
+		if err != nil {
+			return OwnershipCap{}, false, err
+		}
+		capAsBytes, err := APIstub.GetState(capKey)
+		if err != nil {
+			return OwnershipCap{}, false, err
+		}
+		if capAsBytes != nil {
+			ownershipCap := OwnershipCap{}
+			err = json.Unmarshal(capAsBytes, &ownershipCap)
+			return ownershipCap, true, err
+		}
+	}
+	return OwnershipCap{}, false, nil
+}
+
+// exceedsCap tells whether the holdings are over the cap
+func exceedsCap(ownershipCap OwnershipCap, holdings Holdings) bool {
+	return (ownershipCap.MaxHouses > 0 && holdings.Houses > ownershipCap.MaxHouses) ||
+		(ownershipCap.MaxSquareFeet > 0 && holdings.SquareFeets > ownershipCap.MaxSquareFeet)
+}
+
+// parseSquareFeets returns the square feets of a house, which must be a non-negative integer
+func parseSquareFeets(value string) (int, error) {
+	squareFeets, err := strconv.Atoi(value)
+	if err != nil || squareFeets < 0 {
+		return 0, fmt.Errorf("Square feets must be a non-negative number, got %q", value)
+	}
+	return squareFeets, nil
+}
+
+// locationHoldings returns the holdings of every identity in the location, the house of the key excepted
+func locationHoldings(APIstub shim.ChaincodeStubInterface, location string, exceptKey string) (map[string]*Holdings, error) {
+	keys, err := queryIndexedHouseKeys(APIstub, locationIndex, append(locationIndexAttributes(location), ""))
+	if err != nil {
+		return nil, err
+	}
+	holdings := map[string]*Holdings{}
+	for _, key := range keys {
+		if key == exceptKey {
+			continue
+		}
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return nil, err
+		}
+		squareFeets, err := parseSquareFeets(house.SquareFeets)
+		if err != nil {
+			return nil, fmt.Errorf("House %s: %s", key, err.Error())
+		}
+		for _, share := range houseShares(house) {
+			if holdings[share.Owner] == nil {
+				holdings[share.Owner] = &Holdings{Identity: share.Owner}
+			}
+			holdings[share.Owner].Houses++
+			holdings[share.Owner].SquareFeets += squareFeets
+		}
+	}
+	return holdings, nil
+}
+
+func hasCapOverride(APIstub shim.ChaincodeStubInterface, identity string, location string) (bool, error) {
+	overrideKey, err := APIstub.CreateCompositeKey(capOverrideObjectType, []string{location, identity})
+	if err != nil {
+		return false, err
+	}
+	overrideAsBytes, err := APIstub.GetState(overrideKey)
+	return overrideAsBytes != nil, err
+}
+
+// checkOwnershipCaps returns an error when one of the holders would hold more than the cap of the location of the house
+func checkOwnershipCaps(APIstub shim.ChaincodeStubInterface, key string, house House, shares []OwnershipShare) error {
+	location := normalizeLocation(house.Location)
+	ownershipCap, found, err := getOwnershipCap(APIstub, location)
+	if err != nil || !found {
+		return err
+	}
+	holdings, err := locationHoldings(APIstub, location, key)
+	if err != nil {
+		return err
+	}
+
+	squareFeets, err := parseSquareFeets(house.SquareFeets)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		held := Holdings{Identity: share.Owner, Houses: 1, SquareFeets: squareFeets}
+		if previous := holdings[share.Owner]; previous != nil {
+			held.Houses += previous.Houses
+			held.SquareFeets += previous.SquareFeets
+		}
+		if !exceedsCap(ownershipCap, held) {
+			continue
+		}
+		exempt, err := hasCapOverride(APIstub, share.Owner, location)
+		if err != nil {
+			return err
+		}
+		if !exempt {
+			return fmt.Errorf("%s would hold %d houses and %d square feets in %s, over the cap of %d houses and %d square feets",
+				share.Owner, held.Houses, held.SquareFeets, house.Location, ownershipCap.MaxHouses, ownershipCap.MaxSquareFeet)
+		}
+	}
+	return nil
+}
+
+/*
+ * setOwnershipCap caps the holdings of a single identity in a location (* for the default cap), for admins.
+ * Zero limits remove the cap
+ * args: location, maximum number of houses (0 for no limit), maximum total square feets (0 for no limit)
+ */
+func (s *SmartContract) setOwnershipCap(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	location := defaultCapLocation
+	if args[0] != defaultCapLocation {
+		if location = normalizeLocation(args[0]); location == "" {
+			return shim.Error("Location must not be empty")
+		}
+	}
+	maxHouses, err := strconv.Atoi(args[1])
+	if err != nil || maxHouses < 0 {
+		return shim.Error("Maximum number of houses must be a positive number")
+	}
+	maxSquareFeet, err := strconv.Atoi(args[2])
+	if err != nil || maxSquareFeet < 0 {
+		return shim.Error("Maximum square feets must be a positive number")
+	}
+
+	capKey, err := APIstub.CreateCompositeKey(ownershipCapObjectType, []string{location})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if maxHouses == 0 && maxSquareFeet == 0 {
+		if err := APIstub.DelState(capKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	capAsBytes, _ := json.Marshal(OwnershipCap{Location: location, MaxHouses: maxHouses, MaxSquareFeet: maxSquareFeet})
+	if err := APIstub.PutState(capKey, capAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(capAsBytes)
+}
+
+/*
+ * setCapOverride exempts an identity from the ownership cap of a location, or lifts the exemption, for the regulator
+ * args: identity, location, "true" to exempt or "false" to lift the exemption
+ */
+func (s *SmartContract) setCapOverride(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRegulator(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	location := normalizeLocation(args[1])
+	if args[0] == "" || location == "" {
+		return shim.Error("Identity and location must not be empty")
+	}
+	exempt, err := strconv.ParseBool(args[2])
+	if err != nil {
+		return shim.Error("Exemption must be true or false")
+	}
+
+	overrideKey, err := APIstub.CreateCompositeKey(capOverrideObjectType, []string{location, args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if exempt {
+		err = APIstub.PutState(overrideKey, []byte{0x00})
+	} else {
+		err = APIstub.DelState(overrideKey)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * queryOwnershipCapReport returns the identities holding at least a percentage of the cap of a location, by decreasing holdings
+ * args: location, percentage of the cap (e.g. 80)
+ */
+func (s *SmartContract) queryOwnershipCapReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	location := normalizeLocation(args[0])
+	if location == "" {
+		return shim.Error("Location must not be empty")
+	}
+	percentage, err := strconv.Atoi(args[1])
+	if err != nil || percentage <= 0 {
+		return shim.Error("Percentage must be a positive number")
+	}
+
+	ownershipCap, found, err := getOwnershipCap(APIstub, location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No ownership cap applies to " + args[0])
+	}
+	holdings, err := locationHoldings(APIstub, location, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type nearCap struct {
+		Holdings
+		Usage    int  `json:"usage"`
+		Exempt   bool `json:"exempt"`
+		Exceeded bool `json:"exceeded"`
+	}
+	report := []nearCap{}
+	for _, held := range holdings {
+		// Usage is the percentage of the tightest limit
+		usage := 0
+		if ownershipCap.MaxHouses > 0 {
+			usage = held.Houses * 100 / ownershipCap.MaxHouses
+		}
+		if ownershipCap.MaxSquareFeet > 0 && held.SquareFeets*100/ownershipCap.MaxSquareFeet > usage {
+			usage = held.SquareFeets * 100 / ownershipCap.MaxSquareFeet
+		}
+		if usage < percentage {
+			continue
+		}
+		exempt, err := hasCapOverride(APIstub, held.Identity, location)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		report = append(report, nearCap{Holdings: *held, Usage: usage, Exempt: exempt, Exceeded: exceedsCap(ownershipCap, *held)})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Usage != report[j].Usage {
+			return report[i].Usage > report[j].Usage
+		}
+		return report[i].Identity < report[j].Identity
+	})
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
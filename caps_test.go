@@ -0,0 +1,54 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Ownership cap tests
+ * Surfaces of houses checked against the square feet cap of their location.
+ */
+import (
+	"strings"
+	"testing"
+)
+
+func TestSquareFeetMustBeNonNegativeIntegers(t *testing.T) {
+	ledger := newMockLedger(t)
+	for _, squareFeets := range []string{"", "big", "-10", "12.5"} {
+		if message := ledger.refuse(t, ledger.owner, "createHouse", "HOUSE1", "2004", squareFeets, "Paris", "alice"); !strings.Contains(message, "non-negative number") {
+			t.Errorf("createHouse with %q square feets failed with %q", squareFeets, message)
+		}
+	}
+
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	if message := ledger.refuse(t, ledger.owner, "renovateHouse", "HOUSE1", "-1", "residential"); !strings.Contains(message, "non-negative number") {
+		t.Errorf("renovateHouse with negative square feets failed with %q", message)
+	}
+}
+
+func TestRenovationOverTheSquareFeetCapIsRefused(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE2", "2004", "800", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "setOwnershipCap", "Paris", "0", "2500")
+
+	ledger.invoke(t, ledger.owner, "renovateHouse", "HOUSE2", "1300", "residential")
+	if message := ledger.refuse(t, ledger.owner, "renovateHouse", "HOUSE2", "1400", "residential"); !strings.Contains(message, "over the cap") {
+		t.Errorf("Renovation over the cap failed with %q, expected the cap to apply", message)
+	}
+}
@@ -0,0 +1,610 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Defect and insurance claims
+ * Owners file claims on their house against a running warranty of its builder, or against an
+ * insurance policy issued by an insurer. Every claim names its liable party, the builder or the
+ * insurer, and carries the hashes of its evidence. The liable party responds to the claim, the
+ * claim is then adjudicated, by the insurer for an insurance claim and by an arbitrator for a
+ * warranty claim, and the liable party records the payouts of the amount awarded.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	insurancePolicyObjectType = "insurancePolicy"
+	claimObjectType           = "claim"
+	houseClaimIndex           = "key~claim"
+	liablePartyClaimIndex     = "party~claim"
+)
+
+// Bases of the claims
+const (
+	claimWarranty  = "warranty"
+	claimInsurance = "insurance"
+)
+
+// Claim statuses
+const (
+	claimFiled        = "filed"
+	claimAcknowledged = "acknowledged"
+	claimContested    = "contested"
+	claimAdjudicated  = "adjudicated"
+	claimPaid         = "paid"
+)
+
+// Adjudication outcomes, besides outcomeDismissed
+const (
+	outcomeUpheld  = "upheld"
+	outcomePartial = "partial"
+)
+
+// Define the insurance policy structure, issued by an insurer on a house
+type InsurancePolicy struct {
+	ID       string `json:"id"`
+	HouseKey string `json:"housekey"`
+	Insurer  string `json:"insurer"`
+	Cover    int64  `json:"cover"`
+	StartsOn string `json:"startson"`
+	EndsOn   string `json:"endson"`
+	IssuedAt string `json:"issuedat"`
+}
+
+// Define the claim response structure, an answer of the liable party
+type ClaimResponse struct {
+	By          string   `json:"by"`
+	Status      string   `json:"status"`
+	Note        string   `json:"note"`
+	Evidence    []string `json:"evidence,omitempty"`
+	RespondedAt string   `json:"respondedat"`
+}
+
+// Define the claim payout structure
+type ClaimPayout struct {
+	Amount    int64  `json:"amount"`
+	Reference string `json:"reference"`
+	PaidBy    string `json:"paidby"`
+	PaidAt    string `json:"paidat"`
+}
+
+// Define the claim structure. Claims are keyed by claim ID (the filing txID). Coverage is the warranty kind or the policy ID
+type Claim struct {
+	ID            string          `json:"id"`
+	HouseKey      string          `json:"housekey"`
+	Basis         string          `json:"basis"`
+	Coverage      string          `json:"coverage"`
+	LiableParty   string          `json:"liableparty"`
+	Description   string          `json:"description"`
+	ClaimedAmount int64           `json:"claimedamount"`
+	Evidence      []string        `json:"evidence"`
+	FiledBy       string          `json:"filedby"`
+	FiledAt       string          `json:"filedat"`
+	Status        string          `json:"status"`
+	Responses     []ClaimResponse `json:"responses"`
+	Outcome       string          `json:"outcome,omitempty"`
+	AwardedAmount int64           `json:"awardedamount,omitempty"`
+	AdjudicatedBy string          `json:"adjudicatedby,omitempty"`
+	AdjudicatedAt string          `json:"adjudicatedat,omitempty"`
+	Payouts       []ClaimPayout   `json:"payouts"`
+}
+
+func getInsurancePolicy(APIstub shim.ChaincodeStubInterface, id string) (InsurancePolicy, error) {
+	policyKey, err := APIstub.CreateCompositeKey(insurancePolicyObjectType, []string{id})
+	if err != nil {
+		return InsurancePolicy{}, err
+	}
+	policyAsBytes, err := APIstub.GetState(policyKey)
+	if err != nil {
+		return InsurancePolicy{}, err
+	}
+	if policyAsBytes == nil {
+		return InsurancePolicy{}, fmt.Errorf("Insurance policy %s does not exist", id)
+	}
+	policy := InsurancePolicy{}
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+func getClaim(APIstub shim.ChaincodeStubInterface, id string) (Claim, error) {
+	claimKey, err := APIstub.CreateCompositeKey(claimObjectType, []string{id})
+	if err != nil {
+		return Claim{}, err
+	}
+	claimAsBytes, err := APIstub.GetState(claimKey)
+	if err != nil {
+		return Claim{}, err
+	}
+	if claimAsBytes == nil {
+		return Claim{}, fmt.Errorf("Claim %s does not exist", id)
+	}
+	claim := Claim{}
+	err = json.Unmarshal(claimAsBytes, &claim)
+	return claim, err
+}
+
+func putClaim(APIstub shim.ChaincodeStubInterface, claim Claim) ([]byte, error) {
+	claimKey, err := APIstub.CreateCompositeKey(claimObjectType, []string{claim.ID})
+	if err != nil {
+		return nil, err
+	}
+	claimAsBytes, _ := json.Marshal(claim)
+	return claimAsBytes, APIstub.PutState(claimKey, claimAsBytes)
+}
+
+// indexedClaims returns the claims of the house or the liable party, in filing order
+func indexedClaims(APIstub shim.ChaincodeStubInterface, indexName string, attribute string) ([]Claim, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(indexName, []string{attribute})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	claims := []Claim{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		claim, err := getClaim(APIstub, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, claim)
+	}
+	sort.Slice(claims, func(i, j int) bool { return claims[i].FiledAt < claims[j].FiledAt })
+	return claims, nil
+}
+
+// requireLiableParty returns an error unless the invoker answers the claim: the representative of the builder, or the insurer
+func requireLiableParty(APIstub shim.ChaincodeStubInterface, claim Claim) (string, error) {
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return "", err
+	}
+	party := claim.LiableParty
+	if claim.Basis == claimWarranty {
+		builder, err := getBuilder(APIstub, claim.LiableParty)
+		if err != nil {
+			return "", err
+		}
+		party = builder.Representative
+	}
+	if invokerID != party {
+		return "", fmt.Errorf("Access denied. Only %s can answer claim %s", party, claim.ID)
+	}
+	return invokerID, nil
+}
+
+// fileClaim records a new claim of the owner of the house on the coverage
+func fileClaim(APIstub shim.ChaincodeStubInterface, claim Claim, evidence string) sc.Response {
+	house, err := getHouse(APIstub, claim.HouseKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, claim.HouseKey, house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if claim.Description == "" {
+		return shim.Error("Description must not be empty")
+	}
+	if claim.FiledBy, err = getInvokerID(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	claim.ID = APIstub.GetTxID()
+	claim.Evidence = splitList(evidence)
+	claim.Status = claimFiled
+	claim.Responses = []ClaimResponse{}
+	claim.Payouts = []ClaimPayout{}
+	claimAsBytes, err := putClaim(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, index := range [][]string{{houseClaimIndex, claim.HouseKey}, {liablePartyClaimIndex, claim.LiableParty}} {
+		indexKey, err := APIstub.CreateCompositeKey(index[0], []string{index[1], claim.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	emitEvent(APIstub, "claimFiled", claimAsBytes)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * issueInsurancePolicy records an insurance policy on a house, for insurers. The invoker is the insurer
+ * args: policy ID, house key, cover amount, first day, last day (YYYY-MM-DD)
+ */
+func (s *SmartContract) issueInsurancePolicy(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	if err := requireRole(APIstub, roleInsurer); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Policy ID must not be empty")
+	}
+	if _, err := getHouse(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	cover, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startsOn, err := time.Parse(dayLayout, args[3])
+	if err != nil {
+		return shim.Error("First day must be formatted YYYY-MM-DD")
+	}
+	endsOn, err := time.Parse(dayLayout, args[4])
+	if err != nil {
+		return shim.Error("Last day must be formatted YYYY-MM-DD")
+	}
+	if endsOn.Before(startsOn) {
+		return shim.Error("Last day must not be before the first day")
+	}
+
+	policyKey, err := APIstub.CreateCompositeKey(insurancePolicyObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(policyKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("Insurance policy " + args[0] + " already exists")
+	}
+	insurer, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	issuedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, _ := json.Marshal(InsurancePolicy{
+		ID:       args[0],
+		HouseKey: args[1],
+		Insurer:  insurer,
+		Cover:    cover,
+		StartsOn: args[3],
+		EndsOn:   args[4],
+		IssuedAt: issuedAt.Format(timeLayout),
+	})
+	if err := APIstub.PutState(policyKey, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+/*
+ * fileWarrantyClaim files a claim against the builder of a house under one of its running warranties,
+ * only the owner can do it
+ * args: house key, warranty (completion, equipment or structural), description of the defect, claimed amount, evidence hashes
+ */
+func (s *SmartContract) fileWarrantyClaim(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	amount, err := parseAmount(args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	link, _, err := runningWarranty(APIstub, args[0], args[1], txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return fileClaim(APIstub, Claim{
+		HouseKey:      args[0],
+		Basis:         claimWarranty,
+		Coverage:      args[1],
+		LiableParty:   link.Builder,
+		Description:   args[2],
+		ClaimedAmount: amount,
+		FiledAt:       txTime.Format(timeLayout),
+	}, args[4])
+}
+
+/*
+ * fileInsuranceClaim files a claim against the insurer of a house under a running policy, only the owner can do it
+ * args: policy ID, description of the loss, claimed amount, evidence hashes
+ */
+func (s *SmartContract) fileInsuranceClaim(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	amount, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := getInsurancePolicy(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount > policy.Cover {
+		return shim.Error(fmt.Sprintf("Claimed amount exceeds the cover of %d of policy %s", policy.Cover, policy.ID))
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if today := txTime.Format(dayLayout); today < policy.StartsOn || today > policy.EndsOn {
+		return shim.Error("Insurance policy " + policy.ID + " runs from " + policy.StartsOn + " to " + policy.EndsOn)
+	}
+
+	return fileClaim(APIstub, Claim{
+		HouseKey:      policy.HouseKey,
+		Basis:         claimInsurance,
+		Coverage:      policy.ID,
+		LiableParty:   policy.Insurer,
+		Description:   args[1],
+		ClaimedAmount: amount,
+		FiledAt:       txTime.Format(timeLayout),
+	}, args[3])
+}
+
+// addClaimEvidence adds evidence hashes to a claim not adjudicated yet, only its claimant can do it. args: claim ID, evidence hashes
+func (s *SmartContract) addClaimEvidence(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	claim, err := getClaim(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invokerID != claim.FiledBy {
+		return shim.Error("Access denied. Only the claimant can add evidence to claim " + claim.ID)
+	}
+	if claim.Status == claimAdjudicated || claim.Status == claimPaid {
+		return shim.Error("Claim " + claim.ID + " is already adjudicated")
+	}
+	claim.Evidence = append(claim.Evidence, splitList(args[1])...)
+	claimAsBytes, err := putClaim(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * respondToClaim records the answer of the liable party to a claim not adjudicated yet
+ * args: claim ID, status (acknowledged or contested), note, evidence hashes
+ */
+func (s *SmartContract) respondToClaim(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[1] != claimAcknowledged && args[1] != claimContested {
+		return shim.Error("Status must be " + claimAcknowledged + " or " + claimContested)
+	}
+
+	claim, err := getClaim(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	respondent, err := requireLiableParty(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if claim.Status == claimAdjudicated || claim.Status == claimPaid {
+		return shim.Error("Claim " + claim.ID + " is already adjudicated")
+	}
+	respondedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	claim.Status = args[1]
+	claim.Responses = append(claim.Responses, ClaimResponse{
+		By:          respondent,
+		Status:      args[1],
+		Note:        args[2],
+		Evidence:    splitList(args[3]),
+		RespondedAt: respondedAt.Format(timeLayout),
+	})
+	claimAsBytes, err := putClaim(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "claimResponded", claimAsBytes)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * adjudicateClaim decides a claim: by its insurer for an insurance claim, by an arbitrator for a warranty claim
+ * args: claim ID, outcome (upheld, partial or dismissed), amount awarded by a partial outcome
+ */
+func (s *SmartContract) adjudicateClaim(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	claim, err := getClaim(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if claim.Basis == claimInsurance {
+		if err := requireRole(APIstub, roleInsurer); err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err := requireLiableParty(APIstub, claim); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if err := requireRole(APIstub, roleArbitrator); err != nil {
+		return shim.Error(err.Error())
+	}
+	if claim.Status == claimAdjudicated || claim.Status == claimPaid {
+		return shim.Error("Claim " + claim.ID + " is already adjudicated")
+	}
+
+	awarded, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || awarded < 0 || awarded > claim.ClaimedAmount {
+		return shim.Error(fmt.Sprintf("Awarded amount must be a number from 0 to the claimed %d", claim.ClaimedAmount))
+	}
+	switch args[1] {
+	case outcomeUpheld:
+		awarded = claim.ClaimedAmount
+	case outcomePartial:
+		if awarded == 0 || awarded == claim.ClaimedAmount {
+			return shim.Error("A partial award is between 0 and the claimed amount")
+		}
+	case outcomeDismissed:
+		awarded = 0
+	default:
+		return shim.Error("Outcome must be " + outcomeUpheld + ", " + outcomePartial + " or " + outcomeDismissed)
+	}
+
+	if claim.AdjudicatedBy, err = getInvokerID(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	adjudicatedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	claim.Status = claimAdjudicated
+	claim.Outcome = args[1]
+	claim.AwardedAmount = awarded
+	claim.AdjudicatedAt = adjudicatedAt.Format(timeLayout)
+	claimAsBytes, err := putClaim(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "claimAdjudicated", claimAsBytes)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * recordClaimPayout records a payment of the amount awarded by a claim, for its liable party.
+ * The claim is paid once the payouts reach the awarded amount
+ * args: claim ID, amount, payment reference
+ */
+func (s *SmartContract) recordClaimPayout(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	claim, err := getClaim(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payer, err := requireLiableParty(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if claim.Status != claimAdjudicated {
+		return shim.Error("Claim " + claim.ID + " has no outstanding award")
+	}
+	paid := int64(0)
+	for _, payout := range claim.Payouts {
+		paid += payout.Amount
+	}
+	if paid+amount > claim.AwardedAmount {
+		return shim.Error(fmt.Sprintf("Payout exceeds the %d outstanding on claim %s", claim.AwardedAmount-paid, claim.ID))
+	}
+
+	paidAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	claim.Payouts = append(claim.Payouts, ClaimPayout{Amount: amount, Reference: args[2], PaidBy: payer, PaidAt: paidAt.Format(timeLayout)})
+	if paid+amount == claim.AwardedAmount {
+		claim.Status = claimPaid
+	}
+	claimAsBytes, err := putClaim(APIstub, claim)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "claimPaid", claimAsBytes)
+	return shim.Success(claimAsBytes)
+}
+
+// queryHouseClaims returns the claims filed on a house. args: house key
+func (s *SmartContract) queryHouseClaims(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	claims, err := indexedClaims(APIstub, houseClaimIndex, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	claimsAsBytes, _ := json.Marshal(claims)
+	return shim.Success(claimsAsBytes)
+}
+
+// queryLiablePartyClaims returns the claims filed against a builder or an insurer. args: builder ID or insurer
+func (s *SmartContract) queryLiablePartyClaims(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	claims, err := indexedClaims(APIstub, liablePartyClaimIndex, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	claimsAsBytes, _ := json.Marshal(claims)
+	return shim.Success(claimsAsBytes)
+}
@@ -0,0 +1,388 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* House encoding
+ * Houses are stored either as JSON (the original encoding) or as protobuf, described by house.proto.
+ * New records are written with the codec selected by an admin, and records are decoded according
+ * to their content: JSON records always start with '{', which never starts a protobuf House.
+ *
+ * The protobuf codec follows the `protobuf:"N"` field number tags of the structures, so a new
+ * House field only needs a tag (and its line in house.proto) to be encoded.
+ */
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const houseCodecKey = "CONFIG_HOUSECODEC"
+
+// Codec names
+const (
+	codecJSON     = "json"
+	codecProtobuf = "protobuf"
+)
+
+// Define the codec interface, turning houses into state values and back
+type houseCodec interface {
+	Marshal(house House) ([]byte, error)
+	Unmarshal(houseAsBytes []byte, house *House) error
+}
+
+var houseCodecs = map[string]houseCodec{
+	codecJSON:     jsonHouseCodec{},
+	codecProtobuf: protobufHouseCodec{},
+}
+
+type jsonHouseCodec struct{}
+
+func (jsonHouseCodec) Marshal(house House) ([]byte, error) {
+	return json.Marshal(house)
+}
+
+func (jsonHouseCodec) Unmarshal(houseAsBytes []byte, house *House) error {
+	return json.Unmarshal(houseAsBytes, house)
+}
+
+type protobufHouseCodec struct{}
+
+func (protobufHouseCodec) Marshal(house House) ([]byte, error) {
+	return marshalProtobuf(reflect.ValueOf(house))
+}
+
+func (protobufHouseCodec) Unmarshal(houseAsBytes []byte, house *House) error {
+	return unmarshalProtobuf(houseAsBytes, reflect.ValueOf(house).Elem())
+}
+
+// detectHouseCodec returns the name of the codec a stored house was written with
+func detectHouseCodec(houseAsBytes []byte) string {
+	trimmed := bytes.TrimLeft(houseAsBytes, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return codecJSON
+	}
+	return codecProtobuf
+}
+
+// getHouseCodec returns the name of the codec new houses are written with
+func getHouseCodec(APIstub shim.ChaincodeStubInterface) (string, error) {
+	codecAsBytes, err := APIstub.GetState(houseCodecKey)
+	if err != nil {
+		return "", err
+	}
+	if codecAsBytes == nil {
+		return codecJSON, nil
+	}
+	return string(codecAsBytes), nil
+}
+
+// decodeHouse reads a stored house, whatever its encoding
+func decodeHouse(houseAsBytes []byte) (House, error) {
+	house := House{}
+	err := houseCodecs[detectHouseCodec(houseAsBytes)].Unmarshal(houseAsBytes, &house)
+	return house, err
+}
+
+// encodeHouse encodes the house with the configured codec
+func encodeHouse(APIstub shim.ChaincodeStubInterface, house House) ([]byte, error) {
+	codecName, err := getHouseCodec(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	return houseCodecs[codecName].Marshal(house)
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Define the protobuf field structure, a struct field along with its protobuf field number
+type protobufField struct {
+	number int
+	index  int
+}
+
+// protobufFields lists the tagged fields of a structure type, by increasing field number
+func protobufFields(structType reflect.Type) []protobufField {
+	fields := []protobufField{}
+	for i := 0; i < structType.NumField(); i++ {
+		tag := structType.Field(i).Tag.Get("protobuf")
+		if tag == "" {
+			continue
+		}
+		number, err := strconv.Atoi(tag)
+		if err != nil {
+			continue
+		}
+		fields = append(fields, protobufField{number: number, index: i})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].number < fields[j].number })
+	return fields
+}
+
+func appendTag(buffer []byte, number int, wireType int) []byte {
+	return binary.AppendUvarint(buffer, uint64(number)<<3|uint64(wireType))
+}
+
+func appendBytes(buffer []byte, number int, value []byte) []byte {
+	buffer = appendTag(buffer, number, wireBytes)
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// marshalProtobuf encodes a structure, omitting zero values as proto3 does
+func marshalProtobuf(message reflect.Value) ([]byte, error) {
+	buffer := []byte{}
+	for _, field := range protobufFields(message.Type()) {
+		value := message.Field(field.index)
+		var err error
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len() && err == nil; i++ {
+				buffer, err = appendProtobufValue(buffer, field.number, value.Index(i), true)
+			}
+		} else {
+			buffer, err = appendProtobufValue(buffer, field.number, value, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buffer, nil
+}
+
+// appendProtobufValue encodes one value of a field, repeated values being encoded even when zero
+func appendProtobufValue(buffer []byte, number int, value reflect.Value, repeated bool) ([]byte, error) {
+	if !repeated && value.IsZero() {
+		return buffer, nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return appendBytes(buffer, number, []byte(value.String())), nil
+	case reflect.Bool:
+		buffer = appendTag(buffer, number, wireVarint)
+		return binary.AppendUvarint(buffer, 1), nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		buffer = appendTag(buffer, number, wireVarint)
+		return binary.AppendUvarint(buffer, uint64(value.Int())), nil
+	case reflect.Float64:
+		buffer = appendTag(buffer, number, wireFixed64)
+		return binary.LittleEndian.AppendUint64(buffer, math.Float64bits(value.Float())), nil
+	case reflect.Struct:
+		nested, err := marshalProtobuf(value)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(buffer, number, nested), nil
+	case reflect.Ptr:
+		return appendProtobufValue(buffer, number, value.Elem(), true)
+	}
+	return nil, fmt.Errorf("Cannot encode %s as protobuf", value.Type())
+}
+
+// unmarshalProtobuf decodes a structure, skipping the fields it does not know
+func unmarshalProtobuf(data []byte, message reflect.Value) error {
+	fields := map[int]int{}
+	for _, field := range protobufFields(message.Type()) {
+		fields[field.number] = field.index
+	}
+
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("Malformed protobuf tag")
+		}
+		data = data[n:]
+		number, wireType := int(tag>>3), int(tag&7)
+
+		var varint uint64
+		var payload []byte
+		switch wireType {
+		case wireVarint:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("Malformed protobuf varint")
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("Malformed protobuf fixed64")
+			}
+			varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("Malformed protobuf fixed32")
+			}
+			varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("Malformed protobuf length")
+			}
+			payload, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return fmt.Errorf("Unsupported protobuf wire type %d", wireType)
+		}
+
+		index, known := fields[number]
+		if !known {
+			continue
+		}
+		value := message.Field(index)
+		if value.Kind() == reflect.Slice {
+			element := reflect.New(value.Type().Elem()).Elem()
+			if err := setProtobufValue(element, varint, payload); err != nil {
+				return err
+			}
+			value.Set(reflect.Append(value, element))
+		} else if err := setProtobufValue(value, varint, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setProtobufValue(value reflect.Value, varint uint64, payload []byte) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(string(payload))
+	case reflect.Bool:
+		value.SetBool(varint != 0)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		value.SetInt(int64(varint))
+	case reflect.Float64:
+		value.SetFloat(math.Float64frombits(varint))
+	case reflect.Struct:
+		return unmarshalProtobuf(payload, value)
+	case reflect.Ptr:
+		value.Set(reflect.New(value.Type().Elem()))
+		return setProtobufValue(value.Elem(), varint, payload)
+	default:
+		return fmt.Errorf("Cannot decode protobuf into %s", value.Type())
+	}
+	return nil
+}
+
+/*
+ * setHouseCodec selects the encoding of the houses written from now on
+ * args: codec name ("json" or "protobuf")
+ */
+func (s *SmartContract) setHouseCodec(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, found := houseCodecs[args[0]]; !found {
+		return shim.Error("Codec must be \"" + codecJSON + "\" or \"" + codecProtobuf + "\"")
+	}
+
+	if err := APIstub.PutState(houseCodecKey, []byte(args[0])); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * migrateHouseEncoding rewrites a chunk of houses with the configured codec
+ * args: first key to migrate (empty to start from the first house), maximum number of houses to scan
+ */
+func (s *SmartContract) migrateHouseEncoding(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+	startKey := args[0]
+	if startKey == "" {
+		startKey = houseStartKey
+	}
+
+	codecName, err := getHouseCodec(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(startKey, houseEndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result = struct {
+		Scanned  int    `json:"scanned"`
+		Migrated int    `json:"migrated"`
+		NextKey  string `json:"nextkey"`
+	}{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// The first house beyond the chunk is where the next chunk starts
+		if result.Scanned == pageSize {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		if detectHouseCodec(queryResponse.Value) == codecName {
+			continue
+		}
+		house, err := decodeHouse(queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		houseAsBytes, err := houseCodecs[codecName].Marshal(house)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.PutState(queryResponse.Key, houseAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,93 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Response compression
+ * The bulk queries accept an optional trailing argument naming the encoding of their response.
+ * With "gzip", the payload is replaced by an envelope holding the compressed payload along with
+ * its sizes, to cut the size of the proposal responses. The output of compress/gzip only depends
+ * on its input, so every endorser returns the same envelope.
+ */
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Response encodings
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+)
+
+// Functions whose response can be compressed, with their number of arguments before the encoding
+var compressibleFunctions = map[string]int{
+	"queryAllHouses":  0,
+	"exportAllHouses": 2,
+}
+
+// Define the compressed payload structure, Data is encoded in base64 by encoding/json
+type CompressedPayload struct {
+	Encoding       string `json:"encoding"`
+	Size           int    `json:"size"`
+	CompressedSize int    `json:"compressedsize"`
+	Data           []byte `json:"data"`
+}
+
+// negotiateEncoding strips the encoding argument of a compressible function, returning the arguments left and the encoding
+func negotiateEncoding(function string, args []string) ([]string, string, error) {
+	count, compressible := compressibleFunctions[function]
+	if !compressible || len(args) != count+1 {
+		return args, encodingIdentity, nil
+	}
+	encoding := args[count]
+	if encoding != encodingIdentity && encoding != encodingGzip {
+		return nil, "", fmt.Errorf("Encoding must be %q or %q", encodingIdentity, encodingGzip)
+	}
+	return args[:count], encoding, nil
+}
+
+// encodeResponse compresses the payload of a successful response with the encoding
+func encodeResponse(response sc.Response, encoding string) sc.Response {
+	if encoding != encodingGzip || response.Status >= shim.ERRORTHRESHOLD {
+		return response
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(response.Payload); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := writer.Close(); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payloadAsBytes, _ := json.Marshal(CompressedPayload{
+		Encoding:       encodingGzip,
+		Size:           len(response.Payload),
+		CompressedSize: buffer.Len(),
+		Data:           buffer.Bytes(),
+	})
+	return shim.Success(payloadAsBytes)
+}
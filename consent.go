@@ -0,0 +1,220 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Consent
+ * Owners grant organisations (MSPs) the consent to read their personal details, and can revoke
+ * it at any time. Every read of the details by another identity than the owner checks the consent
+ * of the reader's MSP and leaves an access record, kept when the read is submitted as a transaction.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	consentObjectType       = "consent"
+	accessRecordObjectType  = "privateAccess"
+	consentPurposeOwnerData = "ownerDetails"
+)
+
+// Define the consent structure, the consent of an owner for an MSP to read its details
+type Consent struct {
+	Owner     string `json:"owner"`
+	MSPID     string `json:"mspid"`
+	GrantedAt string `json:"grantedat"`
+}
+
+// Define the access record structure, one read of the personal details of an owner
+type AccessRecord struct {
+	Owner      string `json:"owner"`
+	Reader     string `json:"reader"`
+	MSPID      string `json:"mspid"`
+	Purpose    string `json:"purpose"`
+	AccessedAt string `json:"accessedat"`
+	TxID       string `json:"txid"`
+}
+
+// checkConsent returns an error unless the invoker is the owner, or its MSP has the consent of the owner.
+// An access record is written for every read by another identity than the owner
+func checkConsent(APIstub shim.ChaincodeStubInterface, owner string, purpose string) error {
+	reader, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if reader == owner {
+		return nil
+	}
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+
+	consentKey, err := APIstub.CreateCompositeKey(consentObjectType, []string{owner, mspID})
+	if err != nil {
+		return err
+	}
+	consentAsBytes, err := APIstub.GetState(consentKey)
+	if err != nil {
+		return err
+	}
+	if consentAsBytes == nil {
+		return fmt.Errorf("%s has not consented to %s reading its personal details", owner, mspID)
+	}
+
+	accessedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	var record = AccessRecord{
+		Owner:      owner,
+		Reader:     reader,
+		MSPID:      mspID,
+		Purpose:    purpose,
+		AccessedAt: accessedAt.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+	recordKey, err := APIstub.CreateCompositeKey(accessRecordObjectType, []string{owner, record.TxID})
+	if err != nil {
+		return err
+	}
+	recordAsBytes, _ := json.Marshal(record)
+	return APIstub.PutState(recordKey, recordAsBytes)
+}
+
+/*
+ * grantConsent lets the members of an MSP read the personal details of the invoker
+ * args: MSP ID
+ */
+func (s *SmartContract) grantConsent(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if args[0] == "" {
+		return shim.Error("MSP ID must not be empty")
+	}
+
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	consentKey, err := APIstub.CreateCompositeKey(consentObjectType, []string{owner, args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consentAsBytes, _ := json.Marshal(Consent{Owner: owner, MSPID: args[0], GrantedAt: grantedAt.Format(timeLayout)})
+	if err := APIstub.PutState(consentKey, consentAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(consentAsBytes)
+}
+
+/*
+ * revokeConsent withdraws the consent given to an MSP by the invoker
+ * args: MSP ID
+ */
+func (s *SmartContract) revokeConsent(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consentKey, err := APIstub.CreateCompositeKey(consentObjectType, []string{owner, args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consentAsBytes, err := APIstub.GetState(consentKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if consentAsBytes == nil {
+		return shim.Error(owner + " has not consented to " + args[0])
+	}
+	if err := APIstub.DelState(consentKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryMyConsents lists the consents given by the invoker, with the access records of its details
+func (s *SmartContract) queryMyConsents(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result = struct {
+		Consents []Consent      `json:"consents"`
+		Accesses []AccessRecord `json:"accesses"`
+	}{Consents: []Consent{}, Accesses: []AccessRecord{}}
+
+	consentsIterator, err := APIstub.GetStateByPartialCompositeKey(consentObjectType, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer consentsIterator.Close()
+	for consentsIterator.HasNext() {
+		queryResponse, err := consentsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		consent := Consent{}
+		if err := json.Unmarshal(queryResponse.Value, &consent); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Consents = append(result.Consents, consent)
+	}
+
+	accessesIterator, err := APIstub.GetStateByPartialCompositeKey(accessRecordObjectType, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer accessesIterator.Close()
+	for accessesIterator.HasNext() {
+		queryResponse, err := accessesIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		record := AccessRecord{}
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Accesses = append(result.Accesses, record)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
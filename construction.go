@@ -0,0 +1,329 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Construction projects
+ * Houses under construction are registered with their developer as owner, and progress through
+ * the permit, foundation and completion milestones, each attested by an inspector. The developer
+ * can sell such a house off-plan: the sale is recorded as a conditional transfer, completed
+ * automatically when the completion milestone is recorded.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	constructionProjectObjectType = "constructionProject"
+	offPlanSaleObjectType         = "offPlanSale"
+)
+
+// Milestones of a construction project, in the order they are attested
+var constructionMilestones = []string{"permit", "foundation", "completion"}
+
+// Construction project statuses
+const (
+	constructionUnderway  = "underConstruction"
+	constructionCompleted = "completed"
+)
+
+// Define the milestone structure, attested by an inspector
+type Milestone struct {
+	Name       string `json:"name"`
+	ReportHash string `json:"reporthash"`
+	AttestedBy string `json:"attestedby"`
+	AttestedAt string `json:"attestedat"`
+}
+
+// Define the construction project structure, at most one per house
+type ConstructionProject struct {
+	HouseKey     string      `json:"housekey"`
+	Developer    string      `json:"developer"`
+	Milestones   []Milestone `json:"milestones"`
+	Status       string      `json:"status"`
+	RegisteredAt string      `json:"registeredat"`
+}
+
+// Define the off-plan sale structure, the conditional transfer of a house under construction
+type OffPlanSale struct {
+	HouseKey string `json:"housekey"`
+	Seller   string `json:"seller"`
+	Buyer    string `json:"buyer"`
+	Price    int64  `json:"price"`
+	AgreedAt string `json:"agreedat"`
+	TxID     string `json:"txid"`
+}
+
+func getConstructionProject(APIstub shim.ChaincodeStubInterface, key string) (ConstructionProject, error) {
+	projectKey, err := APIstub.CreateCompositeKey(constructionProjectObjectType, []string{key})
+	if err != nil {
+		return ConstructionProject{}, err
+	}
+	projectAsBytes, err := APIstub.GetState(projectKey)
+	if err != nil {
+		return ConstructionProject{}, err
+	}
+	if projectAsBytes == nil {
+		return ConstructionProject{}, fmt.Errorf("Construction project %s does not exist", key)
+	}
+	project := ConstructionProject{}
+	err = json.Unmarshal(projectAsBytes, &project)
+	return project, err
+}
+
+func putConstructionProject(APIstub shim.ChaincodeStubInterface, project ConstructionProject) ([]byte, error) {
+	projectKey, err := APIstub.CreateCompositeKey(constructionProjectObjectType, []string{project.HouseKey})
+	if err != nil {
+		return nil, err
+	}
+	projectAsBytes, _ := json.Marshal(project)
+	return projectAsBytes, APIstub.PutState(projectKey, projectAsBytes)
+}
+
+// getOffPlanSale returns the off-plan sale of the house, nil if none
+func getOffPlanSale(APIstub shim.ChaincodeStubInterface, key string) (*OffPlanSale, error) {
+	saleKey, err := APIstub.CreateCompositeKey(offPlanSaleObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	saleAsBytes, err := APIstub.GetState(saleKey)
+	if err != nil || saleAsBytes == nil {
+		return nil, err
+	}
+	sale := OffPlanSale{}
+	if err := json.Unmarshal(saleAsBytes, &sale); err != nil {
+		return nil, err
+	}
+	return &sale, nil
+}
+
+/*
+ * registerConstructionProject registers a house under construction, owned by its developer
+ * args: house key, year, square feets, location, developer, [usage], [zone], [cadastral reference]
+ */
+func (s *SmartContract) registerConstructionProject(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 5 || len(args) > 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 5 to 8")
+	}
+
+	houseAsBytes, err := APIstub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if houseAsBytes != nil {
+		return shim.Error("House " + args[0] + " already exists")
+	}
+	if response := s.createHouse(APIstub, args); response.Status >= shim.ERRORTHRESHOLD {
+		return response
+	}
+
+	registeredAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	projectAsBytes, err := putConstructionProject(APIstub, ConstructionProject{
+		HouseKey:     args[0],
+		Developer:    args[4],
+		Milestones:   []Milestone{},
+		Status:       constructionUnderway,
+		RegisteredAt: registeredAt.Format(timeLayout),
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(projectAsBytes)
+}
+
+/*
+ * recordConstructionMilestone records the next milestone of a construction project, for inspectors.
+ * Recording the completion completes the off-plan sale of the house
+ * args: house key, milestone (permit, foundation or completion), hash of the inspection report
+ */
+func (s *SmartContract) recordConstructionMilestone(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleInspector); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[2] == "" {
+		return shim.Error("Report hash must not be empty")
+	}
+
+	project, err := getConstructionProject(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if project.Status != constructionUnderway {
+		return shim.Error("Construction of house " + args[0] + " is " + project.Status)
+	}
+	next := constructionMilestones[len(project.Milestones)]
+	if args[1] != next {
+		return shim.Error("The next milestone of house " + args[0] + " is " + next)
+	}
+
+	inspector, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	attestedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	project.Milestones = append(project.Milestones, Milestone{Name: args[1], ReportHash: args[2], AttestedBy: inspector, AttestedAt: attestedAt.Format(timeLayout)})
+	if len(project.Milestones) == len(constructionMilestones) {
+		project.Status = constructionCompleted
+	}
+	projectAsBytes, err := putConstructionProject(APIstub, project)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	emitEvent(APIstub, "constructionMilestoneRecorded", projectAsBytes)
+
+	if project.Status == constructionCompleted {
+		if err := completeOffPlanSale(APIstub, args[0]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(projectAsBytes)
+}
+
+// completeOffPlanSale transfers the house sold off-plan to its buyer. The sale lapses if the seller no longer owns the house
+func completeOffPlanSale(APIstub shim.ChaincodeStubInterface, key string) error {
+	sale, err := getOffPlanSale(APIstub, key)
+	if err != nil || sale == nil {
+		return err
+	}
+	saleKey, err := APIstub.CreateCompositeKey(offPlanSaleObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := APIstub.DelState(saleKey); err != nil {
+		return err
+	}
+
+	house, err := getHouse(APIstub, key)
+	if err != nil {
+		return err
+	}
+	if house.Owner != sale.Seller {
+		logFor(APIstub).Warnf("Off-plan sale of house %s lapsed, %s no longer owns it", key, sale.Seller)
+		return nil
+	}
+	_, err = queueOrExecuteTransfer(APIstub, key, house, sale.Buyer, reasonSale, sale.Price)
+	return err
+}
+
+/*
+ * sellOffPlan records the sale of a house under construction, only the owner can do it. The house is
+ * transferred to the buyer when its completion is recorded
+ * args: house key, buyer, price
+ */
+func (s *SmartContract) sellOffPlan(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	price, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	project, err := getConstructionProject(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if project.Status != constructionUnderway {
+		return shim.Error("House " + args[0] + " is no longer under construction")
+	}
+	existing, err := getOffPlanSale(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("House " + args[0] + " is already sold off-plan to " + existing.Buyer)
+	}
+	// Fail early, the checks are run again on completion
+	if err := checkTransferAllowed(APIstub, args[0], house, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	agreedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var sale = OffPlanSale{
+		HouseKey: args[0],
+		Seller:   house.Owner,
+		Buyer:    args[1],
+		Price:    price,
+		AgreedAt: agreedAt.Format(timeLayout),
+		TxID:     APIstub.GetTxID(),
+	}
+	saleKey, err := APIstub.CreateCompositeKey(offPlanSaleObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	saleAsBytes, _ := json.Marshal(sale)
+	if err := APIstub.PutState(saleKey, saleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "offPlanSaleAgreed", saleAsBytes)
+	return shim.Success(saleAsBytes)
+}
+
+// queryConstructionProject returns the construction project of a house and its off-plan sale. args: house key
+func (s *SmartContract) queryConstructionProject(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	project, err := getConstructionProject(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sale, err := getOffPlanSale(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result = struct {
+		ConstructionProject
+		OffPlanSale *OffPlanSale `json:"offplansale,omitempty"`
+	}{project, sale}
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
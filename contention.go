@@ -0,0 +1,259 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Conflict diagnostics
+ * Transactions racing on a house fail with a generic MVCC_READ_CONFLICT the contract never sees,
+ * the peer invalidates them after the chaincode ran. The contract cannot record the conflicts
+ * themselves: while the diagnostics mode is on, every committed write of a house is recorded in a
+ * ring buffer of the recent writes of the house, telling support which houses are written often
+ * and by which clients, the likely places of conflicts. The conflicts are counted client side,
+ * from the validation codes of the transactions. The ring buffer is written along with the house,
+ * it adds no conflict to the ones on the house itself.
+ */
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	conflictDiagnosticsKey   = "conflictDiagnostics"
+	recentWritesObjectType   = "recentWrites"
+	recentWritesBufferLength = 16
+)
+
+// Define the recent write structure, one write of a house
+type RecentWrite struct {
+	TxID      string `json:"txid"`
+	MSPID     string `json:"mspid"`
+	Writer    string `json:"writer"`
+	Function  string `json:"function"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Define the recent writes structure, a ring buffer whose next write replaces the entry at Next
+type RecentWrites struct {
+	HouseKey string        `json:"housekey"`
+	Next     int           `json:"next"`
+	Writes   []RecentWrite `json:"writes"`
+}
+
+// inOrder returns the writes of the buffer from the oldest to the latest
+func (recent RecentWrites) inOrder() []RecentWrite {
+	if len(recent.Writes) < recentWritesBufferLength {
+		return recent.Writes
+	}
+	return append(append([]RecentWrite{}, recent.Writes[recent.Next:]...), recent.Writes[:recent.Next]...)
+}
+
+func conflictDiagnosticsEnabled(APIstub shim.ChaincodeStubInterface) (bool, error) {
+	enabledAsBytes, err := APIstub.GetState(conflictDiagnosticsKey)
+	if err != nil || enabledAsBytes == nil {
+		return false, err
+	}
+	return strconv.ParseBool(string(enabledAsBytes))
+}
+
+func getRecentWrites(APIstub shim.ChaincodeStubInterface, key string) (RecentWrites, error) {
+	recentKey, err := APIstub.CreateCompositeKey(recentWritesObjectType, []string{key})
+	if err != nil {
+		return RecentWrites{}, err
+	}
+	recentAsBytes, err := APIstub.GetState(recentKey)
+	if err != nil {
+		return RecentWrites{}, err
+	}
+	recent := RecentWrites{HouseKey: key, Writes: []RecentWrite{}}
+	if recentAsBytes == nil {
+		return recent, nil
+	}
+	err = json.Unmarshal(recentAsBytes, &recent)
+	return recent, err
+}
+
+// recordRecentWrite records the write of the house by the transaction, while the diagnostics mode is on
+func recordRecentWrite(APIstub shim.ChaincodeStubInterface, key string) error {
+	enabled, err := conflictDiagnosticsEnabled(APIstub)
+	if err != nil || !enabled {
+		return err
+	}
+	recent, err := getRecentWrites(APIstub, key)
+	if err != nil {
+		return err
+	}
+	// A house written twice by a transaction is recorded once
+	for _, write := range recent.Writes {
+		if write.TxID == APIstub.GetTxID() {
+			return nil
+		}
+	}
+
+	write := RecentWrite{TxID: APIstub.GetTxID()}
+	if write.MSPID, err = getInvokerMSP(APIstub); err != nil {
+		return err
+	}
+	if write.Writer, err = getInvokerID(APIstub); err != nil {
+		return err
+	}
+	write.Function = routedFunction(APIstub)
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	write.Timestamp = txTime.Format(timeLayout)
+
+	if len(recent.Writes) < recentWritesBufferLength {
+		recent.Writes = append(recent.Writes, write)
+	} else {
+		recent.Writes[recent.Next] = write
+	}
+	recent.Next = (recent.Next + 1) % recentWritesBufferLength
+
+	recentKey, err := APIstub.CreateCompositeKey(recentWritesObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	recentAsBytes, _ := json.Marshal(recent)
+	return APIstub.PutState(recentKey, recentAsBytes)
+}
+
+/*
+ * setConflictDiagnostics turns the recording of the recent writes of the houses on or off, for admins.
+ * The writes recorded are kept when it is turned off
+ * args: enabled (true or false)
+ */
+func (s *SmartContract) setConflictDiagnostics(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	enabled, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Enabled must be true or false")
+	}
+
+	if err := APIstub.PutState(conflictDiagnosticsKey, []byte(strconv.FormatBool(enabled))); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * queryRecentWrites returns the recent writes of a house, from the oldest to the latest, for admins
+ * args: house key
+ */
+func (s *SmartContract) queryRecentWrites(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recent, err := getRecentWrites(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	writesAsBytes, _ := json.Marshal(recent.inOrder())
+	return shim.Success(writesAsBytes)
+}
+
+// Define the write activity structure, the committed writes of a house during a period
+type WriteActivity struct {
+	HouseKey string   `json:"housekey"`
+	Writes   int      `json:"writes"`
+	Writers  []string `json:"writers"`
+	Last     string   `json:"last"`
+}
+
+/*
+ * queryBusyHouses returns the houses written more than once during the last seconds, the most written first, for admins.
+ * Only committed writes are counted, not the transactions which failed on a conflict
+ * args: period in seconds
+ */
+func (s *SmartContract) queryBusyHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	period, err := strconv.Atoi(args[0])
+	if err != nil || period <= 0 {
+		return shim.Error("Period must be a positive number of seconds")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	since := txTime.Add(-time.Duration(period) * time.Second)
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(recentWritesObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	activities := []WriteActivity{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		recent := RecentWrites{}
+		if err := json.Unmarshal(queryResponse.Value, &recent); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		activity := WriteActivity{HouseKey: recent.HouseKey}
+		writers := map[string]bool{}
+		for _, write := range recent.inOrder() {
+			timestamp, err := time.Parse(timeLayout, write.Timestamp)
+			if err != nil || timestamp.Before(since) {
+				continue
+			}
+			activity.Writes++
+			writers[write.MSPID+"/"+write.Writer] = true
+			activity.Last = write.Timestamp
+		}
+		if activity.Writes > 1 {
+			activity.Writers = sortedKeys(writers)
+			activities = append(activities, activity)
+		}
+	}
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].Writes > activities[j].Writes
+	})
+
+	activitiesAsBytes, _ := json.Marshal(activities)
+	return shim.Success(activitiesAsBytes)
+}
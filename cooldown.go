@@ -0,0 +1,184 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Anti-flipping cooldown
+ * The minHoldingPeriod rule of the rules table forbids selling a house again within a number of
+ * days of its last transfer. The regulator can approve a resale during the cooldown, the approval
+ * being used up by the sale it allows.
+ */
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	minHoldingPeriodRule     = "minHoldingPeriod"
+	resaleApprovalObjectType = "resaleApproval"
+)
+
+// Define the resale approval structure, the override of the cooldown of a house by the regulator
+type ResaleApproval struct {
+	HouseKey   string `json:"housekey"`
+	Reason     string `json:"reason"`
+	ApprovedBy string `json:"approvedby"`
+	ApprovedAt string `json:"approvedat"`
+}
+
+// cooldownEnd returns the end of the cooldown of the house, zero if it was never transferred
+func cooldownEnd(APIstub shim.ChaincodeStubInterface, key string, days int) (time.Time, error) {
+	last, err := lastTransferTime(APIstub, key)
+	if err != nil || last.IsZero() {
+		return time.Time{}, err
+	}
+	return last.AddDate(0, 0, days), nil
+}
+
+// consumeResaleApproval tells whether the regulator approved the resale of the house, using the approval up
+func consumeResaleApproval(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	approvalKey, err := APIstub.CreateCompositeKey(resaleApprovalObjectType, []string{key})
+	if err != nil {
+		return false, err
+	}
+	approvalAsBytes, err := APIstub.GetState(approvalKey)
+	if err != nil || approvalAsBytes == nil {
+		return false, err
+	}
+	return true, APIstub.DelState(approvalKey)
+}
+
+// holdingPeriodDays returns the longest holding period of the rules table, zero if no rule sets one
+func holdingPeriodDays(APIstub shim.ChaincodeStubInterface) (int, error) {
+	rules, err := getRules(APIstub)
+	if err != nil {
+		return 0, err
+	}
+	longest := 0
+	for _, rule := range rules {
+		if rule.Type != minHoldingPeriodRule || len(rule.Functions) > 0 {
+			continue
+		}
+		if days, _ := strconv.Atoi(rule.Params["days"]); days > longest {
+			longest = days
+		}
+	}
+	return longest, nil
+}
+
+/*
+ * approveResale allows the next sale of a house during its cooldown, for the regulator
+ * args: house key, reason of the approval
+ */
+func (s *SmartContract) approveResale(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRegulator(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	approvedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvalKey, err := APIstub.CreateCompositeKey(resaleApprovalObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvalAsBytes, _ := json.Marshal(ResaleApproval{HouseKey: args[0], Reason: args[1], ApprovedBy: approvedBy, ApprovedAt: approvedAt.Format(timeLayout)})
+	if err := APIstub.PutState(approvalKey, approvalAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "resaleApproved", approvalAsBytes)
+	return shim.Success(approvalAsBytes)
+}
+
+// queryHousesInCooldown returns the houses that cannot be sold yet under the holding period of the rules table, with the end of their cooldown
+func (s *SmartContract) queryHousesInCooldown(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	days, err := holdingPeriodDays(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Only the houses transferred during the months of the holding period can be in cooldown
+	candidates := map[string]bool{}
+	if days > 0 {
+		first := txTime.AddDate(0, 0, -days)
+		for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(txTime); month = month.AddDate(0, 1, 0) {
+			for _, reason := range transferReasons {
+				keys, err := queryIndexedHouseKeys(APIstub, transferIndex, []string{reason, month.Format(periodLayout)})
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+				for _, key := range keys {
+					candidates[key] = true
+				}
+			}
+		}
+	}
+
+	type houseInCooldown struct {
+		Key      string `json:"Key"`
+		EndsAt   string `json:"endsat"`
+		Approved bool   `json:"approved"`
+	}
+	results := []houseInCooldown{}
+	for _, key := range sortedKeys(candidates) {
+		endsAt, err := cooldownEnd(APIstub, key, days)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !txTime.Before(endsAt) {
+			continue
+		}
+		approvalKey, err := APIstub.CreateCompositeKey(resaleApprovalObjectType, []string{key})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		approvalAsBytes, err := APIstub.GetState(approvalKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		results = append(results, houseInCooldown{Key: key, EndsAt: endsAt.Format(timeLayout), Approved: approvalAsBytes != nil})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].EndsAt < results[j].EndsAt })
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
@@ -0,0 +1,286 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Co-signature of high value transfers
+ * Sales above a threshold set by an admin are not completed at once: the transfer waits in a
+ * queue until two distinct registrars approve it, each in a transaction of their own. A transfer
+ * not approved within the validity period of the policy expires.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	cosignPolicyKey          = "CONFIG_COSIGNPOLICY"
+	cosignRequestObjectType  = "cosignRequest"
+	requiredCosignatureCount = 2
+)
+
+// Define the co-signature policy structure. A zero threshold disables the policy
+type CosignPolicy struct {
+	Threshold     int64 `json:"threshold"`
+	ValidityHours int   `json:"validityhours"`
+}
+
+// Define the co-signature request structure, a sale waiting for the approval of the registrars
+type CosignRequest struct {
+	ID          string   `json:"id"`
+	HouseKey    string   `json:"housekey"`
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Price       int64    `json:"price"`
+	RequestedAt string   `json:"requestedat"`
+	ExpiresAt   string   `json:"expiresat"`
+	Approvers   []string `json:"approvers"`
+	Approvals   []string `json:"approvals"`
+	Status      string   `json:"status"`
+}
+
+// Co-signature request statuses
+const (
+	cosignPending   = "pending"
+	cosignCompleted = "completed"
+)
+
+func getCosignPolicy(APIstub shim.ChaincodeStubInterface) (CosignPolicy, error) {
+	policy := CosignPolicy{}
+
+	policyAsBytes, err := APIstub.GetState(cosignPolicyKey)
+	if err != nil || policyAsBytes == nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+// requiresCosignature tells whether a transfer at the price needs the approval of the registrars
+func requiresCosignature(APIstub shim.ChaincodeStubInterface, price int64) (bool, error) {
+	policy, err := getCosignPolicy(APIstub)
+	if err != nil {
+		return false, err
+	}
+	return policy.Threshold > 0 && price > policy.Threshold, nil
+}
+
+// requestCosignature queues the sale of the house for the approval of the registrars
+func requestCosignature(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string, price int64) ([]byte, error) {
+	// Fail early, the checks are run again once the transfer is approved
+	if err := checkTransferAllowed(APIstub, key, house, newOwner); err != nil {
+		return nil, err
+	}
+	policy, err := getCosignPolicy(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	requestedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	var request = CosignRequest{
+		ID:          APIstub.GetTxID(),
+		HouseKey:    key,
+		From:        house.Owner,
+		To:          newOwner,
+		Price:       price,
+		RequestedAt: requestedAt.Format(timeLayout),
+		ExpiresAt:   requestedAt.Add(time.Duration(policy.ValidityHours) * time.Hour).Format(timeLayout),
+		Approvers:   []string{},
+		Approvals:   []string{},
+		Status:      cosignPending,
+	}
+
+	requestAsBytes, err := putCosignRequest(APIstub, request)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(APIstub, "cosignatureRequested", requestAsBytes)
+	return requestAsBytes, nil
+}
+
+func putCosignRequest(APIstub shim.ChaincodeStubInterface, request CosignRequest) ([]byte, error) {
+	requestKey, err := APIstub.CreateCompositeKey(cosignRequestObjectType, []string{request.ID})
+	if err != nil {
+		return nil, err
+	}
+	requestAsBytes, _ := json.Marshal(request)
+	return requestAsBytes, APIstub.PutState(requestKey, requestAsBytes)
+}
+
+/*
+ * setCosignPolicy sets the price above which sales need two registrar approvals, for admins
+ * args: threshold (0 to disable), validity of the requests in hours
+ */
+func (s *SmartContract) setCosignPolicy(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	threshold, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || threshold < 0 {
+		return shim.Error("Threshold must be a positive number")
+	}
+	validity, err := strconv.Atoi(args[1])
+	if err != nil || validity <= 0 {
+		return shim.Error("Validity must be a positive number of hours")
+	}
+
+	policyAsBytes, _ := json.Marshal(CosignPolicy{Threshold: threshold, ValidityHours: validity})
+	if err := APIstub.PutState(cosignPolicyKey, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * approveCosignedTransfer approves a queued sale, for registrars. The second approval completes the transfer
+ * args: request ID
+ */
+func (s *SmartContract) approveCosignedTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requestKey, err := APIstub.CreateCompositeKey(cosignRequestObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	requestAsBytes, err := APIstub.GetState(requestKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if requestAsBytes == nil {
+		return shim.Error("Co-signature request " + args[0] + " does not exist")
+	}
+	request := CosignRequest{}
+	if err := json.Unmarshal(requestAsBytes, &request); err != nil {
+		return shim.Error(err.Error())
+	}
+	if request.Status != cosignPending {
+		return shim.Error("Co-signature request " + request.ID + " is " + request.Status)
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := time.Parse(timeLayout, request.ExpiresAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !txTime.Before(expiresAt) {
+		return shim.Error("Co-signature request " + request.ID + " expired on " + request.ExpiresAt)
+	}
+
+	// Approvers are told apart by their unique ID, so that two certificates of the same registrar count once
+	approver, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, previous := range request.Approvals {
+		if previous == approver {
+			return shim.Error("Co-signature request " + request.ID + " was already approved by this registrar")
+		}
+	}
+	approverName, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if approverName == request.From || approverName == request.To {
+		return shim.Error("A party to the transfer cannot approve it")
+	}
+	request.Approvals = append(request.Approvals, approver)
+	request.Approvers = append(request.Approvers, approverName)
+
+	if len(request.Approvals) >= requiredCosignatureCount {
+		house, err := getHouse(APIstub, request.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if house.Owner != request.From {
+			return shim.Error(fmt.Sprintf("House %s changed hands since the transfer was requested", request.HouseKey))
+		}
+		// A sale owing tax now waits for the settlement of the tax
+		if _, err := completeSale(APIstub, request.HouseKey, house, request.To, request.Price); err != nil {
+			return shim.Error(err.Error())
+		}
+		request.Status = cosignCompleted
+	}
+
+	requestAsBytes, err = putCosignRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(requestAsBytes)
+}
+
+// queryPendingCosignatures lists the sales waiting for registrar approvals that have not expired
+func (s *SmartContract) queryPendingCosignatures(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(cosignRequestObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	requests := []CosignRequest{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		request := CosignRequest{}
+		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
+			return shim.Error(err.Error())
+		}
+		expiresAt, err := time.Parse(timeLayout, request.ExpiresAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if request.Status == cosignPending && txTime.Before(expiresAt) {
+			requests = append(requests, request)
+		}
+	}
+
+	requestsAsBytes, _ := json.Marshal(requests)
+	return shim.Success(requestsAsBytes)
+}
@@ -0,0 +1,282 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* House counters
+ * The number of houses per location, status and owner is kept in dedicated keys, updated with
+ * the indexes on every house write, so that dashboards read a count without scanning the houses.
+ * Co-owners each count the house. Counters are sharded, every house creation counting for the
+ * "registered" status. rebuildHouseCounters recomputes every counter from the houses, should they drift,
+ * in a single transaction so that no write interleaves.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const houseCounterObjectType = "houseCount"
+
+// Define the house counter structure. Values returns the values of the dimension the house counts for
+type houseCounter struct {
+	dimension string
+	values    func(house House) []string
+}
+
+// Counters maintained on every house write
+var houseCounters = []houseCounter{
+	{dimension: "location", values: func(house House) []string {
+		if location := normalizeLocation(house.Location); location != "" {
+			return []string{location}
+		}
+		return []string{}
+	}},
+	{dimension: "status", values: func(house House) []string {
+		return []string{houseStatus(house)}
+	}},
+	{dimension: "owner", values: func(house House) []string {
+		owners := []string{}
+		for _, share := range houseShares(house) {
+			owners = append(owners, share.Owner)
+		}
+		return owners
+	}},
+}
+
+// Define the house count structure, the number of houses of a value of a dimension
+type HouseCount struct {
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+	Count     int    `json:"count"`
+}
+
+func findHouseCounter(dimension string) (houseCounter, error) {
+	for _, counter := range houseCounters {
+		if counter.dimension == dimension {
+			return counter, nil
+		}
+	}
+	return houseCounter{}, fmt.Errorf("Unknown dimension %q, expecting location, status or owner", dimension)
+}
+
+func getHouseCount(APIstub shim.ChaincodeStubInterface, dimension string, value string) (int, error) {
+	count, err := getShardedCount(APIstub, houseCounterObjectType, []string{dimension, value})
+	return int(count), err
+}
+
+// countHouses returns the number of houses of the value of the dimension, every house for an empty dimension,
+// and the value as counted
+func countHouses(APIstub shim.ChaincodeStubInterface, dimension string, value string) (string, int, error) {
+	if dimension == "" {
+		// Every house has a single status
+		counts, err := getShardedCounts(APIstub, houseCounterObjectType, []string{"status"}, 2)
+		if err != nil {
+			return value, 0, err
+		}
+		total := 0
+		for _, count := range counts {
+			if count.count > 0 {
+				total += int(count.count)
+			}
+		}
+		return value, total, nil
+	}
+	if _, err := findHouseCounter(dimension); err != nil {
+		return value, 0, err
+	}
+	if dimension == "location" {
+		location, err := resolveLocationAlias(APIstub, value)
+		if err != nil {
+			return value, 0, err
+		}
+		value = normalizeLocation(location)
+	}
+	count, err := getHouseCount(APIstub, dimension, value)
+	return value, count, err
+}
+
+// addHouseCount adds the delta to the count of the value of the dimension
+func addHouseCount(APIstub shim.ChaincodeStubInterface, dimension string, value string, delta int) error {
+	return addShardedCount(APIstub, houseCounterObjectType, []string{dimension, value}, int64(delta))
+}
+
+// updateHouseCounters moves the house from the counters of its previous version (nil for a new house) to those of the new one (nil once deleted)
+func updateHouseCounters(APIstub shim.ChaincodeStubInterface, previous *House, house *House) error {
+	for _, counter := range houseCounters {
+		deltas := map[string]int{}
+		if previous != nil {
+			for _, value := range counter.values(*previous) {
+				deltas[value]--
+			}
+		}
+		if house != nil {
+			for _, value := range counter.values(*house) {
+				deltas[value]++
+			}
+		}
+		// Values are sorted so that every endorser issues its writes in the same order
+		for _, value := range sortedIntKeys(deltas) {
+			if deltas[value] == 0 {
+				continue
+			}
+			if err := addHouseCount(APIstub, counter.dimension, value, deltas[value]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * queryHouseCount returns the number of houses of a value of a dimension
+ * args: dimension (location, status or owner), value
+ */
+func (s *SmartContract) queryHouseCount(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if _, err := findHouseCounter(args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	value, count, err := countHouses(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	countAsBytes, _ := json.Marshal(HouseCount{Dimension: args[0], Value: value, Count: count})
+	return shim.Success(countAsBytes)
+}
+
+/*
+ * queryHouseCounts returns the number of houses of every value of a dimension
+ * args: dimension (location, status or owner)
+ */
+func (s *SmartContract) queryHouseCounts(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if _, err := findHouseCounter(args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	shardedCounts, err := getShardedCounts(APIstub, houseCounterObjectType, []string{args[0]}, 2)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The shards of a value no longer counted may be left with opposite counts
+	counts := []HouseCount{}
+	for _, count := range shardedCounts {
+		if count.count > 0 {
+			counts = append(counts, HouseCount{Dimension: count.attributes[0], Value: count.attributes[1], Count: int(count.count)})
+		}
+	}
+
+	countsAsBytes, _ := json.Marshal(counts)
+	return shim.Success(countsAsBytes)
+}
+
+// rebuildHouseCounters recomputes every house counter from the houses, for admins
+func (s *SmartContract) rebuildHouseCounters(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	counts := map[string]map[string]int{}
+	for _, counter := range houseCounters {
+		counts[counter.dimension] = map[string]int{}
+	}
+	housesIterator, err := APIstub.GetStateByRange(houseStartKey, houseEndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer housesIterator.Close()
+	for housesIterator.HasNext() {
+		queryResponse, err := housesIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		house, err := decodeHouse(queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, counter := range houseCounters {
+			for _, value := range counter.values(house) {
+				counts[counter.dimension][value]++
+			}
+		}
+	}
+
+	// Every count is written to a single shard, the other shards are deleted
+	countKeys := map[string]bool{}
+	for _, counter := range houseCounters {
+		for value := range counts[counter.dimension] {
+			countKey, err := APIstub.CreateCompositeKey(houseCounterObjectType, []string{counter.dimension, value, counterShard(APIstub)})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			countKeys[countKey] = true
+		}
+	}
+
+	var result = struct {
+		Deleted int `json:"deleted"`
+		Written int `json:"written"`
+	}{}
+	countersIterator, err := APIstub.GetStateByPartialCompositeKey(houseCounterObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer countersIterator.Close()
+	for countersIterator.HasNext() {
+		queryResponse, err := countersIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !countKeys[queryResponse.Key] {
+			if err := APIstub.DelState(queryResponse.Key); err != nil {
+				return shim.Error(err.Error())
+			}
+			result.Deleted++
+		}
+	}
+	for _, counter := range houseCounters {
+		for _, value := range sortedIntKeys(counts[counter.dimension]) {
+			countKey, err := APIstub.CreateCompositeKey(houseCounterObjectType, []string{counter.dimension, value, counterShard(APIstub)})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if err := APIstub.PutState(countKey, []byte(strconv.Itoa(counts[counter.dimension][value]))); err != nil {
+				return shim.Error(err.Error())
+			}
+			result.Written++
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
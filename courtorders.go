@@ -0,0 +1,370 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Court orders
+ * The judiciary executes its orders as a bundle of actions applied in a single transaction, so
+ * that either every action of the order is applied or none is. Every order references the hash of
+ * the order document and is kept on the ledger for audits. The actions are:
+ * - freeze and unfreeze: a frozen house can only change hands by a court order, and is neither
+ *   changed by its owner or the registrars nor split or merged
+ * - transfer: forced transfer of the house to a new owner, lifting its freeze
+ * - lien: registration of a judgment lien of an amount on the house
+ * - resolveDispute: resolution of the open dispute of the house
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	judiciaryMSPKey       = "CONFIG_JUDICIARYMSP"
+	courtOrderObjectType  = "courtOrder"
+	houseFreezeObjectType = "houseFreeze"
+	lienObjectType        = "lien"
+)
+
+// Court order action types
+const (
+	actionFreeze         = "freeze"
+	actionUnfreeze       = "unfreeze"
+	actionTransfer       = "transfer"
+	actionLien           = "lien"
+	actionResolveDispute = "resolveDispute"
+)
+
+// Define the court order action structure. Owner is the new owner of a transfer or a dispute resolved by a transfer
+type CourtOrderAction struct {
+	Type     string `json:"type"`
+	HouseKey string `json:"housekey"`
+	Owner    string `json:"owner,omitempty"`
+	Holder   string `json:"holder,omitempty"`
+	Amount   int64  `json:"amount,omitempty"`
+	Outcome  string `json:"outcome,omitempty"`
+}
+
+// Define the court order structure, the record of an executed order
+type CourtOrder struct {
+	ID         string             `json:"id"`
+	Hash       string             `json:"hash"`
+	Actions    []CourtOrderAction `json:"actions"`
+	ExecutedBy string             `json:"executedby"`
+	MSP        string             `json:"msp"`
+	ExecutedAt string             `json:"executedat"`
+	TxID       string             `json:"txid"`
+}
+
+// Define the house freeze structure, present while the house is frozen
+type HouseFreeze struct {
+	HouseKey string `json:"housekey"`
+	OrderID  string `json:"orderid"`
+	FrozenAt string `json:"frozenat"`
+}
+
+// Define the lien structure, a judgment lien registered by a court order
+type Lien struct {
+	HouseKey     string `json:"housekey"`
+	OrderID      string `json:"orderid"`
+	Holder       string `json:"holder"`
+	Amount       int64  `json:"amount"`
+	RegisteredAt string `json:"registeredat"`
+}
+
+// requireJudiciary returns an error unless the invoker is a court of the judiciary MSP
+func requireJudiciary(APIstub shim.ChaincodeStubInterface) error {
+	if err := requireRole(APIstub, roleCourt); err != nil {
+		return err
+	}
+	judiciaryMSPAsBytes, err := APIstub.GetState(judiciaryMSPKey)
+	if err != nil {
+		return err
+	}
+	if judiciaryMSPAsBytes == nil {
+		return fmt.Errorf("No judiciary MSP is configured")
+	}
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	if mspID != string(judiciaryMSPAsBytes) {
+		return fmt.Errorf("Only the judiciary MSP %s can do this", judiciaryMSPAsBytes)
+	}
+	return nil
+}
+
+// checkNotFrozen returns an error when the house is frozen by a court order
+func checkNotFrozen(APIstub shim.ChaincodeStubInterface, key string) error {
+	freezeKey, err := APIstub.CreateCompositeKey(houseFreezeObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	freezeAsBytes, err := APIstub.GetState(freezeKey)
+	if err != nil || freezeAsBytes == nil {
+		return err
+	}
+	freeze := HouseFreeze{}
+	if err := json.Unmarshal(freezeAsBytes, &freeze); err != nil {
+		return err
+	}
+	return fmt.Errorf("House %s is frozen by court order %s", key, freeze.OrderID)
+}
+
+// validateCourtOrderAction checks the parameters of an action, before any action of the order is applied
+func validateCourtOrderAction(action CourtOrderAction) error {
+	switch action.Type {
+	case actionFreeze, actionUnfreeze:
+	case actionTransfer:
+		if action.Owner == "" {
+			return fmt.Errorf("A transfer requires the new owner")
+		}
+	case actionLien:
+		if action.Holder == "" || action.Amount <= 0 {
+			return fmt.Errorf("A lien requires its holder and a positive amount")
+		}
+	case actionResolveDispute:
+		if action.Outcome != outcomeDismissed && action.Outcome != outcomeForceTransfer {
+			return fmt.Errorf("Outcome must be %q or %q", outcomeDismissed, outcomeForceTransfer)
+		}
+		if action.Outcome == outcomeForceTransfer && action.Owner == "" {
+			return fmt.Errorf("A forced transfer requires the new owner")
+		}
+	default:
+		return fmt.Errorf("Unknown action type %q", action.Type)
+	}
+	return nil
+}
+
+// applyCourtOrderAction applies one action of the order
+func applyCourtOrderAction(APIstub shim.ChaincodeStubInterface, order CourtOrder, action CourtOrderAction) error {
+	house, err := getHouse(APIstub, action.HouseKey)
+	if err != nil {
+		return err
+	}
+	freezeKey, err := APIstub.CreateCompositeKey(houseFreezeObjectType, []string{action.HouseKey})
+	if err != nil {
+		return err
+	}
+
+	switch action.Type {
+	case actionFreeze:
+		freezeAsBytes, _ := json.Marshal(HouseFreeze{HouseKey: action.HouseKey, OrderID: order.ID, FrozenAt: order.ExecutedAt})
+		return APIstub.PutState(freezeKey, freezeAsBytes)
+	case actionUnfreeze:
+		return APIstub.DelState(freezeKey)
+	case actionTransfer:
+		// The court order stands for the co-signature of the registrars, and ends the workflow holding the house
+		if err := breakHouseLock(APIstub, action.HouseKey); err != nil {
+			return err
+		}
+		if err := executeTransfer(APIstub, action.HouseKey, house, []OwnershipShare{{Owner: action.Owner, Share: wholeShare}}, reasonCourtOrder, 0); err != nil {
+			return err
+		}
+		return APIstub.DelState(freezeKey)
+	case actionLien:
+		lienKey, err := APIstub.CreateCompositeKey(lienObjectType, []string{action.HouseKey, order.ID})
+		if err != nil {
+			return err
+		}
+		lienAsBytes, _ := json.Marshal(Lien{HouseKey: action.HouseKey, OrderID: order.ID, Holder: action.Holder, Amount: action.Amount, RegisteredAt: order.ExecutedAt})
+		return APIstub.PutState(lienKey, lienAsBytes)
+	case actionResolveDispute:
+		_, err := closeDispute(APIstub, action.HouseKey, action.Outcome, action.Owner)
+		return err
+	}
+	return nil
+}
+
+/*
+ * setJudiciaryMSP designates the MSP of the judiciary. Once designated, only the judiciary can change it
+ * args: MSP ID
+ */
+func (s *SmartContract) setJudiciaryMSP(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if args[0] == "" {
+		return shim.Error("MSP ID must not be empty")
+	}
+
+	judiciaryMSPAsBytes, err := APIstub.GetState(judiciaryMSPKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if judiciaryMSPAsBytes == nil {
+		err = requireRole(APIstub, roleAdmin)
+	} else {
+		err = requireJudiciary(APIstub)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := APIstub.PutState(judiciaryMSPKey, []byte(args[0])); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * executeCourtOrder applies every action of a court order, or none, for the judiciary. A house can be
+ * the subject of several liens but of a single other action of the order
+ * args: order ID, sha256 of the order document, actions as a JSON array
+ */
+func (s *SmartContract) executeCourtOrder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireJudiciary(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Order ID must not be empty")
+	}
+	if err := validateSHA256(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	actions := []CourtOrderAction{}
+	if err := json.Unmarshal([]byte(args[2]), &actions); err != nil {
+		return shim.Error("Actions must be a JSON array of actions")
+	}
+	if len(actions) == 0 {
+		return shim.Error("A court order must have at least one action")
+	}
+
+	// The writes of the transaction cannot be read back, every house record is changed by one action at most
+	changed := map[string]bool{}
+	for i, action := range actions {
+		if err := validateCourtOrderAction(action); err != nil {
+			return shim.Error(fmt.Sprintf("Action %d: %s", i+1, err))
+		}
+		if action.Type == actionLien {
+			continue
+		}
+		if changed[action.HouseKey] {
+			return shim.Error(fmt.Sprintf("Action %d: house %s is already the subject of another action", i+1, action.HouseKey))
+		}
+		changed[action.HouseKey] = true
+	}
+
+	orderKey, err := APIstub.CreateCompositeKey(courtOrderObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(orderKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("Court order " + args[0] + " was already executed")
+	}
+
+	executedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	executedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var order = CourtOrder{
+		ID:         args[0],
+		Hash:       args[1],
+		Actions:    actions,
+		ExecutedBy: executedBy,
+		MSP:        mspID,
+		ExecutedAt: executedAt.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+	for i, action := range actions {
+		if err := applyCourtOrderAction(APIstub, order, action); err != nil {
+			return shim.Error(fmt.Sprintf("Action %d: %s", i+1, err))
+		}
+	}
+
+	orderAsBytes, _ := json.Marshal(order)
+	if err := APIstub.PutState(orderKey, orderAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "courtOrderExecuted", orderAsBytes)
+	return shim.Success(orderAsBytes)
+}
+
+// queryCourtOrder returns an executed court order. args: order ID
+func (s *SmartContract) queryCourtOrder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	orderKey, err := APIstub.CreateCompositeKey(courtOrderObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orderAsBytes, err := APIstub.GetState(orderKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if orderAsBytes == nil {
+		return shim.Error("Court order " + args[0] + " does not exist")
+	}
+
+	return shim.Success(orderAsBytes)
+}
+
+// queryHouseLiens returns the judgment liens registered on a house. args: house key
+func (s *SmartContract) queryHouseLiens(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(lienObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	liens := []Lien{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		lien := Lien{}
+		if err := json.Unmarshal(queryResponse.Value, &lien); err != nil {
+			return shim.Error(err.Error())
+		}
+		liens = append(liens, lien)
+	}
+
+	liensAsBytes, _ := json.Marshal(liens)
+	return shim.Success(liensAsBytes)
+}
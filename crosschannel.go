@@ -0,0 +1,152 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Cross-channel reads
+ * An aggregator channel can read the houses of sister registries deployed on other channels
+ * (e.g. municipal channels). Sister registries are declared by an admin. Fabric never commits
+ * the writes of a chaincode called on another channel, and only queryHouse is ever called.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const sisterRegistryObjectType = "sisterRegistry"
+
+// Define the sister registry structure, the registry chaincode deployed on another channel
+type SisterRegistry struct {
+	Channel   string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+}
+
+func getSisterRegistry(APIstub shim.ChaincodeStubInterface, channel string) (SisterRegistry, error) {
+	registry := SisterRegistry{}
+
+	registryKey, err := APIstub.CreateCompositeKey(sisterRegistryObjectType, []string{channel})
+	if err != nil {
+		return registry, err
+	}
+	registryAsBytes, err := APIstub.GetState(registryKey)
+	if err != nil {
+		return registry, err
+	}
+	if registryAsBytes == nil {
+		return registry, fmt.Errorf("No sister registry declared on channel %s", channel)
+	}
+
+	err = json.Unmarshal(registryAsBytes, &registry)
+	return registry, err
+}
+
+/*
+ * setSisterRegistry declares the registry chaincode of another channel
+ * args: channel, chaincode name (empty to remove the declaration)
+ */
+func (s *SmartContract) setSisterRegistry(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == APIstub.GetChannelID() {
+		return shim.Error("A sister registry must be on another channel")
+	}
+
+	registryKey, err := APIstub.CreateCompositeKey(sisterRegistryObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if args[1] == "" {
+		err = APIstub.DelState(registryKey)
+	} else {
+		registryAsBytes, _ := json.Marshal(SisterRegistry{Channel: args[0], Chaincode: args[1]})
+		err = APIstub.PutState(registryKey, registryAsBytes)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryAllSisterRegistries(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(sisterRegistryObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	registries := []SisterRegistry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		registry := SisterRegistry{}
+		if err := json.Unmarshal(queryResponse.Value, &registry); err != nil {
+			return shim.Error(err.Error())
+		}
+		registries = append(registries, registry)
+	}
+
+	registriesAsBytes, _ := json.Marshal(registries)
+	return shim.Success(registriesAsBytes)
+}
+
+/*
+ * queryHouseOnChannel reads a house from the sister registry of another channel
+ * args: channel, house key
+ */
+func (s *SmartContract) queryHouseOnChannel(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	registry, err := getSisterRegistry(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	response := APIstub.InvokeChaincode(registry.Chaincode, [][]byte{[]byte("queryHouse"), []byte(args[1])}, registry.Channel)
+	if response.Status != shim.OK {
+		return shim.Error(fmt.Sprintf("Query of %s on channel %s failed: %s", registry.Chaincode, registry.Channel, response.Message))
+	}
+	if len(response.Payload) == 0 {
+		return shim.Error(fmt.Sprintf("House %s does not exist on channel %s", args[1], registry.Channel))
+	}
+
+	var result = struct {
+		Channel string          `json:"Channel"`
+		Key     string          `json:"Key"`
+		Record  json.RawMessage `json:"Record"`
+	}{Channel: registry.Channel, Key: args[1], Record: response.Payload}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
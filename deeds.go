@@ -0,0 +1,420 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Deed tokens
+ * Every house registered mints a unique, non-fungible deed token. The holder of a token is always
+ * the registered owner of its house, so that every transfer of the house moves its token. In the
+ * manner of ERC-721, a holder can approve another identity to transfer one of its tokens, or make
+ * an operator, such as a wallet chaincode, able to transfer all of them. Approvals are granted by
+ * a holder and lapse when the house changes hands. A token is burned when its house is retired.
+ * Houses registered before deed tokens get theirs from mintMissingDeeds.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	deedTokenObjectType    = "deedToken"
+	houseDeedObjectType    = "houseDeed"
+	deedOperatorObjectType = "deedOperator"
+)
+
+// Define the deed token structure. Approved may transfer the token as long as the grantor holds it
+type DeedToken struct {
+	TokenID    string `json:"tokenid"`
+	HouseKey   string `json:"housekey"`
+	MintedAt   string `json:"mintedat"`
+	Approved   string `json:"approved,omitempty"`
+	ApprovedBy string `json:"approvedby,omitempty"`
+	Burned     bool   `json:"burned,omitempty"`
+}
+
+// Define the deed structure, a token along with its current holder
+type Deed struct {
+	DeedToken
+	Holder string `json:"holder,omitempty"`
+}
+
+func getDeedToken(APIstub shim.ChaincodeStubInterface, tokenID string) (DeedToken, error) {
+	tokenKey, err := APIstub.CreateCompositeKey(deedTokenObjectType, []string{tokenID})
+	if err != nil {
+		return DeedToken{}, err
+	}
+	tokenAsBytes, err := APIstub.GetState(tokenKey)
+	if err != nil {
+		return DeedToken{}, err
+	}
+	if tokenAsBytes == nil {
+		return DeedToken{}, fmt.Errorf("Deed token %s does not exist", tokenID)
+	}
+	token := DeedToken{}
+	err = json.Unmarshal(tokenAsBytes, &token)
+	return token, err
+}
+
+func putDeedToken(APIstub shim.ChaincodeStubInterface, token DeedToken) error {
+	tokenKey, err := APIstub.CreateCompositeKey(deedTokenObjectType, []string{token.TokenID})
+	if err != nil {
+		return err
+	}
+	tokenAsBytes, _ := json.Marshal(token)
+	return APIstub.PutState(tokenKey, tokenAsBytes)
+}
+
+// getHouseDeedID returns the ID of the deed token of the house, empty for a house registered before deed tokens
+func getHouseDeedID(APIstub shim.ChaincodeStubInterface, houseKey string) (string, error) {
+	deedKey, err := APIstub.CreateCompositeKey(houseDeedObjectType, []string{houseKey})
+	if err != nil {
+		return "", err
+	}
+	tokenIDAsBytes, err := APIstub.GetState(deedKey)
+	return string(tokenIDAsBytes), err
+}
+
+// mintDeedToken mints the deed token of a newly registered house. The ID is derived from the key of
+// the house and the registering transaction, so that a key reused after a retirement gets a new token
+func mintDeedToken(APIstub shim.ChaincodeStubInterface, houseKey string) error {
+	hash := sha256.Sum256([]byte(deedTokenObjectType + "\x00" + houseKey + "\x00" + APIstub.GetTxID()))
+	mintedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	token := DeedToken{TokenID: hex.EncodeToString(hash[:16]), HouseKey: houseKey, MintedAt: mintedAt.Format(timeLayout)}
+	if err := putDeedToken(APIstub, token); err != nil {
+		return err
+	}
+
+	deedKey, err := APIstub.CreateCompositeKey(houseDeedObjectType, []string{houseKey})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(deedKey, []byte(token.TokenID))
+}
+
+// burnDeedToken burns the deed token of a retired house, if any
+func burnDeedToken(APIstub shim.ChaincodeStubInterface, houseKey string) error {
+	tokenID, err := getHouseDeedID(APIstub, houseKey)
+	if err != nil || tokenID == "" {
+		return err
+	}
+	token, err := getDeedToken(APIstub, tokenID)
+	if err != nil {
+		return err
+	}
+	token.Burned = true
+	token.Approved, token.ApprovedBy = "", ""
+	if err := putDeedToken(APIstub, token); err != nil {
+		return err
+	}
+
+	deedKey, err := APIstub.CreateCompositeKey(houseDeedObjectType, []string{houseKey})
+	if err != nil {
+		return err
+	}
+	return APIstub.DelState(deedKey)
+}
+
+// getDeed returns the token along with its holder, failing for a burned token
+func getDeed(APIstub shim.ChaincodeStubInterface, tokenID string) (Deed, House, error) {
+	token, err := getDeedToken(APIstub, tokenID)
+	if err != nil {
+		return Deed{}, House{}, err
+	}
+	if token.Burned {
+		return Deed{}, House{}, fmt.Errorf("Deed token %s was burned", tokenID)
+	}
+	house, err := getHouse(APIstub, token.HouseKey)
+	if err != nil {
+		return Deed{}, House{}, err
+	}
+	// An approval only holds while the house is held by its grantor
+	if token.ApprovedBy != house.Owner {
+		token.Approved, token.ApprovedBy = "", ""
+	}
+	return Deed{DeedToken: token, Holder: house.Owner}, house, nil
+}
+
+func isDeedOperator(APIstub shim.ChaincodeStubInterface, holder string, operator string) (bool, error) {
+	operatorKey, err := APIstub.CreateCompositeKey(deedOperatorObjectType, []string{holder, operator})
+	if err != nil {
+		return false, err
+	}
+	operatorAsBytes, err := APIstub.GetState(operatorKey)
+	return operatorAsBytes != nil, err
+}
+
+// ownerOf returns the deed token and its holder
+func (s *SmartContract) ownerOf(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	deed, _, err := getDeed(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deedAsBytes, _ := json.Marshal(deed)
+	return shim.Success(deedAsBytes)
+}
+
+// queryDeedsByHolder returns the deed tokens held by an identity, the registered owner of their house
+func (s *SmartContract) queryDeedsByHolder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	keys, err := queryIndexedHouseKeys(APIstub, ownerIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deeds := []Deed{}
+	for _, key := range keys {
+		tokenID, err := getHouseDeedID(APIstub, key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if tokenID == "" {
+			continue
+		}
+		deed, _, err := getDeed(APIstub, tokenID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// Co-owners other than the registered owner do not hold the deed
+		if deed.Holder == args[0] {
+			deeds = append(deeds, deed)
+		}
+	}
+
+	deedsAsBytes, _ := json.Marshal(deeds)
+	return shim.Success(deedsAsBytes)
+}
+
+/*
+ * approveDeed approves an identity to transfer a deed token, only its holder can do it
+ * args: token ID, approved identity (empty to revoke)
+ */
+func (s *SmartContract) approveDeed(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	deed, house, err := getDeed(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, deed.HouseKey, house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deed.Approved, deed.ApprovedBy = args[1], house.Owner
+	if args[1] == "" {
+		deed.ApprovedBy = ""
+	}
+	if err := putDeedToken(APIstub, deed.DeedToken); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deedAsBytes, _ := json.Marshal(deed)
+	return shim.Success(deedAsBytes)
+}
+
+/*
+ * setDeedOperator makes an identity able to transfer every deed token of the invoker, or revokes it
+ * args: operator, approved (true or false)
+ */
+func (s *SmartContract) setDeedOperator(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] != "true" && args[1] != "false" {
+		return shim.Error("Approved must be true or false")
+	}
+	holder, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[0] == holder {
+		return shim.Error("Operator must be another identity")
+	}
+
+	operatorKey, err := APIstub.CreateCompositeKey(deedOperatorObjectType, []string{holder, args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "false" {
+		err = APIstub.DelState(operatorKey)
+	} else {
+		err = APIstub.PutState(operatorKey, []byte{0x00})
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * transferDeedFrom transfers a deed token, and so its house, for its holder, the approved identity or an operator of the holder.
+ * Houses held by several co-owners cannot be transferred by their deed. The transfer goes through the checks of changeHouseOwner,
+ * a sale declaring its price
+ * args: current holder, new holder, token ID, [reason], [price]
+ */
+func (s *SmartContract) transferDeedFrom(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 to 5")
+	}
+	reason, price := reasonSale, ""
+	if len(args) > 3 {
+		reason = args[3]
+	}
+	if len(args) > 4 {
+		price = args[4]
+	}
+	reason, amount, err := parseTransferReason(reason, price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	deed, house, err := getDeed(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if deed.Holder != args[0] {
+		return shim.Error("Deed token " + deed.TokenID + " is not held by " + args[0])
+	}
+	if len(house.Shares) > 0 {
+		return shim.Error("House " + deed.HouseKey + " is held by several co-owners and cannot be transferred by its deed")
+	}
+	if holder := multisigHolder(house); holder != "" {
+		return shim.Error("House " + deed.HouseKey + " is held by the multi-signature account " + holder + ", use proposeMultisigTransfer")
+	}
+
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	operator, err := isDeedOperator(APIstub, deed.Holder, invoker)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != deed.Holder && invoker != deed.Approved && !operator {
+		attorney, err := actsAsAttorney(APIstub, deed.HouseKey, deed.Holder, invoker)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !attorney {
+			return shim.Error("Access denied. Only the holder of deed token " + deed.TokenID + ", its approved identity, an operator or an attorney can transfer it")
+		}
+	}
+
+	if err := checkEntitySignatory(APIstub, deed.HouseKey, house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The approval lapses with the transfer, the new holder not being its grantor. A sale may wait for its
+	// pre-emption window, co-signature or tax, the deed is transferred once it completes
+	requestAsBytes, err := queueOrExecuteTransfer(APIstub, deed.HouseKey, house, args[1], reason, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if requestAsBytes != nil {
+		return shim.Success(requestAsBytes)
+	}
+
+	emitEvent(APIstub, "deedTransferred", []byte(deed.TokenID))
+	return shim.Success(nil)
+}
+
+/*
+ * mintMissingDeeds mints the deed tokens of a chunk of houses registered before deed tokens, for admins
+ * args: first key to scan (empty to start from the first house), maximum number of houses to scan
+ */
+func (s *SmartContract) mintMissingDeeds(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+	startKey := args[0]
+	if startKey == "" {
+		startKey = houseStartKey
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(startKey, houseEndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result = struct {
+		Scanned int    `json:"scanned"`
+		Minted  int    `json:"minted"`
+		NextKey string `json:"nextkey"`
+	}{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// The first house beyond the chunk is where the next chunk starts
+		if result.Scanned == pageSize {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		tokenID, err := getHouseDeedID(APIstub, queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if tokenID != "" {
+			continue
+		}
+		if err := mintDeedToken(APIstub, queryResponse.Key); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Minted++
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
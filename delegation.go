@@ -0,0 +1,269 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Management delegation
+ * The owner of a house can delegate its management to a property manager until an expiry date.
+ * Depending on the permissions granted the manager can create leases and log maintenance, but
+ * can never transfer the house. A delegation lapses when the house changes hands.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	delegationObjectType = "delegation"
+	managerIndex         = "manager~key"
+)
+
+// Permissions that can be delegated to a manager
+const (
+	permissionLeases      = "leases"
+	permissionMaintenance = "maintenance"
+)
+
+var delegablePermissions = []string{permissionLeases, permissionMaintenance}
+
+// Define the delegation structure, the management rights of a manager on a house
+type Delegation struct {
+	HouseKey    string   `json:"housekey"`
+	Manager     string   `json:"manager"`
+	Owner       string   `json:"owner"`
+	Permissions []string `json:"permissions"`
+	Expiry      string   `json:"expiry"`
+}
+
+func getDelegation(APIstub shim.ChaincodeStubInterface, key string, manager string) (Delegation, bool, error) {
+	delegation := Delegation{}
+
+	delegationKey, err := APIstub.CreateCompositeKey(delegationObjectType, []string{key, manager})
+	if err != nil {
+		return delegation, false, err
+	}
+	delegationAsBytes, err := APIstub.GetState(delegationKey)
+	if err != nil || delegationAsBytes == nil {
+		return delegation, false, err
+	}
+
+	err = json.Unmarshal(delegationAsBytes, &delegation)
+	return delegation, true, err
+}
+
+// delegationActive tells whether the delegation is in force for the house at the given time
+func delegationActive(delegation Delegation, house House, at time.Time) bool {
+	expiry, err := time.Parse(timeLayout, delegation.Expiry)
+	return err == nil && at.Before(expiry) && delegation.Owner == house.Owner
+}
+
+// requireOwnerOrManager returns an error unless the invoker owns the house or manages it with the permission
+func requireOwnerOrManager(APIstub shim.ChaincodeStubInterface, key string, house House, permission string) error {
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invokerID == house.Owner {
+		return nil
+	}
+
+	delegation, found, err := getDelegation(APIstub, key, invokerID)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	if found && delegationActive(delegation, house, txTime) {
+		for _, granted := range delegation.Permissions {
+			if granted == permission {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Access denied. Only the owner of house %s or a manager with the %s permission can do this", key, permission)
+}
+
+/*
+ * delegateManagement grants management permissions on the house to a manager, only the owner can do it
+ * args: house key, manager, permissions as a comma separated list, expiry (RFC 3339)
+ */
+func (s *SmartContract) delegateManagement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[1] == "" {
+		return shim.Error("Manager must not be empty")
+	}
+	permissions := splitList(args[2])
+	if len(permissions) == 0 {
+		return shim.Error("At least one permission must be granted")
+	}
+	for _, permission := range permissions {
+		known := false
+		for _, delegable := range delegablePermissions {
+			if permission == delegable {
+				known = true
+			}
+		}
+		if !known {
+			return shim.Error(fmt.Sprintf("Unknown permission %q", permission))
+		}
+	}
+	expiry, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("Expiry must be formatted as RFC 3339")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expiry.After(txTime) {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == house.Owner {
+		return shim.Error("The owner cannot be a manager of its own house")
+	}
+
+	var delegation = Delegation{
+		HouseKey:    args[0],
+		Manager:     args[1],
+		Owner:       house.Owner,
+		Permissions: permissions,
+		Expiry:      expiry.UTC().Format(timeLayout),
+	}
+
+	delegationKey, err := APIstub.CreateCompositeKey(delegationObjectType, []string{delegation.HouseKey, delegation.Manager})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	delegationAsBytes, _ := json.Marshal(delegation)
+	if err := APIstub.PutState(delegationKey, delegationAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexKey, err := APIstub.CreateCompositeKey(managerIndex, []string{delegation.Manager, delegation.HouseKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(delegationAsBytes)
+}
+
+/*
+ * revokeManagement withdraws the delegation of the house to a manager, only the owner can do it
+ * args: house key, manager
+ */
+func (s *SmartContract) revokeManagement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, found, err := getDelegation(APIstub, args[0], args[1]); err != nil {
+		return shim.Error(err.Error())
+	} else if !found {
+		return shim.Error(args[1] + " does not manage house " + args[0])
+	}
+
+	delegationKey, err := APIstub.CreateCompositeKey(delegationObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(delegationKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(managerIndex, []string{args[1], args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(indexKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * queryManagedHouses lists the houses a manager currently controls, with the delegations
+ * args: manager
+ */
+func (s *SmartContract) queryManagedHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	keys, err := queryIndexedHouseKeys(APIstub, managerIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type managedHouse struct {
+		Key        string     `json:"Key"`
+		Record     House      `json:"Record"`
+		Delegation Delegation `json:"Delegation"`
+	}
+
+	managed := []managedHouse{}
+	for _, key := range keys {
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		delegation, found, err := getDelegation(APIstub, key, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if found && delegationActive(delegation, house, txTime) {
+			managed = append(managed, managedHouse{Key: key, Record: house, Delegation: delegation})
+		}
+	}
+
+	managedAsBytes, _ := json.Marshal(managed)
+	return shim.Success(managedAsBytes)
+}
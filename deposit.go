@@ -0,0 +1,314 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Security deposits
+ * The tenant of a lease pays a security deposit held in escrow for the duration of the lease.
+ * The landlord may claim deductions from it, each of them taking effect only once acknowledged
+ * by the tenant or approved by an arbitrator. The balance is refunded once the lease is terminated.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const securityDepositObjectType = "securityDeposit"
+
+// Deposit and deduction statuses
+const (
+	depositHeld       = "held"
+	depositRefunded   = "refunded"
+	deductionClaimed  = "claimed"
+	deductionApproved = "approved"
+)
+
+// Define the deduction structure, a part of the deposit claimed by the landlord
+type Deduction struct {
+	ID         string `json:"id"`
+	Amount     int64  `json:"amount"`
+	Reason     string `json:"reason"`
+	Status     string `json:"status"`
+	ApprovedBy string `json:"approvedby,omitempty"`
+}
+
+// Define the security deposit structure, one per lease. Balance is the amount still held
+type SecurityDeposit struct {
+	LeaseID    string      `json:"leaseid"`
+	Tenant     string      `json:"tenant"`
+	Amount     int64       `json:"amount"`
+	Balance    int64       `json:"balance"`
+	Deductions []Deduction `json:"deductions"`
+	Status     string      `json:"status"`
+	Refunded   int64       `json:"refunded,omitempty"`
+	RefundedAt string      `json:"refundedat,omitempty"`
+}
+
+func getSecurityDeposit(APIstub shim.ChaincodeStubInterface, leaseID string) (SecurityDeposit, error) {
+	deposit := SecurityDeposit{}
+
+	depositKey, err := APIstub.CreateCompositeKey(securityDepositObjectType, []string{leaseID})
+	if err != nil {
+		return deposit, err
+	}
+	depositAsBytes, err := APIstub.GetState(depositKey)
+	if err != nil {
+		return deposit, err
+	}
+	if depositAsBytes == nil {
+		return deposit, fmt.Errorf("No security deposit for lease %s", leaseID)
+	}
+
+	err = json.Unmarshal(depositAsBytes, &deposit)
+	return deposit, err
+}
+
+func putSecurityDeposit(APIstub shim.ChaincodeStubInterface, deposit SecurityDeposit) ([]byte, error) {
+	depositKey, err := APIstub.CreateCompositeKey(securityDepositObjectType, []string{deposit.LeaseID})
+	if err != nil {
+		return nil, err
+	}
+	depositAsBytes, _ := json.Marshal(deposit)
+	return depositAsBytes, APIstub.PutState(depositKey, depositAsBytes)
+}
+
+// claimedDeductions returns the total of the deductions claimed and not yet approved
+func claimedDeductions(deposit SecurityDeposit) int64 {
+	total := int64(0)
+	for _, deduction := range deposit.Deductions {
+		if deduction.Status == deductionClaimed {
+			total += deduction.Amount
+		}
+	}
+	return total
+}
+
+/*
+ * depositSecurity records the payment of the security deposit, only the tenant can do it
+ * args: lease ID, amount
+ */
+func (s *SmartContract) depositSecurity(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != lease.Tenant {
+		return shim.Error("Only the tenant " + lease.Tenant + " can pay the deposit of lease " + lease.ID)
+	}
+	if lease.Status != leaseActive {
+		return shim.Error("Lease " + lease.ID + " is " + lease.Status)
+	}
+	if _, err := getSecurityDeposit(APIstub, lease.ID); err == nil {
+		return shim.Error("The deposit of lease " + lease.ID + " was already paid")
+	}
+
+	depositAsBytes, err := putSecurityDeposit(APIstub, SecurityDeposit{
+		LeaseID:    lease.ID,
+		Tenant:     lease.Tenant,
+		Amount:     amount,
+		Balance:    amount,
+		Deductions: []Deduction{},
+		Status:     depositHeld,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(depositAsBytes)
+}
+
+/*
+ * claimDeduction claims a part of the deposit, only the landlord can do it
+ * args: lease ID, amount, reason
+ */
+func (s *SmartContract) claimDeduction(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+	if args[2] == "" {
+		return shim.Error("Reason must not be empty")
+	}
+
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLandlord(APIstub, lease); err != nil {
+		return shim.Error(err.Error())
+	}
+	deposit, err := getSecurityDeposit(APIstub, lease.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if deposit.Status != depositHeld {
+		return shim.Error("The deposit of lease " + lease.ID + " is " + deposit.Status)
+	}
+	if amount > deposit.Balance-claimedDeductions(deposit) {
+		return shim.Error(fmt.Sprintf("Deductions cannot exceed the deposit balance of %d", deposit.Balance))
+	}
+
+	deposit.Deductions = append(deposit.Deductions, Deduction{ID: APIstub.GetTxID(), Amount: amount, Reason: args[2], Status: deductionClaimed})
+	depositAsBytes, err := putSecurityDeposit(APIstub, deposit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(depositAsBytes)
+}
+
+/*
+ * approveDeduction approves a claimed deduction, for the tenant or an arbitrator
+ * args: lease ID, deduction ID
+ */
+func (s *SmartContract) approveDeduction(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	deposit, err := getSecurityDeposit(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != deposit.Tenant {
+		if err := requireRole(APIstub, roleArbitrator); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	found := false
+	for i, deduction := range deposit.Deductions {
+		if deduction.ID == args[1] && deduction.Status == deductionClaimed {
+			deposit.Deductions[i].Status = deductionApproved
+			deposit.Deductions[i].ApprovedBy = invoker
+			deposit.Balance -= deduction.Amount
+			found = true
+		}
+	}
+	if !found {
+		return shim.Error("No claimed deduction " + args[1] + " on lease " + args[0])
+	}
+
+	depositAsBytes, err := putSecurityDeposit(APIstub, deposit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(depositAsBytes)
+}
+
+/*
+ * refundDeposit refunds the balance of the deposit once the lease is terminated, for the landlord or the tenant.
+ * Deductions still awaiting approval must be settled first
+ * args: lease ID
+ */
+func (s *SmartContract) refundDeposit(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != lease.Landlord && invoker != lease.Tenant {
+		return shim.Error("Only the parties of lease " + lease.ID + " can refund its deposit")
+	}
+	if lease.Status != leaseTerminated {
+		return shim.Error("Lease " + lease.ID + " is not terminated")
+	}
+
+	deposit, err := getSecurityDeposit(APIstub, lease.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if deposit.Status != depositHeld {
+		return shim.Error("The deposit of lease " + lease.ID + " is " + deposit.Status)
+	}
+	if claimedDeductions(deposit) > 0 {
+		return shim.Error("Deductions of lease " + lease.ID + " are awaiting approval")
+	}
+
+	refundedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deposit.Refunded = deposit.Balance
+	deposit.Balance = 0
+	deposit.Status = depositRefunded
+	deposit.RefundedAt = refundedAt.Format(timeLayout)
+
+	depositAsBytes, err := putSecurityDeposit(APIstub, deposit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "depositRefunded", depositAsBytes)
+	return shim.Success(depositAsBytes)
+}
+
+/*
+ * querySecurityDeposit returns the deposit of the lease
+ * args: lease ID
+ */
+func (s *SmartContract) querySecurityDeposit(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	deposit, err := getSecurityDeposit(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	depositAsBytes, _ := json.Marshal(deposit)
+	return shim.Success(depositAsBytes)
+}
@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* State digest
+ * A rolling hash over a key range lets two organisations compare the state they see
+ * without exchanging it. Keys are visited in lexical order, so the digest is deterministic:
+ *   digest(0) = sha256("")
+ *   digest(n) = sha256(digest(n-1) || sha256(key) || sha256(value))
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Define the digest structure returned by computeStateDigest
+type StateDigest struct {
+	Prefix     string `json:"prefix"`
+	Collection string `json:"collection,omitempty"`
+	Count      int    `json:"count"`
+	Digest     string `json:"digest"`
+}
+
+/*
+ * computeStateDigest hashes every key starting with the prefix, in the public state or in a private data collection
+ * args: key prefix (empty for every simple key), private data collection (optional)
+ */
+func (s *SmartContract) computeStateDigest(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	prefix := args[0]
+	collection := ""
+	if len(args) == 2 {
+		collection = args[1]
+	}
+
+	startKey, endKey := "", ""
+	if prefix != "" {
+		startKey, endKey = prefix, prefix+string(utf8.MaxRune)
+	}
+
+	var resultsIterator shim.StateQueryIteratorInterface
+	var err error
+	if collection == "" {
+		resultsIterator, err = APIstub.GetStateByRange(startKey, endKey)
+	} else {
+		resultsIterator, err = APIstub.GetPrivateDataByRange(collection, startKey, endKey)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	digest := sha256.Sum256(nil)
+	count := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		keyHash := sha256.Sum256([]byte(queryResponse.Key))
+		valueHash := sha256.Sum256(queryResponse.Value)
+
+		hasher := sha256.New()
+		hasher.Write(digest[:])
+		hasher.Write(keyHash[:])
+		hasher.Write(valueHash[:])
+		copy(digest[:], hasher.Sum(nil))
+		count++
+	}
+
+	var stateDigest = StateDigest{Prefix: prefix, Collection: collection, Count: count, Digest: hex.EncodeToString(digest[:])}
+
+	stateDigestAsBytes, _ := json.Marshal(stateDigest)
+	return shim.Success(stateDigestAsBytes)
+}
@@ -0,0 +1,247 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Disputes
+ * A court flags a house as in dispute, which blocks any transfer until the court resolves it.
+ * Resolution either dismisses the claim or forces the transfer ordered by the court.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const disputeObjectType = "dispute"
+
+// Dispute statuses
+const (
+	disputeOpen     = "open"
+	disputeResolved = "resolved"
+)
+
+// Dispute outcomes
+const (
+	outcomeDismissed     = "dismissed"
+	outcomeForceTransfer = "transfer"
+)
+
+// Define the dispute structure. Disputes are keyed by house key and dispute ID (the opening txID)
+type Dispute struct {
+	ID         string `json:"id"`
+	HouseKey   string `json:"housekey"`
+	Claimant   string `json:"claimant"`
+	Reason     string `json:"reason"`
+	Status     string `json:"status"`
+	OpenedAt   string `json:"openedat"`
+	Outcome    string `json:"outcome,omitempty"`
+	NewOwner   string `json:"newowner,omitempty"`
+	ResolvedAt string `json:"resolvedat,omitempty"`
+}
+
+func getDispute(APIstub shim.ChaincodeStubInterface, houseKey string, disputeID string) (Dispute, string, error) {
+	dispute := Dispute{}
+
+	disputeKey, err := APIstub.CreateCompositeKey(disputeObjectType, []string{houseKey, disputeID})
+	if err != nil {
+		return dispute, "", err
+	}
+	disputeAsBytes, err := APIstub.GetState(disputeKey)
+	if err != nil {
+		return dispute, "", err
+	}
+	if disputeAsBytes == nil {
+		return dispute, "", fmt.Errorf("Dispute %s does not exist for house %s", disputeID, houseKey)
+	}
+
+	err = json.Unmarshal(disputeAsBytes, &dispute)
+	return dispute, disputeKey, err
+}
+
+/*
+ * openDispute flags a house as in dispute
+ * args: house key, claimant, reason
+ */
+func (s *SmartContract) openDispute(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleCourt); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.DisputeID != "" {
+		return shim.Error("House " + args[0] + " is already in dispute")
+	}
+
+	openedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var dispute = Dispute{
+		ID:       APIstub.GetTxID(),
+		HouseKey: args[0],
+		Claimant: args[1],
+		Reason:   args[2],
+		Status:   disputeOpen,
+		OpenedAt: openedAt.Format(timeLayout),
+	}
+
+	disputeKey, err := APIstub.CreateCompositeKey(disputeObjectType, []string{dispute.HouseKey, dispute.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	disputeAsBytes, _ := json.Marshal(dispute)
+	if err := APIstub.PutState(disputeKey, disputeAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house.DisputeID = dispute.ID
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "disputeOpened", disputeAsBytes)
+	return shim.Success(disputeAsBytes)
+}
+
+/*
+ * resolveDispute closes the open dispute of a house
+ * args: house key, outcome ("dismissed" or "transfer"), new owner (required for "transfer")
+ */
+func (s *SmartContract) resolveDispute(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 2 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	if err := requireRole(APIstub, roleCourt); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	outcome := args[1]
+	newOwner := ""
+	if len(args) == 3 {
+		newOwner = args[2]
+	}
+	if outcome != outcomeDismissed && outcome != outcomeForceTransfer {
+		return shim.Error("Outcome must be \"" + outcomeDismissed + "\" or \"" + outcomeForceTransfer + "\"")
+	}
+	if outcome == outcomeForceTransfer && newOwner == "" {
+		return shim.Error("A forced transfer requires the new owner")
+	}
+
+	disputeAsBytes, err := closeDispute(APIstub, args[0], outcome, newOwner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(disputeAsBytes)
+}
+
+// closeDispute resolves the open dispute of the house with the outcome, transferring it to the new owner for a forced transfer
+func closeDispute(APIstub shim.ChaincodeStubInterface, key string, outcome string, newOwner string) ([]byte, error) {
+	house, err := getHouse(APIstub, key)
+	if err != nil {
+		return nil, err
+	}
+	if house.DisputeID == "" {
+		return nil, fmt.Errorf("House %s is not in dispute", key)
+	}
+
+	dispute, disputeKey, err := getDispute(APIstub, key, house.DisputeID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	dispute.Status = disputeResolved
+	dispute.Outcome = outcome
+	dispute.NewOwner = newOwner
+	dispute.ResolvedAt = resolvedAt.Format(timeLayout)
+
+	disputeAsBytes, _ := json.Marshal(dispute)
+	if err := APIstub.PutState(disputeKey, disputeAsBytes); err != nil {
+		return nil, err
+	}
+
+	// Lift the dispute flag first, so the court ordered transfer is not blocked by it
+	house.DisputeID = ""
+	if outcome == outcomeForceTransfer {
+		err = transferHouse(APIstub, key, house, newOwner, reasonCourtOrder, 0)
+	} else {
+		err = putHouse(APIstub, key, house)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	emitEvent(APIstub, "disputeResolved", disputeAsBytes)
+	return disputeAsBytes, nil
+}
+
+// queryDisputedHouses returns the houses currently in dispute, along with their open dispute
+func (s *SmartContract) queryDisputedHouses(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(disputeObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type disputedHouse struct {
+		Key     string  `json:"Key"`
+		Record  House   `json:"Record"`
+		Dispute Dispute `json:"Dispute"`
+	}
+
+	results := []disputedHouse{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dispute := Dispute{}
+		if err := json.Unmarshal(queryResponse.Value, &dispute); err != nil {
+			return shim.Error(err.Error())
+		}
+		if dispute.Status != disputeOpen {
+			continue
+		}
+		house, err := getHouse(APIstub, dispute.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		results = append(results, disputedHouse{Key: dispute.HouseKey, Record: house, Dispute: dispute})
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
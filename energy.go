@@ -0,0 +1,205 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Energy performance certificates
+ * Every house carries the rating (A to G) of its energy performance certificate (DPE), the ID of
+ * the certificate and its expiry date. A house can only be listed for sale with a valid certificate.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const energyRatingIndex = "energyRating~key"
+
+var energyRatings = []string{"A", "B", "C", "D", "E", "F", "G"}
+
+func parseEnergyRating(rating string) (string, error) {
+	rating = strings.ToUpper(rating)
+	for _, energyRating := range energyRatings {
+		if rating == energyRating {
+			return rating, nil
+		}
+	}
+	return "", fmt.Errorf("Energy rating must be one of %s", strings.Join(energyRatings, ", "))
+}
+
+func energyRatingEntries(house House) [][]string {
+	if house.EnergyRating == "" {
+		return [][]string{}
+	}
+	return [][]string{{house.EnergyRating}}
+}
+
+// checkEnergyCertificate returns an error unless the house has a certificate valid at the transaction date
+func checkEnergyCertificate(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	if house.EnergyCertificateID == "" {
+		return fmt.Errorf("House %s has no energy performance certificate", key)
+	}
+	expiry, err := time.Parse(dayLayout, house.EnergyCertificateExpiry)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(expiry.AddDate(0, 0, 1)) {
+		return fmt.Errorf("Energy performance certificate %s of house %s expired on %s", house.EnergyCertificateID, key, house.EnergyCertificateExpiry)
+	}
+	return nil
+}
+
+/*
+ * setEnergyCertificate records the energy performance certificate of the house, only the owner can do it
+ * args: house key, rating (A to G), certificate ID, expiry date (YYYY-MM-DD)
+ */
+func (s *SmartContract) setEnergyCertificate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	rating, err := parseEnergyRating(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[2] == "" {
+		return shim.Error("Certificate ID must not be empty")
+	}
+	if _, err := time.Parse(dayLayout, args[3]); err != nil {
+		return shim.Error("Expiry date must be formatted YYYY-MM-DD")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house.EnergyRating = rating
+	house.EnergyCertificateID = args[2]
+	house.EnergyCertificateExpiry = args[3]
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * listHouseForSale puts the house on sale at the asking price, only the owner can do it
+ * args: house key, asking price
+ */
+func (s *SmartContract) listHouseForSale(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	price, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || price <= 0 {
+		return shim.Error("Asking price must be a positive number")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkEnergyCertificate(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house.AskingPrice = price
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * withdrawHouseListing takes the house off the market, only the owner can do it
+ * args: house key
+ */
+func (s *SmartContract) withdrawHouseListing(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.AskingPrice == 0 {
+		return shim.Error("House " + args[0] + " is not for sale")
+	}
+
+	house.AskingPrice = 0
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * queryHousesByEnergyRating returns the houses of the given energy rating
+ * args: rating (A to G)
+ */
+func (s *SmartContract) queryHousesByEnergyRating(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	rating, err := parseEnergyRating(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	keys, err := queryIndexedHouseKeys(APIstub, energyRatingIndex, []string{rating})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
@@ -0,0 +1,333 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Legal entities
+ * Houses can be held by legal entities (trusts, SCIs, companies) registered by the registrars.
+ * An entity records its beneficiaries or shareholders with their share, and the list of its
+ * authorized signatories: only a signatory can act as the owner of a house held by the entity,
+ * including its transfer.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	legalEntityObjectType  = "legalEntity"
+	entityMemberObjectType = "entityMember"
+)
+
+// Prefix of the owner names of the legal entities, which are not certificate common names
+const entityPrefix = "#entity:"
+
+// Kinds of legal entities
+var entityKinds = []string{"trust", "sci", "company"}
+
+// Roles of the members of the legal entities
+const (
+	memberBeneficiary = "beneficiary"
+	memberShareholder = "shareholder"
+)
+
+// Define the legal entity structure
+type LegalEntity struct {
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	Signatories  []string `json:"signatories"`
+	RegisteredBy string   `json:"registeredby"`
+	RegisteredAt string   `json:"registeredat"`
+}
+
+// Define the entity member structure, a beneficiary of a trust or a shareholder of a company
+type EntityMember struct {
+	EntityID string `json:"entityid"`
+	Holder   string `json:"holder"`
+	Role     string `json:"role"`
+	Share    int    `json:"share"`
+}
+
+func isLegalEntity(owner string) bool {
+	return strings.HasPrefix(owner, entityPrefix)
+}
+
+func getLegalEntity(APIstub shim.ChaincodeStubInterface, id string) (LegalEntity, error) {
+	entityKey, err := APIstub.CreateCompositeKey(legalEntityObjectType, []string{id})
+	if err != nil {
+		return LegalEntity{}, err
+	}
+	entityAsBytes, err := APIstub.GetState(entityKey)
+	if err != nil {
+		return LegalEntity{}, err
+	}
+	if entityAsBytes == nil {
+		return LegalEntity{}, fmt.Errorf("Legal entity %s does not exist", id)
+	}
+	entity := LegalEntity{}
+	err = json.Unmarshal(entityAsBytes, &entity)
+	return entity, err
+}
+
+func putLegalEntity(APIstub shim.ChaincodeStubInterface, entity LegalEntity) ([]byte, error) {
+	entityKey, err := APIstub.CreateCompositeKey(legalEntityObjectType, []string{entity.ID})
+	if err != nil {
+		return nil, err
+	}
+	entityAsBytes, _ := json.Marshal(entity)
+	return entityAsBytes, APIstub.PutState(entityKey, entityAsBytes)
+}
+
+func getEntityMembers(APIstub shim.ChaincodeStubInterface, id string) ([]EntityMember, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(entityMemberObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	members := []EntityMember{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		member := EntityMember{}
+		if err := json.Unmarshal(queryResponse.Value, &member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// isEntitySignatory tells whether the identity is an authorized signatory of the legal entity
+func isEntitySignatory(APIstub shim.ChaincodeStubInterface, id string, identity string) (bool, error) {
+	entity, err := getLegalEntity(APIstub, id)
+	if err != nil {
+		return false, err
+	}
+	for _, signatory := range entity.Signatories {
+		if signatory == identity {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkEntitySignatory returns an error unless the invoker is a signatory of every legal entity holding a share of the house
+func checkEntitySignatory(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, share := range houseShares(house) {
+		if !isLegalEntity(share.Owner) {
+			continue
+		}
+		signatory, err := isEntitySignatory(APIstub, share.Owner, invokerID)
+		if err != nil {
+			return err
+		}
+		if !signatory {
+			return fmt.Errorf("Access denied. Only a signatory of %s can transfer house %s", share.Owner, key)
+		}
+	}
+	return nil
+}
+
+// checkEntityParty screens the signatories of a legal entity becoming owner, for checkTransferAllowed
+func checkEntityParty(APIstub shim.ChaincodeStubInterface, id string) error {
+	entity, err := getLegalEntity(APIstub, id)
+	if err != nil {
+		return err
+	}
+	for _, signatory := range entity.Signatories {
+		if err := checkNotBlocked(APIstub, signatory); err != nil {
+			return err
+		}
+		if err := checkKYCVerified(APIstub, signatory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * registerLegalEntity registers a legal entity able to hold houses, for registrars
+ * args: entity name (the owner name is #entity: followed by it), kind (trust, sci or company), legal name, signatories as a comma separated list
+ */
+func (s *SmartContract) registerLegalEntity(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Entity name must not be empty")
+	}
+	known := false
+	for _, kind := range entityKinds {
+		known = known || kind == args[1]
+	}
+	if !known {
+		return shim.Error(fmt.Sprintf("Unknown entity kind %q, expecting one of %v", args[1], entityKinds))
+	}
+	signatories := splitList(args[3])
+	if len(signatories) == 0 {
+		return shim.Error("An entity must have at least one signatory")
+	}
+
+	id := entityPrefix + args[0]
+	if _, err := getLegalEntity(APIstub, id); err == nil {
+		return shim.Error("Legal entity " + id + " already exists")
+	}
+	registeredBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	registeredAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entityAsBytes, err := putLegalEntity(APIstub, LegalEntity{ID: id, Kind: args[1], Name: args[2], Signatories: signatories, RegisteredBy: registeredBy, RegisteredAt: registeredAt.Format(timeLayout)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(entityAsBytes)
+}
+
+/*
+ * setEntitySignatories replaces the authorized signatories of a legal entity, for registrars
+ * args: entity ID, signatories as a comma separated list
+ */
+func (s *SmartContract) setEntitySignatories(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	signatories := splitList(args[1])
+	if len(signatories) == 0 {
+		return shim.Error("An entity must have at least one signatory")
+	}
+
+	entity, err := getLegalEntity(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entity.Signatories = signatories
+	entityAsBytes, err := putLegalEntity(APIstub, entity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "entitySignatoriesChanged", entityAsBytes)
+	return shim.Success(entityAsBytes)
+}
+
+/*
+ * setEntityMember records a beneficiary or shareholder of a legal entity with its share, for registrars. A zero share removes it
+ * args: entity ID, holder, role (beneficiary or shareholder), share in basis points
+ */
+func (s *SmartContract) setEntityMember(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[2] != memberBeneficiary && args[2] != memberShareholder {
+		return shim.Error("Role must be " + memberBeneficiary + " or " + memberShareholder)
+	}
+	share, err := strconv.Atoi(args[3])
+	if err != nil || share < 0 || share > wholeShare {
+		return shim.Error(fmt.Sprintf("Share must be between 0 and %d basis points", wholeShare))
+	}
+
+	if _, err := getLegalEntity(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	members, err := getEntityMembers(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	total := share
+	for _, member := range members {
+		if member.Holder != args[1] && member.Role == args[2] {
+			total += member.Share
+		}
+	}
+	if total > wholeShare {
+		return shim.Error(fmt.Sprintf("Shares of the %ss of %s would sum to %d, more than %d", args[2], args[0], total, wholeShare))
+	}
+
+	memberKey, err := APIstub.CreateCompositeKey(entityMemberObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if share == 0 {
+		if err := APIstub.DelState(memberKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	memberAsBytes, _ := json.Marshal(EntityMember{EntityID: args[0], Holder: args[1], Role: args[2], Share: share})
+	if err := APIstub.PutState(memberKey, memberAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(memberAsBytes)
+}
+
+// queryLegalEntity returns a legal entity with its beneficiaries and shareholders. args: entity ID
+func (s *SmartContract) queryLegalEntity(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	entity, err := getLegalEntity(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	members, err := getEntityMembers(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result = struct {
+		LegalEntity
+		Members []EntityMember `json:"members"`
+	}{LegalEntity: entity, Members: members}
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
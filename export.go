@@ -0,0 +1,129 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Bulk export
+ * Houses are exported page by page as newline-delimited JSON, one house per line,
+ * for loading into off-chain analytics stores
+ */
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Define the export line structure, one per exported house
+type exportedHouse struct {
+	Key      string          `json:"key"`
+	LastTxID string          `json:"lastTxID"`
+	Record   json.RawMessage `json:"record"`
+}
+
+// Define the metadata line closing every export chunk, the bookmark resumes the export at the next page
+type exportMetadata struct {
+	ResponseMetadata struct {
+		RecordsCount int32  `json:"RecordsCount"`
+		Bookmark     string `json:"Bookmark"`
+	} `json:"ResponseMetadata"`
+}
+
+// lastTxIDFromHistory finds the last writing transaction of records written before LastTxID was stored
+func lastTxIDFromHistory(APIstub shim.ChaincodeStubInterface, key string) (string, error) {
+	historyIterator, err := APIstub.GetHistoryForKey(key)
+	if err != nil {
+		return "", err
+	}
+	defer historyIterator.Close()
+
+	lastTxID := ""
+	lastSeconds := int64(0)
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return "", err
+		}
+		if modification.Timestamp == nil || modification.Timestamp.Seconds >= lastSeconds {
+			lastTxID = modification.TxId
+			if modification.Timestamp != nil {
+				lastSeconds = modification.Timestamp.Seconds
+			}
+		}
+	}
+	return lastTxID, nil
+}
+
+/*
+ * exportAllHouses returns one page of houses as newline-delimited JSON
+ * args: bookmark (empty for the first page), page size, optional response encoding (identity or gzip)
+ */
+func (s *SmartContract) exportAllHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+
+	resultsIterator, responseMetadata, err := APIstub.GetStateByRangeWithPagination(houseStartKey, houseEndKey, int32(pageSize), args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		house, err := decodeHouse(queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		lastTxID := house.LastTxID
+		if lastTxID == "" {
+			lastTxID, err = lastTxIDFromHistory(APIstub, queryResponse.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		houseAsBytes, _ := json.Marshal(house)
+		lineAsBytes, _ := json.Marshal(exportedHouse{Key: queryResponse.Key, LastTxID: lastTxID, Record: houseAsBytes})
+		buffer.Write(lineAsBytes)
+		buffer.WriteString("\n")
+	}
+
+	var metadata exportMetadata
+	metadata.ResponseMetadata.RecordsCount = responseMetadata.FetchedRecordsCount
+	metadata.ResponseMetadata.Bookmark = responseMetadata.Bookmark
+	metadataAsBytes, _ := json.Marshal(metadata)
+	buffer.Write(metadataAsBytes)
+	buffer.WriteString("\n")
+
+	return shim.Success(buffer.Bytes())
+}
@@ -25,36 +25,82 @@
 package main
 
 /* Imports
- * 4 utility libraries for formatting, handling bytes, reading and writing JSON, and string manipulation
+ * 6 utility libraries for formatting, handling bytes, reading and writing JSON, environment, string manipulation and time
  * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
  */
 import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
+	"time"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	sc "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
 )
 
 // Define the Smart Contract structure
 type SmartContract struct {
 }
 
-// Define the house structure, with 4 properties.  Structure tags are used by encoding/json library
+// Define the house structure, with 16 properties.  Structure tags are used by encoding/json library
+// and by the protobuf codec (field numbers of house.proto, which must be kept in sync)
+// Shares lists the co-owners when the house is held jointly, Owner being then the first of them
+// AskingPrice is set while the house is listed for sale
 type House struct {
-	Year   string `json:"year"`
-	SquareFeets  string `json:"squarefeets"`
-	Location string `json:"location"`
-	Owner  string `json:"owner"`
+	Year                    string           `json:"year" protobuf:"1"`
+	SquareFeets             string           `json:"squarefeets" protobuf:"2"`
+	Location                string           `json:"location" protobuf:"3"`
+	Owner                   string           `json:"owner" protobuf:"4"`
+	Usage                   string           `json:"usage,omitempty" protobuf:"5"`
+	Zone                    string           `json:"zone,omitempty" protobuf:"6"`
+	DisputeID               string           `json:"disputeid,omitempty" protobuf:"7"`
+	Shares                  []OwnershipShare `json:"shares,omitempty" protobuf:"8"`
+	Beneficiaries           []string         `json:"beneficiaries,omitempty" protobuf:"9"`
+	LastTxID                string           `json:"lasttxid,omitempty" protobuf:"10"`
+	Description             string           `json:"description,omitempty" protobuf:"11"`
+	EnergyRating            string           `json:"energyrating,omitempty" protobuf:"12"`
+	EnergyCertificateID     string           `json:"energycertificateid,omitempty" protobuf:"13"`
+	EnergyCertificateExpiry string           `json:"energycertificateexpiry,omitempty" protobuf:"14"`
+	AskingPrice             int64            `json:"askingprice,omitempty" protobuf:"15"`
+	Tags                    []string         `json:"tags,omitempty" protobuf:"16"`
+	Address                 *Address         `json:"address,omitempty" protobuf:"17"`
+	CadastralRef            string           `json:"cadastralref,omitempty" protobuf:"18"`
+	UpdatedAt               string           `json:"updatedat,omitempty" protobuf:"19"`
 }
 
+// Range of keys holding the houses
+const (
+	houseStartKey = "HOUSE0"
+	houseEndKey   = "HOUSE999"
+)
+
+// Layout used to record transaction timestamps in ledger records
+const timeLayout = time.RFC3339
+
 /*
  * The Init method is called when the Smart Contract "fabhouse" is instantiated by the blockchain network
  * Best practice is to have any Ledger initialization in separate function -- see initLedger()
+ * The first admin can be designated by its identity ID as the first argument of Init, or else the MSP whose
+ * members can bootstrap it as the second argument
+ * Init also runs on upgrades, the outboxes opened by an earlier version are indexed then
  */
 func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
+	_, args := APIstub.GetFunctionAndParameters()
+	if len(args) > 0 && args[0] != "" {
+		if _, err := bootstrapAdminIdentity(APIstub, args[0], "init"); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 1 && args[1] != "" {
+		if err := APIstub.PutState(adminBootstrapMSPKey, []byte(args[1])); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := reindexOutboxes(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -64,8 +110,61 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
  */
 func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
 
+	// Writes of the transaction are read back by its later steps
+	APIstub = newWriteCacheStub(APIstub)
+
 	// Retrieve the requested Smart Contract function and arguments
 	function, args := APIstub.GetFunctionAndParameters()
+	applyLogLevel(APIstub)
+
+	// Named arguments and calls of a later version of the API are adapted to the handlers first.
+	// Rules of the rules table restricted to this function are evaluated before running it
+	var response sc.Response
+	var encoding string
+	args, err := resolveNamedArgs(function, args)
+	if err == nil {
+		function, args, err = resolveAPIVersion(function, args)
+	}
+	if err == nil {
+		args, encoding, err = negotiateEncoding(function, args)
+	}
+	if err != nil {
+		response = shim.Error(err.Error())
+	} else if err := evaluateRules(APIstub, ruleContext{function: function}); err != nil {
+		response = shim.Error(err.Error())
+	} else {
+		response = encodeResponse(s.invokeUnlessPaused(APIstub, function, args), encoding)
+	}
+	if response.Status < shim.ERRORTHRESHOLD {
+		if err := deliverNotifications(APIstub, function); err != nil {
+			response = shim.Error(err.Error())
+		}
+	}
+	recordInvocation(APIstub, function, response)
+	if response.Status >= shim.ERRORTHRESHOLD {
+		logFor(APIstub).Warnf("Failed: %s", response.Message)
+	}
+
+	return response
+}
+
+// route calls the handler function of the requested Smart Contract function
+func (s *SmartContract) route(APIstub shim.ChaincodeStubInterface, function string, args []string) sc.Response {
+
+	// The functions of the features disabled by admins, or in a maintenance window, are not available
+	if err := checkFeature(APIstub, function); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkMaintenanceWindows(APIstub, function); err != nil {
+		return shim.Error(err.Error())
+	}
+	args = canonicalizeArgs(APIstub, function, args)
+	if stub := transactionOf(APIstub); stub != nil {
+		previous := stub.function
+		stub.function = function
+		defer func() { stub.function = previous }()
+	}
+
 	// Route to the appropriate handler function to interact with the ledger appropriately
 	if function == "queryHouse" {
 		return s.queryHouse(APIstub, args)
@@ -74,25 +173,733 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 	} else if function == "createHouse" {
 		return s.createHouse(APIstub, args)
 	} else if function == "queryAllHouses" {
-		return s.queryAllHouses(APIstub)
+		return s.queryAllHouses(APIstub, args)
 	} else if function == "changeHouseOwner" {
 		return s.changeHouseOwner(APIstub, args)
+	} else if function == "renovateHouse" {
+		return s.renovateHouse(APIstub, args)
+	} else if function == "setZoningRule" {
+		return s.setZoningRule(APIstub, args)
+	} else if function == "deleteZoningRule" {
+		return s.deleteZoningRule(APIstub, args)
+	} else if function == "queryZoningRule" {
+		return s.queryZoningRule(APIstub, args)
+	} else if function == "queryAllZoningRules" {
+		return s.queryAllZoningRules(APIstub)
+	} else if function == "setMSPRoles" {
+		return s.setMSPRoles(APIstub, args)
+	} else if function == "queryMSPRoles" {
+		return s.queryMSPRoles(APIstub, args)
+	} else if function == "openDispute" {
+		return s.openDispute(APIstub, args)
+	} else if function == "resolveDispute" {
+		return s.resolveDispute(APIstub, args)
+	} else if function == "queryDisputedHouses" {
+		return s.queryDisputedHouses(APIstub)
+	} else if function == "registerBeneficiary" {
+		return s.registerBeneficiary(APIstub, args)
+	} else if function == "removeBeneficiary" {
+		return s.removeBeneficiary(APIstub, args)
+	} else if function == "executeSuccession" {
+		return s.executeSuccession(APIstub, args)
+	} else if function == "queryTransferHistory" {
+		return s.queryTransferHistory(APIstub, args)
+	} else if function == "queryTransferReport" {
+		return s.queryTransferReport(APIstub, args)
+	} else if function == "exportAllHouses" {
+		return s.exportAllHouses(APIstub, args)
+	} else if function == "computeStateDigest" {
+		return s.computeStateDigest(APIstub, args)
+	} else if function == "setCreationQuota" {
+		return s.setCreationQuota(APIstub, args)
+	} else if function == "queryCreationQuota" {
+		return s.queryCreationQuota(APIstub)
+	} else if function == "setLedgerMetrics" {
+		return s.setLedgerMetrics(APIstub, args)
+	} else if function == "getMetrics" {
+		return s.getMetrics(APIstub)
+	} else if function == "setLogLevel" {
+		return s.setLogLevel(APIstub, args)
+	} else if function == "setHouseCodec" {
+		return s.setHouseCodec(APIstub, args)
+	} else if function == "migrateHouseEncoding" {
+		return s.migrateHouseEncoding(APIstub, args)
+	} else if function == "setSisterRegistry" {
+		return s.setSisterRegistry(APIstub, args)
+	} else if function == "queryAllSisterRegistries" {
+		return s.queryAllSisterRegistries(APIstub)
+	} else if function == "queryHouseOnChannel" {
+		return s.queryHouseOnChannel(APIstub, args)
+	} else if function == "setHouseDescription" {
+		return s.setHouseDescription(APIstub, args)
+	} else if function == "searchHouses" {
+		return s.searchHouses(APIstub, args)
+	} else if function == "queryHousesByLocationPrefix" {
+		return s.queryHousesByLocationPrefix(APIstub, args)
+	} else if function == "queryHousesByLocation" {
+		return s.queryHousesByLocation(APIstub, args)
+	} else if function == "addHousePhoto" {
+		return s.addHousePhoto(APIstub, args)
+	} else if function == "removeHousePhoto" {
+		return s.removeHousePhoto(APIstub, args)
+	} else if function == "queryHousePhotos" {
+		return s.queryHousePhotos(APIstub, args)
+	} else if function == "setEnergyCertificate" {
+		return s.setEnergyCertificate(APIstub, args)
+	} else if function == "listHouseForSale" {
+		return s.listHouseForSale(APIstub, args)
+	} else if function == "withdrawHouseListing" {
+		return s.withdrawHouseListing(APIstub, args)
+	} else if function == "queryHousesByEnergyRating" {
+		return s.queryHousesByEnergyRating(APIstub, args)
+	} else if function == "requestKYC" {
+		return s.requestKYC(APIstub)
+	} else if function == "setKYCStatus" {
+		return s.setKYCStatus(APIstub, args)
+	} else if function == "queryKYCStatus" {
+		return s.queryKYCStatus(APIstub, args)
+	} else if function == "queryPendingKYC" {
+		return s.queryPendingKYC(APIstub)
+	} else if function == "setRegulatorMSP" {
+		return s.setRegulatorMSP(APIstub, args)
+	} else if function == "addToBlocklist" {
+		return s.addToBlocklist(APIstub, args)
+	} else if function == "removeFromBlocklist" {
+		return s.removeFromBlocklist(APIstub, args)
+	} else if function == "queryBlocklist" {
+		return s.queryBlocklist(APIstub)
+	} else if function == "scheduleHouseTransfer" {
+		return s.scheduleHouseTransfer(APIstub, args)
+	} else if function == "cancelScheduledTransfer" {
+		return s.cancelScheduledTransfer(APIstub, args)
+	} else if function == "queryScheduledTransfers" {
+		return s.queryScheduledTransfers(APIstub)
+	} else if function == "finalizeDueTransfers" {
+		return s.finalizeDueTransfers(APIstub)
+	} else if function == "grantOption" {
+		return s.grantOption(APIstub, args)
+	} else if function == "exerciseOption" {
+		return s.exerciseOption(APIstub, args)
+	} else if function == "queryHouseOptions" {
+		return s.queryHouseOptions(APIstub, args)
+	} else if function == "createLease" {
+		return s.createLease(APIstub, args)
+	} else if function == "terminateLease" {
+		return s.terminateLease(APIstub, args)
+	} else if function == "queryHouseLeases" {
+		return s.queryHouseLeases(APIstub, args)
+	} else if function == "recordRentPayment" {
+		return s.recordRentPayment(APIstub, args)
+	} else if function == "getArrearsReport" {
+		return s.getArrearsReport(APIstub, args)
+	} else if function == "depositSecurity" {
+		return s.depositSecurity(APIstub, args)
+	} else if function == "claimDeduction" {
+		return s.claimDeduction(APIstub, args)
+	} else if function == "approveDeduction" {
+		return s.approveDeduction(APIstub, args)
+	} else if function == "refundDeposit" {
+		return s.refundDeposit(APIstub, args)
+	} else if function == "querySecurityDeposit" {
+		return s.querySecurityDeposit(APIstub, args)
+	} else if function == "delegateManagement" {
+		return s.delegateManagement(APIstub, args)
+	} else if function == "revokeManagement" {
+		return s.revokeManagement(APIstub, args)
+	} else if function == "queryManagedHouses" {
+		return s.queryManagedHouses(APIstub, args)
+	} else if function == "openMaintenanceRequest" {
+		return s.openMaintenanceRequest(APIstub, args)
+	} else if function == "assignContractor" {
+		return s.assignContractor(APIstub, args)
+	} else if function == "completeMaintenance" {
+		return s.completeMaintenance(APIstub, args)
+	} else if function == "queryMaintenanceRequests" {
+		return s.queryMaintenanceRequests(APIstub, args)
+	} else if function == "queryMaintenanceCosts" {
+		return s.queryMaintenanceCosts(APIstub, args)
+	} else if function == "recordMeterReading" {
+		return s.recordMeterReading(APIstub, args)
+	} else if function == "queryMeterReadings" {
+		return s.queryMeterReadings(APIstub, args)
+	} else if function == "queryConsumption" {
+		return s.queryConsumption(APIstub, args)
+	} else if function == "createHOA" {
+		return s.createHOA(APIstub, args)
+	} else if function == "addHouseToHOA" {
+		return s.addHouseToHOA(APIstub, args)
+	} else if function == "assessHOAFees" {
+		return s.assessHOAFees(APIstub, args)
+	} else if function == "recordHOAPayment" {
+		return s.recordHOAPayment(APIstub, args)
+	} else if function == "queryHOAArrears" {
+		return s.queryHOAArrears(APIstub, args)
+	} else if function == "openHOAResolution" {
+		return s.openHOAResolution(APIstub, args)
+	} else if function == "voteOnHOAResolution" {
+		return s.voteOnHOAResolution(APIstub, args)
+	} else if function == "closeHOAResolution" {
+		return s.closeHOAResolution(APIstub, args)
+	} else if function == "setCosignPolicy" {
+		return s.setCosignPolicy(APIstub, args)
+	} else if function == "approveCosignedTransfer" {
+		return s.approveCosignedTransfer(APIstub, args)
+	} else if function == "queryPendingCosignatures" {
+		return s.queryPendingCosignatures(APIstub)
+	} else if function == "setRule" {
+		return s.setRule(APIstub, args)
+	} else if function == "deleteRule" {
+		return s.deleteRule(APIstub, args)
+	} else if function == "queryRules" {
+		return s.queryRules(APIstub)
+	} else if function == "verifyIntegrity" {
+		return s.verifyIntegrity(APIstub, args)
+	} else if function == "rebuildIndexes" {
+		return s.rebuildIndexes(APIstub, args)
+	} else if function == "setOwnerDetails" {
+		return s.setOwnerDetails(APIstub)
+	} else if function == "queryOwnerDetails" {
+		return s.queryOwnerDetails(APIstub, args)
+	} else if function == "redactOwnerData" {
+		return s.redactOwnerData(APIstub, args)
+	} else if function == "grantConsent" {
+		return s.grantConsent(APIstub, args)
+	} else if function == "revokeConsent" {
+		return s.revokeConsent(APIstub, args)
+	} else if function == "queryMyConsents" {
+		return s.queryMyConsents(APIstub)
+	} else if function == "anchorHash" {
+		return s.anchorHash(APIstub, args)
+	} else if function == "verifyAgainstAnchor" {
+		return s.verifyAgainstAnchor(APIstub, args)
+	} else if function == "splitHouse" {
+		return s.splitHouse(APIstub, args)
+	} else if function == "mergeHouses" {
+		return s.mergeHouses(APIstub, args)
+	} else if function == "queryHouseLineage" {
+		return s.queryHouseLineage(APIstub, args)
+	} else if function == "getLineage" {
+		return s.getLineage(APIstub, args)
+	} else if function == "transferPortfolio" {
+		return s.transferPortfolio(APIstub, args)
+	} else if function == "queryPortfolioJob" {
+		return s.queryPortfolioJob(APIstub, args)
+	} else if function == "queryHousesByOwner" {
+		return s.queryHousesByOwner(APIstub, args)
+	} else if function == "sampleHouses" {
+		return s.sampleHouses(APIstub, args)
+	} else if function == "queryHousesPage" {
+		return s.queryHousesPage(APIstub, args)
+	} else if function == "setRetentionPeriod" {
+		return s.setRetentionPeriod(APIstub, args)
+	} else if function == "archiveExpired" {
+		return s.archiveExpired(APIstub, args)
+	} else if function == "queryArchive" {
+		return s.queryArchive(APIstub, args)
+	} else if function == "setLocationAuthority" {
+		return s.setLocationAuthority(APIstub, args)
+	} else if function == "queryLocationAuthorities" {
+		return s.queryLocationAuthorities(APIstub)
+	} else if function == "setLocationAlias" {
+		return s.setLocationAlias(APIstub, args)
+	} else if function == "queryLocationAliases" {
+		return s.queryLocationAliases(APIstub)
+	} else if function == "setHouseTags" {
+		return s.setHouseTags(APIstub, args)
+	} else if function == "queryHousesByTags" {
+		return s.queryHousesByTags(APIstub, args)
+	} else if function == "queryAveragePricePerSquareMeter" {
+		return s.queryAveragePricePerSquareMeter(APIstub, args)
+	} else if function == "queryPriceTrend" {
+		return s.queryPriceTrend(APIstub, args)
+	} else if function == "queryTopAppreciatingLocations" {
+		return s.queryTopAppreciatingLocations(APIstub, args)
+	} else if function == "findComparables" {
+		return s.findComparables(APIstub, args)
+	} else if function == "registerMortgage" {
+		return s.registerMortgage(APIstub, args)
+	} else if function == "recordMortgagePayment" {
+		return s.recordMortgagePayment(APIstub, args)
+	} else if function == "queryMortgageBalance" {
+		return s.queryMortgageBalance(APIstub, args)
+	} else if function == "payoffAndReleaseMortgage" {
+		return s.payoffAndReleaseMortgage(APIstub, args)
+	} else if function == "queryHouseMortgages" {
+		return s.queryHouseMortgages(APIstub, args)
+	} else if function == "setForeclosurePolicy" {
+		return s.setForeclosurePolicy(APIstub, args)
+	} else if function == "initiateForeclosure" {
+		return s.initiateForeclosure(APIstub, args)
+	} else if function == "cancelForeclosure" {
+		return s.cancelForeclosure(APIstub, args)
+	} else if function == "executeForeclosure" {
+		return s.executeForeclosure(APIstub, args)
+	} else if function == "queryForeclosure" {
+		return s.queryForeclosure(APIstub, args)
+	} else if function == "ownerOf" {
+		return s.ownerOf(APIstub, args)
+	} else if function == "queryDeedsByHolder" {
+		return s.queryDeedsByHolder(APIstub, args)
+	} else if function == "approveDeed" {
+		return s.approveDeed(APIstub, args)
+	} else if function == "setDeedOperator" {
+		return s.setDeedOperator(APIstub, args)
+	} else if function == "transferDeedFrom" {
+		return s.transferDeedFrom(APIstub, args)
+	} else if function == "mintMissingDeeds" {
+		return s.mintMissingDeeds(APIstub, args)
+	} else if function == "transferShareTokens" {
+		return s.transferShareTokens(APIstub, args)
+	} else if function == "approveShareTokens" {
+		return s.approveShareTokens(APIstub, args)
+	} else if function == "transferShareTokensFrom" {
+		return s.transferShareTokensFrom(APIstub, args)
+	} else if function == "queryShareBalance" {
+		return s.queryShareBalance(APIstub, args)
+	} else if function == "queryCapTable" {
+		return s.queryCapTable(APIstub, args)
+	} else if function == "distributeDividend" {
+		return s.distributeDividend(APIstub, args)
+	} else if function == "queryDividends" {
+		return s.queryDividends(APIstub, args)
+	} else if function == "distributeRentIncome" {
+		return s.distributeRentIncome(APIstub, args)
+	} else if function == "queryIncomeStatement" {
+		return s.queryIncomeStatement(APIstub, args)
+	} else if function == "getBalance" {
+		return s.getBalance(APIstub, args)
+	} else if function == "depositBalance" {
+		return s.depositBalance(APIstub, args)
+	} else if function == "withdrawBalance" {
+		return s.withdrawBalance(APIstub, args)
+	} else if function == "transferBalance" {
+		return s.transferBalance(APIstub, args)
+	} else if function == "buyHouse" {
+		return s.buyHouse(APIstub, args)
+	} else if function == "exportStatement" {
+		return s.exportStatement(APIstub, args)
+	} else if function == "setFeeSchedule" {
+		return s.setFeeSchedule(APIstub, args)
+	} else if function == "queryFeeSchedule" {
+		return s.queryFeeSchedule(APIstub)
+	} else if function == "queryFeeRevenue" {
+		return s.queryFeeRevenue(APIstub, args)
+	} else if function == "setTaxRateTable" {
+		return s.setTaxRateTable(APIstub, args)
+	} else if function == "queryTaxRateTables" {
+		return s.queryTaxRateTables(APIstub)
+	} else if function == "recordTaxSettlement" {
+		return s.recordTaxSettlement(APIstub, args)
+	} else if function == "queryTaxDue" {
+		return s.queryTaxDue(APIstub, args)
+	} else if function == "queryTaxObligation" {
+		return s.queryTaxObligation(APIstub, args)
+	} else if function == "grantSubsidy" {
+		return s.grantSubsidy(APIstub, args)
+	} else if function == "recordSubsidyClawback" {
+		return s.recordSubsidyClawback(APIstub, args)
+	} else if function == "queryHouseSubsidies" {
+		return s.queryHouseSubsidies(APIstub, args)
+	} else if function == "querySubsidyReport" {
+		return s.querySubsidyReport(APIstub, args)
+	} else if function == "setJudiciaryMSP" {
+		return s.setJudiciaryMSP(APIstub, args)
+	} else if function == "executeCourtOrder" {
+		return s.executeCourtOrder(APIstub, args)
+	} else if function == "queryCourtOrder" {
+		return s.queryCourtOrder(APIstub, args)
+	} else if function == "queryHouseLiens" {
+		return s.queryHouseLiens(APIstub, args)
+	} else if function == "grantPowerOfAttorney" {
+		return s.grantPowerOfAttorney(APIstub, args)
+	} else if function == "revokePowerOfAttorney" {
+		return s.revokePowerOfAttorney(APIstub, args)
+	} else if function == "queryPowersOfAttorney" {
+		return s.queryPowersOfAttorney(APIstub, args)
+	} else if function == "queryAttorneyInvocations" {
+		return s.queryAttorneyInvocations(APIstub, args)
+	} else if function == "rebindOwnerIdentity" {
+		return s.rebindOwnerIdentity(APIstub, args)
+	} else if function == "contestIdentityRebinding" {
+		return s.contestIdentityRebinding(APIstub, args)
+	} else if function == "confirmIdentityRebinding" {
+		return s.confirmIdentityRebinding(APIstub, args)
+	} else if function == "queryRebindRequests" {
+		return s.queryRebindRequests(APIstub, args)
+	} else if function == "createMultisigAccount" {
+		return s.createMultisigAccount(APIstub, args)
+	} else if function == "queryMultisigAccount" {
+		return s.queryMultisigAccount(APIstub, args)
+	} else if function == "proposeMultisigTransfer" {
+		return s.proposeMultisigTransfer(APIstub, args)
+	} else if function == "approveMultisigTransfer" {
+		return s.approveMultisigTransfer(APIstub, args)
+	} else if function == "queryMultisigProposal" {
+		return s.queryMultisigProposal(APIstub, args)
+	} else if function == "registerLegalEntity" {
+		return s.registerLegalEntity(APIstub, args)
+	} else if function == "setEntitySignatories" {
+		return s.setEntitySignatories(APIstub, args)
+	} else if function == "setEntityMember" {
+		return s.setEntityMember(APIstub, args)
+	} else if function == "queryLegalEntity" {
+		return s.queryLegalEntity(APIstub, args)
+	} else if function == "setOwnershipCap" {
+		return s.setOwnershipCap(APIstub, args)
+	} else if function == "setCapOverride" {
+		return s.setCapOverride(APIstub, args)
+	} else if function == "queryOwnershipCapReport" {
+		return s.queryOwnershipCapReport(APIstub, args)
+	} else if function == "approveResale" {
+		return s.approveResale(APIstub, args)
+	} else if function == "queryHousesInCooldown" {
+		return s.queryHousesInCooldown(APIstub)
+	} else if function == "registerPreemptionRight" {
+		return s.registerPreemptionRight(APIstub, args)
+	} else if function == "exercisePreemption" {
+		return s.exercisePreemption(APIstub, args)
+	} else if function == "finalizeLapsedPreemptions" {
+		return s.finalizeLapsedPreemptions(APIstub)
+	} else if function == "queryPreemptionRights" {
+		return s.queryPreemptionRights(APIstub, args)
+	} else if function == "queryPreemptionNotice" {
+		return s.queryPreemptionNotice(APIstub, args)
+	} else if function == "registerConstructionProject" {
+		return s.registerConstructionProject(APIstub, args)
+	} else if function == "recordConstructionMilestone" {
+		return s.recordConstructionMilestone(APIstub, args)
+	} else if function == "sellOffPlan" {
+		return s.sellOffPlan(APIstub, args)
+	} else if function == "queryConstructionProject" {
+		return s.queryConstructionProject(APIstub, args)
+	} else if function == "registerBuilder" {
+		return s.registerBuilder(APIstub, args)
+	} else if function == "linkHouseBuilder" {
+		return s.linkHouseBuilder(APIstub, args)
+	} else if function == "fileWarrantyClaim" {
+		return s.fileWarrantyClaim(APIstub, args)
+	} else if function == "migrateWarrantyClaims" {
+		return s.migrateWarrantyClaims(APIstub, args)
+	} else if function == "queryBuilder" {
+		return s.queryBuilder(APIstub, args)
+	} else if function == "queryHouseWarranties" {
+		return s.queryHouseWarranties(APIstub, args)
+	} else if function == "issueInsurancePolicy" {
+		return s.issueInsurancePolicy(APIstub, args)
+	} else if function == "fileInsuranceClaim" {
+		return s.fileInsuranceClaim(APIstub, args)
+	} else if function == "addClaimEvidence" {
+		return s.addClaimEvidence(APIstub, args)
+	} else if function == "respondToClaim" {
+		return s.respondToClaim(APIstub, args)
+	} else if function == "adjudicateClaim" {
+		return s.adjudicateClaim(APIstub, args)
+	} else if function == "recordClaimPayout" {
+		return s.recordClaimPayout(APIstub, args)
+	} else if function == "queryHouseClaims" {
+		return s.queryHouseClaims(APIstub, args)
+	} else if function == "queryLiablePartyClaims" {
+		return s.queryLiablePartyClaims(APIstub, args)
+	} else if function == "createBuilding" {
+		return s.createBuilding(APIstub, args)
+	} else if function == "addBuildingUnit" {
+		return s.addBuildingUnit(APIstub, args)
+	} else if function == "assessBuildingCharges" {
+		return s.assessBuildingCharges(APIstub, args)
+	} else if function == "recordBuildingChargePayment" {
+		return s.recordBuildingChargePayment(APIstub, args)
+	} else if function == "queryBuildingUnits" {
+		return s.queryBuildingUnits(APIstub, args)
+	} else if function == "queryBuildingArrears" {
+		return s.queryBuildingArrears(APIstub, args)
+	} else if function == "setHouseAddress" {
+		return s.setHouseAddress(APIstub, args)
+	} else if function == "queryHousesByPostalCode" {
+		return s.queryHousesByPostalCode(APIstub, args)
+	} else if function == "migrateLegacyLocations" {
+		return s.migrateLegacyLocations(APIstub, args)
+	} else if function == "setCadastralRef" {
+		return s.setCadastralRef(APIstub, args)
+	} else if function == "queryHouseByCadastralRef" {
+		return s.queryHouseByCadastralRef(APIstub, args)
+	} else if function == "registerOracle" {
+		return s.registerOracle(APIstub, args)
+	} else if function == "attestFact" {
+		return s.attestFact(APIstub, args)
+	} else if function == "queryAttestedFact" {
+		return s.queryAttestedFact(APIstub, args)
+	} else if function == "queryIndexedValuation" {
+		return s.queryIndexedValuation(APIstub, args)
+	} else if function == "setRiskZone" {
+		return s.setRiskZone(APIstub, args)
+	} else if function == "acknowledgeRiskDisclosure" {
+		return s.acknowledgeRiskDisclosure(APIstub, args)
+	} else if function == "queryHouseRisks" {
+		return s.queryHouseRisks(APIstub, args)
+	} else if function == "queryHousesByRiskClass" {
+		return s.queryHousesByRiskClass(APIstub, args)
+	} else if function == "createRetrofitProgram" {
+		return s.createRetrofitProgram(APIstub, args)
+	} else if function == "recordRetrofitWorks" {
+		return s.recordRetrofitWorks(APIstub, args)
+	} else if function == "awardRetrofitCredits" {
+		return s.awardRetrofitCredits(APIstub, args)
+	} else if function == "transferEfficiencyCredits" {
+		return s.transferEfficiencyCredits(APIstub, args)
+	} else if function == "queryEfficiencyCredits" {
+		return s.queryEfficiencyCredits(APIstub, args)
+	} else if function == "queryRetrofitProgramReport" {
+		return s.queryRetrofitProgramReport(APIstub, args)
+	} else if function == "registerIoTGateway" {
+		return s.registerIoTGateway(APIstub, args)
+	} else if function == "commitReadingsRoot" {
+		return s.commitReadingsRoot(APIstub, args)
+	} else if function == "verifyReading" {
+		return s.verifyReading(APIstub, args)
+	} else if function == "queryReadingCommitments" {
+		return s.queryReadingCommitments(APIstub, args)
+	} else if function == "issueOccupancyCertificate" {
+		return s.issueOccupancyCertificate(APIstub, args)
+	} else if function == "revokeOccupancyCertificate" {
+		return s.revokeOccupancyCertificate(APIstub, args)
+	} else if function == "flagUninhabitable" {
+		return s.flagUninhabitable(APIstub, args)
+	} else if function == "clearHabitabilityFlag" {
+		return s.clearHabitabilityFlag(APIstub, args)
+	} else if function == "listHouseForRent" {
+		return s.listHouseForRent(APIstub, args)
+	} else if function == "withdrawRentalListing" {
+		return s.withdrawRentalListing(APIstub, args)
+	} else if function == "queryRentalListings" {
+		return s.queryRentalListings(APIstub)
+	} else if function == "queryHabitability" {
+		return s.queryHabitability(APIstub, args)
+	} else if function == "issueRentalLicense" {
+		return s.issueRentalLicense(APIstub, args)
+	} else if function == "setRentalNightCap" {
+		return s.setRentalNightCap(APIstub, args)
+	} else if function == "revokeRentalLicense" {
+		return s.revokeRentalLicense(APIstub, args)
+	} else if function == "recordRentalStay" {
+		return s.recordRentalStay(APIstub, args)
+	} else if function == "queryRentalNights" {
+		return s.queryRentalNights(APIstub, args)
+	} else if function == "queryHousesOverNightCap" {
+		return s.queryHousesOverNightCap(APIstub, args)
+	} else if function == "setBookingTerms" {
+		return s.setBookingTerms(APIstub, args)
+	} else if function == "createBooking" {
+		return s.createBooking(APIstub, args)
+	} else if function == "cancelBooking" {
+		return s.cancelBooking(APIstub, args)
+	} else if function == "queryAvailability" {
+		return s.queryAvailability(APIstub, args)
+	} else if function == "submitRating" {
+		return s.submitRating(APIstub, args)
+	} else if function == "queryReputation" {
+		return s.queryReputation(APIstub, args)
+	} else if function == "queryRatings" {
+		return s.queryRatings(APIstub, args)
+	} else if function == "openOutbox" {
+		return s.openOutbox(APIstub, args)
+	} else if function == "closeOutbox" {
+		return s.closeOutbox(APIstub)
+	} else if function == "readOutbox" {
+		return s.readOutbox(APIstub, args)
+	} else if function == "ackNotifications" {
+		return s.ackNotifications(APIstub, args)
+	} else if function == "pruneNotifications" {
+		return s.pruneNotifications(APIstub)
+	} else if function == "registerSubscription" {
+		return s.registerSubscription(APIstub, args)
+	} else if function == "deleteSubscription" {
+		return s.deleteSubscription(APIstub, args)
+	} else if function == "getSubscriptionsMatching" {
+		return s.getSubscriptionsMatching(APIstub, args)
+	} else if function == "queryHouseCount" {
+		return s.queryHouseCount(APIstub, args)
+	} else if function == "queryHouseCounts" {
+		return s.queryHouseCounts(APIstub, args)
+	} else if function == "rebuildHouseCounters" {
+		return s.rebuildHouseCounters(APIstub)
+	} else if function == "registerSavedQuery" {
+		return s.registerSavedQuery(APIstub, args)
+	} else if function == "deleteSavedQuery" {
+		return s.deleteSavedQuery(APIstub, args)
+	} else if function == "querySavedQueries" {
+		return s.querySavedQueries(APIstub)
+	} else if function == "runSavedQuery" {
+		return s.runSavedQuery(APIstub, args)
+	} else if function == "queryHouseWithProof" {
+		return s.queryHouseWithProof(APIstub, args)
+	} else if function == "batch" {
+		return s.batch(APIstub, args)
+	} else if function == "simulate" {
+		return s.simulate(APIstub, args)
+	} else if function == "setFeatureFlag" {
+		return s.setFeatureFlag(APIstub, args)
+	} else if function == "getEnabledFeatures" {
+		return s.getEnabledFeatures(APIstub)
+	} else if function == "getContractMetadata" {
+		return s.getContractMetadata(APIstub)
+	} else if function == "whoAmI" {
+		return s.whoAmI(APIstub)
+	} else if function == "bootstrapAdmin" {
+		return s.bootstrapAdmin(APIstub)
+	} else if function == "assignRole" {
+		return s.assignRole(APIstub, args)
+	} else if function == "revokeRole" {
+		return s.revokeRole(APIstub, args)
+	} else if function == "listRoleAssignments" {
+		return s.listRoleAssignments(APIstub, args)
+	} else if function == "pauseContract" {
+		return s.pauseContract(APIstub, args)
+	} else if function == "resumeContract" {
+		return s.resumeContract(APIstub)
+	} else if function == "queryPause" {
+		return s.queryPause(APIstub)
+	} else if function == "scheduleMaintenanceWindow" {
+		return s.scheduleMaintenanceWindow(APIstub, args)
+	} else if function == "cancelMaintenanceWindow" {
+		return s.cancelMaintenanceWindow(APIstub, args)
+	} else if function == "getMaintenanceSchedule" {
+		return s.getMaintenanceSchedule(APIstub)
+	} else if function == "acquireLock" {
+		return s.acquireLock(APIstub, args)
+	} else if function == "releaseLock" {
+		return s.releaseLock(APIstub, args)
+	} else if function == "queryLock" {
+		return s.queryLock(APIstub, args)
+	} else if function == "setConflictDiagnostics" {
+		return s.setConflictDiagnostics(APIstub, args)
+	} else if function == "queryRecentWrites" {
+		return s.queryRecentWrites(APIstub, args)
+	} else if function == "queryBusyHouses" {
+		return s.queryBusyHouses(APIstub, args)
 	}
 
 	return shim.Error("Invalid Smart Contract function name.")
 }
 
+// getTxTime returns the timestamp of the transaction, identical on every endorser
+func getTxTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+// getHouse reads the house stored under the given key
+func getHouse(APIstub shim.ChaincodeStubInterface, key string) (House, error) {
+	houseAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return House{}, err
+	}
+	if houseAsBytes == nil {
+		return House{}, fmt.Errorf("House %s does not exist", key)
+	}
+
+	return decodeHouse(houseAsBytes)
+}
+
+// putHouse writes the house under the given key, stamped with the ID of the writing transaction,
+// and updates the house indexes. A new house gets its deed token
+func putHouse(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	// Only the holder of the lock of a house writes it
+	if err := checkHouseLock(APIstub, key); err != nil {
+		return err
+	}
+
+	var previous *House
+	previousAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if previousAsBytes != nil {
+		previousHouse, err := decodeHouse(previousAsBytes)
+		if err != nil {
+			return err
+		}
+		previous = &previousHouse
+	}
+
+	house.LastTxID = APIstub.GetTxID()
+	updatedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	house.UpdatedAt = updatedAt.Format(timeLayout)
+	houseAsBytes, err := encodeHouse(APIstub, house)
+	if err != nil {
+		return err
+	}
+	if err := APIstub.PutState(key, houseAsBytes); err != nil {
+		return err
+	}
+	if err := recordHouseWriter(APIstub, key); err != nil {
+		return err
+	}
+	if err := recordRecentWrite(APIstub, key); err != nil {
+		return err
+	}
+	if previous == nil {
+		if err := mintDeedToken(APIstub, key); err != nil {
+			return err
+		}
+	}
+	return updateHouseIndexes(APIstub, key, previous, &house)
+}
+
+// houseAsJSON returns a stored house as JSON, whatever its encoding
+func houseAsJSON(houseAsBytes []byte) ([]byte, error) {
+	if detectHouseCodec(houseAsBytes) == codecJSON {
+		return houseAsBytes, nil
+	}
+	house, err := decodeHouse(houseAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(house)
+}
+
+// queryHouse returns a house, or the selected fields of it. args: house key, fields (optional)
 func (s *SmartContract) queryHouse(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	var paths [][]string
+	if len(args) > 1 {
+		var err error
+		if paths, err = parseFieldSelection(args[1], House{}); err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 	houseAsBytes, _ := APIstub.GetState(args[0])
+	if houseAsBytes == nil {
+		return shim.Success(nil)
+	}
+	houseAsBytes, err := houseAsJSON(houseAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	houseAsBytes, err = projectJSON(houseAsBytes, paths)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(houseAsBytes)
 }
 
+/*
+ * initLedger creates the sample houses, for admins. The keys already used by a house, retired or archived, are skipped
+ */
 func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
 	houses := []House{
 		House{Year: "2007", SquareFeets: "300", Location: "Bayonne", Owner: "Tomoko"},
 		House{Year: "1987", SquareFeets: "178", Location: "Anglet", Owner: "Brad"},
@@ -108,10 +915,20 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 
 	i := 0
 	for i < len(houses) {
-		fmt.Println("i is ", i)
-		houseAsBytes, _ := json.Marshal(houses[i])
-		APIstub.PutState("HOUSE"+strconv.Itoa(i), houseAsBytes)
-		fmt.Println("Added", houses[i])
+		logFor(APIstub).Debugf("i is %d", i)
+		key := "HOUSE" + strconv.Itoa(i)
+		if err := checkNewHouseKey(APIstub, key); err != nil {
+			logFor(APIstub).Infof("Skipped %s: %s", key, err)
+			i = i + 1
+			continue
+		}
+		if err := checkOwnershipCaps(APIstub, key, houses[i], houseShares(houses[i])); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putHouse(APIstub, key, houses[i]); err != nil {
+			return shim.Error(err.Error())
+		}
+		logFor(APIstub).Infof("Added %v", houses[i])
 		i = i + 1
 	}
 
@@ -120,24 +937,83 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 
 func (s *SmartContract) createHouse(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
+	if len(args) < 5 || len(args) > 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 5 to 8")
+	}
+	// An existing house would be overwritten, getting around the checks of its transfers
+	if err := checkNewHouseKey(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := parseSquareFeets(args[2]); err != nil {
+		return shim.Error(err.Error())
 	}
 
 	var house = House{Year: args[1], SquareFeets: args[2], Location: args[3], Owner: args[4]}
+	if len(args) > 5 {
+		house.Usage = args[5]
+	}
+	if len(args) > 6 {
+		house.Zone = args[6]
+	}
+	if len(args) > 7 && args[7] != "" {
+		ref, err := checkCadastralRef(APIstub, args[0], args[7])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		house.CadastralRef = ref
+	}
+	// The location is either a structured address as a JSON object, or a legacy free-form location
+	if isAddressArgument(args[3]) {
+		address, err := parseAddress(APIstub, args[3])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		house.Address, house.Location = &address, address.City
+	}
+	location, err := resolveLocationAlias(APIstub, house.Location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	house.Location = location
 
-	houseAsBytes, _ := json.Marshal(house)
-	APIstub.PutState(args[0], houseAsBytes)
+	if err := checkHouseZone(APIstub, house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := validateZoning(APIstub, house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkLocationAuthority(APIstub, house.Location); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkOwnershipCaps(APIstub, args[0], house, houseShares(house)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := consumeCreationQuota(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	return shim.Success(nil)
 }
 
-func (s *SmartContract) queryAllHouses(APIstub shim.ChaincodeStubInterface) sc.Response {
+// queryAllHouses returns every house, or the selected fields of them. args: fields (optional)
+func (s *SmartContract) queryAllHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	startKey := "HOUSE0"
-	endKey := "HOUSE999"
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1")
+	}
+	var paths [][]string
+	if len(args) > 0 {
+		var err error
+		if paths, err = parseFieldSelection(args[0], House{}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
-	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
+	resultsIterator, err := APIstub.GetStateByRange(houseStartKey, houseEndKey)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -163,42 +1039,85 @@ func (s *SmartContract) queryAllHouses(APIstub shim.ChaincodeStubInterface) sc.R
 		buffer.WriteString("\"")
 
 		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
+		// Record is written as a JSON object, converted from protobuf if needed
+		houseAsBytes, err := houseAsJSON(queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if houseAsBytes, err = projectJSON(houseAsBytes, paths); err != nil {
+			return shim.Error(err.Error())
+		}
+		buffer.Write(houseAsBytes)
 		buffer.WriteString("}")
 		bArrayMemberAlreadyWritten = true
 	}
 	buffer.WriteString("]")
 
-	fmt.Printf("- queryAllHouses:\n%s\n", buffer.String())
+	logFor(APIstub).Debugf("- queryAllHouses:\n%s", buffer.String())
 
 	return shim.Success(buffer.Bytes())
 }
 
 func (s *SmartContract) changeHouseOwner(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 4")
 	}
 
-	houseAsBytes, _ := APIstub.GetState(args[0])
-	house := House{}
+	// The reason is optional, a plain owner change being a sale, which must declare its price
+	reason, price := reasonSale, ""
+	if len(args) > 2 {
+		reason = args[2]
+	}
+	if len(args) > 3 {
+		price = args[3]
+	}
+	reason, amount, err := parseTransferReason(reason, price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	json.Unmarshal(houseAsBytes, &house)
-	house.Owner = args[1]
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if holder := multisigHolder(house); holder != "" {
+		return shim.Error("House " + args[0] + " is held by the multi-signature account " + holder + ", use proposeMultisigTransfer")
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkEntitySignatory(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
 
-	houseAsBytes, _ = json.Marshal(house)
-	APIstub.PutState(args[0], houseAsBytes)
+	// The co-signature request or the tax obligation the transfer waits for, if any
+	requestAsBytes, err := queueOrExecuteTransfer(APIstub, args[0], house, args[1], reason, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	return shim.Success(nil)
+	return shim.Success(requestAsBytes)
 }
 
 // The main function is only relevant in unit test mode. Only included here for completeness.
 func main() {
 
+	// Expose the process metrics to Prometheus when an address is configured
+	startMetricsServer(os.Getenv(metricsAddressEnv))
+
+	// Run as an external service when an address to listen on is configured
+	if address := os.Getenv(serverAddressEnv); address != "" {
+		err := startChaincodeServer(address, new(SmartContract))
+		if err != nil {
+			processLog.Errorf("Error starting Smart Contract server: %s", err)
+		}
+		return
+	}
+
 	// Create a new Smart Contract
 	err := shim.Start(new(SmartContract))
 	if err != nil {
-		fmt.Printf("Error creating new Smart Contract: %s", err)
+		processLog.Errorf("Error creating new Smart Contract: %s", err)
 	}
 }
@@ -0,0 +1,159 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Feature flags
+ * The optional modules of the contract can be switched off per deployment by admins, without
+ * upgrading the chaincode. Every feature covers the functions of its module, which fail while it
+ * is disabled, whether invoked directly, in a batch or in a simulation. Features are enabled until
+ * disabled, and the functions not covered by a feature cannot be disabled.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const featureFlagObjectType = "featureFlag"
+
+// Optional modules and the functions they cover
+var features = map[string][]string{
+	"leases":           {"createLease", "terminateLease", "queryHouseLeases", "recordRentPayment", "getArrearsReport", "listHouseForRent", "withdrawRentalListing", "queryRentalListings"},
+	"shortTermRentals": {"issueRentalLicense", "setRentalNightCap", "revokeRentalLicense", "recordRentalStay", "queryRentalNights", "queryHousesOverNightCap", "setBookingTerms", "createBooking", "cancelBooking", "queryAvailability"},
+	"reputation":       {"submitRating", "queryReputation", "queryRatings"},
+	"tokens":           {"transferShareTokens", "approveShareTokens", "transferShareTokensFrom", "queryShareBalance", "queryCapTable", "distributeDividend", "queryDividends", "distributeRentIncome", "queryIncomeStatement", "approveDeed", "setDeedOperator", "transferDeedFrom"},
+	"options":          {"grantOption", "exerciseOption", "queryHouseOptions"},
+	"mortgages":        {"registerMortgage", "recordMortgagePayment", "queryMortgageBalance", "payoffAndReleaseMortgage", "queryHouseMortgages"},
+	"auctions":         {"setForeclosurePolicy", "initiateForeclosure", "cancelForeclosure", "executeForeclosure", "queryForeclosure"},
+	"buildings":        {"createBuilding", "addBuildingUnit", "assessBuildingCharges", "recordBuildingChargePayment", "queryBuildingUnits", "queryBuildingArrears"},
+	"claims":           {"issueInsurancePolicy", "fileWarrantyClaim", "fileInsuranceClaim", "addClaimEvidence", "respondToClaim", "adjudicateClaim", "recordClaimPayout", "queryHouseClaims", "queryLiablePartyClaims"},
+	"retrofit":         {"createRetrofitProgram", "recordRetrofitWorks", "awardRetrofitCredits", "transferEfficiencyCredits", "queryEfficiencyCredits", "queryRetrofitProgramReport"},
+	"iot":              {"registerIoTGateway", "commitReadingsRoot", "verifyReading", "queryReadingCommitments"},
+	"savedQueries":     {"registerSavedQuery", "deleteSavedQuery", "querySavedQueries", "runSavedQuery"},
+}
+
+// Feature of every function covered by one
+var functionFeatures = func() map[string]string {
+	functionFeatures := map[string]string{}
+	for feature, functions := range features {
+		for _, function := range functions {
+			functionFeatures[function] = feature
+		}
+	}
+	return functionFeatures
+}()
+
+// Define the feature structure, a feature and its state
+type Feature struct {
+	Name      string   `json:"name"`
+	Enabled   bool     `json:"enabled"`
+	Functions []string `json:"functions"`
+}
+
+func featureEnabled(APIstub shim.ChaincodeStubInterface, feature string) (bool, error) {
+	flagKey, err := APIstub.CreateCompositeKey(featureFlagObjectType, []string{feature})
+	if err != nil {
+		return false, err
+	}
+	flagAsBytes, err := APIstub.GetState(flagKey)
+	if err != nil || flagAsBytes == nil {
+		return true, err
+	}
+	return strconv.ParseBool(string(flagAsBytes))
+}
+
+// checkFeature returns an error when the function belongs to a disabled feature
+func checkFeature(APIstub shim.ChaincodeStubInterface, function string) error {
+	feature, covered := functionFeatures[function]
+	if !covered {
+		return nil
+	}
+	enabled, err := featureEnabled(APIstub, feature)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("%s is not available, feature %s is disabled", function, feature)
+	}
+	return nil
+}
+
+/*
+ * setFeatureFlag enables or disables a feature, for admins
+ * args: feature, enabled (true or false)
+ */
+func (s *SmartContract) setFeatureFlag(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, known := features[args[0]]; !known {
+		names := []string{}
+		for feature := range features {
+			names = append(names, feature)
+		}
+		sort.Strings(names)
+		return shim.Error(fmt.Sprintf("Unknown feature %q, expecting one of %v", args[0], names))
+	}
+	enabled, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return shim.Error("Enabled must be true or false")
+	}
+
+	flagKey, err := APIstub.CreateCompositeKey(featureFlagObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(flagKey, []byte(strconv.FormatBool(enabled))); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "featureFlagChanged", []byte(fmt.Sprintf(`{"feature":%q,"enabled":%t}`, args[0], enabled)))
+	return shim.Success(nil)
+}
+
+// getEnabledFeatures returns the features with their state, in order of name
+func (s *SmartContract) getEnabledFeatures(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	names := []string{}
+	for feature := range features {
+		names = append(names, feature)
+	}
+	sort.Strings(names)
+
+	result := []Feature{}
+	for _, name := range names {
+		enabled, err := featureEnabled(APIstub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result = append(result, Feature{Name: name, Enabled: enabled, Functions: features[name]})
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,228 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Transaction fees
+ * The fee schedule, maintained by admins, sets the fee charged on the transfers of each reason
+ * (sale, gift...): either a flat amount or a percentage of the price, in basis points. The fee is
+ * debited from the account of the new registered owner and credited to the treasury account, whose
+ * entries make the fee revenue reports.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const feeScheduleKey = "CONFIG_FEESCHEDULE"
+
+// Account collecting the fees. Certificate common names cannot start with #, no identity can hold it
+const treasuryAccount = "#treasury"
+
+// Fee kinds
+const (
+	feeFlat       = "flat"
+	feePercentage = "percentage"
+)
+
+// Define the fee structure, a flat amount or a rate in basis points of the price
+type Fee struct {
+	Kind   string `json:"kind"`
+	Amount int64  `json:"amount"`
+}
+
+// Define the fee schedule structure, the fee of each operation type (transfer reason)
+type FeeSchedule struct {
+	Fees      map[string]Fee `json:"fees"`
+	UpdatedBy string         `json:"updatedby"`
+	UpdatedAt string         `json:"updatedat"`
+}
+
+func getFeeSchedule(APIstub shim.ChaincodeStubInterface) (FeeSchedule, error) {
+	scheduleAsBytes, err := APIstub.GetState(feeScheduleKey)
+	if err != nil || scheduleAsBytes == nil {
+		return FeeSchedule{Fees: map[string]Fee{}}, err
+	}
+	schedule := FeeSchedule{}
+	err = json.Unmarshal(scheduleAsBytes, &schedule)
+	return schedule, err
+}
+
+// feeAmount returns the fee charged on an operation at the price
+func feeAmount(fee Fee, price int64) int64 {
+	if fee.Kind == feePercentage {
+		return price * fee.Amount / wholeShare
+	}
+	return fee.Amount
+}
+
+// chargeTransferFee debits the fee of the transfer reason from the payer, to the treasury
+func chargeTransferFee(APIstub shim.ChaincodeStubInterface, key string, payer string, reason string, price int64) error {
+	schedule, err := getFeeSchedule(APIstub)
+	if err != nil {
+		return err
+	}
+	fee, found := schedule.Fees[reason]
+	if !found {
+		return nil
+	}
+	amount := feeAmount(fee, price)
+	if amount == 0 {
+		return nil
+	}
+
+	entries := []AccountEntry{
+		{Account: payer, Amount: -amount, Kind: entryFee, Reference: key, Operation: reason},
+		{Account: treasuryAccount, Amount: amount, Kind: entryFee, Reference: key, Operation: reason},
+	}
+	if err := postEntries(APIstub, entries); err != nil {
+		return fmt.Errorf("Fee of %d on the %s of house %s cannot be charged: %s", amount, reason, key, err)
+	}
+	return nil
+}
+
+/*
+ * setFeeSchedule replaces the fee schedule, for admins
+ * args: fees as a JSON object of operation types (transfer reasons) to {"kind": "flat" or "percentage", "amount": amount or basis points}
+ */
+func (s *SmartContract) setFeeSchedule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fees := map[string]Fee{}
+	if err := json.Unmarshal([]byte(args[0]), &fees); err != nil {
+		return shim.Error("Fees must be a JSON object of fees")
+	}
+	for operation, fee := range fees {
+		known := false
+		for _, reason := range transferReasons {
+			known = known || reason == operation
+		}
+		if !known {
+			return shim.Error(fmt.Sprintf("Unknown operation type %q, expecting one of %v", operation, transferReasons))
+		}
+		if fee.Kind != feeFlat && fee.Kind != feePercentage {
+			return shim.Error("Fee kind must be flat or percentage")
+		}
+		if fee.Amount < 0 || (fee.Kind == feePercentage && fee.Amount > wholeShare) {
+			return shim.Error(fmt.Sprintf("Fee amount of %s is out of range", operation))
+		}
+	}
+
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scheduleAsBytes, _ := json.Marshal(FeeSchedule{Fees: fees, UpdatedBy: invokerID, UpdatedAt: txTime.Format(timeLayout)})
+	if err := APIstub.PutState(feeScheduleKey, scheduleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "feeScheduleUpdated", scheduleAsBytes)
+	return shim.Success(scheduleAsBytes)
+}
+
+// queryFeeSchedule returns the fee schedule in force
+func (s *SmartContract) queryFeeSchedule(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	schedule, err := getFeeSchedule(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scheduleAsBytes, _ := json.Marshal(schedule)
+	return shim.Success(scheduleAsBytes)
+}
+
+/*
+ * queryFeeRevenue returns the fees collected by the treasury per month and operation type, for admins
+ * args: first period, last period (YYYY-MM, inclusive)
+ */
+func (s *SmartContract) queryFeeRevenue(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, period := range args {
+		if _, err := time.Parse(periodLayout, period); err != nil {
+			return shim.Error("Periods must be formatted YYYY-MM")
+		}
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(accountEntryObjectType, []string{treasuryAccount})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type periodRevenue struct {
+		Period      string           `json:"period"`
+		Total       int64            `json:"total"`
+		Count       int              `json:"count"`
+		ByOperation map[string]int64 `json:"byoperation"`
+	}
+	revenues := map[string]*periodRevenue{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entry := AccountEntry{}
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return shim.Error(err.Error())
+		}
+		if entry.Kind != entryFee || entry.Period < args[0] || entry.Period > args[1] {
+			continue
+		}
+		revenue, found := revenues[entry.Period]
+		if !found {
+			revenue = &periodRevenue{Period: entry.Period, ByOperation: map[string]int64{}}
+			revenues[entry.Period] = revenue
+		}
+		revenue.Total += entry.Amount
+		revenue.Count++
+		revenue.ByOperation[entry.Operation] += entry.Amount
+	}
+
+	report := []periodRevenue{}
+	for _, revenue := range revenues {
+		report = append(report, *revenue)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Period < report[j].Period })
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
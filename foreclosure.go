@@ -0,0 +1,345 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Foreclosure
+ * The lienholder of a mortgage in default, having missed at least the number of payments set by
+ * the foreclosure policy, can initiate its foreclosure. The borrower is then given a notice period,
+ * tracked on the ledger, before the lienholder can execute the foreclosure: either the house is
+ * transferred to the lienholder, or it is sold at an auction held by the lienholder to the
+ * highest bidder. Either way the mortgage is closed as foreclosed.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	foreclosurePolicyKey  = "CONFIG_FORECLOSUREPOLICY"
+	foreclosureObjectType = "foreclosure"
+)
+
+// Foreclosure statuses and outcomes
+const (
+	foreclosureNoticed   = "noticed"
+	foreclosureExecuted  = "executed"
+	foreclosureCancelled = "cancelled"
+	foreclosureTransfer  = "transfer"
+	foreclosureAuction   = "auction"
+)
+
+// Define the foreclosure policy structure, applying when no policy was set by an admin
+type ForeclosurePolicy struct {
+	MissedPayments int `json:"missedpayments"`
+	NoticeDays     int `json:"noticedays"`
+}
+
+var defaultForeclosurePolicy = ForeclosurePolicy{MissedPayments: 3, NoticeDays: 90}
+
+// Define the foreclosure structure, one per mortgage
+type Foreclosure struct {
+	MortgageID     string `json:"mortgageid"`
+	HouseKey       string `json:"housekey"`
+	Lienholder     string `json:"lienholder"`
+	MissedPayments int    `json:"missedpayments"`
+	Outstanding    int64  `json:"outstanding"`
+	InitiatedAt    string `json:"initiatedat"`
+	NoticeEndsAt   string `json:"noticeendsat"`
+	Status         string `json:"status"`
+	Outcome        string `json:"outcome,omitempty"`
+	Buyer          string `json:"buyer,omitempty"`
+	Price          int64  `json:"price,omitempty"`
+	ClosedAt       string `json:"closedat,omitempty"`
+}
+
+func getForeclosurePolicy(APIstub shim.ChaincodeStubInterface) (ForeclosurePolicy, error) {
+	policyAsBytes, err := APIstub.GetState(foreclosurePolicyKey)
+	if err != nil || policyAsBytes == nil {
+		return defaultForeclosurePolicy, err
+	}
+	policy := ForeclosurePolicy{}
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+func getForeclosure(APIstub shim.ChaincodeStubInterface, mortgageID string) (Foreclosure, bool, error) {
+	foreclosureKey, err := APIstub.CreateCompositeKey(foreclosureObjectType, []string{mortgageID})
+	if err != nil {
+		return Foreclosure{}, false, err
+	}
+	foreclosureAsBytes, err := APIstub.GetState(foreclosureKey)
+	if err != nil || foreclosureAsBytes == nil {
+		return Foreclosure{}, false, err
+	}
+	foreclosure := Foreclosure{}
+	err = json.Unmarshal(foreclosureAsBytes, &foreclosure)
+	return foreclosure, true, err
+}
+
+func putForeclosure(APIstub shim.ChaincodeStubInterface, foreclosure Foreclosure) ([]byte, error) {
+	foreclosureKey, err := APIstub.CreateCompositeKey(foreclosureObjectType, []string{foreclosure.MortgageID})
+	if err != nil {
+		return nil, err
+	}
+	foreclosureAsBytes, _ := json.Marshal(foreclosure)
+	return foreclosureAsBytes, APIstub.PutState(foreclosureKey, foreclosureAsBytes)
+}
+
+/*
+ * setForeclosurePolicy sets when a mortgage can be foreclosed, for admins
+ * args: number of missed payments, notice period in days
+ */
+func (s *SmartContract) setForeclosurePolicy(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	missedPayments, err := strconv.Atoi(args[0])
+	if err != nil || missedPayments <= 0 {
+		return shim.Error("Number of missed payments must be a positive number")
+	}
+	noticeDays, err := strconv.Atoi(args[1])
+	if err != nil || noticeDays < 0 {
+		return shim.Error("Notice period must be a positive number of days")
+	}
+
+	policyAsBytes, _ := json.Marshal(ForeclosurePolicy{MissedPayments: missedPayments, NoticeDays: noticeDays})
+	if err := APIstub.PutState(foreclosurePolicyKey, policyAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * initiateForeclosure serves the notice of foreclosure of a mortgage in default, only the lienholder can do it
+ * args: mortgage ID
+ */
+func (s *SmartContract) initiateForeclosure(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLienholder(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage.Status != mortgageActive {
+		return shim.Error("Mortgage " + mortgage.ID + " is " + mortgage.Status)
+	}
+	existing, found, err := getForeclosure(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found && existing.Status == foreclosureNoticed {
+		return shim.Error("Foreclosure of mortgage " + mortgage.ID + " is already initiated")
+	}
+
+	policy, err := getForeclosurePolicy(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	paid, err := getMortgagePaid(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement, err := mortgageStatement(mortgage, paid, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if statement.MissedPayments < policy.MissedPayments {
+		return shim.Error(fmt.Sprintf("Mortgage %s missed %d payments, foreclosure requires %d", mortgage.ID, statement.MissedPayments, policy.MissedPayments))
+	}
+
+	var foreclosure = Foreclosure{
+		MortgageID:     mortgage.ID,
+		HouseKey:       mortgage.HouseKey,
+		Lienholder:     mortgage.Lienholder,
+		MissedPayments: statement.MissedPayments,
+		Outstanding:    statement.Outstanding,
+		InitiatedAt:    txTime.Format(timeLayout),
+		NoticeEndsAt:   txTime.AddDate(0, 0, policy.NoticeDays).Format(timeLayout),
+		Status:         foreclosureNoticed,
+	}
+	foreclosureAsBytes, err := putForeclosure(APIstub, foreclosure)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "foreclosureNoticed", foreclosureAsBytes)
+	return shim.Success(foreclosureAsBytes)
+}
+
+/*
+ * cancelForeclosure withdraws the notice of foreclosure, once the borrower cured the default, only the lienholder can do it
+ * args: mortgage ID
+ */
+func (s *SmartContract) cancelForeclosure(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLienholder(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	foreclosure, found, err := getForeclosure(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found || foreclosure.Status != foreclosureNoticed {
+		return shim.Error("No foreclosure of mortgage " + mortgage.ID + " is in progress")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	foreclosure.Status = foreclosureCancelled
+	foreclosure.ClosedAt = txTime.Format(timeLayout)
+	foreclosureAsBytes, err := putForeclosure(APIstub, foreclosure)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(foreclosureAsBytes)
+}
+
+/*
+ * executeForeclosure forecloses the mortgage once the notice period is over, only the lienholder can do it.
+ * The house goes to the lienholder, or to the winner of the auction at the auction price
+ * args: mortgage ID, outcome (transfer or auction), buyer and price for an auction
+ */
+func (s *SmartContract) executeForeclosure(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 4")
+	}
+	if (args[1] == foreclosureTransfer) != (len(args) == 2) || (args[1] != foreclosureTransfer && args[1] != foreclosureAuction) {
+		return shim.Error("Outcome must be \"" + foreclosureTransfer + "\", or \"" + foreclosureAuction + "\" followed by the buyer and the price")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLienholder(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage.Status != mortgageActive {
+		return shim.Error("Mortgage " + mortgage.ID + " is " + mortgage.Status)
+	}
+	foreclosure, found, err := getForeclosure(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found || foreclosure.Status != foreclosureNoticed {
+		return shim.Error("No foreclosure of mortgage " + mortgage.ID + " is in progress")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	noticeEndsAt, err := time.Parse(timeLayout, foreclosure.NoticeEndsAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if txTime.Before(noticeEndsAt) {
+		return shim.Error("The notice period of the foreclosure of mortgage " + mortgage.ID + " ends at " + foreclosure.NoticeEndsAt)
+	}
+
+	// The lienholder takes the house, or sells it at the auction price
+	buyer, reason, price := mortgage.Lienholder, reasonForeclosure, int64(0)
+	if args[1] == foreclosureAuction {
+		buyer, reason = args[2], reasonSale
+		if price, err = strconv.ParseInt(args[3], 10, 64); err != nil || price <= 0 {
+			return shim.Error("Price must be a positive number")
+		}
+	}
+
+	house, err := getHouse(APIstub, mortgage.HouseKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// The co-signature policy does not apply, the foreclosure following its own notice process
+	if err := breakHouseLock(APIstub, mortgage.HouseKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := executeTransfer(APIstub, mortgage.HouseKey, house, []OwnershipShare{{Owner: buyer, Share: wholeShare}}, reason, price); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mortgage.Status = mortgageForeclosed
+	mortgage.ReleasedAt = txTime.Format(timeLayout)
+	if _, err := putMortgage(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	foreclosure.Status = foreclosureExecuted
+	foreclosure.Outcome = args[1]
+	foreclosure.Buyer = buyer
+	foreclosure.Price = price
+	foreclosure.ClosedAt = txTime.Format(timeLayout)
+	foreclosureAsBytes, err := putForeclosure(APIstub, foreclosure)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "foreclosureExecuted", foreclosureAsBytes)
+	return shim.Success(foreclosureAsBytes)
+}
+
+// queryForeclosure returns the foreclosure of a mortgage
+func (s *SmartContract) queryForeclosure(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	foreclosure, found, err := getForeclosure(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("Mortgage " + args[0] + " was never foreclosed")
+	}
+
+	foreclosureAsBytes, _ := json.Marshal(foreclosure)
+	return shim.Success(foreclosureAsBytes)
+}
@@ -0,0 +1,525 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Homeowners associations
+ * An association groups houses and is run by its administrator, the identity that created it.
+ * Membership fees are assessed on every member house per period and their payments recorded,
+ * so that arrears show per house. The co-owners of member houses vote on the resolutions of the
+ * association, each vote weighing the voter's share of the house.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	hoaObjectType           = "hoa"
+	hoaAssessmentObjectType = "hoaAssessment"
+	hoaResolutionObjectType = "hoaResolution"
+	hoaVoteObjectType       = "hoaVote"
+)
+
+// Resolution statuses
+const (
+	resolutionOpen     = "open"
+	resolutionAdopted  = "adopted"
+	resolutionRejected = "rejected"
+)
+
+// Define the homeowners association structure
+type HOA struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Administrator string   `json:"administrator"`
+	FeePerPeriod  int64    `json:"feeperperiod"`
+	Houses        []string `json:"houses"`
+}
+
+// Define the assessment structure, the fee due by a member house for a period
+type HOAAssessment struct {
+	HOAID    string `json:"hoaid"`
+	Period   string `json:"period"`
+	HouseKey string `json:"housekey"`
+	Amount   int64  `json:"amount"`
+	Paid     int64  `json:"paid"`
+}
+
+// Define the resolution structure, identified by the ID of the opening transaction. Votes are in basis points
+type HOAResolution struct {
+	ID       string `json:"id"`
+	HOAID    string `json:"hoaid"`
+	Title    string `json:"title"`
+	Status   string `json:"status"`
+	OpenedAt string `json:"openedat"`
+	Yes      int    `json:"yes"`
+	No       int    `json:"no"`
+}
+
+// Define the vote structure, the vote of a co-owner of a member house
+type HOAVote struct {
+	HouseKey string `json:"housekey"`
+	Voter    string `json:"voter"`
+	Vote     bool   `json:"vote"`
+	Weight   int    `json:"weight"`
+}
+
+func getHOA(APIstub shim.ChaincodeStubInterface, hoaID string) (HOA, error) {
+	hoa := HOA{}
+
+	hoaKey, err := APIstub.CreateCompositeKey(hoaObjectType, []string{hoaID})
+	if err != nil {
+		return hoa, err
+	}
+	hoaAsBytes, err := APIstub.GetState(hoaKey)
+	if err != nil {
+		return hoa, err
+	}
+	if hoaAsBytes == nil {
+		return hoa, fmt.Errorf("Homeowners association %s does not exist", hoaID)
+	}
+
+	err = json.Unmarshal(hoaAsBytes, &hoa)
+	return hoa, err
+}
+
+func putHOA(APIstub shim.ChaincodeStubInterface, hoa HOA) ([]byte, error) {
+	hoaKey, err := APIstub.CreateCompositeKey(hoaObjectType, []string{hoa.ID})
+	if err != nil {
+		return nil, err
+	}
+	hoaAsBytes, _ := json.Marshal(hoa)
+	return hoaAsBytes, APIstub.PutState(hoaKey, hoaAsBytes)
+}
+
+// requireHOAAdministrator reads the association and returns an error unless the invoker runs it
+func requireHOAAdministrator(APIstub shim.ChaincodeStubInterface, hoaID string) (HOA, error) {
+	hoa, err := getHOA(APIstub, hoaID)
+	if err != nil {
+		return hoa, err
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return hoa, err
+	}
+	if invoker != hoa.Administrator {
+		return hoa, fmt.Errorf("Only the administrator of homeowners association %s can do this", hoaID)
+	}
+	return hoa, nil
+}
+
+func getHOAResolution(APIstub shim.ChaincodeStubInterface, hoaID string, resolutionID string) (HOAResolution, error) {
+	resolution := HOAResolution{}
+
+	resolutionKey, err := APIstub.CreateCompositeKey(hoaResolutionObjectType, []string{hoaID, resolutionID})
+	if err != nil {
+		return resolution, err
+	}
+	resolutionAsBytes, err := APIstub.GetState(resolutionKey)
+	if err != nil {
+		return resolution, err
+	}
+	if resolutionAsBytes == nil {
+		return resolution, fmt.Errorf("Resolution %s of homeowners association %s does not exist", resolutionID, hoaID)
+	}
+
+	err = json.Unmarshal(resolutionAsBytes, &resolution)
+	return resolution, err
+}
+
+func putHOAResolution(APIstub shim.ChaincodeStubInterface, resolution HOAResolution) ([]byte, error) {
+	resolutionKey, err := APIstub.CreateCompositeKey(hoaResolutionObjectType, []string{resolution.HOAID, resolution.ID})
+	if err != nil {
+		return nil, err
+	}
+	resolutionAsBytes, _ := json.Marshal(resolution)
+	return resolutionAsBytes, APIstub.PutState(resolutionKey, resolutionAsBytes)
+}
+
+/*
+ * createHOA creates a homeowners association administered by the invoker
+ * args: association ID, name, fee per period
+ */
+func (s *SmartContract) createHOA(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("Association ID and name must not be empty")
+	}
+	fee, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || fee < 0 {
+		return shim.Error("Fee must be a positive number")
+	}
+	if _, err := getHOA(APIstub, args[0]); err == nil {
+		return shim.Error("Homeowners association " + args[0] + " already exists")
+	}
+
+	administrator, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	hoaAsBytes, err := putHOA(APIstub, HOA{ID: args[0], Name: args[1], Administrator: administrator, FeePerPeriod: fee, Houses: []string{}})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(hoaAsBytes)
+}
+
+/*
+ * addHouseToHOA makes the house a member of the association, for its administrator
+ * args: association ID, house key
+ */
+func (s *SmartContract) addHouseToHOA(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	hoa, err := requireHOAAdministrator(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, member := range hoa.Houses {
+		if member == args[1] {
+			return shim.Error("House " + args[1] + " is already a member of " + hoa.ID)
+		}
+	}
+
+	hoa.Houses = append(hoa.Houses, args[1])
+	hoaAsBytes, err := putHOA(APIstub, hoa)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(hoaAsBytes)
+}
+
+/*
+ * assessHOAFees assesses the fee of the period on every member house, for the administrator
+ * args: association ID, period (YYYY-MM)
+ */
+func (s *SmartContract) assessHOAFees(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if _, err := time.Parse(periodLayout, args[1]); err != nil {
+		return shim.Error("Period must be formatted YYYY-MM")
+	}
+
+	hoa, err := requireHOAAdministrator(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	assessments := []HOAAssessment{}
+	for _, houseKey := range hoa.Houses {
+		assessmentKey, err := APIstub.CreateCompositeKey(hoaAssessmentObjectType, []string{hoa.ID, houseKey, args[1]})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		existingAsBytes, err := APIstub.GetState(assessmentKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if existingAsBytes != nil {
+			return shim.Error("Fees of period " + args[1] + " are already assessed")
+		}
+
+		assessment := HOAAssessment{HOAID: hoa.ID, Period: args[1], HouseKey: houseKey, Amount: hoa.FeePerPeriod}
+		assessmentAsBytes, _ := json.Marshal(assessment)
+		if err := APIstub.PutState(assessmentKey, assessmentAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		assessments = append(assessments, assessment)
+	}
+
+	assessmentsAsBytes, _ := json.Marshal(assessments)
+	return shim.Success(assessmentsAsBytes)
+}
+
+/*
+ * recordHOAPayment records a fee payment of a member house, for the administrator
+ * args: association ID, house key, period (YYYY-MM), amount
+ */
+func (s *SmartContract) recordHOAPayment(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	amount, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	if _, err := requireHOAAdministrator(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	assessmentKey, err := APIstub.CreateCompositeKey(hoaAssessmentObjectType, []string{args[0], args[1], args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assessmentAsBytes, err := APIstub.GetState(assessmentKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if assessmentAsBytes == nil {
+		return shim.Error("No fee of period " + args[2] + " was assessed on house " + args[1])
+	}
+	assessment := HOAAssessment{}
+	if err := json.Unmarshal(assessmentAsBytes, &assessment); err != nil {
+		return shim.Error(err.Error())
+	}
+	if assessment.Paid+amount > assessment.Amount {
+		return shim.Error(fmt.Sprintf("Payment exceeds the %d left due", assessment.Amount-assessment.Paid))
+	}
+
+	assessment.Paid += amount
+	assessmentAsBytes, _ = json.Marshal(assessment)
+	if err := APIstub.PutState(assessmentKey, assessmentAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(assessmentAsBytes)
+}
+
+/*
+ * queryHOAArrears lists the fees left unpaid by each member house
+ * args: association ID
+ */
+func (s *SmartContract) queryHOAArrears(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(hoaAssessmentObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type houseArrears struct {
+		HouseKey string          `json:"housekey"`
+		Unpaid   []HOAAssessment `json:"unpaid"`
+		Total    int64           `json:"total"`
+	}
+
+	// Assessments are keyed by house, so that the arrears of a house are contiguous
+	arrears := []houseArrears{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		assessment := HOAAssessment{}
+		if err := json.Unmarshal(queryResponse.Value, &assessment); err != nil {
+			return shim.Error(err.Error())
+		}
+		if assessment.Paid >= assessment.Amount {
+			continue
+		}
+		if len(arrears) == 0 || arrears[len(arrears)-1].HouseKey != assessment.HouseKey {
+			arrears = append(arrears, houseArrears{HouseKey: assessment.HouseKey, Unpaid: []HOAAssessment{}})
+		}
+		last := &arrears[len(arrears)-1]
+		last.Unpaid = append(last.Unpaid, assessment)
+		last.Total += assessment.Amount - assessment.Paid
+	}
+
+	arrearsAsBytes, _ := json.Marshal(arrears)
+	return shim.Success(arrearsAsBytes)
+}
+
+/*
+ * openHOAResolution puts a resolution to the vote, for the administrator
+ * args: association ID, title
+ */
+func (s *SmartContract) openHOAResolution(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("Title must not be empty")
+	}
+
+	if _, err := requireHOAAdministrator(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	openedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resolutionAsBytes, err := putHOAResolution(APIstub, HOAResolution{
+		ID:       APIstub.GetTxID(),
+		HOAID:    args[0],
+		Title:    args[1],
+		Status:   resolutionOpen,
+		OpenedAt: openedAt.Format(timeLayout),
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(resolutionAsBytes)
+}
+
+/*
+ * voteOnHOAResolution records the vote of a co-owner of a member house, weighted by its share
+ * args: association ID, resolution ID, house key, vote (yes or no)
+ */
+func (s *SmartContract) voteOnHOAResolution(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[3] != "yes" && args[3] != "no" {
+		return shim.Error("Vote must be yes or no")
+	}
+
+	hoa, err := getHOA(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	member := false
+	for _, houseKey := range hoa.Houses {
+		if houseKey == args[2] {
+			member = true
+		}
+	}
+	if !member {
+		return shim.Error("House " + args[2] + " is not a member of " + hoa.ID)
+	}
+	resolution, err := getHOAResolution(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if resolution.Status != resolutionOpen {
+		return shim.Error("Resolution " + resolution.ID + " is " + resolution.Status)
+	}
+
+	house, err := getHouse(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	voter, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	weight := 0
+	for _, share := range houseShares(house) {
+		if share.Owner == voter {
+			weight += share.Share
+		}
+	}
+	if weight == 0 {
+		return shim.Error(voter + " does not own house " + args[2])
+	}
+
+	voteKey, err := APIstub.CreateCompositeKey(hoaVoteObjectType, []string{args[0], args[1], args[2], voter})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(voteKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error(voter + " already voted on resolution " + resolution.ID + " for house " + args[2])
+	}
+
+	voteAsBytes, _ := json.Marshal(HOAVote{HouseKey: args[2], Voter: voter, Vote: args[3] == "yes", Weight: weight})
+	if err := APIstub.PutState(voteKey, voteAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(voteAsBytes)
+}
+
+/*
+ * closeHOAResolution tallies the votes and closes the resolution, for the administrator
+ * args: association ID, resolution ID
+ */
+func (s *SmartContract) closeHOAResolution(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	if _, err := requireHOAAdministrator(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	resolution, err := getHOAResolution(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if resolution.Status != resolutionOpen {
+		return shim.Error("Resolution " + resolution.ID + " is already " + resolution.Status)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(hoaVoteObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		vote := HOAVote{}
+		if err := json.Unmarshal(queryResponse.Value, &vote); err != nil {
+			return shim.Error(err.Error())
+		}
+		if vote.Vote {
+			resolution.Yes += vote.Weight
+		} else {
+			resolution.No += vote.Weight
+		}
+	}
+
+	resolution.Status = resolutionRejected
+	if resolution.Yes > resolution.No {
+		resolution.Status = resolutionAdopted
+	}
+	resolutionAsBytes, err := putHOAResolution(APIstub, resolution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "hoaResolutionClosed", resolutionAsBytes)
+	return shim.Success(resolutionAsBytes)
+}
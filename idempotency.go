@@ -0,0 +1,119 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Idempotency keys
+ * A client may pass a token of its choosing in the "idempotencyKey" transient field of a
+ * mutating transaction. The first successful transaction with that token records its result,
+ * and any retry with the same token returns that result instead of running again.
+ * Tokens are scoped to the invoking identity and to the function, and the operations of a batch
+ * each use the token suffixed with their index.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Transient field holding the idempotency token
+const idempotencyKeyField = "idempotencyKey"
+
+const idempotencyObjectType = "idempotency"
+
+// Functions accepting an idempotency token
+var idempotentFunctions = map[string]bool{
+	"createHouse":      true,
+	"changeHouseOwner": true,
+}
+
+// Define the processed token structure, the outcome of the first transaction carrying the token
+type ProcessedToken struct {
+	TxID     string `json:"txid"`
+	ArgsHash string `json:"argshash"`
+	Payload  []byte `json:"payload"`
+}
+
+// hashArgs fingerprints the arguments, so that a token cannot be reused for another request.
+// They are canonicalized first, a retry spelling the same request differently is the same request
+func hashArgs(APIstub shim.ChaincodeStubInterface, function string, args []string) string {
+	args = canonicalizeArgs(APIstub, function, args)
+	hash := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// invokeIdempotent runs the function, or replays the result of an earlier transaction carrying the same token
+func (s *SmartContract) invokeIdempotent(APIstub shim.ChaincodeStubInterface, function string, args []string) sc.Response {
+	if !idempotentFunctions[function] {
+		return s.route(APIstub, function, args)
+	}
+
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	token := string(transient[idempotencyKeyField])
+	if token == "" {
+		return s.route(APIstub, function, args)
+	}
+	if stub := transactionOf(APIstub); stub != nil && stub.operation != "" {
+		token += "#" + stub.operation
+	}
+
+	invoker, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tokenKey, err := APIstub.CreateCompositeKey(idempotencyObjectType, []string{invoker, function, token})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	processedAsBytes, err := APIstub.GetState(tokenKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	argsHash := hashArgs(APIstub, function, args)
+	if processedAsBytes != nil {
+		processed := ProcessedToken{}
+		if err := json.Unmarshal(processedAsBytes, &processed); err != nil {
+			return shim.Error(err.Error())
+		}
+		if processed.ArgsHash != argsHash {
+			return shim.Error("Idempotency key " + token + " was already used with other arguments")
+		}
+		logFor(APIstub).Infof("Replaying result of transaction %s for idempotency key %s", processed.TxID, token)
+		return shim.Success(processed.Payload)
+	}
+
+	response := s.route(APIstub, function, args)
+	if response.Status >= shim.ERRORTHRESHOLD {
+		return response
+	}
+
+	processedAsBytes, _ = json.Marshal(ProcessedToken{TxID: APIstub.GetTxID(), ArgsHash: argsHash, Payload: response.Payload})
+	if err := APIstub.PutState(tokenKey, processedAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return response
+}
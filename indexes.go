@@ -0,0 +1,321 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* House indexes
+ * Secondary indexes are composite keys derived from the house records, maintained by putHouse
+ * whenever a house is written. They work on both LevelDB and CouchDB state databases.
+ * Each index entry is made of the index attributes followed by the house key, and holds no value.
+ */
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Define the house index structure. Entries returns the attribute lists of the index entries of a house
+type houseIndex struct {
+	name    string
+	entries func(house House) [][]string
+}
+
+// Indexes maintained on every house write
+var houseIndexes = []houseIndex{
+	{name: searchTermIndex, entries: searchTermEntries},
+	{name: locationIndex, entries: locationEntries},
+	{name: energyRatingIndex, entries: energyRatingEntries},
+	{name: ownerIndex, entries: ownerEntries},
+	{name: statusIndex, entries: statusEntries},
+	{name: tagIndex, entries: tagEntries},
+	{name: postalCodeIndex, entries: postalCodeEntries},
+	{name: cadastralIndex, entries: cadastralEntries},
+}
+
+// indexEntryKeys returns the composite keys of the entries of the index for the house
+func indexEntryKeys(APIstub shim.ChaincodeStubInterface, index houseIndex, key string, house House) (map[string]bool, error) {
+	entryKeys := map[string]bool{}
+	for _, attributes := range index.entries(house) {
+		entryKey, err := APIstub.CreateCompositeKey(index.name, append(attributes, key))
+		if err != nil {
+			return nil, err
+		}
+		entryKeys[entryKey] = true
+	}
+	return entryKeys, nil
+}
+
+// updateHouseIndexes replaces the index entries and the counts of the previous version of the house (nil for a new house)
+func updateHouseIndexes(APIstub shim.ChaincodeStubInterface, key string, previous *House, house *House) error {
+	for _, index := range houseIndexes {
+		previousKeys, newKeys := map[string]bool{}, map[string]bool{}
+		var err error
+		if previous != nil {
+			if previousKeys, err = indexEntryKeys(APIstub, index, key, *previous); err != nil {
+				return err
+			}
+		}
+		if house != nil {
+			if newKeys, err = indexEntryKeys(APIstub, index, key, *house); err != nil {
+				return err
+			}
+		}
+
+		// Keys are sorted so that every endorser issues its writes in the same order
+		for _, entryKey := range sortedKeys(previousKeys) {
+			if !newKeys[entryKey] {
+				if err := APIstub.DelState(entryKey); err != nil {
+					return err
+				}
+			}
+		}
+		for _, entryKey := range sortedKeys(newKeys) {
+			if !previousKeys[entryKey] {
+				// Only the key name is needed, no need to store a duplicate copy of the house
+				if err := APIstub.PutState(entryKey, []byte{0x00}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return updateHouseCounters(APIstub, previous, house)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// queryIndexedHouseKeys returns the keys of the houses whose index entries start with the given attributes
+func queryIndexedHouseKeys(APIstub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]string, error) {
+	return queryIndexedHouseKeysUpTo(APIstub, indexName, attributes, 0)
+}
+
+// queryIndexedHouseKeysUpTo returns the keys of the first houses, up to limit (0 for no limit), whose index entries
+// start with the given attributes
+func queryIndexedHouseKeysUpTo(APIstub shim.ChaincodeStubInterface, indexName string, attributes []string, limit int) ([]string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	seen := map[string]bool{}
+	keys := []string{}
+	for resultsIterator.HasNext() && (limit == 0 || len(keys) < limit) {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, entryAttributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		houseKey := entryAttributes[len(entryAttributes)-1]
+		if !seen[houseKey] {
+			seen[houseKey] = true
+			keys = append(keys, houseKey)
+		}
+	}
+	return keys, nil
+}
+
+// Define the house result structure, the shape of the house lists returned by queries
+type houseResult struct {
+	Key    string `json:"Key"`
+	Record House  `json:"Record"`
+}
+
+// getHouseResults reads the houses of the given keys
+func getHouseResults(APIstub shim.ChaincodeStubInterface, keys []string) ([]houseResult, error) {
+	results := []houseResult{}
+	for _, key := range keys {
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, houseResult{Key: key, Record: house})
+	}
+	return results, nil
+}
+
+// Prefix of the bookmarks of the second phase of rebuildIndexes, followed by the hex encoded last entry checked
+const purgeBookmarkPrefix = "purge:"
+
+/*
+ * rebuildIndexes rebuilds one chunk of an index, for admins. The houses are scanned first to write
+ * their missing entries, then the entries of the index are scanned to delete the stale ones.
+ * Pagination is not available to transactions writing to the ledger, so the bookmark is the first
+ * house key of the next chunk, then the last entry checked
+ * args: index name, maximum number of records to scan, bookmark (empty to start)
+ */
+func (s *SmartContract) rebuildIndexes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var index *houseIndex
+	for i := range houseIndexes {
+		if houseIndexes[i].name == args[0] {
+			index = &houseIndexes[i]
+		}
+	}
+	if index == nil {
+		return shim.Error("Unknown index " + args[0])
+	}
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+
+	var result = struct {
+		Scanned  int    `json:"scanned"`
+		Written  int    `json:"written"`
+		Deleted  int    `json:"deleted"`
+		Bookmark string `json:"bookmark"`
+	}{}
+
+	if strings.HasPrefix(args[2], purgeBookmarkPrefix) {
+		lastKey, err := hex.DecodeString(strings.TrimPrefix(args[2], purgeBookmarkPrefix))
+		if err != nil {
+			return shim.Error("Invalid bookmark " + args[2])
+		}
+		result.Scanned, result.Deleted, result.Bookmark, err = purgeIndexEntries(APIstub, *index, string(lastKey), pageSize)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		startKey := args[2]
+		if startKey == "" {
+			startKey = houseStartKey
+		}
+		resultsIterator, err := APIstub.GetStateByRange(startKey, houseEndKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer resultsIterator.Close()
+
+		// The houses done, the next chunk starts the purge of the entries
+		result.Bookmark = purgeBookmarkPrefix
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if result.Scanned == pageSize {
+				result.Bookmark = queryResponse.Key
+				break
+			}
+			result.Scanned++
+
+			house, err := decodeHouse(queryResponse.Value)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			entryKeys, err := indexEntryKeys(APIstub, *index, queryResponse.Key, house)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			for _, entryKey := range sortedKeys(entryKeys) {
+				entryAsBytes, err := APIstub.GetState(entryKey)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+				if entryAsBytes == nil {
+					if err := APIstub.PutState(entryKey, []byte{0x00}); err != nil {
+						return shim.Error(err.Error())
+					}
+					result.Written++
+				}
+			}
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// purgeIndexEntries deletes the entries of the index not derived from their house, checking at most
+// pageSize entries after lastKey. It returns the counts and the bookmark, empty once every entry was checked
+func purgeIndexEntries(APIstub shim.ChaincodeStubInterface, index houseIndex, lastKey string, pageSize int) (int, int, string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(index.name, []string{})
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer resultsIterator.Close()
+
+	scanned, deleted := 0, 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, 0, "", err
+		}
+		// Composite keys cannot start a range, the entries already checked are skipped
+		if queryResponse.Key <= lastKey {
+			continue
+		}
+		if scanned == pageSize {
+			return scanned, deleted, purgeBookmarkPrefix + hex.EncodeToString([]byte(lastKey)), nil
+		}
+		scanned++
+		lastKey = queryResponse.Key
+
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		houseKey := attributes[len(attributes)-1]
+		derived := false
+		houseAsBytes, err := APIstub.GetState(houseKey)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if houseAsBytes != nil {
+			house, err := decodeHouse(houseAsBytes)
+			if err != nil {
+				return 0, 0, "", err
+			}
+			entryKeys, err := indexEntryKeys(APIstub, index, houseKey, house)
+			if err != nil {
+				return 0, 0, "", err
+			}
+			derived = entryKeys[queryResponse.Key]
+		}
+		if !derived {
+			if err := APIstub.DelState(queryResponse.Key); err != nil {
+				return 0, 0, "", err
+			}
+			deleted++
+		}
+	}
+	return scanned, deleted, "", nil
+}
@@ -0,0 +1,227 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Integrity checks
+ * verifyIntegrity walks the state one page at a time for operational audits, and reports the
+ * houses whose shares are inconsistent, the index entries no longer derived from their house
+ * and the records referencing houses that do not exist. The state is walked as a sequence of
+ * scans, the returned bookmark naming the scan to resume along with its position.
+ */
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Define the integrity issue structure, one inconsistency found in the state
+type IntegrityIssue struct {
+	Scan    string `json:"scan"`
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// Define the integrity scan structure. An empty object type scans the houses, check returns the problem found with a record
+type integrityScan struct {
+	name       string
+	objectType string
+	check      func(APIstub shim.ChaincodeStubInterface, key string, value []byte) (string, error)
+}
+
+// integrityScans returns the scans walked by verifyIntegrity, in order
+func integrityScans() []integrityScan {
+	scans := []integrityScan{{name: "houses", check: checkHouseShares}}
+	for _, index := range houseIndexes {
+		scans = append(scans, integrityScan{name: index.name, objectType: index.name, check: checkHouseIndexEntry(index)})
+	}
+
+	// Records whose first key attribute is the key of a house
+	for _, objectType := range []string{transferObjectType, photoObjectType, scheduledTransferObjectType, houseOptionIndex,
+		houseLeaseIndex, delegationObjectType, maintenanceObjectType, meterReadingObjectType, houseMortgageIndex, houseSubsidyIndex, lienObjectType, houseFreezeObjectType} {
+		scans = append(scans, integrityScan{name: objectType, objectType: objectType, check: checkHouseAttribute})
+	}
+
+	scans = append(scans, integrityScan{name: leaseObjectType, objectType: leaseObjectType, check: checkLeaseHouse})
+	return scans
+}
+
+// houseExists tells whether the house exists, or existed before being retired by a split or a merge
+func houseExists(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	houseAsBytes, err := APIstub.GetState(key)
+	if err != nil || houseAsBytes != nil {
+		return houseAsBytes != nil, err
+	}
+	return houseRetired(APIstub, key)
+}
+
+func checkHouseShares(APIstub shim.ChaincodeStubInterface, key string, value []byte) (string, error) {
+	house, err := decodeHouse(value)
+	if err != nil {
+		return "undecodable house: " + err.Error(), nil
+	}
+	if len(house.Shares) == 0 {
+		return "", nil
+	}
+
+	total := 0
+	for _, share := range house.Shares {
+		total += share.Share
+	}
+	if total != wholeShare {
+		return "shares sum to " + strconv.Itoa(total) + " instead of " + strconv.Itoa(wholeShare), nil
+	}
+	if house.Shares[0].Owner != house.Owner {
+		return "owner " + house.Owner + " is not the first co-owner " + house.Shares[0].Owner, nil
+	}
+	return "", nil
+}
+
+func checkHouseIndexEntry(index houseIndex) func(shim.ChaincodeStubInterface, string, []byte) (string, error) {
+	return func(APIstub shim.ChaincodeStubInterface, key string, value []byte) (string, error) {
+		_, attributes, err := APIstub.SplitCompositeKey(key)
+		if err != nil {
+			return "", err
+		}
+		houseKey := attributes[len(attributes)-1]
+		houseAsBytes, err := APIstub.GetState(houseKey)
+		if err != nil {
+			return "", err
+		}
+		if houseAsBytes == nil {
+			return "orphaned entry of deleted house " + houseKey, nil
+		}
+		house, err := decodeHouse(houseAsBytes)
+		if err != nil {
+			return "", err
+		}
+		entryKeys, err := indexEntryKeys(APIstub, index, houseKey, house)
+		if err != nil {
+			return "", err
+		}
+		if !entryKeys[key] {
+			return "stale entry no longer derived from house " + houseKey, nil
+		}
+		return "", nil
+	}
+}
+
+func checkHouseAttribute(APIstub shim.ChaincodeStubInterface, key string, value []byte) (string, error) {
+	_, attributes, err := APIstub.SplitCompositeKey(key)
+	if err != nil {
+		return "", err
+	}
+	exists, err := houseExists(APIstub, attributes[0])
+	if err != nil || exists {
+		return "", err
+	}
+	return "references nonexistent house " + attributes[0], nil
+}
+
+func checkLeaseHouse(APIstub shim.ChaincodeStubInterface, key string, value []byte) (string, error) {
+	lease := Lease{}
+	if err := json.Unmarshal(value, &lease); err != nil {
+		return "undecodable lease: " + err.Error(), nil
+	}
+	exists, err := houseExists(APIstub, lease.HouseKey)
+	if err != nil || exists {
+		return "", err
+	}
+	return "lease on nonexistent house " + lease.HouseKey, nil
+}
+
+/*
+ * verifyIntegrity checks one page of the state, for admins
+ * args: page size, bookmark (empty to start, then the bookmark returned by the previous page)
+ */
+func (s *SmartContract) verifyIntegrity(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+
+	// The bookmark is the index of the scan, then the bookmark of the scan itself
+	scans := integrityScans()
+	scanIndex, scanBookmark := 0, ""
+	if args[1] != "" {
+		parts := strings.SplitN(args[1], ":", 2)
+		scanIndex, err = strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || scanIndex < 0 || scanIndex >= len(scans) {
+			return shim.Error("Invalid bookmark " + args[1])
+		}
+		scanBookmark = parts[1]
+	}
+	scan := scans[scanIndex]
+
+	var resultsIterator shim.StateQueryIteratorInterface
+	var responseMetadata *sc.QueryResponseMetadata
+	if scan.objectType == "" {
+		resultsIterator, responseMetadata, err = APIstub.GetStateByRangeWithPagination(houseStartKey, houseEndKey, int32(pageSize), scanBookmark)
+	} else {
+		resultsIterator, responseMetadata, err = APIstub.GetStateByPartialCompositeKeyWithPagination(scan.objectType, []string{}, int32(pageSize), scanBookmark)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var report = struct {
+		Scan     string           `json:"scan"`
+		Scanned  int              `json:"scanned"`
+		Issues   []IntegrityIssue `json:"issues"`
+		Bookmark string           `json:"bookmark"`
+	}{Scan: scan.name, Issues: []IntegrityIssue{}}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		problem, err := scan.check(APIstub, queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if problem != "" {
+			report.Issues = append(report.Issues, IntegrityIssue{Scan: scan.name, Key: queryResponse.Key, Problem: problem})
+		}
+		report.Scanned++
+	}
+
+	// A short page ends the scan, the next page starts the following scan. An empty bookmark ends the walk
+	if responseMetadata.FetchedRecordsCount < int32(pageSize) {
+		if scanIndex+1 < len(scans) {
+			report.Bookmark = strconv.Itoa(scanIndex+1) + ":"
+		}
+	} else {
+		report.Bookmark = strconv.Itoa(scanIndex) + ":" + responseMetadata.Bookmark
+	}
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
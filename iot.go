@@ -0,0 +1,337 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* IoT reading commitments
+ * Smart meters and sensors read far too often for every reading to be a transaction. Instead the
+ * IoT gateway of a house commits, for every period, the Merkle root of the readings of a sensor
+ * and keeps the readings off the ledger. Anyone holding a reading and its Merkle proof can then
+ * check it against the committed root. Leaves and nodes are hashed as in RFC 6962: a leaf is the
+ * SHA-256 of 0x00 followed by the reading as serialized by the gateway, a node the SHA-256 of 0x01
+ * followed by its two children.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	iotGatewayObjectType        = "iotGateway"
+	readingCommitmentObjectType = "readingCommitment"
+	lastCommitmentObjectType    = "lastReadingCommitment"
+)
+
+// Define the IoT gateway structure, the identity committing the readings of houses
+type IoTGateway struct {
+	ID           string   `json:"id"`
+	Houses       []string `json:"houses"`
+	RegisteredBy string   `json:"registeredby"`
+}
+
+// Define the reading commitment structure, the Merkle root of the readings of a sensor over a period
+type ReadingCommitment struct {
+	HouseKey    string `json:"housekey"`
+	Sensor      string `json:"sensor"`
+	PeriodStart string `json:"periodstart"`
+	PeriodEnd   string `json:"periodend"`
+	Count       int    `json:"count"`
+	Root        string `json:"root"`
+	Gateway     string `json:"gateway"`
+	CommittedAt string `json:"committedat"`
+	TxID        string `json:"txid"`
+}
+
+// Define the Merkle proof step structure, the sibling of the node on the path from the leaf to the root
+type MerkleProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"`
+}
+
+func merkleLeafHash(data []byte) []byte {
+	digest := sha256.Sum256(append([]byte{0x00}, data...))
+	return digest[:]
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+	digest := sha256.Sum256(append(append([]byte{0x01}, left...), right...))
+	return digest[:]
+}
+
+// merkleRootFromProof returns the root hash of the tree holding the leaf data along the proof
+func merkleRootFromProof(data []byte, proof []MerkleProofStep) ([]byte, error) {
+	hash := merkleLeafHash(data)
+	for i, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return nil, fmt.Errorf("Step %d of the proof must be a hex encoded sha256", i)
+		}
+		switch step.Position {
+		case "left":
+			hash = merkleNodeHash(sibling, hash)
+		case "right":
+			hash = merkleNodeHash(hash, sibling)
+		default:
+			return nil, fmt.Errorf("Position of step %d of the proof must be left or right", i)
+		}
+	}
+	return hash, nil
+}
+
+func getReadingCommitment(APIstub shim.ChaincodeStubInterface, key string, sensor string, periodStart string) (ReadingCommitment, error) {
+	commitmentKey, err := APIstub.CreateCompositeKey(readingCommitmentObjectType, []string{key, sensor, periodStart})
+	if err != nil {
+		return ReadingCommitment{}, err
+	}
+	commitmentAsBytes, err := APIstub.GetState(commitmentKey)
+	if err != nil {
+		return ReadingCommitment{}, err
+	}
+	if commitmentAsBytes == nil {
+		return ReadingCommitment{}, fmt.Errorf("No readings of sensor %s of house %s are committed for the period starting %s", sensor, key, periodStart)
+	}
+	commitment := ReadingCommitment{}
+	err = json.Unmarshal(commitmentAsBytes, &commitment)
+	return commitment, err
+}
+
+/*
+ * registerIoTGateway sets the houses whose readings a gateway commits, for utilities. No house removes the gateway
+ * args: gateway identity, house keys as a comma separated list
+ */
+func (s *SmartContract) registerIoTGateway(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleUtility); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Gateway identity must not be empty")
+	}
+	houses := splitList(args[1])
+	for _, key := range houses {
+		if _, err := getHouse(APIstub, key); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	gatewayKey, err := APIstub.CreateCompositeKey(iotGatewayObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(houses) == 0 {
+		if err := APIstub.DelState(gatewayKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	registeredBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	gatewayAsBytes, _ := json.Marshal(IoTGateway{ID: args[0], Houses: houses, RegisteredBy: registeredBy})
+	if err := APIstub.PutState(gatewayKey, gatewayAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(gatewayAsBytes)
+}
+
+/*
+ * commitReadingsRoot commits the Merkle root of the readings of a sensor of a house over a period, for its gateway.
+ * Periods of a sensor follow each other without overlapping
+ * args: house key, sensor, period start, period end (RFC 3339), number of readings, Merkle root (hex sha256)
+ */
+func (s *SmartContract) commitReadingsRoot(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+	if args[1] == "" {
+		return shim.Error("Sensor must not be empty")
+	}
+	periodStart, err := time.Parse(timeLayout, args[2])
+	if err != nil {
+		return shim.Error("Period start must be formatted as RFC 3339")
+	}
+	periodEnd, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("Period end must be formatted as RFC 3339")
+	}
+	if !periodEnd.After(periodStart) {
+		return shim.Error("Period end must be after its start")
+	}
+	count, err := strconv.Atoi(args[4])
+	if err != nil || count <= 0 {
+		return shim.Error("Number of readings must be a positive number")
+	}
+	if err := validateSHA256(args[5]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	gatewayID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	gatewayKey, err := APIstub.CreateCompositeKey(iotGatewayObjectType, []string{gatewayID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	gatewayAsBytes, err := APIstub.GetState(gatewayKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	gateway := IoTGateway{}
+	if gatewayAsBytes != nil {
+		if err := json.Unmarshal(gatewayAsBytes, &gateway); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	served := false
+	for _, key := range gateway.Houses {
+		served = served || key == args[0]
+	}
+	if !served {
+		return shim.Error("Access denied. " + gatewayID + " is not the gateway of house " + args[0])
+	}
+
+	lastKey, err := APIstub.CreateCompositeKey(lastCommitmentObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lastEnd, err := APIstub.GetState(lastKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lastEnd != nil {
+		end, err := time.Parse(timeLayout, string(lastEnd))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if periodStart.Before(end) {
+			return shim.Error("Readings of sensor " + args[1] + " of house " + args[0] + " are already committed until " + string(lastEnd))
+		}
+	}
+
+	committedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var commitment = ReadingCommitment{
+		HouseKey:    args[0],
+		Sensor:      args[1],
+		PeriodStart: periodStart.UTC().Format(timeLayout),
+		PeriodEnd:   periodEnd.UTC().Format(timeLayout),
+		Count:       count,
+		Root:        strings.ToLower(args[5]),
+		Gateway:     gatewayID,
+		CommittedAt: committedAt.Format(timeLayout),
+		TxID:        APIstub.GetTxID(),
+	}
+	commitmentKey, err := APIstub.CreateCompositeKey(readingCommitmentObjectType, []string{commitment.HouseKey, commitment.Sensor, commitment.PeriodStart})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	commitmentAsBytes, _ := json.Marshal(commitment)
+	if err := APIstub.PutState(commitmentKey, commitmentAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(lastKey, []byte(commitment.PeriodEnd)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(commitmentAsBytes)
+}
+
+/*
+ * verifyReading tells whether a reading belongs to the readings committed for a period, given its Merkle proof
+ * args: house key, sensor, period start (RFC 3339), reading as serialized by the gateway, proof as a JSON array of {hash, position (left or right)} from the leaf up
+ */
+func (s *SmartContract) verifyReading(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	periodStart, err := time.Parse(timeLayout, args[2])
+	if err != nil {
+		return shim.Error("Period start must be formatted as RFC 3339")
+	}
+	proof := []MerkleProofStep{}
+	if err := json.Unmarshal([]byte(args[4]), &proof); err != nil {
+		return shim.Error("Proof must be a JSON array of {hash, position}")
+	}
+
+	commitment, err := getReadingCommitment(APIstub, args[0], args[1], periodStart.UTC().Format(timeLayout))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	root, err := merkleRootFromProof([]byte(args[3]), proof)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var verification = struct {
+		Match        bool              `json:"match"`
+		ComputedRoot string            `json:"computedroot"`
+		Commitment   ReadingCommitment `json:"commitment"`
+	}{Match: hex.EncodeToString(root) == commitment.Root, ComputedRoot: hex.EncodeToString(root), Commitment: commitment}
+
+	verificationAsBytes, _ := json.Marshal(verification)
+	return shim.Success(verificationAsBytes)
+}
+
+// queryReadingCommitments returns the roots committed for a sensor of a house, in order of period. args: house key, sensor
+func (s *SmartContract) queryReadingCommitments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(readingCommitmentObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	commitments := []ReadingCommitment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		commitment := ReadingCommitment{}
+		if err := json.Unmarshal(queryResponse.Value, &commitment); err != nil {
+			return shim.Error(err.Error())
+		}
+		commitments = append(commitments, commitment)
+	}
+
+	commitmentsAsBytes, _ := json.Marshal(commitments)
+	return shim.Success(commitmentsAsBytes)
+}
@@ -0,0 +1,197 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Know your customer
+ * An owner identity asks to be verified, then a compliance officer marks it as verified,
+ * or as expired once its documents are no longer valid. A house can only be transferred
+ * to verified owners.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const kycObjectType = "kyc"
+
+// KYC statuses
+const (
+	kycPending  = "pending"
+	kycVerified = "verified"
+	kycExpired  = "expired"
+)
+
+// Define the KYC structure, the verification status of an owner identity
+type KYCRecord struct {
+	Owner     string `json:"owner"`
+	Status    string `json:"status"`
+	UpdatedBy string `json:"updatedby"`
+	UpdatedAt string `json:"updatedat"`
+}
+
+func getKYCRecord(APIstub shim.ChaincodeStubInterface, owner string) (KYCRecord, bool, error) {
+	record := KYCRecord{}
+
+	kycKey, err := APIstub.CreateCompositeKey(kycObjectType, []string{owner})
+	if err != nil {
+		return record, false, err
+	}
+	recordAsBytes, err := APIstub.GetState(kycKey)
+	if err != nil || recordAsBytes == nil {
+		return record, false, err
+	}
+
+	err = json.Unmarshal(recordAsBytes, &record)
+	return record, true, err
+}
+
+func putKYCRecord(APIstub shim.ChaincodeStubInterface, owner string, status string) ([]byte, error) {
+	updatedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	updatedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	kycKey, err := APIstub.CreateCompositeKey(kycObjectType, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+	recordAsBytes, _ := json.Marshal(KYCRecord{Owner: owner, Status: status, UpdatedBy: updatedBy, UpdatedAt: updatedAt.Format(timeLayout)})
+	return recordAsBytes, APIstub.PutState(kycKey, recordAsBytes)
+}
+
+// checkKYCVerified returns an error unless the owner identity is verified
+func checkKYCVerified(APIstub shim.ChaincodeStubInterface, owner string) error {
+	record, found, err := getKYCRecord(APIstub, owner)
+	if err != nil {
+		return err
+	}
+	if !found || record.Status != kycVerified {
+		return fmt.Errorf("Owner %s has not passed KYC verification", owner)
+	}
+	return nil
+}
+
+// requestKYC asks for the verification of the invoker's identity
+func (s *SmartContract) requestKYC(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record, found, err := getKYCRecord(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found && record.Status != kycExpired {
+		return shim.Error("KYC of " + owner + " is already " + record.Status)
+	}
+
+	recordAsBytes, err := putKYCRecord(APIstub, owner, kycPending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(recordAsBytes)
+}
+
+/*
+ * setKYCStatus marks an owner identity as verified or expired, for compliance officers
+ * args: owner, status (verified or expired)
+ */
+func (s *SmartContract) setKYCStatus(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[0] == "" {
+		return shim.Error("Owner must not be empty")
+	}
+	if args[1] != kycVerified && args[1] != kycExpired {
+		return shim.Error("KYC status must be " + kycVerified + " or " + kycExpired)
+	}
+	if err := requireRole(APIstub, roleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordAsBytes, err := putKYCRecord(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(recordAsBytes)
+}
+
+// queryKYCStatus returns the KYC record of an owner identity
+func (s *SmartContract) queryKYCStatus(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	record, found, err := getKYCRecord(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No KYC record for " + args[0])
+	}
+
+	recordAsBytes, _ := json.Marshal(record)
+	return shim.Success(recordAsBytes)
+}
+
+// queryPendingKYC lists the owner identities awaiting verification, for compliance officers
+func (s *SmartContract) queryPendingKYC(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	if err := requireRole(APIstub, roleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(kycObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []KYCRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		record := KYCRecord{}
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return shim.Error(err.Error())
+		}
+		if record.Status == kycPending {
+			records = append(records, record)
+		}
+	}
+
+	recordsAsBytes, _ := json.Marshal(records)
+	return shim.Success(recordsAsBytes)
+}
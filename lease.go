@@ -0,0 +1,462 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Leases and rent
+ * The owner of a house leases it to a tenant at a monthly rent, provided the house has a valid
+ * occupancy certificate. Rent payments are recorded against the lease per monthly period, so
+ * that missed periods show in the arrears report.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	leaseObjectType       = "lease"
+	houseLeaseIndex       = "key~lease"
+	rentPaymentObjectType = "rentPayment"
+)
+
+// Lease statuses
+const (
+	leaseActive     = "active"
+	leaseTerminated = "terminated"
+)
+
+// Define the lease structure, identified by the ID of the creating transaction.
+// Rent is due for every period from StartPeriod to EndPeriod, or to termination
+type Lease struct {
+	ID           string `json:"id"`
+	HouseKey     string `json:"housekey"`
+	Landlord     string `json:"landlord"`
+	Tenant       string `json:"tenant"`
+	MonthlyRent  int64  `json:"monthlyrent"`
+	StartPeriod  string `json:"startperiod"`
+	EndPeriod    string `json:"endperiod"`
+	Status       string `json:"status"`
+	TerminatedAt string `json:"terminatedat,omitempty"`
+}
+
+// Define the rent payment structure, one payment (possibly partial) of the rent of a period
+type RentPayment struct {
+	LeaseID    string `json:"leaseid"`
+	Period     string `json:"period"`
+	Amount     int64  `json:"amount"`
+	RecordedBy string `json:"recordedby"`
+	RecordedAt string `json:"recordedat"`
+	TxID       string `json:"txid"`
+}
+
+func getLease(APIstub shim.ChaincodeStubInterface, leaseID string) (Lease, error) {
+	lease := Lease{}
+
+	leaseKey, err := APIstub.CreateCompositeKey(leaseObjectType, []string{leaseID})
+	if err != nil {
+		return lease, err
+	}
+	leaseAsBytes, err := APIstub.GetState(leaseKey)
+	if err != nil {
+		return lease, err
+	}
+	if leaseAsBytes == nil {
+		return lease, fmt.Errorf("Lease %s does not exist", leaseID)
+	}
+
+	err = json.Unmarshal(leaseAsBytes, &lease)
+	return lease, err
+}
+
+func putLease(APIstub shim.ChaincodeStubInterface, lease Lease) ([]byte, error) {
+	leaseKey, err := APIstub.CreateCompositeKey(leaseObjectType, []string{lease.ID})
+	if err != nil {
+		return nil, err
+	}
+	leaseAsBytes, _ := json.Marshal(lease)
+	return leaseAsBytes, APIstub.PutState(leaseKey, leaseAsBytes)
+}
+
+// requireLandlord returns an error unless the invoker is the landlord of the lease
+func requireLandlord(APIstub shim.ChaincodeStubInterface, lease Lease) error {
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invoker != lease.Landlord {
+		return fmt.Errorf("Only the landlord %s can do this on lease %s", lease.Landlord, lease.ID)
+	}
+	return nil
+}
+
+// lastRentPeriod returns the last period for which rent is due, at the given date
+func lastRentPeriod(lease Lease, at string) string {
+	last := lease.EndPeriod
+	if at < last {
+		last = at
+	}
+	if lease.TerminatedAt != "" && lease.TerminatedAt[:len(periodLayout)] < last {
+		last = lease.TerminatedAt[:len(periodLayout)]
+	}
+	return last
+}
+
+/*
+ * createLease leases the house to a tenant, for the owner or a manager with the leases permission
+ * args: house key, tenant, monthly rent, first period, last period (both YYYY-MM)
+ */
+func (s *SmartContract) createLease(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	if args[1] == "" {
+		return shim.Error("Tenant must not be empty")
+	}
+	rent, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || rent <= 0 {
+		return shim.Error("Monthly rent must be a positive number")
+	}
+	if _, err := time.Parse(periodLayout, args[3]); err != nil {
+		return shim.Error("First period must be formatted YYYY-MM")
+	}
+	if _, err := time.Parse(periodLayout, args[4]); err != nil {
+		return shim.Error("Last period must be formatted YYYY-MM")
+	}
+	if args[4] < args[3] {
+		return shim.Error("Last period must not be before the first period")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkOccupancyCertificate(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var lease = Lease{
+		ID:          APIstub.GetTxID(),
+		HouseKey:    args[0],
+		Landlord:    house.Owner,
+		Tenant:      args[1],
+		MonthlyRent: rent,
+		StartPeriod: args[3],
+		EndPeriod:   args[4],
+		Status:      leaseActive,
+	}
+	leaseStart, leaseEnd := leaseDays(lease)
+	bookings, _, err := stayConflicts(APIstub, lease.HouseKey, leaseStart, leaseEnd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(bookings) > 0 {
+		return shim.Error(fmt.Sprintf("House %s is booked from %s to %s by booking %s", lease.HouseKey, bookings[0].Start, bookings[0].End, bookings[0].ID))
+	}
+
+	leaseAsBytes, err := putLease(APIstub, lease)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(houseLeaseIndex, []string{lease.HouseKey, lease.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+	// The house is let, it is no longer for rent
+	listingKey, err := APIstub.CreateCompositeKey(rentalListingObjectType, []string{lease.HouseKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(listingKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "leaseCreated", leaseAsBytes)
+	return shim.Success(leaseAsBytes)
+}
+
+/*
+ * terminateLease ends the lease early, only the landlord can do it
+ * args: lease ID
+ */
+func (s *SmartContract) terminateLease(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLandlord(APIstub, lease); err != nil {
+		return shim.Error(err.Error())
+	}
+	if lease.Status != leaseActive {
+		return shim.Error("Lease " + lease.ID + " is already " + lease.Status)
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lease.Status = leaseTerminated
+	lease.TerminatedAt = txTime.Format(timeLayout)
+
+	leaseAsBytes, err := putLease(APIstub, lease)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "leaseTerminated", leaseAsBytes)
+	return shim.Success(leaseAsBytes)
+}
+
+/*
+ * queryHouseLeases lists the leases of the house
+ * args: house key
+ */
+func (s *SmartContract) queryHouseLeases(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(houseLeaseIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	leases := []Lease{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		lease, err := getLease(APIstub, attributes[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		leases = append(leases, lease)
+	}
+
+	leasesAsBytes, _ := json.Marshal(leases)
+	return shim.Success(leasesAsBytes)
+}
+
+/*
+ * recordRentPayment records a payment of rent for a period of the lease, only the landlord can do it
+ * args: lease ID, period (YYYY-MM), amount
+ */
+func (s *SmartContract) recordRentPayment(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if _, err := time.Parse(periodLayout, args[1]); err != nil {
+		return shim.Error("Period must be formatted YYYY-MM")
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLandlord(APIstub, lease); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] < lease.StartPeriod || args[1] > lease.EndPeriod {
+		return shim.Error(fmt.Sprintf("Period %s is outside lease %s (%s to %s)", args[1], lease.ID, lease.StartPeriod, lease.EndPeriod))
+	}
+
+	recordedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recordedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var payment = RentPayment{
+		LeaseID:    lease.ID,
+		Period:     args[1],
+		Amount:     amount,
+		RecordedBy: recordedBy,
+		RecordedAt: recordedAt.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+
+	paymentKey, err := APIstub.CreateCompositeKey(rentPaymentObjectType, []string{payment.LeaseID, payment.Period, payment.TxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	paymentAsBytes, _ := json.Marshal(payment)
+	if err := APIstub.PutState(paymentKey, paymentAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(paymentAsBytes)
+}
+
+// getRentPaid sums the rent payments of the lease per period
+func getRentPaid(APIstub shim.ChaincodeStubInterface, leaseID string) (map[string]int64, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rentPaymentObjectType, []string{leaseID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	paid := map[string]int64{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		payment := RentPayment{}
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		paid[payment.Period] += payment.Amount
+	}
+	return paid, nil
+}
+
+/*
+ * getArrearsReport lists the leases of the invoker, as landlord, with periods not fully paid at the date
+ * args: date (YYYY-MM-DD)
+ */
+func (s *SmartContract) getArrearsReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	asOf, err := time.Parse(dayLayout, args[0])
+	if err != nil {
+		return shim.Error("Date must be formatted YYYY-MM-DD")
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type missedPeriod struct {
+		Period string `json:"period"`
+		Due    int64  `json:"due"`
+		Paid   int64  `json:"paid"`
+	}
+	type arrears struct {
+		LeaseID  string         `json:"leaseid"`
+		HouseKey string         `json:"housekey"`
+		Tenant   string         `json:"tenant"`
+		Missed   []missedPeriod `json:"missed"`
+		Total    int64          `json:"total"`
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(leaseObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	report := []arrears{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		lease := Lease{}
+		if err := json.Unmarshal(queryResponse.Value, &lease); err != nil {
+			return shim.Error(err.Error())
+		}
+		if lease.Landlord != invoker {
+			continue
+		}
+
+		paid, err := getRentPaid(APIstub, lease.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		leaseArrears := arrears{LeaseID: lease.ID, HouseKey: lease.HouseKey, Tenant: lease.Tenant, Missed: []missedPeriod{}}
+		last := lastRentPeriod(lease, asOf.Format(periodLayout))
+		start, _ := time.Parse(periodLayout, lease.StartPeriod)
+		for month := start; month.Format(periodLayout) <= last; month = month.AddDate(0, 1, 0) {
+			period := month.Format(periodLayout)
+			if paid[period] < lease.MonthlyRent {
+				leaseArrears.Missed = append(leaseArrears.Missed, missedPeriod{Period: period, Due: lease.MonthlyRent, Paid: paid[period]})
+				leaseArrears.Total += lease.MonthlyRent - paid[period]
+			}
+		}
+		if len(leaseArrears.Missed) > 0 {
+			report = append(report, leaseArrears)
+		}
+	}
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Total > report[j].Total })
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
+
+// isActiveTenant tells whether the identity is the tenant of an active lease of the house
+func isActiveTenant(APIstub shim.ChaincodeStubInterface, key string, identity string) (bool, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(houseLeaseIndex, []string{key})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return false, err
+		}
+		lease, err := getLease(APIstub, attributes[1])
+		if err != nil {
+			return false, err
+		}
+		if lease.Tenant == identity && lease.Status == leaseActive {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Mock ledger
+ * The contract invoked in process on a mock stub, complete with the invocation pipeline, by
+ * identities of self-signed certificates: alice administers the ledger and registers the houses,
+ * bob passes KYC to receive them.
+ */
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// serializedIdentity returns the creator of the transactions of an identity of the MSP, with a self-signed certificate
+func serializedIdentity(tb testing.TB, mspID string, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return creator
+}
+
+// Define the mock ledger structure, a mock stub and the identities invoking it
+type mockLedger struct {
+	stub  *shimtest.MockStub
+	owner []byte
+	buyer []byte
+	tx    int
+}
+
+func newMockLedger(tb testing.TB) *mockLedger {
+	ledger := &mockLedger{
+		stub:  shimtest.NewMockStub("fabhouse", new(SmartContract)),
+		owner: serializedIdentity(tb, "Org1MSP", "alice"),
+		buyer: serializedIdentity(tb, "Org1MSP", "bob"),
+	}
+	ledger.stub.Creator = ledger.owner
+	if response := ledger.stub.MockInit("init", [][]byte{[]byte("init"), []byte(""), []byte("Org1MSP")}); response.Status != shim.OK {
+		tb.Fatal(response.Message)
+	}
+
+	ledger.invoke(tb, ledger.owner, "bootstrapAdmin")
+	ledger.assignRoles(tb, ledger.owner, roleCompliance, roleRegistrar)
+	ledger.invoke(tb, ledger.owner, "setKYCStatus", "bob", kycVerified)
+	return ledger
+}
+
+// call runs the function as the identity and returns its response
+func (ledger *mockLedger) call(creator []byte, function string, args ...string) sc.Response {
+	ledger.tx++
+	ledger.stub.Creator = creator
+	invocation := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invocation = append(invocation, []byte(arg))
+	}
+	return ledger.stub.MockInvoke(ledger.lastTxID(), invocation)
+}
+
+// lastTxID returns the ID of the last transaction invoked
+func (ledger *mockLedger) lastTxID() string {
+	return fmt.Sprintf("tx%d", ledger.tx)
+}
+
+// invoke runs the function as the identity, failing the test when it fails
+func (ledger *mockLedger) invoke(tb testing.TB, creator []byte, function string, args ...string) []byte {
+	tb.Helper()
+	response := ledger.call(creator, function, args...)
+	if response.Status != shim.OK {
+		tb.Fatalf("%s: %s", function, response.Message)
+	}
+	return response.Payload
+}
+
+// refuse runs the function as the identity, failing the test unless it fails
+func (ledger *mockLedger) refuse(tb testing.TB, creator []byte, function string, args ...string) string {
+	tb.Helper()
+	response := ledger.call(creator, function, args...)
+	if response.Status == shim.OK {
+		tb.Fatalf("%s succeeded, expected it to fail", function)
+	}
+	return response.Message
+}
+
+// identityID returns the unique ID of the identity, as returned by whoAmI
+func (ledger *mockLedger) identityID(tb testing.TB, creator []byte) string {
+	identity := Identity{}
+	if err := json.Unmarshal(ledger.invoke(tb, creator, "whoAmI"), &identity); err != nil {
+		tb.Fatal(err)
+	}
+	return identity.ID
+}
+
+func (ledger *mockLedger) assignRoles(tb testing.TB, creator []byte, roles ...string) {
+	id := ledger.identityID(tb, creator)
+	for _, role := range roles {
+		ledger.invoke(tb, ledger.owner, "assignRole", id, role)
+	}
+}
+
+// Define the paging stub structure, a mock stub serving paginated queries as a single page
+type pagingStub struct {
+	*shimtest.MockStub
+}
+
+func (stub pagingStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resultsIterator, &sc.QueryResponseMetadata{}, nil
+}
+
+// inTransaction runs the function directly on the stub in a transaction of its own, as the owner. Unlike the
+// invocations, it can call the paginated queries
+func (ledger *mockLedger) inTransaction(run func(APIstub shim.ChaincodeStubInterface)) {
+	ledger.tx++
+	ledger.stub.Creator = ledger.owner
+	txID := ledger.lastTxID()
+	ledger.stub.MockTransactionStart(txID)
+	run(newWriteCacheStub(pagingStub{ledger.stub}))
+	ledger.stub.MockTransactionEnd(txID)
+}
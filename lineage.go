@@ -0,0 +1,665 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Splits and merges
+ * Cadastral operations, recorded by the registrar: a house split into units, or houses merged into
+ * one. The house records replaced are retired, deleted from the house range along with their index
+ * entries, and their last state is kept in the lineage record of their key. Lineage records link
+ * every house to its parents and children so that the history can be followed across operations.
+ * A house with active mortgages, liens, leases or options, a scheduled transfer or a pending
+ * co-signature request is refused: those records are keyed by the house and would be left behind.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const lineageObjectType = "houseLineage"
+
+// Cadastral operations
+const (
+	operationSplit = "split"
+	operationMerge = "merge"
+)
+
+// Define the lineage structure, how a house was created and retired by cadastral operations
+// Record is the last state of a retired house
+type HouseLineage struct {
+	Key       string   `json:"key"`
+	Parents   []string `json:"parents,omitempty"`
+	CreatedBy string   `json:"createdby,omitempty"`
+	CreatedAt string   `json:"createdat,omitempty"`
+	Children  []string `json:"children,omitempty"`
+	RetiredBy string   `json:"retiredby,omitempty"`
+	RetiredAt string   `json:"retiredat,omitempty"`
+	Record    *House   `json:"record,omitempty"`
+}
+
+// Define the unit structure, one of the houses created by a split
+type HouseUnit struct {
+	Key         string `json:"key"`
+	SquareFeets string `json:"squarefeets"`
+	Location    string `json:"location,omitempty"`
+}
+
+// getHouseLineage returns the lineage record of the house, empty when the house never took part in an operation
+func getHouseLineage(APIstub shim.ChaincodeStubInterface, key string) (HouseLineage, error) {
+	lineageKey, err := APIstub.CreateCompositeKey(lineageObjectType, []string{key})
+	if err != nil {
+		return HouseLineage{}, err
+	}
+	lineageAsBytes, err := APIstub.GetState(lineageKey)
+	if err != nil {
+		return HouseLineage{}, err
+	}
+	lineage := HouseLineage{Key: key}
+	if lineageAsBytes == nil {
+		return lineage, nil
+	}
+	err = json.Unmarshal(lineageAsBytes, &lineage)
+	return lineage, err
+}
+
+func putHouseLineage(APIstub shim.ChaincodeStubInterface, lineage HouseLineage) error {
+	lineageKey, err := APIstub.CreateCompositeKey(lineageObjectType, []string{lineage.Key})
+	if err != nil {
+		return err
+	}
+	lineageAsBytes, _ := json.Marshal(lineage)
+	return APIstub.PutState(lineageKey, lineageAsBytes)
+}
+
+// houseRetired tells whether the key is the one of a house retired by a cadastral operation
+func houseRetired(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	lineage, err := getHouseLineage(APIstub, key)
+	return lineage.RetiredBy != "", err
+}
+
+// checkNewHouseKey returns an error unless the key can hold a new house: in the range of the houses,
+// never used by a house, retired or with archived records
+func checkNewHouseKey(APIstub shim.ChaincodeStubInterface, key string) error {
+	if key < houseStartKey || key >= houseEndKey {
+		return fmt.Errorf("House key %s is out of the range %s to %s", key, houseStartKey, houseEndKey)
+	}
+	used, err := houseKeyUsed(APIstub, key)
+	if err != nil {
+		return err
+	}
+	if used {
+		return fmt.Errorf("House key %s is already used", key)
+	}
+	return nil
+}
+
+// houseKeyUsed tells whether a house, live, retired or archived, is stored under the key
+func houseKeyUsed(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	houseAsBytes, err := APIstub.GetState(key)
+	if err != nil || houseAsBytes != nil {
+		return houseAsBytes != nil, err
+	}
+	retired, err := houseRetired(APIstub, key)
+	if err != nil || retired {
+		return retired, err
+	}
+	return hasArchivedRecords(APIstub, key)
+}
+
+// checkOperationAllowed returns an error when the house cannot take part in a cadastral operation
+func checkOperationAllowed(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	if house.DisputeID != "" {
+		return fmt.Errorf("House %s is in dispute (%s) and cannot be split or merged", key, house.DisputeID)
+	}
+	if err := checkNotFrozen(APIstub, key); err != nil {
+		return err
+	}
+	if err := checkLocationAuthority(APIstub, house.Location); err != nil {
+		return err
+	}
+	for _, share := range houseShares(house) {
+		if err := checkNotBlocked(APIstub, share.Owner); err != nil {
+			return err
+		}
+	}
+	return checkNotEncumbered(APIstub, key, house)
+}
+
+// indexedIDs returns the IDs listed under the house key in a key~id index
+func indexedIDs(APIstub shim.ChaincodeStubInterface, index string, key string) ([]string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(index, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, attributes[1])
+	}
+	return ids, nil
+}
+
+// checkNotEncumbered returns an error while the house has records that would not follow it to its units:
+// active mortgages, liens, active leases, live options, a scheduled transfer or a pending co-signature request
+func checkNotEncumbered(APIstub shim.ChaincodeStubInterface, key string, house House) error {
+	mortgageIDs, err := indexedIDs(APIstub, houseMortgageIndex, key)
+	if err != nil {
+		return err
+	}
+	for _, mortgageID := range mortgageIDs {
+		mortgage, err := getMortgage(APIstub, mortgageID)
+		if err != nil {
+			return err
+		}
+		if mortgage.Status == mortgageActive {
+			return fmt.Errorf("House %s has an active mortgage (%s) and cannot be split or merged", key, mortgageID)
+		}
+	}
+
+	liensIterator, err := APIstub.GetStateByPartialCompositeKey(lienObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	hasLien := liensIterator.HasNext()
+	liensIterator.Close()
+	if hasLien {
+		return fmt.Errorf("House %s has a lien and cannot be split or merged", key)
+	}
+
+	leaseIDs, err := indexedIDs(APIstub, houseLeaseIndex, key)
+	if err != nil {
+		return err
+	}
+	for _, leaseID := range leaseIDs {
+		lease, err := getLease(APIstub, leaseID)
+		if err != nil {
+			return err
+		}
+		if lease.Status == leaseActive {
+			return fmt.Errorf("House %s has an active lease (%s) and cannot be split or merged", key, leaseID)
+		}
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	optionIDs, err := indexedIDs(APIstub, houseOptionIndex, key)
+	if err != nil {
+		return err
+	}
+	for _, optionID := range optionIDs {
+		option, err := getOption(APIstub, optionID)
+		if err != nil {
+			return err
+		}
+		status, err := currentOptionStatus(option, house.Owner, txTime)
+		if err != nil {
+			return err
+		}
+		if status == optionLive {
+			return fmt.Errorf("House %s has a live option (%s) and cannot be split or merged", key, optionID)
+		}
+	}
+
+	scheduledKey, err := APIstub.CreateCompositeKey(scheduledTransferObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	scheduledAsBytes, err := APIstub.GetState(scheduledKey)
+	if err != nil {
+		return err
+	}
+	if scheduledAsBytes != nil {
+		return fmt.Errorf("House %s has a scheduled transfer and cannot be split or merged", key)
+	}
+
+	requestsIterator, err := APIstub.GetStateByPartialCompositeKey(cosignRequestObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer requestsIterator.Close()
+	for requestsIterator.HasNext() {
+		queryResponse, err := requestsIterator.Next()
+		if err != nil {
+			return err
+		}
+		request := CosignRequest{}
+		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
+			return err
+		}
+		expiresAt, err := time.Parse(timeLayout, request.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		if request.HouseKey == key && request.Status == cosignPending && txTime.Before(expiresAt) {
+			return fmt.Errorf("House %s has a pending co-signature request (%s) and cannot be split or merged", key, request.ID)
+		}
+	}
+	return nil
+}
+
+// retireHouse deletes the house, its index entries and its deed token, keeping its last state in its lineage record
+func retireHouse(APIstub shim.ChaincodeStubInterface, key string, house House, operation string, children []string, retiredAt string) error {
+	// Only the holder of the lock of a house retires it, the lock goes with the house
+	if err := checkHouseLock(APIstub, key); err != nil {
+		return err
+	}
+	lockKey, err := APIstub.CreateCompositeKey(houseLockObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := APIstub.DelState(lockKey); err != nil {
+		return err
+	}
+
+	lineage, err := getHouseLineage(APIstub, key)
+	if err != nil {
+		return err
+	}
+	lineage.Children = children
+	lineage.RetiredBy = operation
+	lineage.RetiredAt = retiredAt
+	lineage.Record = &house
+	if err := putHouseLineage(APIstub, lineage); err != nil {
+		return err
+	}
+
+	if err := APIstub.DelState(key); err != nil {
+		return err
+	}
+	if err := burnDeedToken(APIstub, key); err != nil {
+		return err
+	}
+	return updateHouseIndexes(APIstub, key, &house, nil)
+}
+
+/*
+ * splitHouse retires a house and creates its units, for the registrar. Every unit is held by the
+ * owners of the house in the same shares, and the square feets of the units must sum to the ones of the house
+ * args: house key, units as a JSON array of {key, squarefeets, location} (location defaults to the one of the house)
+ */
+func (s *SmartContract) splitHouse(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkOperationAllowed(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	units := []HouseUnit{}
+	if err := json.Unmarshal([]byte(args[1]), &units); err != nil {
+		return shim.Error("Units must be a JSON array of {key, squarefeets, location}")
+	}
+	if len(units) < 2 {
+		return shim.Error("A house must be split into at least 2 units")
+	}
+	totalSquareFeets, seen := 0, map[string]bool{}
+	for _, unit := range units {
+		if seen[unit.Key] {
+			return shim.Error("Unit key " + unit.Key + " is duplicated")
+		}
+		seen[unit.Key] = true
+		if err := checkNewHouseKey(APIstub, unit.Key); err != nil {
+			return shim.Error(err.Error())
+		}
+		squareFeets, err := strconv.Atoi(unit.SquareFeets)
+		if err != nil || squareFeets <= 0 {
+			return shim.Error("Square feets of unit " + unit.Key + " must be a positive number")
+		}
+		totalSquareFeets += squareFeets
+	}
+	if houseSquareFeets, err := strconv.Atoi(house.SquareFeets); err == nil && totalSquareFeets != houseSquareFeets {
+		return shim.Error(fmt.Sprintf("Square feets of the units sum to %d instead of the %d of house %s", totalSquareFeets, houseSquareFeets, args[0]))
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	children := []string{}
+	for _, unit := range units {
+		var child = House{
+			Year:          house.Year,
+			SquareFeets:   unit.SquareFeets,
+			Location:      house.Location,
+			Owner:         house.Owner,
+			Usage:         house.Usage,
+			Zone:          house.Zone,
+			Shares:        house.Shares,
+			Beneficiaries: house.Beneficiaries,
+		}
+		if unit.Location != "" {
+			if child.Location, err = resolveLocationAlias(APIstub, unit.Location); err != nil {
+				return shim.Error(err.Error())
+			}
+			if err := checkLocationAuthority(APIstub, child.Location); err != nil {
+				return shim.Error(err.Error())
+			}
+			if err := checkHouseZone(APIstub, child); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		if err := putHouse(APIstub, unit.Key, child); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := putHouseLineage(APIstub, HouseLineage{Key: unit.Key, Parents: []string{args[0]}, CreatedBy: operationSplit, CreatedAt: txTime.Format(timeLayout)}); err != nil {
+			return shim.Error(err.Error())
+		}
+		children = append(children, unit.Key)
+	}
+	if err := retireHouse(APIstub, args[0], house, operationSplit, children, txTime.Format(timeLayout)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	childrenAsBytes, _ := json.Marshal(children)
+	emitEvent(APIstub, "houseSplit", childrenAsBytes)
+	return shim.Success(childrenAsBytes)
+}
+
+// mergedShares returns the shares of the owners of the houses weighted by their square feets, the
+// rounding remainder going to the first owner
+func mergedShares(houses []House, squareFeets []int) []OwnershipShare {
+	owners, weights, total := []string{}, map[string]int64{}, int64(0)
+	for i, house := range houses {
+		for _, share := range houseShares(house) {
+			if _, known := weights[share.Owner]; !known {
+				owners = append(owners, share.Owner)
+			}
+			weights[share.Owner] += int64(squareFeets[i]) * int64(share.Share)
+			total += int64(squareFeets[i]) * int64(share.Share)
+		}
+	}
+
+	shares, allocated := []OwnershipShare{}, 0
+	for _, owner := range owners {
+		share := int(weights[owner] * wholeShare / total)
+		shares = append(shares, OwnershipShare{Owner: owner, Share: share})
+		allocated += share
+	}
+	shares[0].Share += wholeShare - allocated
+	return shares
+}
+
+/*
+ * mergeHouses retires houses and creates the house merging them, for the registrar. The owners of
+ * the houses hold the new house in proportion of the square feets they held
+ * args: house keys as a comma separated list, key of the new house
+ */
+func (s *SmartContract) mergeHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	keys := splitList(args[0])
+	if len(keys) < 2 {
+		return shim.Error("At least 2 houses must be merged")
+	}
+	if err := checkNewHouseKey(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	houses, squareFeets, seen := []House{}, []int{}, map[string]bool{}
+	totalSquareFeets := 0
+	for _, key := range keys {
+		if seen[key] {
+			return shim.Error("House " + key + " is listed twice")
+		}
+		seen[key] = true
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := checkOperationAllowed(APIstub, key, house); err != nil {
+			return shim.Error(err.Error())
+		}
+		houseSquareFeets, err := strconv.Atoi(house.SquareFeets)
+		if err != nil || houseSquareFeets <= 0 {
+			return shim.Error("Square feets of house " + key + " must be a positive number to weigh its owners")
+		}
+		houses = append(houses, house)
+		squareFeets = append(squareFeets, houseSquareFeets)
+		totalSquareFeets += houseSquareFeets
+	}
+
+	shares := mergedShares(houses, squareFeets)
+	var merged = House{
+		Year:        houses[0].Year,
+		SquareFeets: strconv.Itoa(totalSquareFeets),
+		Location:    houses[0].Location,
+		Owner:       shares[0].Owner,
+		Usage:       houses[0].Usage,
+		Zone:        houses[0].Zone,
+		Shares:      shares,
+	}
+	if len(shares) == 1 {
+		merged.Shares = nil
+	}
+	if err := validateZoning(APIstub, merged); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i, key := range keys {
+		if err := retireHouse(APIstub, key, houses[i], operationMerge, []string{args[1]}, txTime.Format(timeLayout)); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putHouse(APIstub, args[1], merged); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putHouseLineage(APIstub, HouseLineage{Key: args[1], Parents: keys, CreatedBy: operationMerge, CreatedAt: txTime.Format(timeLayout)}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mergedAsBytes, _ := json.Marshal(houseResult{Key: args[1], Record: merged})
+	emitEvent(APIstub, "housesMerged", mergedAsBytes)
+	return shim.Success(mergedAsBytes)
+}
+
+// queryHouseLineage returns the lineage record of a house, including the last state of a retired house
+func (s *SmartContract) queryHouseLineage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	lineage, err := getHouseLineage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lineageAsBytes, _ := json.Marshal(lineage)
+	return shim.Success(lineageAsBytes)
+}
+
+// Define the lineage node structure, a house or an owner of the lineage graph
+type LineageNode struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Retired bool   `json:"retired,omitempty"`
+}
+
+// Define the lineage edge structure, a split or a merge between two houses, or a transfer between two owners of a house
+type LineageEdge struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Type      string `json:"type"`
+	HouseKey  string `json:"housekey"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// Maximum depth of the lineage graph, bounding the reads of a query
+const maxLineageDepth = 10
+
+// Define the lineage graph builder, accumulating distinct nodes and edges
+type lineageGraph struct {
+	Nodes     []LineageNode `json:"nodes"`
+	Edges     []LineageEdge `json:"edges"`
+	nodeIDs   map[string]bool
+	edgeIDs   map[string]bool
+	houseKeys map[string]bool
+}
+
+func (graph *lineageGraph) addNode(node LineageNode) {
+	if !graph.nodeIDs[node.ID] {
+		graph.nodeIDs[node.ID] = true
+		graph.Nodes = append(graph.Nodes, node)
+	}
+}
+
+func (graph *lineageGraph) addEdge(edge LineageEdge) {
+	edgeID := edge.Type + "\x00" + edge.From + "\x00" + edge.To + "\x00" + edge.HouseKey
+	if !graph.edgeIDs[edgeID] {
+		graph.edgeIDs[edgeID] = true
+		graph.Edges = append(graph.Edges, edge)
+	}
+}
+
+func ownerNodeID(owner string) string {
+	return "owner:" + owner
+}
+
+// addHouse adds the house, its transfer chain and the houses it was split from or merged with, returning them
+func (graph *lineageGraph) addHouse(APIstub shim.ChaincodeStubInterface, key string) ([]string, error) {
+	lineage, err := getHouseLineage(APIstub, key)
+	if err != nil {
+		return nil, err
+	}
+	graph.addNode(LineageNode{ID: key, Type: "house", Name: key, Retired: lineage.RetiredBy != ""})
+
+	related := []string{}
+	for _, parent := range lineage.Parents {
+		graph.addEdge(LineageEdge{From: parent, To: key, Type: lineage.CreatedBy, HouseKey: key, Timestamp: lineage.CreatedAt})
+		related = append(related, parent)
+	}
+	for _, child := range lineage.Children {
+		graph.addEdge(LineageEdge{From: key, To: child, Type: lineage.RetiredBy, HouseKey: child, Timestamp: lineage.RetiredAt})
+		related = append(related, child)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		transfer := Transfer{}
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			return nil, err
+		}
+		graph.addNode(LineageNode{ID: ownerNodeID(transfer.From), Type: "owner", Name: transfer.From})
+		for _, share := range transfer.To {
+			graph.addNode(LineageNode{ID: ownerNodeID(share.Owner), Type: "owner", Name: share.Owner})
+			graph.addEdge(LineageEdge{From: ownerNodeID(transfer.From), To: ownerNodeID(share.Owner), Type: "transfer", HouseKey: key, Timestamp: transfer.Timestamp})
+		}
+	}
+	return related, nil
+}
+
+/*
+ * getLineage returns the provenance graph of a house: the houses it was split from or merged
+ * into, followed up to the depth, and the transfer chain of every house of the graph
+ * args: house key, depth (number of splits or merges followed from the house)
+ */
+func (s *SmartContract) getLineage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	depth, err := strconv.Atoi(args[1])
+	if err != nil || depth < 0 || depth > maxLineageDepth {
+		return shim.Error(fmt.Sprintf("Depth must be a number from 0 to %d", maxLineageDepth))
+	}
+	exists, err := houseExists(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !exists {
+		return shim.Error("House " + args[0] + " does not exist")
+	}
+
+	graph := lineageGraph{Nodes: []LineageNode{}, Edges: []LineageEdge{}, nodeIDs: map[string]bool{}, edgeIDs: map[string]bool{}, houseKeys: map[string]bool{args[0]: true}}
+	frontier := []string{args[0]}
+	for level := 0; len(frontier) > 0; level++ {
+		next := []string{}
+		for _, key := range frontier {
+			related, err := graph.addHouse(APIstub, key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if level == depth {
+				continue
+			}
+			for _, relatedKey := range related {
+				if !graph.houseKeys[relatedKey] {
+					graph.houseKeys[relatedKey] = true
+					next = append(next, relatedKey)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	// The houses beyond the depth are still named by the edges leading to them
+	for _, edge := range graph.Edges {
+		if edge.Type != "transfer" {
+			graph.addNode(LineageNode{ID: edge.From, Type: "house", Name: edge.From})
+			graph.addNode(LineageNode{ID: edge.To, Type: "house", Name: edge.To})
+		}
+	}
+
+	graphAsBytes, _ := json.Marshal(graph)
+	return shim.Success(graphAsBytes)
+}
@@ -0,0 +1,247 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* House locks
+ * A workflow spanning several transactions, such as closing a sale, locks the house for its
+ * duration so that nobody else writes it in between. A lock is held by the identity acquiring it,
+ * the only one allowed to write the house until it releases the lock or the lock expires.
+ * Expiry is compared to the transaction timestamp, and the holder extends a lock by acquiring it again.
+ * Notaries and registrars locking a house they do not own cannot extend their lock past three days.
+ * A court order or a foreclosure breaks the lock of the house it transfers.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const houseLockObjectType = "houseLock"
+
+// Longest time a house can be locked, in seconds
+const maxLockTTL = 24 * 60 * 60
+
+// Longest time a notary or registrar keeps a house locked, renewals included, in seconds
+const maxLockHold = 3 * 24 * 60 * 60
+
+// Define the house lock structure
+type HouseLock struct {
+	HouseKey   string `json:"housekey"`
+	Holder     string `json:"holder"`
+	AcquiredAt string `json:"acquiredat"`
+	ExpiresAt  string `json:"expiresat"`
+}
+
+// getHouseLock returns the lock of the house not expired at the transaction time, nil if none
+func getHouseLock(APIstub shim.ChaincodeStubInterface, key string) (*HouseLock, error) {
+	lockKey, err := APIstub.CreateCompositeKey(houseLockObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	lockAsBytes, err := APIstub.GetState(lockKey)
+	if err != nil || lockAsBytes == nil {
+		return nil, err
+	}
+	lock := HouseLock{}
+	if err := json.Unmarshal(lockAsBytes, &lock); err != nil {
+		return nil, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := time.Parse(timeLayout, lock.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !txTime.Before(expiresAt) {
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+// checkHouseLock returns an error when the house is locked by another identity than the invoker
+func checkHouseLock(APIstub shim.ChaincodeStubInterface, key string) error {
+	lock, err := getHouseLock(APIstub, key)
+	if err != nil || lock == nil {
+		return err
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invokerID != lock.Holder {
+		return fmt.Errorf("House %s is locked by %s until %s", key, lock.Holder, lock.ExpiresAt)
+	}
+	return nil
+}
+
+// breakHouseLock removes the lock of the house whoever holds it, for a court order or a foreclosure transferring it
+func breakHouseLock(APIstub shim.ChaincodeStubInterface, key string) error {
+	lock, err := getHouseLock(APIstub, key)
+	if err != nil {
+		return err
+	}
+	lockKey, err := APIstub.CreateCompositeKey(houseLockObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := APIstub.DelState(lockKey); err != nil {
+		return err
+	}
+	if lock != nil {
+		lockAsBytes, _ := json.Marshal(lock)
+		emitEvent(APIstub, "houseUnlocked", lockAsBytes)
+	}
+	return nil
+}
+
+/*
+ * acquireLock locks a house, or extends the lock of the invoker, for the owner, notaries and the registrar
+ * args: house key, time to live in seconds (at most a day)
+ */
+func (s *SmartContract) acquireLock(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ownerErr := requireOwner(APIstub, args[0], house)
+	if ownerErr != nil {
+		if requireRole(APIstub, roleNotary, roleRegistrar) != nil {
+			return shim.Error(ownerErr.Error())
+		}
+	}
+	ttl, err := strconv.Atoi(args[1])
+	if err != nil || ttl <= 0 || ttl > maxLockTTL {
+		return shim.Error(fmt.Sprintf("Time to live must be a number of seconds from 1 to %d", maxLockTTL))
+	}
+	if err := checkHouseLock(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lock := HouseLock{
+		HouseKey:   args[0],
+		Holder:     invokerID,
+		AcquiredAt: txTime.Format(timeLayout),
+		ExpiresAt:  txTime.Add(time.Duration(ttl) * time.Second).Format(timeLayout),
+	}
+	// A renewal keeps the time the lock was first acquired
+	existing, err := getHouseLock(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		lock.AcquiredAt = existing.AcquiredAt
+	}
+	if ownerErr != nil {
+		acquiredAt, err := time.Parse(timeLayout, lock.AcquiredAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if txTime.Add(time.Duration(ttl) * time.Second).After(acquiredAt.Add(maxLockHold * time.Second)) {
+			return shim.Error(fmt.Sprintf("A notary or registrar keeps house %s locked at most %d seconds from %s", args[0], maxLockHold, lock.AcquiredAt))
+		}
+	}
+	lockKey, err := APIstub.CreateCompositeKey(houseLockObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lockAsBytes, _ := json.Marshal(lock)
+	if err := APIstub.PutState(lockKey, lockAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "houseLocked", lockAsBytes)
+	return shim.Success(lockAsBytes)
+}
+
+/*
+ * releaseLock releases the lock of a house, for its holder and admins
+ * args: house key
+ */
+func (s *SmartContract) releaseLock(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	lock, err := getHouseLock(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lock == nil {
+		return shim.Error("House " + args[0] + " is not locked")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invokerID != lock.Holder {
+		if err := requireRole(APIstub, roleAdmin); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	lockKey, err := APIstub.CreateCompositeKey(houseLockObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(lockKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lockAsBytes, _ := json.Marshal(lock)
+	emitEvent(APIstub, "houseUnlocked", lockAsBytes)
+	return shim.Success(nil)
+}
+
+/*
+ * queryLock returns the lock of a house, null when it is not locked
+ * args: house key
+ */
+func (s *SmartContract) queryLock(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	lock, err := getHouseLock(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lockAsBytes, _ := json.Marshal(lock)
+	return shim.Success(lockAsBytes)
+}
@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Logging
+ * Log lines are JSON objects written to the standard output of the chaincode process.
+ * The level is read from FABHOUSE_LOG_LEVEL (debug, info, warning, error; info by default)
+ * and can be overridden at runtime by an admin with setLogLevel, the override being kept
+ * in the ledger so every peer applies it.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Environment variable holding the default log level
+const logLevelEnv = "FABHOUSE_LOG_LEVEL"
+
+const logLevelKey = "CONFIG_LOGLEVEL"
+
+// Log levels, in increasing order of severity
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+// Level configured by the environment, and level currently applied
+var (
+	defaultLogLevel = parseLogLevelOrInfo(os.Getenv(logLevelEnv))
+	currentLogLevel = defaultLogLevel
+)
+
+func parseLogLevel(name string) (int32, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return int32(level), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("Unknown log level %q, expecting one of %s", name, strings.Join(levelNames, ", "))
+}
+
+func parseLogLevelOrInfo(name string) int32 {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return levelInfo
+	}
+	return level
+}
+
+// Define the logger structure, carrying the context added to every line
+type Logger struct {
+	fields map[string]string
+}
+
+// logFor returns a logger carrying the context of the transaction
+func logFor(APIstub shim.ChaincodeStubInterface) *Logger {
+	function, _ := APIstub.GetFunctionAndParameters()
+	fields := map[string]string{
+		"txID":     APIstub.GetTxID(),
+		"channel":  APIstub.GetChannelID(),
+		"function": function,
+	}
+	if mspID, err := getInvokerMSP(APIstub); err == nil {
+		fields["mspID"] = mspID
+	}
+	return &Logger{fields: fields}
+}
+
+// processLog is the logger used outside of any transaction
+var processLog = &Logger{fields: map[string]string{}}
+
+func (l *Logger) log(level int32, format string, args ...interface{}) {
+	if level < atomic.LoadInt32(&currentLogLevel) {
+		return
+	}
+
+	line := map[string]string{}
+	for name, value := range l.fields {
+		line[name] = value
+	}
+	line["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = levelNames[level]
+	line["msg"] = fmt.Sprintf(format, args...)
+
+	lineAsBytes, _ := json.Marshal(line)
+	fmt.Fprintln(os.Stdout, string(lineAsBytes))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(levelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(levelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(levelWarning, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(levelError, format, args...) }
+
+// applyLogLevel switches to the level stored in the ledger, or back to the default level when none is stored
+func applyLogLevel(APIstub shim.ChaincodeStubInterface) {
+	level := defaultLogLevel
+
+	levelAsBytes, err := APIstub.GetState(logLevelKey)
+	if err == nil && levelAsBytes != nil {
+		if storedLevel, err := parseLogLevel(string(levelAsBytes)); err == nil {
+			level = storedLevel
+		}
+	}
+	atomic.StoreInt32(&currentLogLevel, level)
+}
+
+/*
+ * setLogLevel overrides the log level of every peer, e.g. "debug" to turn verbose logging on
+ * args: level (empty to go back to the level of the environment)
+ */
+func (s *SmartContract) setLogLevel(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var err error
+	if args[0] == "" {
+		err = APIstub.DelState(logLevelKey)
+	} else {
+		level, parseErr := parseLogLevel(args[0])
+		if parseErr != nil {
+			return shim.Error(parseErr.Error())
+		}
+		err = APIstub.PutState(logLevelKey, []byte(levelNames[level]))
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	logFor(APIstub).Infof("Log level set to %q", args[0])
+	return shim.Success(nil)
+}
@@ -0,0 +1,303 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Maintenance
+ * The owner or a tenant of a house opens maintenance requests. The owner, or a manager with the
+ * maintenance permission, assigns a contractor then logs the completion of the work with its
+ * cost and the hashes of the supporting documents (invoices, reports).
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const maintenanceObjectType = "maintenance"
+
+// Maintenance request statuses
+const (
+	maintenanceOpen      = "open"
+	maintenanceAssigned  = "assigned"
+	maintenanceCompleted = "completed"
+)
+
+// Define the maintenance request structure, identified by the ID of the opening transaction
+type MaintenanceRequest struct {
+	ID             string   `json:"id"`
+	HouseKey       string   `json:"housekey"`
+	Description    string   `json:"description"`
+	OpenedBy       string   `json:"openedby"`
+	OpenedAt       string   `json:"openedat"`
+	Status         string   `json:"status"`
+	Contractor     string   `json:"contractor,omitempty"`
+	Cost           int64    `json:"cost,omitempty"`
+	DocumentHashes []string `json:"documenthashes,omitempty"`
+	CompletedAt    string   `json:"completedat,omitempty"`
+}
+
+func getMaintenanceRequest(APIstub shim.ChaincodeStubInterface, key string, requestID string) (MaintenanceRequest, error) {
+	request := MaintenanceRequest{}
+
+	requestKey, err := APIstub.CreateCompositeKey(maintenanceObjectType, []string{key, requestID})
+	if err != nil {
+		return request, err
+	}
+	requestAsBytes, err := APIstub.GetState(requestKey)
+	if err != nil {
+		return request, err
+	}
+	if requestAsBytes == nil {
+		return request, fmt.Errorf("Maintenance request %s of house %s does not exist", requestID, key)
+	}
+
+	err = json.Unmarshal(requestAsBytes, &request)
+	return request, err
+}
+
+func putMaintenanceRequest(APIstub shim.ChaincodeStubInterface, request MaintenanceRequest) ([]byte, error) {
+	requestKey, err := APIstub.CreateCompositeKey(maintenanceObjectType, []string{request.HouseKey, request.ID})
+	if err != nil {
+		return nil, err
+	}
+	requestAsBytes, _ := json.Marshal(request)
+	return requestAsBytes, APIstub.PutState(requestKey, requestAsBytes)
+}
+
+// getMaintenanceRequests returns the maintenance requests of a house, or of every house when the key is empty
+func getMaintenanceRequests(APIstub shim.ChaincodeStubInterface, key string) ([]MaintenanceRequest, error) {
+	attributes := []string{}
+	if key != "" {
+		attributes = append(attributes, key)
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(maintenanceObjectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	requests := []MaintenanceRequest{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		request := MaintenanceRequest{}
+		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+/*
+ * openMaintenanceRequest opens a maintenance request, for the owner, a manager or a tenant of the house
+ * args: house key, description
+ */
+func (s *SmartContract) openMaintenanceRequest(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("Description must not be empty")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	openedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionMaintenance); err != nil {
+		tenant, tenantErr := isActiveTenant(APIstub, args[0], openedBy)
+		if tenantErr != nil {
+			return shim.Error(tenantErr.Error())
+		}
+		if !tenant {
+			return shim.Error(err.Error())
+		}
+	}
+	openedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requestAsBytes, err := putMaintenanceRequest(APIstub, MaintenanceRequest{
+		ID:          APIstub.GetTxID(),
+		HouseKey:    args[0],
+		Description: args[1],
+		OpenedBy:    openedBy,
+		OpenedAt:    openedAt.Format(timeLayout),
+		Status:      maintenanceOpen,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "maintenanceRequested", requestAsBytes)
+	return shim.Success(requestAsBytes)
+}
+
+/*
+ * assignContractor assigns a contractor to a maintenance request, for the owner or a manager
+ * args: house key, request ID, contractor
+ */
+func (s *SmartContract) assignContractor(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[2] == "" {
+		return shim.Error("Contractor must not be empty")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionMaintenance); err != nil {
+		return shim.Error(err.Error())
+	}
+	request, err := getMaintenanceRequest(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request.Status == maintenanceCompleted {
+		return shim.Error("Maintenance request " + request.ID + " is already completed")
+	}
+
+	request.Contractor = args[2]
+	request.Status = maintenanceAssigned
+	requestAsBytes, err := putMaintenanceRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(requestAsBytes)
+}
+
+/*
+ * completeMaintenance logs the completion of the work, for the owner or a manager
+ * args: house key, request ID, cost, document hashes as a comma separated list
+ */
+func (s *SmartContract) completeMaintenance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	cost, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || cost < 0 {
+		return shim.Error("Cost must be a positive number")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionMaintenance); err != nil {
+		return shim.Error(err.Error())
+	}
+	request, err := getMaintenanceRequest(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request.Status != maintenanceAssigned {
+		return shim.Error("Maintenance request " + request.ID + " is " + request.Status + ", a contractor must be assigned first")
+	}
+	completedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	request.Cost = cost
+	request.DocumentHashes = splitList(args[3])
+	request.Status = maintenanceCompleted
+	request.CompletedAt = completedAt.Format(timeLayout)
+	requestAsBytes, err := putMaintenanceRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "maintenanceCompleted", requestAsBytes)
+	return shim.Success(requestAsBytes)
+}
+
+/*
+ * queryMaintenanceRequests lists the maintenance requests of a house
+ * args: house key (empty for every house), status (empty for any status)
+ */
+func (s *SmartContract) queryMaintenanceRequests(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	requests, err := getMaintenanceRequests(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	filtered := []MaintenanceRequest{}
+	for _, request := range requests {
+		if args[1] == "" || request.Status == args[1] {
+			filtered = append(filtered, request)
+		}
+	}
+
+	requestsAsBytes, _ := json.Marshal(filtered)
+	return shim.Success(requestsAsBytes)
+}
+
+/*
+ * queryMaintenanceCosts totals the cost of the completed maintenance of a house
+ * args: house key
+ */
+func (s *SmartContract) queryMaintenanceCosts(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	requests, err := getMaintenanceRequests(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var totals = struct {
+		HouseKey  string `json:"housekey"`
+		Completed int    `json:"completed"`
+		Total     int64  `json:"total"`
+	}{HouseKey: args[0]}
+	for _, request := range requests {
+		if request.Status == maintenanceCompleted {
+			totals.Completed++
+			totals.Total += request.Cost
+		}
+	}
+
+	totalsAsBytes, _ := json.Marshal(totals)
+	return shim.Success(totalsAsBytes)
+}
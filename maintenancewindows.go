@@ -0,0 +1,209 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Maintenance windows
+ * Admins schedule windows of time during which some functions of the contract are unavailable,
+ * failing with a MAINTENANCE error. Windows are compared to the transaction timestamp and expire
+ * at their end: expired windows are no longer listed, and are deleted when a window is scheduled.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const maintenanceWindowObjectType = "maintenanceWindow"
+
+// Define the maintenance window structure, functions unavailable from start (inclusive) to end (exclusive)
+type MaintenanceWindow struct {
+	ID        string   `json:"id"`
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	Functions []string `json:"functions"`
+	Reason    string   `json:"reason"`
+}
+
+// getMaintenanceWindows returns the windows not expired at the time, with the keys of the expired ones
+func getMaintenanceWindows(APIstub shim.ChaincodeStubInterface, at time.Time) ([]MaintenanceWindow, []string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(maintenanceWindowObjectType, []string{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	windows, expired := []MaintenanceWindow{}, []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		window := MaintenanceWindow{}
+		if err := json.Unmarshal(queryResponse.Value, &window); err != nil {
+			return nil, nil, err
+		}
+		end, err := time.Parse(timeLayout, window.End)
+		if err != nil {
+			return nil, nil, err
+		}
+		if at.Before(end) {
+			windows = append(windows, window)
+		} else {
+			expired = append(expired, queryResponse.Key)
+		}
+	}
+	return windows, expired, nil
+}
+
+// checkMaintenanceWindows returns an error when the function is in a maintenance window open at the transaction time
+func checkMaintenanceWindows(APIstub shim.ChaincodeStubInterface, function string) error {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	windows, _, err := getMaintenanceWindows(APIstub, txTime)
+	if err != nil {
+		return err
+	}
+	for _, window := range windows {
+		start, _ := time.Parse(timeLayout, window.Start)
+		if txTime.Before(start) || !containsString(window.Functions, function) {
+			continue
+		}
+		return fmt.Errorf("%s: %s is unavailable until %s: %s", maintenanceError, function, window.End, window.Reason)
+	}
+	return nil
+}
+
+/*
+ * scheduleMaintenanceWindow adds or replaces a maintenance window, for admins
+ * args: window ID, start, end (RFC 3339), functions as a comma separated list, reason
+ */
+func (s *SmartContract) scheduleMaintenanceWindow(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Window ID must not be empty")
+	}
+	start, err := time.Parse(timeLayout, args[1])
+	if err != nil {
+		return shim.Error("Start must be formatted as RFC 3339")
+	}
+	end, err := time.Parse(timeLayout, args[2])
+	if err != nil {
+		return shim.Error("End must be formatted as RFC 3339")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !end.After(start) || !end.After(txTime) {
+		return shim.Error("End must be after start and in the future")
+	}
+	functions := splitList(args[3])
+	if len(functions) == 0 {
+		return shim.Error("Functions must not be empty")
+	}
+	for _, function := range functions {
+		// The schedule must stay manageable whatever the windows
+		if function == "scheduleMaintenanceWindow" || function == "cancelMaintenanceWindow" {
+			return shim.Error("A maintenance window cannot apply to " + function)
+		}
+	}
+
+	// Expired windows are purged on the way
+	_, expired, err := getMaintenanceWindows(APIstub, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, windowKey := range expired {
+		if err := APIstub.DelState(windowKey); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	windowKey, err := APIstub.CreateCompositeKey(maintenanceWindowObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	windowAsBytes, _ := json.Marshal(MaintenanceWindow{ID: args[0], Start: start.UTC().Format(timeLayout), End: end.UTC().Format(timeLayout), Functions: functions, Reason: args[4]})
+	if err := APIstub.PutState(windowKey, windowAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "maintenanceScheduled", windowAsBytes)
+	return shim.Success(windowAsBytes)
+}
+
+/*
+ * cancelMaintenanceWindow deletes a maintenance window, for admins
+ * args: window ID
+ */
+func (s *SmartContract) cancelMaintenanceWindow(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	windowKey, err := APIstub.CreateCompositeKey(maintenanceWindowObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	windowAsBytes, err := APIstub.GetState(windowKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if windowAsBytes == nil {
+		return shim.Error("Maintenance window " + args[0] + " does not exist")
+	}
+	if err := APIstub.DelState(windowKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "maintenanceCancelled", windowAsBytes)
+	return shim.Success(nil)
+}
+
+// getMaintenanceSchedule returns the maintenance windows not expired, open or to come
+func (s *SmartContract) getMaintenanceSchedule(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	windows, _, err := getMaintenanceWindows(APIstub, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	windowsAsBytes, _ := json.Marshal(windows)
+	return shim.Success(windowsAsBytes)
+}
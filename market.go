@@ -0,0 +1,390 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Market analytics
+ * Every sale at a disclosed price is recorded in a time series per location and quarter, from
+ * which the analytics queries aggregate prices per square meter. Aggregations walk the series
+ * page by page, so that their memory only grows with the number of groups.
+ * Square feets are converted to square meters, sales of houses of unknown size are left out of
+ * the averages.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const salePriceObjectType = "salePrice"
+
+// Number of sales read per page by the aggregations
+const aggregationPageSize = 100
+
+const squareMetersPerSquareFoot = 0.09290304
+
+// Define the sale price structure, one point of the price time series of a location
+type SalePrice struct {
+	HouseKey    string `json:"housekey"`
+	Location    string `json:"location"`
+	Quarter     string `json:"quarter"`
+	Price       int64  `json:"price"`
+	SquareFeets int    `json:"squarefeets"`
+	Year        string `json:"year"`
+	Timestamp   string `json:"timestamp"`
+	TxID        string `json:"txid"`
+}
+
+// quarterOf returns the quarter of the time, formatted YYYY-Qn
+func quarterOf(t time.Time) string {
+	return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+}
+
+// recordSalePrice adds a sale at a disclosed price to the time series of the location of the house
+func recordSalePrice(APIstub shim.ChaincodeStubInterface, key string, house House, reason string, price int64) error {
+	if reason != reasonSale || price == 0 {
+		return nil
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	squareFeets, _ := strconv.Atoi(house.SquareFeets)
+
+	var sale = SalePrice{
+		HouseKey:    key,
+		Location:    normalizeLocation(house.Location),
+		Quarter:     quarterOf(txTime),
+		Price:       price,
+		SquareFeets: squareFeets,
+		Year:        house.Year,
+		Timestamp:   txTime.Format(timeLayout),
+		TxID:        APIstub.GetTxID(),
+	}
+	saleKey, err := APIstub.CreateCompositeKey(salePriceObjectType, []string{sale.Location, sale.Quarter, sale.HouseKey, sale.TxID})
+	if err != nil {
+		return err
+	}
+	saleAsBytes, _ := json.Marshal(sale)
+	return APIstub.PutState(saleKey, saleAsBytes)
+}
+
+// forEachSale calls visit on the recorded sales matching the attributes, reading them page by page
+func forEachSale(APIstub shim.ChaincodeStubInterface, attributes []string, visit func(sale SalePrice)) error {
+	bookmark, more := "", true
+	for more {
+		var err error
+		if bookmark, more, err = visitSalePage(APIstub, attributes, bookmark, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitSalePage calls visit on one page of sales, returning the bookmark of the next page and whether there may be one
+func visitSalePage(APIstub shim.ChaincodeStubInterface, attributes []string, bookmark string, visit func(sale SalePrice)) (string, bool, error) {
+	resultsIterator, responseMetadata, err := APIstub.GetStateByPartialCompositeKeyWithPagination(salePriceObjectType, attributes, aggregationPageSize, bookmark)
+	if err != nil {
+		return "", false, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", false, err
+		}
+		sale := SalePrice{}
+		if err := json.Unmarshal(queryResponse.Value, &sale); err != nil {
+			return "", false, err
+		}
+		visit(sale)
+	}
+	return responseMetadata.Bookmark, responseMetadata.FetchedRecordsCount == aggregationPageSize, nil
+}
+
+// Define the price aggregate structure, the sales of a group and their average price per square meter
+type priceAggregate struct {
+	Sales                      int `json:"sales"`
+	measuredPrice              int64
+	squareMeters               float64
+	AveragePricePerSquareMeter float64 `json:"averagepricepersquaremeter"`
+}
+
+func (aggregate *priceAggregate) add(sale SalePrice) {
+	aggregate.Sales++
+	if sale.SquareFeets > 0 {
+		aggregate.measuredPrice += sale.Price
+		aggregate.squareMeters += float64(sale.SquareFeets) * squareMetersPerSquareFoot
+		aggregate.AveragePricePerSquareMeter = float64(aggregate.measuredPrice) / aggregate.squareMeters
+	}
+}
+
+/*
+ * queryAveragePricePerSquareMeter returns the average sale price per square meter of a location, per quarter
+ * args: location
+ */
+func (s *SmartContract) queryAveragePricePerSquareMeter(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	location, err := resolveLocationAlias(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quarters := map[string]*priceAggregate{}
+	err = forEachSale(APIstub, []string{normalizeLocation(location)}, func(sale SalePrice) {
+		if quarters[sale.Quarter] == nil {
+			quarters[sale.Quarter] = &priceAggregate{}
+		}
+		quarters[sale.Quarter].add(sale)
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type quarterLine struct {
+		Quarter string `json:"quarter"`
+		*priceAggregate
+	}
+	lines := []quarterLine{}
+	for quarter, aggregate := range quarters {
+		lines = append(lines, quarterLine{Quarter: quarter, priceAggregate: aggregate})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Quarter < lines[j].Quarter })
+
+	linesAsBytes, _ := json.Marshal(lines)
+	return shim.Success(linesAsBytes)
+}
+
+// queryPriceTrend returns the successive sale prices of a house and their change from the previous sale
+func (s *SmartContract) queryPriceTrend(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type trendPoint struct {
+		Timestamp     string  `json:"timestamp"`
+		Price         int64   `json:"price"`
+		ChangePercent float64 `json:"changepercent"`
+	}
+	points := []trendPoint{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		transfer := Transfer{}
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			return shim.Error(err.Error())
+		}
+		if transfer.Reason == reasonSale && transfer.Price > 0 {
+			points = append(points, trendPoint{Timestamp: transfer.Timestamp, Price: transfer.Price})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+	for i := 1; i < len(points); i++ {
+		points[i].ChangePercent = float64(points[i].Price-points[i-1].Price) * 100 / float64(points[i-1].Price)
+	}
+
+	pointsAsBytes, _ := json.Marshal(points)
+	return shim.Success(pointsAsBytes)
+}
+
+/*
+ * queryTopAppreciatingLocations returns the locations whose average price per square meter rose the most between two quarters
+ * args: number of locations, first quarter, last quarter (formatted YYYY-Qn)
+ */
+func (s *SmartContract) queryTopAppreciatingLocations(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count <= 0 {
+		return shim.Error("Number of locations must be a positive number")
+	}
+	if args[1] >= args[2] {
+		return shim.Error("First quarter must be before the last quarter")
+	}
+
+	first, last := map[string]*priceAggregate{}, map[string]*priceAggregate{}
+	err = forEachSale(APIstub, []string{}, func(sale SalePrice) {
+		aggregates := first
+		if sale.Quarter == args[2] {
+			aggregates = last
+		} else if sale.Quarter != args[1] {
+			return
+		}
+		if aggregates[sale.Location] == nil {
+			aggregates[sale.Location] = &priceAggregate{}
+		}
+		aggregates[sale.Location].add(sale)
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type appreciation struct {
+		Location         string  `json:"location"`
+		FirstAverage     float64 `json:"firstaverage"`
+		LastAverage      float64 `json:"lastaverage"`
+		AppreciationRate float64 `json:"appreciationpercent"`
+	}
+	locations := []appreciation{}
+	for location, firstAggregate := range first {
+		lastAggregate := last[location]
+		if lastAggregate == nil || firstAggregate.AveragePricePerSquareMeter == 0 || lastAggregate.AveragePricePerSquareMeter == 0 {
+			continue
+		}
+		locations = append(locations, appreciation{
+			Location:         location,
+			FirstAverage:     firstAggregate.AveragePricePerSquareMeter,
+			LastAverage:      lastAggregate.AveragePricePerSquareMeter,
+			AppreciationRate: (lastAggregate.AveragePricePerSquareMeter - firstAggregate.AveragePricePerSquareMeter) * 100 / firstAggregate.AveragePricePerSquareMeter,
+		})
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].AppreciationRate != locations[j].AppreciationRate {
+			return locations[i].AppreciationRate > locations[j].AppreciationRate
+		}
+		return locations[i].Location < locations[j].Location
+	})
+	if len(locations) > count {
+		locations = locations[:count]
+	}
+
+	locationsAsBytes, _ := json.Marshal(locations)
+	return shim.Success(locationsAsBytes)
+}
+
+// Comparables are sold within this window, and differ by at most this many years of construction
+const (
+	comparablesWindowDays = 730
+	comparablesYearSpan   = 30
+)
+
+// Define the comparable structure, a recent sale ranked by its similarity to the valued house
+type Comparable struct {
+	SalePrice
+	Score float64 `json:"score"`
+}
+
+// similarityScore is 1 for a house of the same size and year sold today, down to 0. Size weighs for half the score
+func similarityScore(squareFeets int, year int, sale SalePrice, age time.Duration) float64 {
+	sizeScore := 0.0
+	if squareFeets > 0 && sale.SquareFeets > 0 {
+		sizeScore = 1 - float64(absInt(sale.SquareFeets-squareFeets))/float64(squareFeets)
+	}
+	yearScore := 0.0
+	if saleYear, err := strconv.Atoi(sale.Year); err == nil && year > 0 {
+		yearScore = 1 - float64(absInt(saleYear-year))/comparablesYearSpan
+	}
+	recencyScore := 1 - age.Hours()/(24*comparablesWindowDays)
+	return 0.5*clampScore(sizeScore) + 0.25*clampScore(yearScore) + 0.25*clampScore(recencyScore)
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func clampScore(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+/*
+ * findComparables returns the houses of the same location sold recently, ranked by similarity of size and year of construction
+ * args: house key, maximum number of results
+ */
+func (s *SmartContract) findComparables(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	maxResults, err := strconv.Atoi(args[1])
+	if err != nil || maxResults <= 0 {
+		return shim.Error("Maximum number of results must be a positive number")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	squareFeets, _ := strconv.Atoi(house.SquareFeets)
+	year, _ := strconv.Atoi(house.Year)
+	since := txTime.AddDate(0, 0, -comparablesWindowDays)
+
+	// Only the last sale of every house is a comparable
+	latest := map[string]SalePrice{}
+	err = forEachSale(APIstub, []string{normalizeLocation(house.Location)}, func(sale SalePrice) {
+		if sale.HouseKey != args[0] && sale.Timestamp > latest[sale.HouseKey].Timestamp {
+			latest[sale.HouseKey] = sale
+		}
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	comparables := []Comparable{}
+	for _, sale := range latest {
+		soldAt, err := time.Parse(timeLayout, sale.Timestamp)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if soldAt.Before(since) {
+			continue
+		}
+		comparables = append(comparables, Comparable{SalePrice: sale, Score: similarityScore(squareFeets, year, sale, txTime.Sub(soldAt))})
+	}
+	sort.Slice(comparables, func(i, j int) bool {
+		if comparables[i].Score != comparables[j].Score {
+			return comparables[i].Score > comparables[j].Score
+		}
+		return comparables[i].HouseKey < comparables[j].HouseKey
+	})
+	if len(comparables) > maxResults {
+		comparables = comparables[:maxResults]
+	}
+
+	comparablesAsBytes, _ := json.Marshal(comparables)
+	return shim.Success(comparablesAsBytes)
+}
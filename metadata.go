@@ -0,0 +1,436 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Contract metadata
+ * The functions of the contract are described here for SDKs and API gateways, which generate
+ * their clients and validate their calls from it. Every function routed by Invoke has an entry,
+ * with its parameters in order, the roles it requires and the events it can emit.
+ * Arguments are always passed as strings, the type tells how the string is parsed.
+ */
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const contractName = "fabhouse"
+
+// Define the parameter metadata structure. Type is string, json or list (comma separated)
+type ParameterMetadata struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+// Define the function metadata structure. Roles are alternatives, any of them grants access
+type FunctionMetadata struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Parameters  []ParameterMetadata `json:"parameters"`
+	Roles       []string            `json:"roles,omitempty"`
+	Events      []string            `json:"events,omitempty"`
+	Feature     string              `json:"feature,omitempty"`
+}
+
+// params describes parameters from their descriptions, in square brackets for optional parameters.
+// The name is the camel cased description up to its " as " or parenthesis
+func params(descriptions ...string) []ParameterMetadata {
+	parameters := []ParameterMetadata{}
+	for _, description := range descriptions {
+		parameter := ParameterMetadata{Type: "string"}
+		if strings.HasPrefix(description, "[") && strings.HasSuffix(description, "]") {
+			parameter.Optional = true
+			description = description[1 : len(description)-1]
+		}
+		parameter.Description = description
+
+		name := description
+		for _, separator := range []string{" as ", " ("} {
+			if i := strings.Index(name, separator); i > 0 {
+				name = name[:i]
+			}
+		}
+		for i, word := range strings.FieldsFunc(name, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if i == 0 {
+				parameter.Name = strings.ToLower(word)
+			} else {
+				parameter.Name += strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+
+		if strings.Contains(description, "JSON") {
+			parameter.Type = "json"
+		} else if strings.Contains(description, "comma separated list") {
+			parameter.Type = "list"
+		}
+		parameters = append(parameters, parameter)
+	}
+	return parameters
+}
+
+// Functions of the contract, in the order of Invoke. A new function must be added here as well
+var contractFunctions = []FunctionMetadata{
+	{Name: "queryHouse", Description: "Returns a house, or the selected fields of it", Parameters: params("house key", "[fields]")},
+	{Name: "initLedger", Description: "Creates the sample houses, for admins, skipping the keys already used", Roles: []string{roleAdmin}},
+	{Name: "createHouse", Description: "Creates a house, with an address as a JSON object in place of the location", Parameters: params("house key", "year", "square feets", "location", "owner", "[usage]", "[zone]", "[cadastral reference]"), Roles: []string{roleRegistrar}},
+	{Name: "queryAllHouses", Description: "Returns every house, or the selected fields of them", Parameters: params("[fields]")},
+	{Name: "changeHouseOwner", Description: "Transfers a house to a new owner, by the owner or its attorney, queued for co-signature or tax settlement when required", Parameters: params("house key", "new owner", "[reason]", "[price (required for a sale)]"), Events: []string{"preemptionNotified", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "renovateHouse", Description: "Changes the surface and usage of a house, subject to the zoning rule of its zone and the ownership caps of its location, for the owner and the registrars of its location", Parameters: params("house key", "new square feets", "new usage"), Roles: []string{roleRegistrar}},
+	{Name: "setZoningRule", Description: "Creates or replaces the rule of a zone", Parameters: params("zone", "maxSquareFeets (0 for no limit)", "allowed usages as a comma separated list (empty for any)", "[locations the zone lies in as a comma separated list]"), Roles: []string{rolePlanner}},
+	{Name: "deleteZoningRule", Description: "Deletes the zoning rule of a zone", Parameters: params("zone"), Roles: []string{rolePlanner}},
+	{Name: "queryZoningRule", Description: "Returns the zoning rule of a zone", Parameters: params("zone")},
+	{Name: "queryAllZoningRules", Description: "Returns every zoning rule"},
+	{Name: "setMSPRoles", Description: "Grants roles to every member of an MSP, replacing the previous grant", Parameters: params("MSP ID", "roles as a comma separated list (empty to revoke all)"), Roles: []string{roleAdmin}},
+	{Name: "queryMSPRoles", Description: "Returns the roles granted to an MSP", Parameters: params("MSP ID")},
+	{Name: "openDispute", Description: "Flags a house as in dispute", Parameters: params("house key", "claimant", "reason"), Roles: []string{roleCourt}, Events: []string{"disputeOpened"}},
+	{Name: "resolveDispute", Description: "Closes the open dispute of a house", Parameters: params("house key", "outcome (\"dismissed\" or \"transfer\")", "[new owner (required for \"transfer\")]"), Roles: []string{roleCourt}, Events: []string{"disputeResolved"}},
+	{Name: "queryDisputedHouses", Description: "Returns the houses currently in dispute, along with their open dispute"},
+	{Name: "registerBeneficiary", Description: "Adds a beneficiary to the house, only the owner can do it", Parameters: params("house key", "beneficiary"), Events: []string{"attorneyInvocation"}},
+	{Name: "removeBeneficiary", Description: "Removes a beneficiary from the house, only the owner can do it", Parameters: params("house key", "beneficiary"), Events: []string{"attorneyInvocation"}},
+	{Name: "executeSuccession", Description: "Transfers the house of a deceased owner to its registered beneficiaries", Parameters: params("house key", "hash of the death certificate"), Roles: []string{roleRegistrar, roleNotary}, Events: []string{"successionExecuted"}},
+	{Name: "queryTransferHistory", Description: "Returns every recorded transfer of a house", Parameters: params("house key")},
+	{Name: "queryTransferReport", Description: "Counts the transfers per reason and per period, for the registrar", Parameters: params("first period", "last period (both inclusive, formatted YYYY-MM, empty for unbounded)"), Roles: []string{roleRegistrar}},
+	{Name: "exportAllHouses", Description: "Returns one page of houses as newline-delimited JSON", Parameters: params("bookmark", "page size", "[response encoding]")},
+	{Name: "computeStateDigest", Description: "Hashes every key starting with the prefix, in the public state or in a private data collection", Parameters: params("key prefix (empty for every simple key)", "[private data collection]")},
+	{Name: "setCreationQuota", Description: "Sets how many houses an identity may create per day", Parameters: params("quota (0 for no limit)"), Roles: []string{roleAdmin}},
+	{Name: "queryCreationQuota", Description: "Returns the house creation quota"},
+	{Name: "setLedgerMetrics", Description: "Enables or disables the ledger counters", Parameters: params("\"true\" or \"false\""), Roles: []string{roleAdmin}},
+	{Name: "getMetrics", Description: "Returns the ledger counters, and the process counters of the peer answering the query"},
+	{Name: "setLogLevel", Description: "Overrides the log level of every peer, e.g. \"debug\" to turn verbose logging on", Parameters: params("level (empty to go back to the level of the environment)"), Roles: []string{roleAdmin}},
+	{Name: "setHouseCodec", Description: "Selects the encoding of the houses written from now on", Parameters: params("codec name (\"json\" or \"protobuf\")"), Roles: []string{roleAdmin}},
+	{Name: "migrateHouseEncoding", Description: "Rewrites a chunk of houses with the configured codec", Parameters: params("first key to migrate (empty to start from the first house)", "maximum number of houses to scan"), Roles: []string{roleAdmin}},
+	{Name: "setSisterRegistry", Description: "Declares the registry chaincode of another channel", Parameters: params("channel", "chaincode name (empty to remove the declaration)"), Roles: []string{roleAdmin}},
+	{Name: "queryAllSisterRegistries", Description: "Returns every sister registry"},
+	{Name: "queryHouseOnChannel", Description: "Reads a house from the sister registry of another channel", Parameters: params("channel", "house key")},
+	{Name: "setHouseDescription", Description: "Replaces the free-text description of a house, only the owner can do it", Parameters: params("house key", "description"), Events: []string{"attorneyInvocation"}},
+	{Name: "searchHouses", Description: "Returns the houses whose location or description contain all the terms", Parameters: params("search terms")},
+	{Name: "queryHousesByLocationPrefix", Description: "Returns the houses whose location starts with the prefix, ignoring case", Parameters: params("location prefix")},
+	{Name: "queryHousesByLocation", Description: "Returns the houses located exactly at the location, ignoring case", Parameters: params("location")},
+	{Name: "addHousePhoto", Description: "Attaches a photo to the house, only the owner can do it", Parameters: params("house key", "CID", "width", "height", "caption", "sha256 of the image (hex)"), Events: []string{"attorneyInvocation"}},
+	{Name: "removeHousePhoto", Description: "Detaches a photo from the house, only the owner can do it", Parameters: params("house key", "CID"), Events: []string{"attorneyInvocation"}},
+	{Name: "queryHousePhotos", Description: "Lists the photos of the house", Parameters: params("house key")},
+	{Name: "setEnergyCertificate", Description: "Records the energy performance certificate of the house, only the owner can do it", Parameters: params("house key", "rating (A to G)", "certificate ID", "expiry date (YYYY-MM-DD)"), Events: []string{"attorneyInvocation"}},
+	{Name: "listHouseForSale", Description: "Puts the house on sale at the asking price, only the owner can do it", Parameters: params("house key", "asking price"), Events: []string{"attorneyInvocation"}},
+	{Name: "withdrawHouseListing", Description: "Takes the house off the market, only the owner can do it", Parameters: params("house key"), Events: []string{"attorneyInvocation"}},
+	{Name: "queryHousesByEnergyRating", Description: "Returns the houses of the given energy rating", Parameters: params("rating (A to G)")},
+	{Name: "requestKYC", Description: "Asks for the verification of the invoker's identity"},
+	{Name: "setKYCStatus", Description: "Marks an owner identity as verified or expired, for compliance officers", Parameters: params("owner", "status (verified or expired)"), Roles: []string{roleCompliance}},
+	{Name: "queryKYCStatus", Description: "Returns the KYC record of an owner identity", Parameters: params("owner ID")},
+	{Name: "queryPendingKYC", Description: "Lists the owner identities awaiting verification, for compliance officers", Roles: []string{roleCompliance}},
+	{Name: "setRegulatorMSP", Description: "Designates the MSP of the regulator", Parameters: params("MSP ID"), Roles: []string{roleAdmin}},
+	{Name: "addToBlocklist", Description: "Blocks an identity from any transfer, for the regulator", Parameters: params("identity", "reason")},
+	{Name: "removeFromBlocklist", Description: "Lifts the block on an identity, for the regulator", Parameters: params("identity")},
+	{Name: "queryBlocklist", Description: "Lists the blocked identities, for the regulator"},
+	{Name: "scheduleHouseTransfer", Description: "Records a transfer of the house effective at a future date, only the owner can do it", Parameters: params("house key", "new owner", "effective date (RFC 3339)", "[reason]", "[price (required for a sale)]"), Events: []string{"transferScheduled", "attorneyInvocation"}},
+	{Name: "cancelScheduledTransfer", Description: "Cancels the scheduled transfer of the house, only the owner can do it", Parameters: params("house key"), Events: []string{"attorneyInvocation"}},
+	{Name: "queryScheduledTransfers", Description: "Lists the transfers waiting for their effective date"},
+	{Name: "finalizeDueTransfers", Description: "Completes the scheduled transfers whose effective date has passed"},
+	{Name: "grantOption", Description: "Grants an option to buy the house, only the owner can do it", Parameters: params("house key", "optionee", "strike price", "expiry (RFC 3339)"), Events: []string{"optionGranted", "attorneyInvocation"}},
+	{Name: "exerciseOption", Description: "Buys the house at the strike price, only the optionee can do it before expiry, the sale being queued when it must wait for pre-emption rights, co-signatures or its tax", Parameters: params("option ID"), Events: []string{"optionExercised", "preemptionNotified", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "queryHouseOptions", Description: "Lists the options granted on the house, with their current status", Parameters: params("house key")},
+	{Name: "createLease", Description: "Leases the house to a tenant, for the owner or a manager with the leases permission", Parameters: params("house key", "tenant", "monthly rent", "first period", "last period (both YYYY-MM)"), Events: []string{"leaseCreated"}},
+	{Name: "terminateLease", Description: "Ends the lease early, only the landlord can do it", Parameters: params("lease ID"), Events: []string{"leaseTerminated"}},
+	{Name: "queryHouseLeases", Description: "Lists the leases of the house", Parameters: params("house key")},
+	{Name: "recordRentPayment", Description: "Records a payment of rent for a period of the lease, only the landlord can do it", Parameters: params("lease ID", "period (YYYY-MM)", "amount")},
+	{Name: "getArrearsReport", Description: "Lists the leases of the invoker, as landlord, with periods not fully paid at the date", Parameters: params("date (YYYY-MM-DD)")},
+	{Name: "depositSecurity", Description: "Records the payment of the security deposit, only the tenant can do it", Parameters: params("lease ID", "amount")},
+	{Name: "claimDeduction", Description: "Claims a part of the deposit, only the landlord can do it", Parameters: params("lease ID", "amount", "reason")},
+	{Name: "approveDeduction", Description: "Approves a claimed deduction, for the tenant or an arbitrator", Parameters: params("lease ID", "deduction ID"), Roles: []string{roleArbitrator}},
+	{Name: "refundDeposit", Description: "Refunds the balance of the deposit once the lease is terminated, for the landlord or the tenant", Parameters: params("lease ID"), Events: []string{"depositRefunded"}},
+	{Name: "querySecurityDeposit", Description: "Returns the deposit of the lease", Parameters: params("lease ID")},
+	{Name: "delegateManagement", Description: "Grants management permissions on the house to a manager, only the owner can do it", Parameters: params("house key", "manager", "permissions as a comma separated list", "expiry (RFC 3339)"), Events: []string{"attorneyInvocation"}},
+	{Name: "revokeManagement", Description: "Withdraws the delegation of the house to a manager, only the owner can do it", Parameters: params("house key", "manager"), Events: []string{"attorneyInvocation"}},
+	{Name: "queryManagedHouses", Description: "Lists the houses a manager currently controls, with the delegations", Parameters: params("manager")},
+	{Name: "openMaintenanceRequest", Description: "Opens a maintenance request, for the owner, a manager or a tenant of the house", Parameters: params("house key", "description"), Events: []string{"maintenanceRequested"}},
+	{Name: "assignContractor", Description: "Assigns a contractor to a maintenance request, for the owner or a manager", Parameters: params("house key", "request ID", "contractor")},
+	{Name: "completeMaintenance", Description: "Logs the completion of the work, for the owner or a manager", Parameters: params("house key", "request ID", "cost", "document hashes as a comma separated list"), Events: []string{"maintenanceCompleted"}},
+	{Name: "queryMaintenanceRequests", Description: "Lists the maintenance requests of a house", Parameters: params("house key (empty for every house)", "status (empty for any status)")},
+	{Name: "queryMaintenanceCosts", Description: "Totals the cost of the completed maintenance of a house", Parameters: params("house key")},
+	{Name: "recordMeterReading", Description: "Appends a reading of a meter of the house, for utilities", Parameters: params("house key", "meter type (electricity, water or gas)", "value"), Roles: []string{roleUtility}},
+	{Name: "queryMeterReadings", Description: "Lists the readings of a meter of the house, oldest first", Parameters: params("house key", "meter type")},
+	{Name: "queryConsumption", Description: "Computes the consumption of a meter of the house between two dates", Parameters: params("house key", "meter type", "from", "to (RFC 3339)")},
+	{Name: "createHOA", Description: "Creates a homeowners association administered by the invoker", Parameters: params("association ID", "name", "fee per period")},
+	{Name: "addHouseToHOA", Description: "Makes the house a member of the association, for its administrator", Parameters: params("association ID", "house key")},
+	{Name: "assessHOAFees", Description: "Assesses the fee of the period on every member house, for the administrator", Parameters: params("association ID", "period (YYYY-MM)")},
+	{Name: "recordHOAPayment", Description: "Records a fee payment of a member house, for the administrator", Parameters: params("association ID", "house key", "period (YYYY-MM)", "amount")},
+	{Name: "queryHOAArrears", Description: "Lists the fees left unpaid by each member house", Parameters: params("association ID")},
+	{Name: "openHOAResolution", Description: "Puts a resolution to the vote, for the administrator", Parameters: params("association ID", "title")},
+	{Name: "voteOnHOAResolution", Description: "Records the vote of a co-owner of a member house, weighted by its share", Parameters: params("association ID", "resolution ID", "house key", "vote (yes or no)")},
+	{Name: "closeHOAResolution", Description: "Tallies the votes and closes the resolution, for the administrator", Parameters: params("association ID", "resolution ID"), Events: []string{"hoaResolutionClosed"}},
+	{Name: "setCosignPolicy", Description: "Sets the price above which sales need two registrar approvals, for admins", Parameters: params("threshold (0 to disable)", "validity of the requests in hours"), Roles: []string{roleAdmin}},
+	{Name: "approveCosignedTransfer", Description: "Approves a queued sale, for registrars", Parameters: params("request ID"), Roles: []string{roleRegistrar}, Events: []string{"transferTaxDue"}},
+	{Name: "queryPendingCosignatures", Description: "Lists the sales waiting for registrar approvals that have not expired"},
+	{Name: "setRule", Description: "Adds or replaces a rule of the rules table, for admins", Parameters: params("rule ID", "type", "parameters as a JSON object of strings", "functions as a comma separated list (empty for transfers)"), Roles: []string{roleAdmin}},
+	{Name: "deleteRule", Description: "Removes a rule from the rules table, for admins", Parameters: params("rule ID"), Roles: []string{roleAdmin}},
+	{Name: "queryRules", Description: "Returns the rules table"},
+	{Name: "verifyIntegrity", Description: "Checks one page of the state, for admins", Parameters: params("page size", "bookmark (empty to start, then the bookmark returned by the previous page)"), Roles: []string{roleAdmin}},
+	{Name: "rebuildIndexes", Description: "Rebuilds one chunk of an index, for admins", Parameters: params("index name", "maximum number of records to scan", "bookmark (empty to start)"), Roles: []string{roleAdmin}},
+	{Name: "setOwnerDetails", Description: "Stores the personal details of the invoker, given in the ownerDetails and salt transient fields"},
+	{Name: "queryOwnerDetails", Description: "Returns the personal details of an owner, or the selected fields of them, for the owner and the MSPs it consented to", Parameters: params("owner", "[fields]")},
+	{Name: "redactOwnerData", Description: "Erases the personal details of an owner, for the owner and compliance officers", Parameters: params("owner")},
+	{Name: "grantConsent", Description: "Lets the members of an MSP read the personal details of the invoker", Parameters: params("MSP ID")},
+	{Name: "revokeConsent", Description: "Withdraws the consent given to an MSP by the invoker", Parameters: params("MSP ID")},
+	{Name: "queryMyConsents", Description: "Lists the consents given by the invoker, with the access records of its details"},
+	{Name: "anchorHash", Description: "Notarizes the salted hash of a document", Parameters: params("entity key", "purpose", "salted hash (hex sha256)")},
+	{Name: "verifyAgainstAnchor", Description: "Tells whether a salted hash matches the anchored document", Parameters: params("entity key", "purpose", "candidate hash (hex sha256)")},
+	{Name: "splitHouse", Description: "Retires a house and creates its units, for the registrar", Parameters: params("house key", "units as a JSON array of {key, squarefeets, location} (location defaults to the one of the house)"), Roles: []string{roleRegistrar}, Events: []string{"houseSplit"}},
+	{Name: "mergeHouses", Description: "Retires houses and creates the house merging them, for the registrar", Parameters: params("house keys as a comma separated list", "key of the new house"), Roles: []string{roleRegistrar}, Events: []string{"housesMerged"}},
+	{Name: "queryHouseLineage", Description: "Returns the lineage record of a house, including the last state of a retired house", Parameters: params("house key")},
+	{Name: "getLineage", Description: "Returns the provenance graph of a house: the houses it was split from or merged into, followed up to the depth, and the transfer chain of every house of the graph", Parameters: params("house key", "depth (number of splits or merges followed from the house)")},
+	{Name: "transferPortfolio", Description: "Transfers one batch of the houses of an owner to another owner, by the owner or the court", Parameters: params("previous owner", "new owner", "maximum number of houses of the batch", "job ID (empty to start)"), Roles: []string{roleCourt}, Events: []string{"portfolioTransferred"}},
+	{Name: "queryPortfolioJob", Description: "Returns the progress of a portfolio transfer", Parameters: params("job ID")},
+	{Name: "queryHousesByOwner", Description: "Returns the houses the identity holds, alone or with co-owners", Parameters: params("owner ID")},
+	{Name: "sampleHouses", Description: "Draws a reproducible sample of house keys for spot audits", Parameters: params("sample size", "seed (empty to use the transaction ID)")},
+	{Name: "queryHousesPage", Description: "Returns one numbered page of the houses matching a filter, with the total count, in key order or sorted", Parameters: params("dimension (location, status, owner or empty for every house)", "value", "page number (from 1)", "page size", "[sort field (year, size, price or lastUpdated)]", "[sort order (asc or desc)]")},
+	{Name: "setRetentionPeriod", Description: "Sets the number of days closed records are kept before archival, for admins", Parameters: params("retention in days"), Roles: []string{roleAdmin}},
+	{Name: "archiveExpired", Description: "Archives one page of the closed records older than the retention period, for admins", Parameters: params("page size", "bookmark (empty to start, then the bookmark returned by the previous page)"), Roles: []string{roleAdmin}},
+	{Name: "queryArchive", Description: "Returns the archive record of a closed record", Parameters: params("kind (dispute or lease)", "ID of the original record")},
+	{Name: "setLocationAuthority", Description: "Sets the MSP keeping the houses of a location, for admins", Parameters: params("location", "MSP ID (empty to remove the authority)"), Roles: []string{roleAdmin}},
+	{Name: "queryLocationAuthorities", Description: "Returns the table of the location authorities"},
+	{Name: "setLocationAlias", Description: "Maps a spelling of a location to its canonical name, for admins", Parameters: params("alias", "canonical name (empty to remove the alias)"), Roles: []string{roleAdmin}},
+	{Name: "queryLocationAliases", Description: "Returns the table of the location aliases"},
+	{Name: "setHouseTags", Description: "Replaces the amenity tags of a house, only the owner can do it", Parameters: params("house key", "tags as a comma separated list (empty to clear)"), Events: []string{"attorneyInvocation"}},
+	{Name: "queryHousesByTags", Description: "Returns the houses carrying all, or any, of the tags", Parameters: params("mode (all or any)", "tags")},
+	{Name: "queryAveragePricePerSquareMeter", Description: "Returns the average sale price per square meter of a location, per quarter", Parameters: params("location")},
+	{Name: "queryPriceTrend", Description: "Returns the successive sale prices of a house and their change from the previous sale", Parameters: params("house key")},
+	{Name: "queryTopAppreciatingLocations", Description: "Returns the locations whose average price per square meter rose the most between two quarters", Parameters: params("number of locations", "first quarter", "last quarter (formatted YYYY-Qn)")},
+	{Name: "findComparables", Description: "Returns the houses of the same location sold recently, ranked by similarity of size and year of construction", Parameters: params("house key", "maximum number of results")},
+	{Name: "registerMortgage", Description: "Registers a mortgage on the house in favor of a lienholder, only the owner can do it", Parameters: params("house key", "lienholder", "principal", "annual rate in basis points", "term in months", "first period (YYYY-MM)"), Events: []string{"mortgageRegistered", "attorneyInvocation"}},
+	{Name: "recordMortgagePayment", Description: "Records a payment received on an active mortgage, only the lienholder can do it", Parameters: params("mortgage ID", "amount")},
+	{Name: "queryMortgageBalance", Description: "Returns the outstanding balance of a mortgage at a date", Parameters: params("mortgage ID", "date (YYYY-MM-DD)")},
+	{Name: "payoffAndReleaseMortgage", Description: "Records the final payment of the outstanding balance and releases the lien, only the lienholder can do it", Parameters: params("mortgage ID", "amount of the final payment (at least the outstanding balance)"), Events: []string{"mortgageReleased"}},
+	{Name: "queryHouseMortgages", Description: "Returns the mortgages registered on a house", Parameters: params("house key")},
+	{Name: "setForeclosurePolicy", Description: "Sets when a mortgage can be foreclosed, for admins", Parameters: params("number of missed payments", "notice period in days"), Roles: []string{roleAdmin}},
+	{Name: "initiateForeclosure", Description: "Serves the notice of foreclosure of a mortgage in default, only the lienholder can do it", Parameters: params("mortgage ID"), Events: []string{"foreclosureNoticed"}},
+	{Name: "cancelForeclosure", Description: "Withdraws the notice of foreclosure, once the borrower cured the default, only the lienholder can do it", Parameters: params("mortgage ID")},
+	{Name: "executeForeclosure", Description: "Forecloses the mortgage once the notice period is over, only the lienholder can do it", Parameters: params("mortgage ID", "outcome (transfer or auction)", "[buyer (for an auction)]", "[price (for an auction)]"), Events: []string{"houseUnlocked", "foreclosureExecuted"}},
+	{Name: "queryForeclosure", Description: "Returns the foreclosure of a mortgage", Parameters: params("mortgage ID")},
+	{Name: "ownerOf", Description: "Returns the deed token and its holder", Parameters: params("house key")},
+	{Name: "queryDeedsByHolder", Description: "Returns the deed tokens held by an identity, the registered owner of their house", Parameters: params("holder ID")},
+	{Name: "approveDeed", Description: "Approves an identity to transfer a deed token, only its holder can do it", Parameters: params("token ID", "approved identity (empty to revoke)"), Events: []string{"attorneyInvocation"}},
+	{Name: "setDeedOperator", Description: "Makes an identity able to transfer every deed token of the invoker, or revokes it", Parameters: params("operator", "approved (true or false)")},
+	{Name: "transferDeedFrom", Description: "Transfers a deed token, and so its house, for its holder, the approved identity or an operator of the holder", Parameters: params("current holder", "new holder", "token ID", "[reason]", "[price (required for a sale)]"), Events: []string{"deedTransferred", "preemptionNotified", "transferTaxDue", "cosignatureRequested", "attorneyInvocation"}},
+	{Name: "mintMissingDeeds", Description: "Mints the deed tokens of a chunk of houses registered before deed tokens, for admins", Parameters: params("first key to scan (empty to start from the first house)", "maximum number of houses to scan"), Roles: []string{roleAdmin}},
+	{Name: "transferShareTokens", Description: "Moves share tokens of a house from the invoker to another identity by a share transfer, returning the tax obligation it waits for when it owes tax", Parameters: params("house key", "recipient", "number of tokens"), Events: []string{"transferTaxDue"}},
+	{Name: "approveShareTokens", Description: "Allows a spender to move up to an amount of the share tokens of a house held by the invoker", Parameters: params("house key", "spender", "number of tokens (0 to revoke)")},
+	{Name: "transferShareTokensFrom", Description: "Moves share tokens of a holder within the allowance of the invoker, returning the tax obligation it waits for when it owes tax", Parameters: params("house key", "holder", "recipient", "number of tokens"), Events: []string{"transferTaxDue"}},
+	{Name: "queryShareBalance", Description: "Returns the share tokens of a house held by an identity, and what a spender may still move of them", Parameters: params("house key", "holder", "spender (empty to skip the allowance)")},
+	{Name: "queryCapTable", Description: "Returns the holders of the share tokens of a house and their part of the supply", Parameters: params("house key")},
+	{Name: "distributeDividend", Description: "Records a distribution of income of the house to the holders of its share tokens, for the owner or a manager with the leases permission", Parameters: params("house key", "period (YYYY-MM)", "amount"), Events: []string{"dividendDistributed"}},
+	{Name: "queryDividends", Description: "Returns the dividends paid on a house, to every holder or to one of them", Parameters: params("house key", "holder (empty for every holder)")},
+	{Name: "distributeRentIncome", Description: "Credits the rent income of a period to the accounts of the holders of the share tokens of the house, in proportion of their tokens, for the owner or a manager with the leases permission", Parameters: params("house key", "period (YYYY-MM)", "amount"), Events: []string{"rentIncomeDistributed"}},
+	{Name: "queryIncomeStatement", Description: "Returns the income paid to a holder on every house for a period, with its total", Parameters: params("holder", "period (YYYY-MM)")},
+	{Name: "getBalance", Description: "Returns the account of an identity, for the holder, admins and the custodian", Parameters: params("identity")},
+	{Name: "depositBalance", Description: "Credits the account of an identity with money received off the ledger, for the custodian", Parameters: params("identity", "amount", "reference of the payment"), Roles: []string{roleCustodian}},
+	{Name: "withdrawBalance", Description: "Debits the account of the invoker with an amount the custodian pays out of the ledger", Parameters: params("amount", "reference of the payout (bank account)"), Events: []string{"balanceWithdrawn"}},
+	{Name: "transferBalance", Description: "Moves an amount from the account of the invoker to the account of another identity", Parameters: params("recipient", "amount", "memo")},
+	{Name: "buyHouse", Description: "Buys a listed house at its asking price, settled on the accounts of the buyer and the holders, once the pre-emption window closed when rights apply", Parameters: params("house key"), Events: []string{"preemptionNotified"}},
+	{Name: "exportStatement", Description: "Returns the entries of the account of an identity over a range of months, in the order they were posted, with the opening and closing balances, for the holder, admins and the custodian", Parameters: params("identity", "first period", "last period (YYYY-MM, inclusive)")},
+	{Name: "setFeeSchedule", Description: "Replaces the fee schedule, for admins", Parameters: params("fees as a JSON object of operation types (transfer reasons) to {\"kind\": \"flat\" or \"percentage\", \"amount\": amount or basis points}"), Roles: []string{roleAdmin}, Events: []string{"feeScheduleUpdated"}},
+	{Name: "queryFeeSchedule", Description: "Returns the fee schedule in force"},
+	{Name: "queryFeeRevenue", Description: "Returns the fees collected by the treasury per month and operation type, for admins", Parameters: params("first period", "last period (YYYY-MM, inclusive)"), Roles: []string{roleAdmin}},
+	{Name: "setTaxRateTable", Description: "Replaces the tax bands of a location (* for the default table), for admins", Parameters: params("location", "bands as a JSON array of {\"ceiling\": price (0 for the top band), \"rate\": basis points}"), Roles: []string{roleAdmin}},
+	{Name: "queryTaxRateTables", Description: "Returns the tax rate tables of every location"},
+	{Name: "recordTaxSettlement", Description: "Records the payment of the tax of a sale or share transfer, for the tax authority, and completes it", Parameters: params("tax obligation ID", "reference of the payment"), Roles: []string{roleTaxAuthority}, Events: []string{"transferTaxSettled"}},
+	{Name: "queryTaxDue", Description: "Returns the tax obligations not settled yet, of one house or of all (empty key), with their total", Parameters: params("house key")},
+	{Name: "queryTaxObligation", Description: "Returns a tax obligation", Parameters: params("tax obligation ID")},
+	{Name: "grantSubsidy", Description: "Records a subsidy granted to a house, for grantors", Parameters: params("house key", "program", "amount", "conditions", "clawback period in months"), Roles: []string{roleGrantor}, Events: []string{"subsidyGranted"}},
+	{Name: "recordSubsidyClawback", Description: "Records the repayment of a subsidy, for grantors, releasing the sale of the house", Parameters: params("subsidy ID", "reference of the repayment"), Roles: []string{roleGrantor}},
+	{Name: "queryHouseSubsidies", Description: "Returns the subsidies granted to a house", Parameters: params("house key")},
+	{Name: "querySubsidyReport", Description: "Returns the subsidies of a program with the amounts granted and clawed back", Parameters: params("program")},
+	{Name: "setJudiciaryMSP", Description: "Designates the MSP of the judiciary", Parameters: params("MSP ID"), Roles: []string{roleAdmin}},
+	{Name: "executeCourtOrder", Description: "Applies every action of a court order, or none, for the judiciary", Parameters: params("order ID", "sha256 of the order document", "actions as a JSON array"), Events: []string{"houseUnlocked", "courtOrderExecuted", "disputeResolved"}},
+	{Name: "queryCourtOrder", Description: "Returns an executed court order", Parameters: params("order ID")},
+	{Name: "queryHouseLiens", Description: "Returns the judgment liens registered on a house", Parameters: params("house key")},
+	{Name: "grantPowerOfAttorney", Description: "Registers a power of attorney of the invoker, replacing the previous one of the attorney", Parameters: params("attorney", "scopes as a comma separated list (sales, transfers)", "house keys as a comma separated list (empty for all)", "expiry (RFC 3339)"), Events: []string{"powerOfAttorneyGranted", "attorneyInvocation"}},
+	{Name: "revokePowerOfAttorney", Description: "Revokes the power of attorney of the invoker held by an attorney", Parameters: params("attorney"), Events: []string{"powerOfAttorneyRevoked"}},
+	{Name: "queryPowersOfAttorney", Description: "Returns the powers of attorney granted by an owner, revoked ones included", Parameters: params("owner")},
+	{Name: "queryAttorneyInvocations", Description: "Returns the log of the functions invoked under the powers of attorney of an owner", Parameters: params("owner")},
+	{Name: "rebindOwnerIdentity", Description: "Requests to bind an owner name to a new certificate, for registrars", Parameters: params("owner ID", "sha256 of the DER encoded new certificate"), Roles: []string{roleRegistrar}, Events: []string{"identityRebindingRequested"}},
+	{Name: "contestIdentityRebinding", Description: "Cancels a pending rebinding of the invoker's identity", Parameters: params("request ID"), Events: []string{"identityRebindingContested"}},
+	{Name: "confirmIdentityRebinding", Description: "Completes a rebinding once its challenge period is over, for the holder of the new certificate", Parameters: params("request ID"), Events: []string{"identityRebound"}},
+	{Name: "queryRebindRequests", Description: "Returns the rebinding requests of an owner", Parameters: params("owner ID")},
+	{Name: "createMultisigAccount", Description: "Creates a multi-signature account, for one of its members", Parameters: params("account name (the owner name is #multisig: followed by it)", "members as a comma separated list", "threshold")},
+	{Name: "queryMultisigAccount", Description: "Returns a multi-signature account", Parameters: params("account ID")},
+	{Name: "proposeMultisigTransfer", Description: "Proposes the transfer of a house held by a multi-signature account, for its members", Parameters: params("house key", "new owner", "reason", "price (required for a sale)"), Events: []string{"multisigTransferProposed", "preemptionNotified", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "approveMultisigTransfer", Description: "Approves a proposed transfer, for the members of the account", Parameters: params("proposal ID"), Events: []string{"preemptionNotified", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "queryMultisigProposal", Description: "Returns a multi-signature proposal", Parameters: params("proposal ID")},
+	{Name: "registerLegalEntity", Description: "Registers a legal entity able to hold houses, for registrars", Parameters: params("entity name (the owner name is #entity: followed by it)", "kind (trust, sci or company)", "legal name", "signatories as a comma separated list"), Roles: []string{roleRegistrar}},
+	{Name: "setEntitySignatories", Description: "Replaces the authorized signatories of a legal entity, for registrars", Parameters: params("entity ID", "signatories as a comma separated list"), Roles: []string{roleRegistrar}, Events: []string{"entitySignatoriesChanged"}},
+	{Name: "setEntityMember", Description: "Records a beneficiary or shareholder of a legal entity with its share, for registrars", Parameters: params("entity ID", "holder", "role (beneficiary or shareholder)", "share in basis points"), Roles: []string{roleRegistrar}},
+	{Name: "queryLegalEntity", Description: "Returns a legal entity with its beneficiaries and shareholders", Parameters: params("entity ID")},
+	{Name: "setOwnershipCap", Description: "Caps the holdings of a single identity in a location (* for the default cap), for admins", Parameters: params("location", "maximum number of houses (0 for no limit)", "maximum total square feets (0 for no limit)"), Roles: []string{roleAdmin}},
+	{Name: "setCapOverride", Description: "Exempts an identity from the ownership cap of a location, or lifts the exemption, for the regulator", Parameters: params("identity", "location", "\"true\" to exempt or \"false\" to lift the exemption")},
+	{Name: "queryOwnershipCapReport", Description: "Returns the identities holding at least a percentage of the cap of a location, by decreasing holdings", Parameters: params("location", "percentage of the cap (e.g. 80)")},
+	{Name: "approveResale", Description: "Allows the next sale of a house during its cooldown, for the regulator", Parameters: params("house key", "reason of the approval"), Events: []string{"resaleApproved"}},
+	{Name: "queryHousesInCooldown", Description: "Returns the houses that cannot be sold yet under the holding period of the rules table, with the end of their cooldown"},
+	{Name: "registerPreemptionRight", Description: "Registers a right of first refusal, for registrars", Parameters: params("scope (location or tenant)", "location or house key", "holder", "window in days"), Roles: []string{roleRegistrar}},
+	{Name: "exercisePreemption", Description: "Buys the house of a pending notice at the price of the offer, for a holder of the right within the window", Parameters: params("notice ID"), Events: []string{"preemptionExercised", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "finalizeLapsedPreemptions", Description: "Completes the sales whose pre-emption window lapsed without the right being exercised", Events: []string{"transferTaxDue", "cosignatureRequested"}},
+	{Name: "queryPreemptionRights", Description: "Returns the pre-emption rights applying to a house", Parameters: params("house key")},
+	{Name: "queryPreemptionNotice", Description: "Returns a pre-emption notice", Parameters: params("notice ID")},
+	{Name: "registerConstructionProject", Description: "Registers a house under construction, owned by its developer", Parameters: params("house key", "year", "square feets", "location", "developer", "[usage]", "[zone]", "[cadastral reference]")},
+	{Name: "recordConstructionMilestone", Description: "Records the next milestone of a construction project, for inspectors", Parameters: params("house key", "milestone (permit, foundation or completion)", "hash of the inspection report"), Roles: []string{roleInspector}, Events: []string{"constructionMilestoneRecorded", "preemptionNotified", "transferTaxDue", "cosignatureRequested"}},
+	{Name: "sellOffPlan", Description: "Records the sale of a house under construction, only the owner can do it", Parameters: params("house key", "buyer", "price"), Events: []string{"offPlanSaleAgreed", "attorneyInvocation"}},
+	{Name: "queryConstructionProject", Description: "Returns the construction project of a house and its off-plan sale", Parameters: params("house key")},
+	{Name: "registerBuilder", Description: "Adds or updates a builder of the registry, for registrars", Parameters: params("builder ID", "name", "license number", "representative"), Roles: []string{roleRegistrar}},
+	{Name: "linkHouseBuilder", Description: "Records the builder of a house and starts its statutory warranties, for registrars", Parameters: params("house key", "builder ID", "completion date (YYYY-MM-DD, or empty)"), Roles: []string{roleRegistrar}},
+	{Name: "fileWarrantyClaim", Description: "Files a claim against the builder of a house under one of its running warranties, only the owner can do it", Parameters: params("house key", "warranty (completion, equipment or structural)", "description of the defect", "claimed amount", "evidence hashes"), Events: []string{"claimFiled", "attorneyInvocation"}},
+	{Name: "migrateWarrantyClaims", Description: "Moves a chunk of the warranty claims filed before the claim ledger to it, for admins", Parameters: params("maximum number of claims to migrate"), Roles: []string{roleAdmin}},
+	{Name: "queryBuilder", Description: "Returns a builder with the houses it constructed and the warranty claims filed against it", Parameters: params("builder ID")},
+	{Name: "queryHouseWarranties", Description: "Returns the builder and the statutory warranties of a house", Parameters: params("house key")},
+	{Name: "issueInsurancePolicy", Description: "Records an insurance policy on a house, for insurers", Parameters: params("policy ID", "house key", "cover amount", "first day", "last day (YYYY-MM-DD)"), Roles: []string{roleInsurer}},
+	{Name: "fileInsuranceClaim", Description: "Files a claim against the insurer of a house under a running policy, only the owner can do it", Parameters: params("policy ID", "description of the loss", "claimed amount", "evidence hashes"), Events: []string{"claimFiled", "attorneyInvocation"}},
+	{Name: "addClaimEvidence", Description: "Adds evidence hashes to a claim not adjudicated yet, only its claimant can do it", Parameters: params("claim ID", "evidence hashes")},
+	{Name: "respondToClaim", Description: "Records the answer of the liable party to a claim not adjudicated yet", Parameters: params("claim ID", "status (acknowledged or contested)", "note", "evidence hashes"), Events: []string{"claimResponded"}},
+	{Name: "adjudicateClaim", Description: "Decides a claim: by its insurer for an insurance claim, by an arbitrator for a warranty claim", Parameters: params("claim ID", "outcome (upheld, partial or dismissed)", "amount awarded by a partial outcome"), Roles: []string{roleInsurer, roleArbitrator}, Events: []string{"claimAdjudicated"}},
+	{Name: "recordClaimPayout", Description: "Records a payment of the amount awarded by a claim, for its liable party", Parameters: params("claim ID", "amount", "payment reference"), Events: []string{"claimPaid"}},
+	{Name: "queryHouseClaims", Description: "Returns the claims filed on a house", Parameters: params("house key")},
+	{Name: "queryLiablePartyClaims", Description: "Returns the claims filed against a builder or an insurer", Parameters: params("builder ID or insurer")},
+	{Name: "createBuilding", Description: "Creates a condominium building administered by the invoker", Parameters: params("building ID", "name", "location", "shared areas as a comma separated list")},
+	{Name: "addBuildingUnit", Description: "Makes the house a unit of the building, for its administrator", Parameters: params("building ID", "house key", "quota of the shared areas in basis points")},
+	{Name: "assessBuildingCharges", Description: "Allocates co-ownership charges to the units of the building by quota, for the administrator", Parameters: params("building ID", "period (YYYY-MM)", "total amount", "purpose"), Events: []string{"buildingChargesAssessed"}},
+	{Name: "recordBuildingChargePayment", Description: "Records a charge payment of a unit, for the administrator", Parameters: params("building ID", "house key", "assessment ID", "amount")},
+	{Name: "queryBuildingUnits", Description: "Returns the units of a building with their quota and house record", Parameters: params("building ID")},
+	{Name: "queryBuildingArrears", Description: "Lists the charges left unpaid by each unit of a building", Parameters: params("building ID")},
+	{Name: "setHouseAddress", Description: "Sets the structured address of a house, for registrars", Parameters: params("house key", "address as a JSON object with street, number, postalcode, city and country"), Roles: []string{roleRegistrar}},
+	{Name: "queryHousesByPostalCode", Description: "Returns the houses of a postal code", Parameters: params("country code", "postal code")},
+	{Name: "migrateLegacyLocations", Description: "Parses the free-form location of a chunk of houses into a structured address, for admins", Parameters: params("first key to migrate (empty to start from the first house)", "maximum number of houses to scan", "country code"), Roles: []string{roleAdmin}},
+	{Name: "setCadastralRef", Description: "Sets the cadastral reference of a house, for registrars", Parameters: params("house key", "cadastral reference"), Roles: []string{roleRegistrar}},
+	{Name: "queryHouseByCadastralRef", Description: "Returns the house holding a cadastral reference", Parameters: params("cadastral reference")},
+	{Name: "registerOracle", Description: "Whitelists an oracle identity for feeds, for admins", Parameters: params("oracle identity", "feeds as a comma separated list (priceIndex, floodZone, interestRate)"), Roles: []string{roleAdmin}},
+	{Name: "attestFact", Description: "Records a fact observed by the invoking oracle", Parameters: params("feed", "subject (location, * for national values, or rate name)", "value as a JSON object", "observation time (RFC 3339)", "signature"), Events: []string{"factAttested"}},
+	{Name: "queryAttestedFact", Description: "Returns the latest fact attested about a subject of a feed", Parameters: params("feed", "subject")},
+	{Name: "queryIndexedValuation", Description: "Values a house at the price of its last sale, indexed by the attested price index of its location (or the national one) from the time of the sale to the time of the query", Parameters: params("house key")},
+	{Name: "setRiskZone", Description: "Designates the class of a hazard at a location, for planners", Parameters: params("location", "hazard (flood, landslide, seismic or wildfire)", "class (none, low, medium or high)"), Roles: []string{rolePlanner}, Events: []string{"riskZoneDesignated"}},
+	{Name: "acknowledgeRiskDisclosure", Description: "Records that the invoker, buyer of a house, acknowledged the disclosure of its current risks", Parameters: params("house key", "hash of the disclosure document")},
+	{Name: "queryHouseRisks", Description: "Returns the classifications of the hazards of the location of a house", Parameters: params("house key")},
+	{Name: "queryHousesByRiskClass", Description: "Returns the houses of the locations where the hazard is of the class", Parameters: params("hazard", "class")},
+	{Name: "createRetrofitProgram", Description: "Creates a retrofit program whose credits the invoker awards, for grantors", Parameters: params("program ID", "name", "credits per energy class gained"), Roles: []string{roleGrantor}},
+	{Name: "recordRetrofitWorks", Description: "Records energy retrofit works on a house under a program, only the owner can do it", Parameters: params("house key", "program ID", "description", "hash of the works invoice"), Events: []string{"attorneyInvocation"}},
+	{Name: "awardRetrofitCredits", Description: "Awards the credits of the energy classes gained by retrofit works to the owner of the house, for the authority of the program", Parameters: params("house key", "works ID"), Events: []string{"retrofitCreditsAwarded"}},
+	{Name: "transferEfficiencyCredits", Description: "Transfers efficiency credits of the invoker to another identity", Parameters: params("recipient", "amount", "memo")},
+	{Name: "queryEfficiencyCredits", Description: "Returns the credit account of an identity, for the holder, admins and the custodian", Parameters: params("identity")},
+	{Name: "queryRetrofitProgramReport", Description: "Sums up the works of a program, for its authority: works recorded and awarded, credits awarded and the count of awards per rating before and after the works", Parameters: params("program ID")},
+	{Name: "registerIoTGateway", Description: "Sets the houses whose readings a gateway commits, for utilities", Parameters: params("gateway identity", "house keys as a comma separated list"), Roles: []string{roleUtility}},
+	{Name: "commitReadingsRoot", Description: "Commits the Merkle root of the readings of a sensor of a house over a period, for its gateway", Parameters: params("house key", "sensor", "period start", "period end (RFC 3339)", "number of readings", "Merkle root (hex sha256)")},
+	{Name: "verifyReading", Description: "Tells whether a reading belongs to the readings committed for a period, given its Merkle proof", Parameters: params("house key", "sensor", "period start (RFC 3339)", "reading as serialized by the gateway", "proof as a JSON array of {hash, position (left or right)} from the leaf up")},
+	{Name: "queryReadingCommitments", Description: "Returns the roots committed for a sensor of a house, in order of period", Parameters: params("house key", "sensor")},
+	{Name: "issueOccupancyCertificate", Description: "Records the occupancy certificate of a house, replacing the previous one, for inspectors", Parameters: params("house key", "certificate ID", "expiry date (YYYY-MM-DD)"), Roles: []string{roleInspector}, Events: []string{"occupancyCertificateIssued"}},
+	{Name: "revokeOccupancyCertificate", Description: "Revokes the occupancy certificate of a house, for inspectors", Parameters: params("house key", "reason"), Roles: []string{roleInspector}},
+	{Name: "flagUninhabitable", Description: "Flags a house as uninhabitable, for inspectors", Parameters: params("house key", "reason"), Roles: []string{roleInspector}, Events: []string{"houseFlaggedUninhabitable"}},
+	{Name: "clearHabitabilityFlag", Description: "Lifts the uninhabitable flag of a house once fixed, for inspectors", Parameters: params("house key"), Roles: []string{roleInspector}},
+	{Name: "listHouseForRent", Description: "Offers the house for lease at a monthly rent, for the owner or a manager with the leases permission", Parameters: params("house key", "monthly rent")},
+	{Name: "withdrawRentalListing", Description: "Takes the house off the rental market, for the owner or a manager with the leases permission", Parameters: params("house key")},
+	{Name: "queryRentalListings", Description: "Returns the houses offered for rent"},
+	{Name: "queryHabitability", Description: "Returns the occupancy certificate and habitability flag of a house", Parameters: params("house key")},
+	{Name: "issueRentalLicense", Description: "Licenses the house for short-term rentals, replacing its previous license, for planners", Parameters: params("house key", "license ID", "annual night cap", "expiry date (YYYY-MM-DD)"), Roles: []string{rolePlanner}, Events: []string{"rentalLicenseIssued"}},
+	{Name: "setRentalNightCap", Description: "Changes the annual night cap of the license of a house, for planners", Parameters: params("house key", "annual night cap"), Roles: []string{rolePlanner}},
+	{Name: "revokeRentalLicense", Description: "Revokes the short-term rental license of a house, for planners", Parameters: params("house key", "reason"), Roles: []string{rolePlanner}, Events: []string{"rentalLicenseRevoked"}},
+	{Name: "recordRentalStay", Description: "Records the nights of a short stay, for the owner or a manager with the leases permission", Parameters: params("house key", "check-in day (YYYY-MM-DD)", "number of nights")},
+	{Name: "queryRentalNights", Description: "Returns the nights let by a house in a year against its cap", Parameters: params("house key", "year (YYYY)")},
+	{Name: "queryHousesOverNightCap", Description: "Returns the licensed houses which used up their night cap of the year, or went over it, for planners", Parameters: params("year (YYYY)"), Roles: []string{rolePlanner}},
+	{Name: "setBookingTerms", Description: "Sets the nightly rate and cancellation policy of the bookings of a house, for the owner or a manager with the leases permission", Parameters: params("house key", "nightly rate", "cancellation policy (flexible, moderate or strict)")},
+	{Name: "createBooking", Description: "Books the house for a guest, for the owner or a manager with the leases permission", Parameters: params("house key", "arrival day", "departure day (YYYY-MM-DD)", "guest"), Events: []string{"bookingCreated"}},
+	{Name: "cancelBooking", Description: "Cancels a booking before arrival, for its guest, or the owner or a manager of the house with the leases permission", Parameters: params("booking ID"), Events: []string{"bookingCancelled"}},
+	{Name: "queryAvailability", Description: "Tells whether the house is free from the arrival day to the departure day, with the bookings and leases in the way", Parameters: params("house key", "arrival day", "departure day (YYYY-MM-DD)")},
+	{Name: "submitRating", Description: "Rates the other party of a lease once it is over, for its landlord or its tenant", Parameters: params("lease ID", "scores as a JSON object of criterion to score (1 to 5)", "comment"), Events: []string{"ratingSubmitted"}},
+	{Name: "queryReputation", Description: "Returns the scores of an identity as landlord and as tenant, averaged per criterion over its ratings", Parameters: params("identity")},
+	{Name: "queryRatings", Description: "Returns the ratings received by an identity", Parameters: params("identity")},
+	{Name: "openOutbox", Description: "Subscribes the invoker to the events, or changes the events of its outbox, for subscribers", Parameters: params("event names as a comma separated list (empty for every event)"), Roles: []string{roleSubscriber}},
+	{Name: "closeOutbox", Description: "Unsubscribes the invoker, its notifications not acknowledged being dropped"},
+	{Name: "readOutbox", Description: "Returns the notifications of the invoker not acknowledged yet, in sequence order, up to 24 hours of the log at once", Parameters: params("maximum number of notifications (up to 100)", "[bookmark returned by the previous read]")},
+	{Name: "ackNotifications", Description: "Acknowledges the notifications of the invoker up to a sequence number, moving its cursor past them", Parameters: params("sequence number", "[bookmark the notifications were read from]")},
+	{Name: "pruneNotifications", Description: "Removes from the log the notifications acknowledged by every outbox, up to 1000 at once"},
+	{Name: "registerSubscription", Description: "Registers a webhook for the events matching the filter, or replaces the subscription of the invoker", Parameters: params("subscription ID", "webhook URL", "filter as a JSON object of events, locations and owners lists")},
+	{Name: "deleteSubscription", Description: "Removes a subscription, only its owner can do it", Parameters: params("subscription ID")},
+	{Name: "getSubscriptionsMatching", Description: "Returns the subscriptions matching an event, in order of ID", Parameters: params("event as a JSON object of event, housekey, location and owner")},
+	{Name: "queryHouseCount", Description: "Returns the number of houses of a value of a dimension", Parameters: params("dimension (location, status or owner)", "value")},
+	{Name: "queryHouseCounts", Description: "Returns the number of houses of every value of a dimension", Parameters: params("dimension (location, status or owner)")},
+	{Name: "rebuildHouseCounters", Description: "Recomputes every house counter from the houses, for admins", Roles: []string{roleAdmin}},
+	{Name: "registerSavedQuery", Description: "Registers or replaces a saved query, for admins", Parameters: params("name", "description", "CouchDB query as JSON with \"{{name}}\" placeholders"), Roles: []string{roleAdmin}},
+	{Name: "deleteSavedQuery", Description: "Removes a saved query, for admins", Parameters: params("name"), Roles: []string{roleAdmin}},
+	{Name: "querySavedQueries", Description: "Returns the saved queries, in order of name"},
+	{Name: "runSavedQuery", Description: "Runs a saved query with the parameters, returning one page of the records it selects", Parameters: params("name", "parameters as a JSON object", "[page size (200 by default)]", "[bookmark]")},
+	{Name: "queryHouseWithProof", Description: "Returns a house with the provenance of its last write", Parameters: params("house key")},
+	{Name: "batch", Description: "Runs the operations in order in one transaction, returning their results, or failing with the first failing operation", Parameters: params("operations as a JSON array of {function, args}")},
+	{Name: "simulate", Description: "Runs a function without writing anything, returning its response and the writes and events it would issue", Parameters: params("function", "arguments as a JSON array of strings")},
+	{Name: "setFeatureFlag", Description: "Enables or disables a feature, for admins", Parameters: params("feature", "enabled (true or false)"), Roles: []string{roleAdmin}, Events: []string{"featureFlagChanged"}},
+	{Name: "getEnabledFeatures", Description: "Returns the features with their state, in order of name"},
+	{Name: "getContractMetadata", Description: "Returns the description of the functions available"},
+	{Name: "whoAmI", Description: "Returns the identity of the invoker with its certificate attributes and the roles the contract resolves for it"},
+	{Name: "bootstrapAdmin", Description: "Assigns the admin role to the invoker, a member of the MSP named at instantiation, when no admin was designated at instantiation or bootstrapped before", Events: []string{"roleAssigned"}},
+	{Name: "assignRole", Description: "Assigns a role to an identity, for admins", Parameters: params("identity ID (as returned by whoAmI)", "role"), Roles: []string{roleAdmin}, Events: []string{"roleAssigned"}},
+	{Name: "revokeRole", Description: "Revokes a role assigned to an identity, for admins", Parameters: params("identity ID", "role"), Roles: []string{roleAdmin}, Events: []string{"roleRevoked"}},
+	{Name: "listRoleAssignments", Description: "Returns the roles assigned on the ledger, for admins", Parameters: params("identity ID (empty for every identity)"), Roles: []string{roleAdmin}},
+	{Name: "pauseContract", Description: "Pauses the contract, for admins", Parameters: params("reason"), Roles: []string{roleAdmin}, Events: []string{"contractPaused"}},
+	{Name: "resumeContract", Description: "Resumes a paused contract, for admins", Roles: []string{roleAdmin}, Events: []string{"contractResumed"}},
+	{Name: "queryPause", Description: "Returns the pause of the contract, null when it is not paused"},
+	{Name: "scheduleMaintenanceWindow", Description: "Adds or replaces a maintenance window, for admins", Parameters: params("window ID", "start (RFC 3339)", "end (RFC 3339)", "functions as a comma separated list", "reason"), Roles: []string{roleAdmin}, Events: []string{"maintenanceScheduled"}},
+	{Name: "cancelMaintenanceWindow", Description: "Deletes a maintenance window, for admins", Parameters: params("window ID"), Roles: []string{roleAdmin}, Events: []string{"maintenanceCancelled"}},
+	{Name: "getMaintenanceSchedule", Description: "Returns the maintenance windows not expired, open or to come"},
+	{Name: "acquireLock", Description: "Locks a house, or extends the lock of the invoker, for the owner, notaries and the registrar", Parameters: params("house key", "time to live in seconds (at most a day)"), Roles: []string{roleNotary, roleRegistrar}, Events: []string{"houseLocked"}},
+	{Name: "releaseLock", Description: "Releases the lock of a house, for its holder and admins", Parameters: params("house key"), Roles: []string{roleAdmin}, Events: []string{"houseUnlocked"}},
+	{Name: "queryLock", Description: "Returns the lock of a house, null when it is not locked", Parameters: params("house key")},
+	{Name: "setConflictDiagnostics", Description: "Turns the recording of the recent writes of the houses on or off, for admins", Parameters: params("enabled (true or false)"), Roles: []string{roleAdmin}},
+	{Name: "queryRecentWrites", Description: "Returns the recent writes of a house, from the oldest to the latest, for admins", Parameters: params("house key"), Roles: []string{roleAdmin}},
+	{Name: "queryBusyHouses", Description: "Returns the houses written more than once during the last seconds, the most written first, for admins. Only committed writes are counted, not the transactions which failed on a conflict", Parameters: params("period in seconds"), Roles: []string{roleAdmin}},
+
+	// Functions changed by version 2 of the API
+	{Name: "v2:createHouse", Description: "Creates a house described by a typed JSON object", Parameters: params("house key", "house as a JSON object of year, squarefeets, location or address, owner, usage, zone and cadastralref"), Roles: []string{roleRegistrar}},
+	{Name: "v2:changeHouseOwner", Description: "Transfers a house, the reason and the price of a sale being required", Parameters: params("house key", "transfer as a JSON object of newowner, reason and price")},
+}
+
+// getContractMetadata returns the name of the contract and the functions available, without those of the disabled features
+func (s *SmartContract) getContractMetadata(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	functions := []FunctionMetadata{}
+	for _, function := range contractFunctions {
+		if function.Parameters == nil {
+			function.Parameters = []ParameterMetadata{}
+		}
+		if feature, covered := functionFeatures[function.Name]; covered {
+			enabled, err := featureEnabled(APIstub, feature)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if !enabled {
+				continue
+			}
+			function.Feature = feature
+		}
+		functions = append(functions, function)
+	}
+
+	var metadata = struct {
+		Name      string             `json:"name"`
+		Functions []FunctionMetadata `json:"functions"`
+	}{Name: contractName, Functions: functions}
+
+	metadataAsBytes, _ := json.Marshal(metadata)
+	return shim.Success(metadataAsBytes)
+}
@@ -0,0 +1,238 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Utility meters
+ * Utilities append the readings of the electricity, water and gas meters of a house. Readings
+ * are timestamped with the transaction and can never decrease, so the consumption between two
+ * dates is the difference of the last readings taken at or before them.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	meterReadingObjectType     = "meterReading"
+	lastMeterReadingObjectType = "lastMeterReading"
+)
+
+// Meter types
+var meterTypes = []string{"electricity", "water", "gas"}
+
+// Define the meter reading structure, the index of a meter at the transaction time
+type MeterReading struct {
+	HouseKey   string `json:"housekey"`
+	MeterType  string `json:"metertype"`
+	Value      int64  `json:"value"`
+	ReadAt     string `json:"readat"`
+	RecordedBy string `json:"recordedby"`
+	TxID       string `json:"txid"`
+}
+
+func validateMeterType(meterType string) error {
+	for _, known := range meterTypes {
+		if meterType == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown meter type %q", meterType)
+}
+
+/*
+ * recordMeterReading appends a reading of a meter of the house, for utilities
+ * args: house key, meter type (electricity, water or gas), value
+ */
+func (s *SmartContract) recordMeterReading(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := validateMeterType(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	value, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || value < 0 {
+		return shim.Error("Value must be a positive number")
+	}
+	if err := requireRole(APIstub, roleUtility); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The last reading is kept apart, so that the check does not scan the whole history
+	lastKey, err := APIstub.CreateCompositeKey(lastMeterReadingObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lastAsBytes, err := APIstub.GetState(lastKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lastAsBytes != nil {
+		last := MeterReading{}
+		if err := json.Unmarshal(lastAsBytes, &last); err != nil {
+			return shim.Error(err.Error())
+		}
+		if value < last.Value {
+			return shim.Error(fmt.Sprintf("Reading %d is lower than the last reading %d of %s", value, last.Value, last.ReadAt))
+		}
+	}
+
+	recordedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	readAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var reading = MeterReading{
+		HouseKey:   args[0],
+		MeterType:  args[1],
+		Value:      value,
+		ReadAt:     readAt.Format(timeLayout),
+		RecordedBy: recordedBy,
+		TxID:       APIstub.GetTxID(),
+	}
+
+	readingKey, err := APIstub.CreateCompositeKey(meterReadingObjectType, []string{reading.HouseKey, reading.MeterType, reading.ReadAt, reading.TxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	readingAsBytes, _ := json.Marshal(reading)
+	if err := APIstub.PutState(readingKey, readingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(lastKey, readingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(readingAsBytes)
+}
+
+/*
+ * queryMeterReadings lists the readings of a meter of the house, oldest first
+ * args: house key, meter type
+ */
+func (s *SmartContract) queryMeterReadings(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	readings, err := getMeterReadings(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	readingsAsBytes, _ := json.Marshal(readings)
+	return shim.Success(readingsAsBytes)
+}
+
+// getMeterReadings returns the readings of a meter, in the order of their timestamps
+func getMeterReadings(APIstub shim.ChaincodeStubInterface, key string, meterType string) ([]MeterReading, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(meterReadingObjectType, []string{key, meterType})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	readings := []MeterReading{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		reading := MeterReading{}
+		if err := json.Unmarshal(queryResponse.Value, &reading); err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+/*
+ * queryConsumption computes the consumption of a meter of the house between two dates
+ * args: house key, meter type, from, to (RFC 3339)
+ */
+func (s *SmartContract) queryConsumption(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := validateMeterType(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	from, err := time.Parse(timeLayout, args[2])
+	if err != nil {
+		return shim.Error("From must be formatted as RFC 3339")
+	}
+	to, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("To must be formatted as RFC 3339")
+	}
+	if to.Before(from) {
+		return shim.Error("To must not be before from")
+	}
+
+	readings, err := getMeterReadings(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Last readings taken at or before each date
+	var start, end *MeterReading
+	for i := range readings {
+		readAt, err := time.Parse(timeLayout, readings[i].ReadAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !readAt.After(from) {
+			start = &readings[i]
+		}
+		if !readAt.After(to) {
+			end = &readings[i]
+		}
+	}
+	if start == nil {
+		return shim.Error("No reading of the " + args[1] + " meter of house " + args[0] + " at or before " + args[2])
+	}
+
+	var consumption = struct {
+		HouseKey    string       `json:"housekey"`
+		MeterType   string       `json:"metertype"`
+		From        MeterReading `json:"from"`
+		To          MeterReading `json:"to"`
+		Consumption int64        `json:"consumption"`
+	}{HouseKey: args[0], MeterType: args[1], From: *start, To: *end, Consumption: end.Value - start.Value}
+
+	consumptionAsBytes, _ := json.Marshal(consumption)
+	return shim.Success(consumptionAsBytes)
+}
@@ -0,0 +1,209 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Invocation metrics
+ * Two sets of counters are kept per Smart Contract function:
+ * - process counters, kept in memory by the chaincode process and exposed in the Prometheus
+ *   text format when FABHOUSE_METRICS_ADDRESS is set (e.g. ":9443"). They count every
+ *   invocation seen by this peer, failed ones included.
+ * - ledger counters, written with the transaction once enabled by an admin. A failed
+ *   invocation is never committed, so ledger counters only count successful transactions.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Environment variable holding the listen address of the Prometheus endpoint
+const metricsAddressEnv = "FABHOUSE_METRICS_ADDRESS"
+
+const (
+	ledgerMetricsKey          = "CONFIG_LEDGERMETRICS"
+	invocationCountObjectType = "invocationCount"
+)
+
+// Define the function metrics structure, the counters of one Smart Contract function
+type FunctionMetrics struct {
+	Function       string `json:"function"`
+	Invocations    int64  `json:"invocations"`
+	Failures       int64  `json:"failures,omitempty"`
+	ArgumentErrors int64  `json:"argumenterrors,omitempty"`
+}
+
+// Process counters, shared by the concurrent invocations handled by this chaincode process
+var processMetrics = struct {
+	sync.Mutex
+	functions map[string]*FunctionMetrics
+}{functions: map[string]*FunctionMetrics{}}
+
+// isArgumentError tells whether the response rejected the arguments of the invocation
+func isArgumentError(response sc.Response) bool {
+	return strings.HasPrefix(response.Message, "Incorrect number of arguments")
+}
+
+// recordInvocation updates the process counters, and the ledger counters when enabled
+func recordInvocation(APIstub shim.ChaincodeStubInterface, function string, response sc.Response) {
+	failed := response.Status >= shim.ERRORTHRESHOLD
+
+	processMetrics.Lock()
+	metrics, found := processMetrics.functions[function]
+	if !found {
+		metrics = &FunctionMetrics{Function: function}
+		processMetrics.functions[function] = metrics
+	}
+	metrics.Invocations++
+	if failed {
+		metrics.Failures++
+		if isArgumentError(response) {
+			metrics.ArgumentErrors++
+		}
+	}
+	processMetrics.Unlock()
+
+	if failed {
+		return
+	}
+	enabled, err := ledgerMetricsEnabled(APIstub)
+	if err != nil || !enabled {
+		return
+	}
+	if err := incrementInvocationCount(APIstub, function); err != nil {
+		logFor(APIstub).Warnf("Error recording metrics: %s", err)
+	}
+}
+
+func ledgerMetricsEnabled(APIstub shim.ChaincodeStubInterface) (bool, error) {
+	enabledAsBytes, err := APIstub.GetState(ledgerMetricsKey)
+	if err != nil || enabledAsBytes == nil {
+		return false, err
+	}
+
+	enabled := false
+	err = json.Unmarshal(enabledAsBytes, &enabled)
+	return enabled, err
+}
+
+// The invocation counts of the functions are sharded, every invocation of a function incrementing its count
+func incrementInvocationCount(APIstub shim.ChaincodeStubInterface, function string) error {
+	return addShardedCount(APIstub, invocationCountObjectType, []string{function}, 1)
+}
+
+/*
+ * setLedgerMetrics enables or disables the ledger counters
+ * args: "true" or "false"
+ */
+func (s *SmartContract) setLedgerMetrics(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] != "true" && args[0] != "false" {
+		return shim.Error("Expecting \"true\" or \"false\"")
+	}
+
+	if err := APIstub.PutState(ledgerMetricsKey, []byte(args[0])); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getMetrics returns the ledger counters, and the process counters of the peer answering the query
+func (s *SmartContract) getMetrics(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	counts, err := getShardedCounts(APIstub, invocationCountObjectType, []string{}, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ledger := []FunctionMetrics{}
+	for _, count := range counts {
+		ledger = append(ledger, FunctionMetrics{Function: count.attributes[0], Invocations: count.count})
+	}
+
+	var metrics = struct {
+		Ledger  []FunctionMetrics `json:"ledger"`
+		Process []FunctionMetrics `json:"process"`
+	}{Ledger: ledger, Process: snapshotProcessMetrics()}
+
+	metricsAsBytes, _ := json.Marshal(metrics)
+	return shim.Success(metricsAsBytes)
+}
+
+// snapshotProcessMetrics copies the process counters, sorted by function name
+func snapshotProcessMetrics() []FunctionMetrics {
+	processMetrics.Lock()
+	defer processMetrics.Unlock()
+
+	snapshot := []FunctionMetrics{}
+	for _, metrics := range processMetrics.functions {
+		snapshot = append(snapshot, *metrics)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Function < snapshot[j].Function })
+	return snapshot
+}
+
+// serveMetrics writes the process counters in the Prometheus text exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := snapshotProcessMetrics()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP fabhouse_invocations_total Smart Contract invocations handled by this process.")
+	fmt.Fprintln(w, "# TYPE fabhouse_invocations_total counter")
+	for _, metrics := range snapshot {
+		fmt.Fprintf(w, "fabhouse_invocations_total{function=%q} %d\n", metrics.Function, metrics.Invocations)
+	}
+	fmt.Fprintln(w, "# HELP fabhouse_failures_total Smart Contract invocations returning an error.")
+	fmt.Fprintln(w, "# TYPE fabhouse_failures_total counter")
+	for _, metrics := range snapshot {
+		fmt.Fprintf(w, "fabhouse_failures_total{function=%q} %d\n", metrics.Function, metrics.Failures)
+	}
+	fmt.Fprintln(w, "# HELP fabhouse_argument_errors_total Smart Contract invocations rejected for their arguments.")
+	fmt.Fprintln(w, "# TYPE fabhouse_argument_errors_total counter")
+	for _, metrics := range snapshot {
+		fmt.Fprintf(w, "fabhouse_argument_errors_total{function=%q} %d\n", metrics.Function, metrics.ArgumentErrors)
+	}
+}
+
+// startMetricsServer serves the Prometheus endpoint in the background, nothing is started for an empty address
+func startMetricsServer(address string) {
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			processLog.Errorf("Error serving metrics on %s: %s", address, err)
+		}
+	}()
+}
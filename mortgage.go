@@ -0,0 +1,460 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Mortgages
+ * A mortgage is a lien on a house registered by its owner in favor of a lienholder, repaid by
+ * monthly installments over its term. Interest accrues monthly on the outstanding balance from the
+ * first period, and the payments recorded by the lienholder are applied in the period they are
+ * made. The lien follows the house when it changes hands, until it is paid off and released.
+ * Amounts are integers, interest being rounded down every month.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	mortgageObjectType        = "mortgage"
+	houseMortgageIndex        = "key~mortgage"
+	mortgagePaymentObjectType = "mortgagePayment"
+)
+
+// Mortgage statuses
+const (
+	mortgageActive     = "active"
+	mortgageReleased   = "released"
+	mortgageForeclosed = "foreclosed"
+)
+
+// Define the mortgage structure, identified by the ID of the registering transaction
+// Rate is the annual interest rate in basis points, MonthlyPayment the installment amortizing the principal over the term
+type Mortgage struct {
+	ID             string `json:"id"`
+	HouseKey       string `json:"housekey"`
+	Borrower       string `json:"borrower"`
+	Lienholder     string `json:"lienholder"`
+	Principal      int64  `json:"principal"`
+	Rate           int    `json:"rate"`
+	TermMonths     int    `json:"termmonths"`
+	StartPeriod    string `json:"startperiod"`
+	MonthlyPayment int64  `json:"monthlypayment"`
+	Status         string `json:"status"`
+	ReleasedAt     string `json:"releasedat,omitempty"`
+}
+
+// Define the mortgage payment structure, one installment or prepayment made to the lienholder
+type MortgagePayment struct {
+	MortgageID string `json:"mortgageid"`
+	Period     string `json:"period"`
+	Amount     int64  `json:"amount"`
+	Final      bool   `json:"final,omitempty"`
+	RecordedBy string `json:"recordedby"`
+	RecordedAt string `json:"recordedat"`
+	TxID       string `json:"txid"`
+}
+
+// Define the mortgage statement structure, the state of the repayment at a date
+type MortgageStatement struct {
+	MortgageID      string `json:"mortgageid"`
+	AsOf            string `json:"asof"`
+	Outstanding     int64  `json:"outstanding"`
+	InterestAccrued int64  `json:"interestaccrued"`
+	Paid            int64  `json:"paid"`
+	MissedPayments  int    `json:"missedpayments"`
+	MonthlyPayment  int64  `json:"monthlypayment"`
+}
+
+func getMortgage(APIstub shim.ChaincodeStubInterface, mortgageID string) (Mortgage, error) {
+	mortgage := Mortgage{}
+
+	mortgageKey, err := APIstub.CreateCompositeKey(mortgageObjectType, []string{mortgageID})
+	if err != nil {
+		return mortgage, err
+	}
+	mortgageAsBytes, err := APIstub.GetState(mortgageKey)
+	if err != nil {
+		return mortgage, err
+	}
+	if mortgageAsBytes == nil {
+		return mortgage, fmt.Errorf("Mortgage %s does not exist", mortgageID)
+	}
+
+	err = json.Unmarshal(mortgageAsBytes, &mortgage)
+	return mortgage, err
+}
+
+func putMortgage(APIstub shim.ChaincodeStubInterface, mortgage Mortgage) ([]byte, error) {
+	mortgageKey, err := APIstub.CreateCompositeKey(mortgageObjectType, []string{mortgage.ID})
+	if err != nil {
+		return nil, err
+	}
+	mortgageAsBytes, _ := json.Marshal(mortgage)
+	return mortgageAsBytes, APIstub.PutState(mortgageKey, mortgageAsBytes)
+}
+
+// requireLienholder returns an error unless the invoker is the lienholder of the mortgage
+func requireLienholder(APIstub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invoker != mortgage.Lienholder {
+		return fmt.Errorf("Only the lienholder %s can do this on mortgage %s", mortgage.Lienholder, mortgage.ID)
+	}
+	return nil
+}
+
+// monthlyInterest returns the interest of a month on the balance, rounded down
+func monthlyInterest(balance int64, rate int) int64 {
+	return balance * int64(rate) / (12 * 10000)
+}
+
+// amortizingPayment returns the monthly installment repaying the principal over the term, rounded up:
+// principal * r / (1 - (1 + r)^-term), r being the monthly rate. Rationals keep it identical on every endorser
+func amortizingPayment(principal int64, rate int, termMonths int) int64 {
+	if rate == 0 {
+		return (principal + int64(termMonths) - 1) / int64(termMonths)
+	}
+	monthlyRate := big.NewRat(int64(rate), 12*10000)
+	growth := new(big.Rat).Add(big.NewRat(1, 1), monthlyRate)
+	compounded := big.NewRat(1, 1)
+	for i := 0; i < termMonths; i++ {
+		compounded.Mul(compounded, growth)
+	}
+	// principal * r * (1 + r)^term / ((1 + r)^term - 1)
+	payment := new(big.Rat).Mul(big.NewRat(principal, 1), monthlyRate)
+	payment.Mul(payment, compounded)
+	payment.Quo(payment, new(big.Rat).Sub(compounded, big.NewRat(1, 1)))
+	quotient, remainder := new(big.Int).QuoRem(payment.Num(), payment.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient.Int64()
+}
+
+// getMortgagePaid sums the payments of the mortgage per period
+func getMortgagePaid(APIstub shim.ChaincodeStubInterface, mortgageID string) (map[string]int64, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(mortgagePaymentObjectType, []string{mortgageID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	paid := map[string]int64{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		payment := MortgagePayment{}
+		if err := json.Unmarshal(queryResponse.Value, &payment); err != nil {
+			return nil, err
+		}
+		paid[payment.Period] += payment.Amount
+	}
+	return paid, nil
+}
+
+// mortgageStatement replays the mortgage month by month up to the period of the date. Every period
+// before the one of the date whose payments fall short of the installment is a missed payment
+func mortgageStatement(mortgage Mortgage, paid map[string]int64, asOf time.Time) (MortgageStatement, error) {
+	statement := MortgageStatement{MortgageID: mortgage.ID, AsOf: asOf.Format(dayLayout), MonthlyPayment: mortgage.MonthlyPayment}
+
+	period, err := time.Parse(periodLayout, mortgage.StartPeriod)
+	if err != nil {
+		return statement, err
+	}
+	last := asOf.Format(periodLayout)
+	balance := mortgage.Principal
+	for ; period.Format(periodLayout) <= last; period = period.AddDate(0, 1, 0) {
+		current := period.Format(periodLayout)
+		interest := monthlyInterest(balance, mortgage.Rate)
+		due := balance + interest
+		statement.InterestAccrued += interest
+		statement.Paid += paid[current]
+		balance = due - paid[current]
+		if current < last && balance > 0 && paid[current] < mortgage.MonthlyPayment {
+			statement.MissedPayments++
+		}
+	}
+	if balance < 0 {
+		balance = 0
+	}
+	statement.Outstanding = balance
+	return statement, nil
+}
+
+/*
+ * registerMortgage registers a mortgage on the house in favor of a lienholder, only the owner can do it
+ * args: house key, lienholder, principal, annual rate in basis points, term in months, first period (YYYY-MM)
+ */
+func (s *SmartContract) registerMortgage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+	if args[1] == "" {
+		return shim.Error("Lienholder must not be empty")
+	}
+	principal, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || principal <= 0 {
+		return shim.Error("Principal must be a positive number")
+	}
+	rate, err := strconv.Atoi(args[3])
+	if err != nil || rate < 0 || rate > 10000 {
+		return shim.Error("Rate must be a number of basis points from 0 to 10000")
+	}
+	termMonths, err := strconv.Atoi(args[4])
+	if err != nil || termMonths <= 0 || termMonths > 600 {
+		return shim.Error("Term must be a number of months from 1 to 600")
+	}
+	if _, err := time.Parse(periodLayout, args[5]); err != nil {
+		return shim.Error("First period must be formatted YYYY-MM")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotBlocked(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var mortgage = Mortgage{
+		ID:             APIstub.GetTxID(),
+		HouseKey:       args[0],
+		Borrower:       house.Owner,
+		Lienholder:     args[1],
+		Principal:      principal,
+		Rate:           rate,
+		TermMonths:     termMonths,
+		StartPeriod:    args[5],
+		MonthlyPayment: amortizingPayment(principal, rate, termMonths),
+		Status:         mortgageActive,
+	}
+	mortgageAsBytes, err := putMortgage(APIstub, mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexKey, err := APIstub.CreateCompositeKey(houseMortgageIndex, []string{mortgage.HouseKey, mortgage.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "mortgageRegistered", mortgageAsBytes)
+	return shim.Success(mortgageAsBytes)
+}
+
+// putMortgagePayment records a payment of the mortgage in the period of the transaction
+func putMortgagePayment(APIstub shim.ChaincodeStubInterface, mortgage Mortgage, amount int64, final bool) ([]byte, error) {
+	recordedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	recordedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	var payment = MortgagePayment{
+		MortgageID: mortgage.ID,
+		Period:     recordedAt.Format(periodLayout),
+		Amount:     amount,
+		Final:      final,
+		RecordedBy: recordedBy,
+		RecordedAt: recordedAt.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+	paymentKey, err := APIstub.CreateCompositeKey(mortgagePaymentObjectType, []string{payment.MortgageID, payment.TxID})
+	if err != nil {
+		return nil, err
+	}
+	paymentAsBytes, _ := json.Marshal(payment)
+	return paymentAsBytes, APIstub.PutState(paymentKey, paymentAsBytes)
+}
+
+/*
+ * recordMortgagePayment records a payment received on an active mortgage, only the lienholder can do it
+ * args: mortgage ID, amount
+ */
+func (s *SmartContract) recordMortgagePayment(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLienholder(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage.Status != mortgageActive {
+		return shim.Error("Mortgage " + mortgage.ID + " is " + mortgage.Status)
+	}
+
+	paymentAsBytes, err := putMortgagePayment(APIstub, mortgage, amount, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(paymentAsBytes)
+}
+
+/*
+ * queryMortgageBalance returns the outstanding balance of a mortgage at a date
+ * args: mortgage ID, date (YYYY-MM-DD)
+ */
+func (s *SmartContract) queryMortgageBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	asOf, err := time.Parse(dayLayout, args[1])
+	if err != nil {
+		return shim.Error("Date must be formatted YYYY-MM-DD")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	paid, err := getMortgagePaid(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement, err := mortgageStatement(mortgage, paid, asOf)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	statementAsBytes, _ := json.Marshal(statement)
+	return shim.Success(statementAsBytes)
+}
+
+/*
+ * payoffAndReleaseMortgage records the final payment of the outstanding balance and releases the lien, only the lienholder can do it
+ * args: mortgage ID, amount of the final payment (at least the outstanding balance)
+ */
+func (s *SmartContract) payoffAndReleaseMortgage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount < 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	mortgage, err := getMortgage(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireLienholder(APIstub, mortgage); err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage.Status != mortgageActive {
+		return shim.Error("Mortgage " + mortgage.ID + " is " + mortgage.Status)
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	paid, err := getMortgagePaid(APIstub, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement, err := mortgageStatement(mortgage, paid, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount < statement.Outstanding {
+		return shim.Error(fmt.Sprintf("Mortgage %s has an outstanding balance of %d, the final payment of %d does not pay it off", mortgage.ID, statement.Outstanding, amount))
+	}
+
+	if amount > 0 {
+		if _, err := putMortgagePayment(APIstub, mortgage, amount, true); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	mortgage.Status = mortgageReleased
+	mortgage.ReleasedAt = txTime.Format(timeLayout)
+	mortgageAsBytes, err := putMortgage(APIstub, mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "mortgageReleased", mortgageAsBytes)
+	return shim.Success(mortgageAsBytes)
+}
+
+// queryHouseMortgages returns the mortgages registered on a house
+func (s *SmartContract) queryHouseMortgages(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(houseMortgageIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	mortgages := []Mortgage{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		mortgage, err := getMortgage(APIstub, attributes[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		mortgages = append(mortgages, mortgage)
+	}
+
+	mortgagesAsBytes, _ := json.Marshal(mortgages)
+	return shim.Success(mortgagesAsBytes)
+}
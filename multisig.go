@@ -0,0 +1,364 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Multi-signature owner accounts
+ * A multi-signature account is an owner made of several member identities with an M-of-N policy.
+ * Houses held by such an account, alone or as a co-owner, are not transferred at once: a member
+ * proposes the transfer, which is executed once the threshold number of distinct members approved
+ * it, each approval in a transaction of its own, the proposal counting as the first approval.
+ * An approved transfer may still wait for the co-signature of registrars or the settlement of its tax.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	multisigAccountObjectType  = "multisigAccount"
+	multisigProposalObjectType = "multisigProposal"
+)
+
+// Prefix of the owner names of the multi-signature accounts, which are not certificate common names
+const multisigPrefix = "#multisig:"
+
+// Multi-signature proposal statuses
+const (
+	multisigPending  = "pending"
+	multisigApproved = "approved"
+)
+
+// Define the multi-signature account structure
+type MultisigAccount struct {
+	ID        string   `json:"id"`
+	Members   []string `json:"members"`
+	Threshold int      `json:"threshold"`
+	CreatedBy string   `json:"createdby"`
+	CreatedAt string   `json:"createdat"`
+}
+
+// Define the multi-signature proposal structure, a transfer waiting for the approvals of the members
+type MultisigProposal struct {
+	ID         string   `json:"id"`
+	Account    string   `json:"account"`
+	HouseKey   string   `json:"housekey"`
+	NewOwner   string   `json:"newowner"`
+	Reason     string   `json:"reason"`
+	Price      int64    `json:"price"`
+	ProposedAt string   `json:"proposedat"`
+	Approvals  []string `json:"approvals"`
+	Approvers  []string `json:"approvers"`
+	Status     string   `json:"status"`
+}
+
+func isMultisigAccount(owner string) bool {
+	return strings.HasPrefix(owner, multisigPrefix)
+}
+
+func getMultisigAccount(APIstub shim.ChaincodeStubInterface, id string) (MultisigAccount, error) {
+	accountKey, err := APIstub.CreateCompositeKey(multisigAccountObjectType, []string{id})
+	if err != nil {
+		return MultisigAccount{}, err
+	}
+	accountAsBytes, err := APIstub.GetState(accountKey)
+	if err != nil {
+		return MultisigAccount{}, err
+	}
+	if accountAsBytes == nil {
+		return MultisigAccount{}, fmt.Errorf("Multi-signature account %s does not exist", id)
+	}
+	account := MultisigAccount{}
+	err = json.Unmarshal(accountAsBytes, &account)
+	return account, err
+}
+
+func getMultisigProposal(APIstub shim.ChaincodeStubInterface, id string) (MultisigProposal, error) {
+	proposalKey, err := APIstub.CreateCompositeKey(multisigProposalObjectType, []string{id})
+	if err != nil {
+		return MultisigProposal{}, err
+	}
+	proposalAsBytes, err := APIstub.GetState(proposalKey)
+	if err != nil {
+		return MultisigProposal{}, err
+	}
+	if proposalAsBytes == nil {
+		return MultisigProposal{}, fmt.Errorf("Multi-signature proposal %s does not exist", id)
+	}
+	proposal := MultisigProposal{}
+	err = json.Unmarshal(proposalAsBytes, &proposal)
+	return proposal, err
+}
+
+func putMultisigProposal(APIstub shim.ChaincodeStubInterface, proposal MultisigProposal) ([]byte, error) {
+	proposalKey, err := APIstub.CreateCompositeKey(multisigProposalObjectType, []string{proposal.ID})
+	if err != nil {
+		return nil, err
+	}
+	proposalAsBytes, _ := json.Marshal(proposal)
+	return proposalAsBytes, APIstub.PutState(proposalKey, proposalAsBytes)
+}
+
+// multisigHolder returns the multi-signature account holding the house, alone or as a co-owner, empty if none
+func multisigHolder(house House) string {
+	for _, share := range houseShares(house) {
+		if isMultisigAccount(share.Owner) {
+			return share.Owner
+		}
+	}
+	return ""
+}
+
+// checkMultisigParty screens the members of a multi-signature account becoming owner, for checkTransferAllowed
+func checkMultisigParty(APIstub shim.ChaincodeStubInterface, id string) error {
+	account, err := getMultisigAccount(APIstub, id)
+	if err != nil {
+		return err
+	}
+	for _, member := range account.Members {
+		if err := checkNotBlocked(APIstub, member); err != nil {
+			return err
+		}
+		if err := checkKYCVerified(APIstub, member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireMultisigMember returns the account and the unique ID of the invoker, failing unless the invoker is one of its members
+func requireMultisigMember(APIstub shim.ChaincodeStubInterface, id string) (MultisigAccount, string, string, error) {
+	account, err := getMultisigAccount(APIstub, id)
+	if err != nil {
+		return account, "", "", err
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return account, "", "", err
+	}
+	for _, member := range account.Members {
+		if member == invokerID {
+			// Approvals are told apart by unique ID, two certificates of the same member count once
+			uniqueID, err := getInvokerUniqueID(APIstub)
+			return account, invokerID, uniqueID, err
+		}
+	}
+	return account, "", "", fmt.Errorf("Access denied. Only the members of %s can do this", id)
+}
+
+// approveMultisigProposal adds the approval of the invoker to the proposal, executing the transfer at the threshold
+func approveMultisigProposal(APIstub shim.ChaincodeStubInterface, proposal MultisigProposal) ([]byte, error) {
+	account, approverName, approver, err := requireMultisigMember(APIstub, proposal.Account)
+	if err != nil {
+		return nil, err
+	}
+	for _, previous := range proposal.Approvals {
+		if previous == approver {
+			return nil, fmt.Errorf("Multi-signature proposal %s was already approved by %s", proposal.ID, approverName)
+		}
+	}
+	proposal.Approvals = append(proposal.Approvals, approver)
+	proposal.Approvers = append(proposal.Approvers, approverName)
+
+	if len(proposal.Approvals) >= account.Threshold {
+		house, err := getHouse(APIstub, proposal.HouseKey)
+		if err != nil {
+			return nil, err
+		}
+		if multisigHolder(house) != proposal.Account {
+			return nil, fmt.Errorf("House %s changed hands since the transfer was proposed", proposal.HouseKey)
+		}
+		// The transfer may still wait for the co-signature of registrars or the settlement of its tax
+		if _, err := queueOrExecuteTransfer(APIstub, proposal.HouseKey, house, proposal.NewOwner, proposal.Reason, proposal.Price); err != nil {
+			return nil, err
+		}
+		proposal.Status = multisigApproved
+	}
+	return putMultisigProposal(APIstub, proposal)
+}
+
+/*
+ * createMultisigAccount creates a multi-signature account, for one of its members
+ * args: account name (the owner name is #multisig: followed by it), members as a comma separated list, threshold
+ */
+func (s *SmartContract) createMultisigAccount(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] == "" {
+		return shim.Error("Account name must not be empty")
+	}
+	members, seen := []string{}, map[string]bool{}
+	for _, member := range splitList(args[1]) {
+		if isMultisigAccount(member) {
+			return shim.Error("A multi-signature account cannot be a member")
+		}
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+	threshold, err := strconv.Atoi(args[2])
+	if err != nil || threshold < 1 || threshold > len(members) {
+		return shim.Error(fmt.Sprintf("Threshold must be between 1 and the number of members (%d)", len(members)))
+	}
+	createdBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !seen[createdBy] {
+		return shim.Error("Access denied. Only a member can create the account")
+	}
+
+	id := multisigPrefix + args[0]
+	accountKey, err := APIstub.CreateCompositeKey(multisigAccountObjectType, []string{id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(accountKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("Multi-signature account " + id + " already exists")
+	}
+	createdAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accountAsBytes, _ := json.Marshal(MultisigAccount{ID: id, Members: members, Threshold: threshold, CreatedBy: createdBy, CreatedAt: createdAt.Format(timeLayout)})
+	if err := APIstub.PutState(accountKey, accountAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(accountAsBytes)
+}
+
+// queryMultisigAccount returns a multi-signature account. args: account ID
+func (s *SmartContract) queryMultisigAccount(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account, err := getMultisigAccount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accountAsBytes, _ := json.Marshal(account)
+	return shim.Success(accountAsBytes)
+}
+
+/*
+ * proposeMultisigTransfer proposes the transfer of a house held by a multi-signature account, for its members
+ * args: house key, new owner, reason, price (required for a sale)
+ */
+func (s *SmartContract) proposeMultisigTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	reason, price, err := parseTransferReason(args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	holder := multisigHolder(house)
+	if holder == "" {
+		return shim.Error("House " + args[0] + " is not held by a multi-signature account")
+	}
+	// Fail early, the checks are run again once the transfer is approved
+	if err := checkTransferAllowed(APIstub, args[0], house, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	proposedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var proposal = MultisigProposal{
+		ID:         APIstub.GetTxID(),
+		Account:    holder,
+		HouseKey:   args[0],
+		NewOwner:   args[1],
+		Reason:     reason,
+		Price:      price,
+		ProposedAt: proposedAt.Format(timeLayout),
+		Approvals:  []string{},
+		Approvers:  []string{},
+		Status:     multisigPending,
+	}
+	proposalAsBytes, err := approveMultisigProposal(APIstub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "multisigTransferProposed", proposalAsBytes)
+	return shim.Success(proposalAsBytes)
+}
+
+// approveMultisigTransfer approves a proposed transfer, for the members of the account. args: proposal ID
+func (s *SmartContract) approveMultisigTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getMultisigProposal(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.Status != multisigPending {
+		return shim.Error("Multi-signature proposal " + proposal.ID + " is " + proposal.Status)
+	}
+	proposalAsBytes, err := approveMultisigProposal(APIstub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(proposalAsBytes)
+}
+
+// queryMultisigProposal returns a multi-signature proposal. args: proposal ID
+func (s *SmartContract) queryMultisigProposal(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getMultisigProposal(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+	return shim.Success(proposalAsBytes)
+}
@@ -0,0 +1,121 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Named arguments
+ * Every function can be called with a single JSON object argument, naming its parameters as in
+ * the contract metadata, in place of its positional arguments: createHouse can be called with
+ * {"houseKey": "HOUSE10", "year": "1999", "squareFeets": "120", "location": "Bayonne", "owner": "Tomoko"}.
+ * The object is validated against the parameters of the function and converted to positional
+ * arguments. Strings are passed as they are, lists may be JSON arrays of strings and the other
+ * values are passed as their JSON text. Functions whose first parameter is JSON take a
+ * single JSON argument as positional.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// functionParametersOf returns the parameters of a function, possibly in the namespace of a version
+// keeping it as it is in version 1, and whether the function is described
+func functionParametersOf(function string) ([]ParameterMetadata, bool) {
+	if parameters, found := functionParameters[function]; found {
+		return parameters, true
+	}
+	if separator := strings.Index(function, ":"); separator >= 0 {
+		parameters, found := functionParameters[function[separator+1:]]
+		return parameters, found
+	}
+	return nil, false
+}
+
+// isNamedCall tells whether the arguments are a single JSON object naming the parameters of the function
+func isNamedCall(parameters []ParameterMetadata, args []string) bool {
+	return len(args) == 1 && len(parameters) > 0 && parameters[0].Type != "json" &&
+		strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+// namedArgValue returns the positional argument of a named value
+func namedArgValue(parameter ParameterMetadata, value json.RawMessage) (string, error) {
+	text := strings.TrimSpace(string(value))
+	switch {
+	case strings.HasPrefix(text, `"`):
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	case strings.HasPrefix(text, "[") && parameter.Type == "list":
+		items := []string{}
+		if err := json.Unmarshal(value, &items); err != nil {
+			return "", fmt.Errorf("Parameter %s must be a list of strings", parameter.Name)
+		}
+		return strings.Join(items, ","), nil
+	case text == "null":
+		return "", nil
+	}
+	// Numbers, booleans and JSON values, an address in place of a location for instance
+	return text, nil
+}
+
+// resolveNamedArgs converts a named call of the function to its positional arguments
+func resolveNamedArgs(function string, args []string) ([]string, error) {
+	parameters, described := functionParametersOf(function)
+	if !described || !isNamedCall(parameters, args) {
+		return args, nil
+	}
+
+	named := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(args[0]), &named); err != nil {
+		return nil, fmt.Errorf("Named arguments must be a JSON object: %s", err.Error())
+	}
+	expected := map[string]bool{}
+	names := []string{}
+	for _, parameter := range parameters {
+		expected[parameter.Name] = true
+		names = append(names, parameter.Name)
+	}
+	for name := range named {
+		if !expected[name] {
+			return nil, fmt.Errorf("Unknown parameter %q of %s, expecting %v", name, function, names)
+		}
+	}
+
+	// Optional parameters left out are passed empty, unless no later parameter is given
+	positional, given := []string{}, 0
+	for _, parameter := range parameters {
+		value, found := named[parameter.Name]
+		if !found {
+			if !parameter.Optional {
+				return nil, fmt.Errorf("Missing parameter %s of %s", parameter.Name, function)
+			}
+			positional = append(positional, "")
+			continue
+		}
+		arg, err := namedArgValue(parameter, value)
+		if err != nil {
+			return nil, err
+		}
+		positional = append(positional, arg)
+		given = len(positional)
+	}
+	return positional[:given], nil
+}
@@ -0,0 +1,466 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Occupancy certificates and habitability
+ * Municipal inspectors issue the occupancy certificate of a house, stating that it may be lived
+ * in until its expiry, and flag the houses they find uninhabitable. A flag revokes the certificate
+ * and takes the house off the rental market; a new certificate can only be issued once the flag is
+ * cleared. Leases require a certificate valid at their creation and end the rental listing.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	occupancyCertificateObjectType = "occupancyCertificate"
+	habitabilityFlagObjectType     = "habitabilityFlag"
+	rentalListingObjectType        = "rentalListing"
+)
+
+// Occupancy certificate statuses
+const (
+	certificateValid   = "valid"
+	certificateRevoked = "revoked"
+)
+
+// Define the occupancy certificate structure, the current certificate of a house
+type OccupancyCertificate struct {
+	ID            string `json:"id"`
+	HouseKey      string `json:"housekey"`
+	Inspector     string `json:"inspector"`
+	IssuedAt      string `json:"issuedat"`
+	Expiry        string `json:"expiry"`
+	Status        string `json:"status"`
+	RevokedAt     string `json:"revokedat,omitempty"`
+	RevokedReason string `json:"revokedreason,omitempty"`
+}
+
+// Define the habitability flag structure, set on a house found uninhabitable
+type HabitabilityFlag struct {
+	HouseKey  string `json:"housekey"`
+	Reason    string `json:"reason"`
+	FlaggedBy string `json:"flaggedby"`
+	FlaggedAt string `json:"flaggedat"`
+}
+
+// Define the rental listing structure, a house offered for lease at a monthly rent
+type RentalListing struct {
+	HouseKey    string `json:"housekey"`
+	MonthlyRent int64  `json:"monthlyrent"`
+	ListedBy    string `json:"listedby"`
+	ListedAt    string `json:"listedat"`
+}
+
+func getOccupancyCertificate(APIstub shim.ChaincodeStubInterface, key string) (OccupancyCertificate, bool, error) {
+	certificateKey, err := APIstub.CreateCompositeKey(occupancyCertificateObjectType, []string{key})
+	if err != nil {
+		return OccupancyCertificate{}, false, err
+	}
+	certificateAsBytes, err := APIstub.GetState(certificateKey)
+	if err != nil || certificateAsBytes == nil {
+		return OccupancyCertificate{}, false, err
+	}
+	certificate := OccupancyCertificate{}
+	err = json.Unmarshal(certificateAsBytes, &certificate)
+	return certificate, true, err
+}
+
+func putOccupancyCertificate(APIstub shim.ChaincodeStubInterface, certificate OccupancyCertificate) ([]byte, error) {
+	certificateKey, err := APIstub.CreateCompositeKey(occupancyCertificateObjectType, []string{certificate.HouseKey})
+	if err != nil {
+		return nil, err
+	}
+	certificateAsBytes, _ := json.Marshal(certificate)
+	return certificateAsBytes, APIstub.PutState(certificateKey, certificateAsBytes)
+}
+
+func getHabitabilityFlag(APIstub shim.ChaincodeStubInterface, key string) (*HabitabilityFlag, error) {
+	flagKey, err := APIstub.CreateCompositeKey(habitabilityFlagObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	flagAsBytes, err := APIstub.GetState(flagKey)
+	if err != nil || flagAsBytes == nil {
+		return nil, err
+	}
+	flag := HabitabilityFlag{}
+	if err := json.Unmarshal(flagAsBytes, &flag); err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// checkOccupancyCertificate returns an error unless the house has an occupancy certificate valid at the transaction date
+func checkOccupancyCertificate(APIstub shim.ChaincodeStubInterface, key string) error {
+	certificate, found, err := getOccupancyCertificate(APIstub, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("House %s has no occupancy certificate", key)
+	}
+	if certificate.Status != certificateValid {
+		return fmt.Errorf("Occupancy certificate %s of house %s was revoked: %s", certificate.ID, key, certificate.RevokedReason)
+	}
+	expiry, err := time.Parse(dayLayout, certificate.Expiry)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(expiry.AddDate(0, 0, 1)) {
+		return fmt.Errorf("Occupancy certificate %s of house %s expired on %s", certificate.ID, key, certificate.Expiry)
+	}
+	return nil
+}
+
+// checkHabitable returns an error when the house is flagged uninhabitable
+func checkHabitable(APIstub shim.ChaincodeStubInterface, key string) error {
+	flag, err := getHabitabilityFlag(APIstub, key)
+	if err != nil || flag == nil {
+		return err
+	}
+	return fmt.Errorf("House %s was flagged uninhabitable on %s: %s", key, flag.FlaggedAt, flag.Reason)
+}
+
+/*
+ * issueOccupancyCertificate records the occupancy certificate of a house, replacing the previous one, for inspectors
+ * args: house key, certificate ID, expiry date (YYYY-MM-DD)
+ */
+func (s *SmartContract) issueOccupancyCertificate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleInspector); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("Certificate ID must not be empty")
+	}
+	expiry, err := time.Parse(dayLayout, args[2])
+	if err != nil {
+		return shim.Error("Expiry date must be formatted YYYY-MM-DD")
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkHabitable(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiry.Before(txTime.Truncate(24 * time.Hour)) {
+		return shim.Error("Expiry date must not be in the past")
+	}
+	inspector, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificateAsBytes, err := putOccupancyCertificate(APIstub, OccupancyCertificate{
+		ID:        args[1],
+		HouseKey:  args[0],
+		Inspector: inspector,
+		IssuedAt:  txTime.Format(timeLayout),
+		Expiry:    args[2],
+		Status:    certificateValid,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "occupancyCertificateIssued", certificateAsBytes)
+	return shim.Success(certificateAsBytes)
+}
+
+// revokeCertificate revokes the current occupancy certificate of the house, if any
+func revokeCertificate(APIstub shim.ChaincodeStubInterface, key string, reason string, at string) error {
+	certificate, found, err := getOccupancyCertificate(APIstub, key)
+	if err != nil || !found || certificate.Status == certificateRevoked {
+		return err
+	}
+	certificate.Status = certificateRevoked
+	certificate.RevokedAt = at
+	certificate.RevokedReason = reason
+	_, err = putOccupancyCertificate(APIstub, certificate)
+	return err
+}
+
+/*
+ * revokeOccupancyCertificate revokes the occupancy certificate of a house, for inspectors
+ * args: house key, reason
+ */
+func (s *SmartContract) revokeOccupancyCertificate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleInspector); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("Reason must not be empty")
+	}
+	certificate, found, err := getOccupancyCertificate(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found || certificate.Status == certificateRevoked {
+		return shim.Error("House " + args[0] + " has no occupancy certificate to revoke")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := revokeCertificate(APIstub, args[0], args[1], txTime.Format(timeLayout)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * flagUninhabitable flags a house as uninhabitable, for inspectors. Its occupancy certificate is revoked and its rental listing withdrawn
+ * args: house key, reason
+ */
+func (s *SmartContract) flagUninhabitable(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleInspector); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("Reason must not be empty")
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	inspector, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	flag := HabitabilityFlag{HouseKey: args[0], Reason: args[1], FlaggedBy: inspector, FlaggedAt: txTime.Format(timeLayout)}
+	flagKey, err := APIstub.CreateCompositeKey(habitabilityFlagObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	flagAsBytes, _ := json.Marshal(flag)
+	if err := APIstub.PutState(flagKey, flagAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := revokeCertificate(APIstub, args[0], args[1], flag.FlaggedAt); err != nil {
+		return shim.Error(err.Error())
+	}
+	listingKey, err := APIstub.CreateCompositeKey(rentalListingObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(listingKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "houseFlaggedUninhabitable", flagAsBytes)
+	return shim.Success(flagAsBytes)
+}
+
+/*
+ * clearHabitabilityFlag lifts the uninhabitable flag of a house once fixed, for inspectors. A new certificate must still be issued
+ * args: house key
+ */
+func (s *SmartContract) clearHabitabilityFlag(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleInspector); err != nil {
+		return shim.Error(err.Error())
+	}
+	flag, err := getHabitabilityFlag(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if flag == nil {
+		return shim.Error("House " + args[0] + " is not flagged uninhabitable")
+	}
+	flagKey, err := APIstub.CreateCompositeKey(habitabilityFlagObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(flagKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * listHouseForRent offers the house for lease at a monthly rent, for the owner or a manager with the leases permission
+ * args: house key, monthly rent
+ */
+func (s *SmartContract) listHouseForRent(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	rent, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || rent <= 0 {
+		return shim.Error("Monthly rent must be a positive number")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkHabitable(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	listedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	listingKey, err := APIstub.CreateCompositeKey(rentalListingObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	listingAsBytes, _ := json.Marshal(RentalListing{HouseKey: args[0], MonthlyRent: rent, ListedBy: listedBy, ListedAt: txTime.Format(timeLayout)})
+	if err := APIstub.PutState(listingKey, listingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(listingAsBytes)
+}
+
+/*
+ * withdrawRentalListing takes the house off the rental market, for the owner or a manager with the leases permission
+ * args: house key
+ */
+func (s *SmartContract) withdrawRentalListing(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+	listingKey, err := APIstub.CreateCompositeKey(rentalListingObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	listingAsBytes, err := APIstub.GetState(listingKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if listingAsBytes == nil {
+		return shim.Error("House " + args[0] + " is not for rent")
+	}
+	if err := APIstub.DelState(listingKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryRentalListings returns the houses offered for rent
+func (s *SmartContract) queryRentalListings(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rentalListingObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	listings := []RentalListing{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		listing := RentalListing{}
+		if err := json.Unmarshal(queryResponse.Value, &listing); err != nil {
+			return shim.Error(err.Error())
+		}
+		listings = append(listings, listing)
+	}
+
+	listingsAsBytes, _ := json.Marshal(listings)
+	return shim.Success(listingsAsBytes)
+}
+
+// queryHabitability returns the occupancy certificate and habitability flag of a house. args: house key
+func (s *SmartContract) queryHabitability(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	certificate, found, err := getOccupancyCertificate(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	flag, err := getHabitabilityFlag(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var habitability = struct {
+		Certificate *OccupancyCertificate `json:"certificate"`
+		Flag        *HabitabilityFlag     `json:"flag"`
+		Habitable   bool                  `json:"habitable"`
+		Leasable    bool                  `json:"leasable"`
+	}{Flag: flag, Habitable: flag == nil, Leasable: checkOccupancyCertificate(APIstub, args[0]) == nil}
+	if found {
+		habitability.Certificate = &certificate
+	}
+
+	habitabilityAsBytes, _ := json.Marshal(habitability)
+	return shim.Success(habitabilityAsBytes)
+}
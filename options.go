@@ -0,0 +1,274 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Options to buy
+ * The owner grants an optionee the right to buy the house at a strike price until an expiry date.
+ * The option is void as soon as the house no longer belongs to its grantor, e.g. when it was sold
+ * to someone else in the meantime.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	optionObjectType = "option"
+	houseOptionIndex = "key~option"
+)
+
+// Option statuses
+const (
+	optionLive      = "live"
+	optionExercised = "exercised"
+	optionExpired   = "expired"
+	optionVoid      = "void"
+)
+
+// Define the option structure, identified by the ID of the granting transaction
+type Option struct {
+	ID          string `json:"id"`
+	HouseKey    string `json:"housekey"`
+	Grantor     string `json:"grantor"`
+	Optionee    string `json:"optionee"`
+	StrikePrice int64  `json:"strikeprice"`
+	Expiry      string `json:"expiry"`
+	Status      string `json:"status"`
+	ExercisedAt string `json:"exercisedat,omitempty"`
+}
+
+func getOption(APIstub shim.ChaincodeStubInterface, optionID string) (Option, error) {
+	option := Option{}
+
+	optionKey, err := APIstub.CreateCompositeKey(optionObjectType, []string{optionID})
+	if err != nil {
+		return option, err
+	}
+	optionAsBytes, err := APIstub.GetState(optionKey)
+	if err != nil {
+		return option, err
+	}
+	if optionAsBytes == nil {
+		return option, fmt.Errorf("Option %s does not exist", optionID)
+	}
+
+	err = json.Unmarshal(optionAsBytes, &option)
+	return option, err
+}
+
+// currentOptionStatus returns the status of a live option at the given time, given the current house owner
+func currentOptionStatus(option Option, owner string, at time.Time) (string, error) {
+	if option.Status != optionLive {
+		return option.Status, nil
+	}
+	expiry, err := time.Parse(timeLayout, option.Expiry)
+	if err != nil {
+		return "", err
+	}
+	if !at.Before(expiry) {
+		return optionExpired, nil
+	}
+	if owner != option.Grantor {
+		return optionVoid, nil
+	}
+	return optionLive, nil
+}
+
+/*
+ * grantOption grants an option to buy the house, only the owner can do it
+ * args: house key, optionee, strike price, expiry (RFC 3339)
+ */
+func (s *SmartContract) grantOption(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[1] == "" {
+		return shim.Error("Optionee must not be empty")
+	}
+	strikePrice, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || strikePrice <= 0 {
+		return shim.Error("Strike price must be a positive number")
+	}
+	expiry, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("Expiry must be formatted as RFC 3339")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expiry.After(txTime) {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var option = Option{
+		ID:          APIstub.GetTxID(),
+		HouseKey:    args[0],
+		Grantor:     house.Owner,
+		Optionee:    args[1],
+		StrikePrice: strikePrice,
+		Expiry:      expiry.UTC().Format(timeLayout),
+		Status:      optionLive,
+	}
+
+	optionKey, err := APIstub.CreateCompositeKey(optionObjectType, []string{option.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	optionAsBytes, _ := json.Marshal(option)
+	if err := APIstub.PutState(optionKey, optionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexKey, err := APIstub.CreateCompositeKey(houseOptionIndex, []string{option.HouseKey, option.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "optionGranted", optionAsBytes)
+	return shim.Success(optionAsBytes)
+}
+
+/*
+ * exerciseOption buys the house at the strike price, only the optionee can do it before expiry. The sale goes through
+ * the pre-emption rights, co-signatures and tax like any sale: the option is exercised, the sale queued when it must wait
+ * args: option ID
+ */
+func (s *SmartContract) exerciseOption(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	option, err := getOption(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invoker != option.Optionee {
+		return shim.Error("Only " + option.Optionee + " can exercise option " + option.ID)
+	}
+
+	house, err := getHouse(APIstub, option.HouseKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	status, err := currentOptionStatus(option, house.Owner, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if status != optionLive {
+		return shim.Error("Option " + option.ID + " is " + status)
+	}
+
+	if holder := multisigHolder(house); holder != "" {
+		return shim.Error("House " + option.HouseKey + " is held by the multi-signature account " + holder)
+	}
+	if _, err := queueOrExecuteTransfer(APIstub, option.HouseKey, house, option.Optionee, reasonSale, option.StrikePrice); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	option.Status = optionExercised
+	option.ExercisedAt = txTime.Format(timeLayout)
+	optionKey, err := APIstub.CreateCompositeKey(optionObjectType, []string{option.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	optionAsBytes, _ := json.Marshal(option)
+	if err := APIstub.PutState(optionKey, optionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "optionExercised", optionAsBytes)
+	return shim.Success(optionAsBytes)
+}
+
+/*
+ * queryHouseOptions lists the options granted on the house, with their current status
+ * args: house key
+ */
+func (s *SmartContract) queryHouseOptions(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(houseOptionIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	options := []Option{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		option, err := getOption(APIstub, attributes[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if option.Status, err = currentOptionStatus(option, house.Owner, txTime); err != nil {
+			return shim.Error(err.Error())
+		}
+		options = append(options, option)
+	}
+
+	optionsAsBytes, _ := json.Marshal(options)
+	return shim.Success(optionsAsBytes)
+}
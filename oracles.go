@@ -0,0 +1,480 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Oracle attestations
+ * Admins whitelist oracle identities for the feeds they may publish: official price indexes,
+ * flood zone designations and interest rates. An oracle pushes a fact about a subject of a feed,
+ * a location or a rate name, along with the time it was observed and its signature with the key
+ * of the oracle's certificate, so that the fact can be checked off the ledger as well. Every feed
+ * validates the schema of its values. The history of the facts is kept, and other functions read
+ * the latest value attested, or the value attested at a given time, with latestAttestation and
+ * attestationAt.
+ */
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	oracleObjectType            = "oracle"
+	oracleAttestationObjectType = "oracleAttestation"
+	oracleLatestObjectType      = "oracleLatest"
+)
+
+// Feeds published by the oracles
+const (
+	feedPriceIndex   = "priceIndex"
+	feedFloodZone    = "floodZone"
+	feedInterestRate = "interestRate"
+)
+
+// Subject of the national value of a feed
+const nationalSubject = "*"
+
+// Risk classes, from the safest, also the flood zone designations
+var riskClasses = []string{"none", "low", "medium", "high"}
+
+// Schemas of the feed values: validate returns an error unless the value is well formed
+var oracleFeeds = map[string]func(value []byte) error{
+	// {"value": index value, positive}
+	feedPriceIndex: func(value []byte) error {
+		var index struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.Unmarshal(value, &index); err != nil || index.Value == nil || *index.Value <= 0 {
+			return fmt.Errorf("Price index value must be {\"value\": positive number}")
+		}
+		return nil
+	},
+	// {"zone": none, low, medium or high}
+	feedFloodZone: func(value []byte) error {
+		var designation struct {
+			Zone string `json:"zone"`
+		}
+		if err := json.Unmarshal(value, &designation); err == nil {
+			for _, zone := range riskClasses {
+				if designation.Zone == zone {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("Flood zone value must be {\"zone\": one of %v}", riskClasses)
+	},
+	// {"rate": rate in basis points, possibly negative}
+	feedInterestRate: func(value []byte) error {
+		var rate struct {
+			Rate *int64 `json:"rate"`
+		}
+		if err := json.Unmarshal(value, &rate); err != nil || rate.Rate == nil {
+			return fmt.Errorf("Interest rate value must be {\"rate\": basis points}")
+		}
+		return nil
+	},
+}
+
+// Define the oracle structure, a whitelisted identity and the feeds it publishes
+type Oracle struct {
+	ID    string   `json:"id"`
+	Feeds []string `json:"feeds"`
+}
+
+// Define the oracle attestation structure, a fact observed by an oracle
+type OracleAttestation struct {
+	Feed       string          `json:"feed"`
+	Subject    string          `json:"subject"`
+	Value      json.RawMessage `json:"value"`
+	ObservedAt string          `json:"observedat"`
+	Oracle     string          `json:"oracle"`
+	Signature  string          `json:"signature"`
+	RecordedAt string          `json:"recordedat"`
+	TxID       string          `json:"txid"`
+}
+
+func getOracle(APIstub shim.ChaincodeStubInterface, id string) (Oracle, error) {
+	oracleKey, err := APIstub.CreateCompositeKey(oracleObjectType, []string{id})
+	if err != nil {
+		return Oracle{}, err
+	}
+	oracleAsBytes, err := APIstub.GetState(oracleKey)
+	if err != nil {
+		return Oracle{}, err
+	}
+	if oracleAsBytes == nil {
+		return Oracle{}, fmt.Errorf("Oracle %s does not exist", id)
+	}
+	oracle := Oracle{}
+	err = json.Unmarshal(oracleAsBytes, &oracle)
+	return oracle, err
+}
+
+// attestationPayload returns the bytes an oracle signs: feed, subject, compact JSON value and observation time, one per line
+func attestationPayload(feed string, subject string, value []byte, observedAt string) []byte {
+	return []byte(feed + "\n" + subject + "\n" + string(value) + "\n" + observedAt)
+}
+
+// oracleSubject normalizes the subject of a feed: locations are normalized, rate names kept as they are
+func oracleSubject(feed string, subject string) string {
+	if feed == feedInterestRate || subject == nationalSubject {
+		return subject
+	}
+	return normalizeLocation(subject)
+}
+
+// latestAttestation returns the latest fact attested about the subject of the feed, nil if none
+func latestAttestation(APIstub shim.ChaincodeStubInterface, feed string, subject string) (*OracleAttestation, error) {
+	latestKey, err := APIstub.CreateCompositeKey(oracleLatestObjectType, []string{feed, oracleSubject(feed, subject)})
+	if err != nil {
+		return nil, err
+	}
+	attestationAsBytes, err := APIstub.GetState(latestKey)
+	if err != nil || attestationAsBytes == nil {
+		return nil, err
+	}
+	attestation := OracleAttestation{}
+	if err := json.Unmarshal(attestationAsBytes, &attestation); err != nil {
+		return nil, err
+	}
+	return &attestation, nil
+}
+
+// attestationAt returns the last fact about the subject of the feed observed at or before the time, nil if none
+func attestationAt(APIstub shim.ChaincodeStubInterface, feed string, subject string, at time.Time) (*OracleAttestation, error) {
+	// Attestations are keyed by observation time, so the last one read is the one in force
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(oracleAttestationObjectType, []string{feed, oracleSubject(feed, subject)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var found *OracleAttestation
+	limit := at.UTC().Format(timeLayout)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		attestation := OracleAttestation{}
+		if err := json.Unmarshal(queryResponse.Value, &attestation); err != nil {
+			return nil, err
+		}
+		if attestation.ObservedAt > limit {
+			break
+		}
+		found = &attestation
+	}
+	return found, nil
+}
+
+/*
+ * registerOracle whitelists an oracle identity for feeds, for admins. No feed removes the oracle
+ * args: oracle identity, feeds as a comma separated list (priceIndex, floodZone, interestRate)
+ */
+func (s *SmartContract) registerOracle(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Oracle identity must not be empty")
+	}
+	feeds := splitList(args[1])
+	for _, feed := range feeds {
+		if _, known := oracleFeeds[feed]; !known {
+			return shim.Error("Unknown feed " + feed)
+		}
+	}
+
+	oracleKey, err := APIstub.CreateCompositeKey(oracleObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(feeds) == 0 {
+		if err := APIstub.DelState(oracleKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	oracleAsBytes, _ := json.Marshal(Oracle{ID: args[0], Feeds: feeds})
+	if err := APIstub.PutState(oracleKey, oracleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(oracleAsBytes)
+}
+
+/*
+ * attestFact records a fact observed by the invoking oracle. The signature is the base64 ASN.1 ECDSA signature, with the
+ * key of the oracle's certificate, of the SHA-256 of the feed, subject, compact JSON value and observation time, one per line
+ * args: feed, subject (location, * for national values, or rate name), value as a JSON object, observation time (RFC 3339), signature
+ */
+func (s *SmartContract) attestFact(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oracle, err := getOracle(APIstub, invokerID)
+	if err != nil {
+		return shim.Error("Access denied. " + invokerID + " is not a whitelisted oracle")
+	}
+	whitelisted := false
+	for _, feed := range oracle.Feeds {
+		whitelisted = whitelisted || feed == args[0]
+	}
+	if !whitelisted {
+		return shim.Error("Access denied. Oracle " + invokerID + " does not publish feed " + args[0])
+	}
+
+	subject := oracleSubject(args[0], args[1])
+	if subject == "" {
+		return shim.Error("Subject must not be empty")
+	}
+	value := bytes.Buffer{}
+	if err := json.Compact(&value, []byte(args[2])); err != nil {
+		return shim.Error("Value must be a JSON object")
+	}
+	if err := oracleFeeds[args[0]](value.Bytes()); err != nil {
+		return shim.Error(err.Error())
+	}
+	observedAt, err := time.Parse(timeLayout, args[3])
+	if err != nil {
+		return shim.Error("Observation time must be formatted as RFC 3339")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if observedAt.After(txTime) {
+		return shim.Error("Observation time must not be in the future")
+	}
+
+	// The signature covers the subject as given, before its normalization
+	cert, err := getInvokerCertificate(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return shim.Error("The certificate of oracle " + invokerID + " has no ECDSA key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(args[4])
+	if err != nil {
+		return shim.Error("Signature must be base64 encoded")
+	}
+	digest := sha256.Sum256(attestationPayload(args[0], args[1], value.Bytes(), args[3]))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return shim.Error("Invalid signature of oracle " + invokerID)
+	}
+
+	var attestation = OracleAttestation{
+		Feed:       args[0],
+		Subject:    subject,
+		Value:      json.RawMessage(value.Bytes()),
+		ObservedAt: observedAt.UTC().Format(timeLayout),
+		Oracle:     invokerID,
+		Signature:  args[4],
+		RecordedAt: txTime.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+	attestationAsBytes, _ := json.Marshal(attestation)
+	attestationKey, err := APIstub.CreateCompositeKey(oracleAttestationObjectType, []string{attestation.Feed, attestation.Subject, attestation.ObservedAt, attestation.TxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(attestationKey, attestationAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// A late observation does not replace a more recent one
+	latest, err := latestAttestation(APIstub, attestation.Feed, attestation.Subject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if latest == nil || latest.ObservedAt <= attestation.ObservedAt {
+		latestKey, err := APIstub.CreateCompositeKey(oracleLatestObjectType, []string{attestation.Feed, attestation.Subject})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.PutState(latestKey, attestationAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	emitEvent(APIstub, "factAttested", attestationAsBytes)
+	return shim.Success(attestationAsBytes)
+}
+
+// queryAttestedFact returns the latest fact attested about a subject of a feed. args: feed, subject
+func (s *SmartContract) queryAttestedFact(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	attestation, err := latestAttestation(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if attestation == nil {
+		return shim.Error("No fact of feed " + args[0] + " is attested about " + args[1])
+	}
+
+	attestationAsBytes, _ := json.Marshal(attestation)
+	return shim.Success(attestationAsBytes)
+}
+
+// priceIndexAt returns the price index of the location in force at the time, falling back to the national index. Zero if none
+func priceIndexAt(APIstub shim.ChaincodeStubInterface, location string, at time.Time) (float64, error) {
+	for _, subject := range []string{location, nationalSubject} {
+		attestation, err := attestationAt(APIstub, feedPriceIndex, subject, at)
+		if err != nil {
+			return 0, err
+		}
+		if attestation != nil {
+			var index struct {
+				Value float64 `json:"value"`
+			}
+			err := json.Unmarshal(attestation.Value, &index)
+			return index.Value, err
+		}
+	}
+	return 0, nil
+}
+
+// lastSaleOf returns the last sale of the house at a disclosed price, nil if none is recorded
+func lastSaleOf(APIstub shim.ChaincodeStubInterface, key string, house House) (*SalePrice, error) {
+	var lastSale *SalePrice
+	err := forEachSale(APIstub, []string{normalizeLocation(house.Location)}, func(sale SalePrice) {
+		if sale.HouseKey == key && (lastSale == nil || sale.Timestamp > lastSale.Timestamp) {
+			copied := sale
+			lastSale = &copied
+		}
+	})
+	return lastSale, err
+}
+
+// houseValuation values the house at the price of its last sale, indexed when the price index of its location is
+// attested at the time of the sale and now. Found is false when no sale of the house is recorded
+func houseValuation(APIstub shim.ChaincodeStubInterface, key string, house House) (int64, bool, error) {
+	lastSale, err := lastSaleOf(APIstub, key, house)
+	if err != nil || lastSale == nil {
+		return 0, false, err
+	}
+	soldAt, err := time.Parse(timeLayout, lastSale.Timestamp)
+	if err != nil {
+		return 0, false, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return 0, false, err
+	}
+	location := normalizeLocation(house.Location)
+	indexAtSale, err := priceIndexAt(APIstub, location, soldAt)
+	if err != nil {
+		return 0, false, err
+	}
+	latestIndex, err := priceIndexAt(APIstub, location, txTime)
+	if err != nil {
+		return 0, false, err
+	}
+	if indexAtSale == 0 || latestIndex == 0 {
+		return lastSale.Price, true, nil
+	}
+	return int64(float64(lastSale.Price) * latestIndex / indexAtSale), true, nil
+}
+
+/*
+ * queryIndexedValuation values a house at the price of its last sale, indexed by the attested price index of its
+ * location (or the national one) from the time of the sale to the time of the query
+ * args: house key
+ */
+func (s *SmartContract) queryIndexedValuation(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location := normalizeLocation(house.Location)
+	lastSale, err := lastSaleOf(APIstub, args[0], house)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lastSale == nil {
+		return shim.Error("No sale of house " + args[0] + " at a disclosed price is recorded")
+	}
+
+	soldAt, err := time.Parse(timeLayout, lastSale.Timestamp)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexAtSale, err := priceIndexAt(APIstub, location, soldAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	latestIndex, err := priceIndexAt(APIstub, location, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if indexAtSale == 0 || latestIndex == 0 {
+		return shim.Error("No price index is attested for " + location + " at the time of the sale")
+	}
+
+	var valuation = struct {
+		HouseKey      string  `json:"housekey"`
+		LastSalePrice int64   `json:"lastsaleprice"`
+		LastSaleAt    string  `json:"lastsaleat"`
+		IndexAtSale   float64 `json:"indexatsale"`
+		LatestIndex   float64 `json:"latestindex"`
+		Valuation     int64   `json:"valuation"`
+	}{
+		HouseKey:      args[0],
+		LastSalePrice: lastSale.Price,
+		LastSaleAt:    lastSale.Timestamp,
+		IndexAtSale:   indexAtSale,
+		LatestIndex:   latestIndex,
+		Valuation:     int64(float64(lastSale.Price) * latestIndex / indexAtSale),
+	}
+	valuationAsBytes, _ := json.Marshal(valuation)
+	return shim.Success(valuationAsBytes)
+}
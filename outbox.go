@@ -0,0 +1,705 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Notification outbox
+ * A transaction emits a single chaincode event, the last one set, and event hub clients miss the
+ * events of the blocks committed while they were disconnected. Integration middleware subscribes
+ * instead to an outbox. Every event emitted by a successful transaction that an outbox is interested
+ * in is written once to the notification log, under the timestamp of its transaction, without
+ * touching the outboxes, so that concurrent transactions do not conflict on them. The subscriber
+ * reads the log from its cursor, the sequence numbers of its notifications being assigned as they
+ * are read, and acknowledges what it processed, which moves its cursor, so that every notification
+ * is consumed once whatever the disconnections. Notifications are only read once settled, a while
+ * after their transaction, for a transaction committed late with an earlier timestamp to be read
+ * in order. A read covers a bounded number of hours of the log and returns a bookmark to resume
+ * from, which the subscriber also acknowledges to move its cursor past hours without notifications.
+ * Outboxes are indexed by event, so that a transaction only reads the index entries of the events
+ * it emits. pruneNotifications removes the notifications every outbox acknowledged.
+ */
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	outboxObjectType       = "outbox"
+	outboxCursorObjectType = "outboxCursor"
+	outboxEventIndex       = "outboxEvent"
+	notificationObjectType = "notification"
+)
+
+// Event of the index entries of the outboxes notified of every event
+const allOutboxEvents = "*"
+
+// Key of the hour of the oldest notifications not pruned
+const notificationHorizonKey = "notificationHorizon"
+
+// Maximum number of notifications read at once
+const maxOutboxRead = 100
+
+// Maximum number of hours of the log read at once
+const maxOutboxHoursRead = 24
+
+// Maximum number of notifications pruned at once
+const maxNotificationsPruned = 1000
+
+// Delay after which the notifications of a transaction are read
+const notificationSettleDelay = 5 * time.Minute
+
+// The log is partitioned by hour, its timestamps are of fixed width so that they sort in time order
+const (
+	notificationBucketLayout = "2006-01-02T15"
+	notificationTimeLayout   = "2006-01-02T15:04:05.000000000Z"
+)
+
+// Define the outbox structure: the events a subscriber is notified of, none for every event
+type Outbox struct {
+	Subscriber string   `json:"subscriber"`
+	Events     []string `json:"events"`
+}
+
+// Define the outbox cursor structure: the last notification acknowledged by a subscriber, from the opening of its outbox
+type OutboxCursor struct {
+	Subscriber string `json:"subscriber"`
+	AckedSeq   int64  `json:"ackedseq"`
+	Position   string `json:"position"`
+	PositionAt string `json:"positionat"`
+}
+
+// Define the notification structure, an event emitted by a transaction as recorded in the log, numbered for a subscriber
+type Notification struct {
+	Seq      int64  `json:"seq,omitempty"`
+	Event    string `json:"event"`
+	Payload  []byte `json:"payload"`
+	Function string `json:"function"`
+	TxID     string `json:"txid"`
+	At       string `json:"at"`
+	key      string
+}
+
+type emittedEvent struct {
+	name    string
+	payload []byte
+}
+
+// emitEvent sets the chaincode event of the transaction and keeps it for the outboxes, delivered once the transaction succeeded
+func emitEvent(APIstub shim.ChaincodeStubInterface, name string, payload []byte) {
+	APIstub.SetEvent(name, payload)
+	if transaction := transactionOf(APIstub); transaction != nil {
+		transaction.emitted = append(transaction.emitted, emittedEvent{name: name, payload: payload})
+	}
+}
+
+func notificationKey(APIstub shim.ChaincodeStubInterface, at time.Time, txID string, index string) (string, error) {
+	at = at.UTC()
+	return APIstub.CreateCompositeKey(notificationObjectType, []string{at.Format(notificationBucketLayout), at.Format(notificationTimeLayout), txID, index})
+}
+
+func getOutbox(APIstub shim.ChaincodeStubInterface, subscriber string) (Outbox, error) {
+	outboxKey, err := APIstub.CreateCompositeKey(outboxObjectType, []string{subscriber})
+	if err != nil {
+		return Outbox{}, err
+	}
+	outboxAsBytes, err := APIstub.GetState(outboxKey)
+	if err != nil {
+		return Outbox{}, err
+	}
+	if outboxAsBytes == nil {
+		return Outbox{}, fmt.Errorf("Outbox of %s does not exist", subscriber)
+	}
+	outbox := Outbox{}
+	err = json.Unmarshal(outboxAsBytes, &outbox)
+	return outbox, err
+}
+
+func putOutbox(APIstub shim.ChaincodeStubInterface, outbox Outbox) ([]byte, error) {
+	outboxKey, err := APIstub.CreateCompositeKey(outboxObjectType, []string{outbox.Subscriber})
+	if err != nil {
+		return nil, err
+	}
+	outboxAsBytes, _ := json.Marshal(outbox)
+	return outboxAsBytes, APIstub.PutState(outboxKey, outboxAsBytes)
+}
+
+// outboxEventKeys returns the keys of the event index entries of the outbox
+func outboxEventKeys(APIstub shim.ChaincodeStubInterface, outbox Outbox) ([]string, error) {
+	events := outbox.Events
+	if len(events) == 0 {
+		events = []string{allOutboxEvents}
+	}
+	keys := []string{}
+	for _, event := range events {
+		key, err := APIstub.CreateCompositeKey(outboxEventIndex, []string{event, outbox.Subscriber})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// indexOutbox replaces the event index entries of the previous version of the outbox (nil for a new outbox)
+func indexOutbox(APIstub shim.ChaincodeStubInterface, previous *Outbox, outbox *Outbox) error {
+	previousKeys, newKeys := map[string]bool{}, map[string]bool{}
+	for _, entry := range []struct {
+		outbox *Outbox
+		keys   map[string]bool
+	}{{previous, previousKeys}, {outbox, newKeys}} {
+		if entry.outbox == nil {
+			continue
+		}
+		keys, err := outboxEventKeys(APIstub, *entry.outbox)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			entry.keys[key] = true
+		}
+	}
+	for _, key := range sortedKeys(previousKeys) {
+		if !newKeys[key] {
+			if err := APIstub.DelState(key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range sortedKeys(newKeys) {
+		if err := APIstub.PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexOutboxes writes the event index entries of every outbox, for the outboxes opened before they were indexed
+func reindexOutboxes(APIstub shim.ChaincodeStubInterface) error {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(outboxObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		outbox := Outbox{}
+		if err := json.Unmarshal(queryResponse.Value, &outbox); err != nil {
+			return err
+		}
+		if err := indexOutbox(APIstub, nil, &outbox); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOutboxFor tells whether an outbox is notified of the event, its index entries only being read
+func hasOutboxFor(APIstub shim.ChaincodeStubInterface, event string) (bool, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(outboxEventIndex, []string{event})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
+
+func getOutboxCursor(APIstub shim.ChaincodeStubInterface, subscriber string) (OutboxCursor, error) {
+	cursorKey, err := APIstub.CreateCompositeKey(outboxCursorObjectType, []string{subscriber})
+	if err != nil {
+		return OutboxCursor{}, err
+	}
+	cursorAsBytes, err := APIstub.GetState(cursorKey)
+	if err != nil {
+		return OutboxCursor{}, err
+	}
+	if cursorAsBytes == nil {
+		return OutboxCursor{}, fmt.Errorf("Outbox of %s does not exist", subscriber)
+	}
+	cursor := OutboxCursor{}
+	err = json.Unmarshal(cursorAsBytes, &cursor)
+	return cursor, err
+}
+
+func putOutboxCursor(APIstub shim.ChaincodeStubInterface, cursor OutboxCursor) ([]byte, error) {
+	cursorKey, err := APIstub.CreateCompositeKey(outboxCursorObjectType, []string{cursor.Subscriber})
+	if err != nil {
+		return nil, err
+	}
+	cursorAsBytes, _ := json.Marshal(cursor)
+	return cursorAsBytes, APIstub.PutState(cursorKey, cursorAsBytes)
+}
+
+// deliverNotifications writes the events emitted by the transaction that an outbox is interested in to the notification log.
+// Only the index entries of the emitted events are read, they change when subscribers open or close their outboxes
+func deliverNotifications(APIstub shim.ChaincodeStubInterface, function string) error {
+	transaction := transactionOf(APIstub)
+	if transaction == nil || len(transaction.emitted) == 0 {
+		return nil
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+
+	everyEvent, err := hasOutboxFor(APIstub, allOutboxEvents)
+	if err != nil {
+		return err
+	}
+	subscribed := map[string]bool{}
+	for i, event := range transaction.emitted {
+		if !everyEvent {
+			known, found := subscribed[event.name]
+			if !found {
+				if known, err = hasOutboxFor(APIstub, event.name); err != nil {
+					return err
+				}
+				subscribed[event.name] = known
+			}
+			if !known {
+				continue
+			}
+		}
+		notificationAsBytes, _ := json.Marshal(Notification{
+			Event:    event.name,
+			Payload:  event.payload,
+			Function: function,
+			TxID:     APIstub.GetTxID(),
+			At:       txTime.UTC().Format(notificationTimeLayout),
+		})
+		key, err := notificationKey(APIstub, txTime, APIstub.GetTxID(), fmt.Sprintf("%04d", i))
+		if err != nil {
+			return err
+		}
+		if err := APIstub.PutState(key, notificationAsBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// listNotifications returns up to limit settled notifications of the outbox after the cursor, numbered from the last one
+// acknowledged, reading at most maxOutboxHoursRead hours of the log. It also returns the position the read stopped at,
+// to resume from, and whether every settled notification was read
+func listNotifications(APIstub shim.ChaincodeStubInterface, outbox Outbox, cursor OutboxCursor, limit int) ([]Notification, OutboxCursor, bool, error) {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, cursor, false, err
+	}
+	settledAt := txTime.UTC().Add(-notificationSettleDelay)
+	settled := settledAt.Format(notificationTimeLayout)
+	positionAt, err := time.Parse(notificationTimeLayout, cursor.PositionAt)
+	if err != nil {
+		return nil, cursor, false, err
+	}
+
+	notifications := []Notification{}
+	next := cursor
+	bucket := positionAt.Truncate(time.Hour)
+	for hours := 0; bucket.Format(notificationTimeLayout) <= settled; hours, bucket = hours+1, bucket.Add(time.Hour) {
+		if hours == maxOutboxHoursRead {
+			// The next read starts with this hour, before its first notification
+			if next.Position, err = notificationKey(APIstub, bucket, "", ""); err != nil {
+				return nil, cursor, false, err
+			}
+			next.PositionAt = bucket.Format(notificationTimeLayout)
+			return notifications, next, false, nil
+		}
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(notificationObjectType, []string{bucket.Format(notificationBucketLayout)})
+		if err != nil {
+			return nil, cursor, false, err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, cursor, false, err
+			}
+			if queryResponse.Key <= cursor.Position {
+				continue
+			}
+			notification := Notification{}
+			if err := json.Unmarshal(queryResponse.Value, &notification); err != nil {
+				resultsIterator.Close()
+				return nil, cursor, false, err
+			}
+			if notification.At > settled {
+				break
+			}
+			if len(outbox.Events) > 0 && !containsString(outbox.Events, notification.Event) {
+				continue
+			}
+			notification.Seq, notification.key = next.AckedSeq+1, queryResponse.Key
+			notifications = append(notifications, notification)
+			next = OutboxCursor{Subscriber: cursor.Subscriber, AckedSeq: notification.Seq, Position: notification.key, PositionAt: notification.At}
+			if len(notifications) == limit {
+				resultsIterator.Close()
+				return notifications, next, false, nil
+			}
+		}
+		resultsIterator.Close()
+	}
+
+	// Without notifications of the outbox, the next read starts with the notifications not settled yet
+	if len(notifications) == 0 {
+		position, err := notificationKey(APIstub, settledAt, "", "")
+		if err != nil {
+			return nil, cursor, false, err
+		}
+		if position > next.Position {
+			next.Position, next.PositionAt = position, settled
+		}
+	}
+	return notifications, next, true, nil
+}
+
+// outboxBookmark returns the bookmark of the position, the sequence number reached first
+func outboxBookmark(position OutboxCursor) string {
+	return strconv.FormatInt(position.AckedSeq, 10) + ":" + hex.EncodeToString([]byte(position.Position)) + ":" + position.PositionAt
+}
+
+// parseOutboxBookmark returns the position of the bookmark, which must not be before the cursor
+func parseOutboxBookmark(cursor OutboxCursor, bookmark string) (OutboxCursor, error) {
+	parts := strings.SplitN(bookmark, ":", 3)
+	if len(parts) != 3 {
+		return cursor, fmt.Errorf("Invalid bookmark %s", bookmark)
+	}
+	seq, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return cursor, fmt.Errorf("Invalid bookmark %s", bookmark)
+	}
+	position, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return cursor, fmt.Errorf("Invalid bookmark %s", bookmark)
+	}
+	if _, err := time.Parse(notificationTimeLayout, parts[2]); err != nil {
+		return cursor, fmt.Errorf("Invalid bookmark %s", bookmark)
+	}
+	if seq < cursor.AckedSeq || string(position) < cursor.Position {
+		return cursor, fmt.Errorf("Bookmark %s is before the cursor of the outbox", bookmark)
+	}
+	return OutboxCursor{Subscriber: cursor.Subscriber, AckedSeq: seq, Position: string(position), PositionAt: parts[2]}, nil
+}
+
+/*
+ * openOutbox subscribes the invoker to the events, or changes the events of its outbox, for subscribers.
+ * A new outbox is notified of the events emitted from its opening
+ * args: event names as a comma separated list (empty for every event)
+ */
+func (s *SmartContract) openOutbox(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleSubscriber); err != nil {
+		return shim.Error(err.Error())
+	}
+	subscriber, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getOutboxCursor(APIstub, subscriber); err != nil {
+		txTime, err := getTxTime(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// The cursor starts before the notifications of the transactions with the same timestamp
+		position, err := notificationKey(APIstub, txTime, "", "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err := putOutboxCursor(APIstub, OutboxCursor{Subscriber: subscriber, Position: position, PositionAt: txTime.UTC().Format(notificationTimeLayout)}); err != nil {
+			return shim.Error(err.Error())
+		}
+		horizonAsBytes, err := APIstub.GetState(notificationHorizonKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if horizonAsBytes == nil {
+			if err := APIstub.PutState(notificationHorizonKey, []byte(txTime.UTC().Format(notificationBucketLayout))); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	var previous *Outbox
+	if existing, err := getOutbox(APIstub, subscriber); err == nil {
+		previous = &existing
+	}
+	outbox := Outbox{Subscriber: subscriber, Events: splitList(args[0])}
+	if err := indexOutbox(APIstub, previous, &outbox); err != nil {
+		return shim.Error(err.Error())
+	}
+	outboxAsBytes, err := putOutbox(APIstub, outbox)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(outboxAsBytes)
+}
+
+// closeOutbox unsubscribes the invoker, its notifications not acknowledged being dropped
+func (s *SmartContract) closeOutbox(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	subscriber, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	outbox, err := getOutbox(APIstub, subscriber)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := indexOutbox(APIstub, &outbox, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, objectType := range []string{outboxObjectType, outboxCursorObjectType} {
+		key, err := APIstub.CreateCompositeKey(objectType, []string{subscriber})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.DelState(key); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * readOutbox returns the notifications of the invoker not acknowledged yet, in sequence order, up to 24 hours of the log
+ * at once. More tells whether others follow, read from the returned bookmark
+ * args: maximum number of notifications (up to 100), [bookmark returned by the previous read]
+ */
+func (s *SmartContract) readOutbox(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 || limit > maxOutboxRead {
+		return shim.Error(fmt.Sprintf("Maximum number of notifications must be a number from 1 to %d", maxOutboxRead))
+	}
+	subscriber, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	outbox, err := getOutbox(APIstub, subscriber)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cursor, err := getOutboxCursor(APIstub, subscriber)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	from := cursor
+	if len(args) > 1 && args[1] != "" {
+		if from, err = parseOutboxBookmark(cursor, args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// One more notification tells whether others follow
+	notifications, next, complete, err := listNotifications(APIstub, outbox, from, limit+1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		last := notifications[limit-1]
+		next = OutboxCursor{Subscriber: subscriber, AckedSeq: last.Seq, Position: last.key, PositionAt: last.At}
+	}
+
+	var result = struct {
+		AckedSeq      int64          `json:"ackedseq"`
+		More          bool           `json:"more"`
+		Bookmark      string         `json:"bookmark"`
+		Notifications []Notification `json:"notifications"`
+	}{AckedSeq: cursor.AckedSeq, More: !complete, Bookmark: outboxBookmark(next), Notifications: notifications}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+/*
+ * ackNotifications acknowledges the notifications of the invoker up to a sequence number, moving its cursor past them.
+ * With the bookmark the notifications were read from, they are looked for from the bookmark on, and acknowledging the
+ * sequence number of the bookmark moves the cursor to it, past the hours read without notifications. Acknowledging
+ * again a sequence number already acknowledged does nothing
+ * args: sequence number, [bookmark the notifications were read from]
+ */
+func (s *SmartContract) ackNotifications(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	seq, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || seq < 0 {
+		return shim.Error("Sequence number must be a positive number")
+	}
+	subscriber, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	outbox, err := getOutbox(APIstub, subscriber)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cursor, err := getOutboxCursor(APIstub, subscriber)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	from := cursor
+	if len(args) > 1 && args[1] != "" {
+		if from, err = parseOutboxBookmark(cursor, args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+		if seq < from.AckedSeq && seq > cursor.AckedSeq {
+			return shim.Error(fmt.Sprintf("Notification %d is before bookmark %s", seq, args[1]))
+		}
+	}
+
+	if seq > from.AckedSeq {
+		notifications, next, complete, err := listNotifications(APIstub, outbox, from, int(seq-from.AckedSeq))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if int64(len(notifications)) < seq-from.AckedSeq {
+			if !complete {
+				return shim.Error(fmt.Sprintf("Outbox of %s has no notification %d up to bookmark %s", subscriber, seq, outboxBookmark(next)))
+			}
+			return shim.Error(fmt.Sprintf("Outbox of %s has no notification %d, the last is %d", subscriber, seq, next.AckedSeq))
+		}
+		cursor = next
+	} else if seq == from.AckedSeq {
+		cursor = from
+	}
+	cursorAsBytes, err := putOutboxCursor(APIstub, cursor)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(cursorAsBytes)
+}
+
+// pruneNotifications removes from the log the notifications acknowledged by every outbox, up to 1000 at once
+func (s *SmartContract) pruneNotifications(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	horizonAsBytes, err := APIstub.GetState(notificationHorizonKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if horizonAsBytes == nil {
+		return shim.Success([]byte("0"))
+	}
+	horizon, err := time.Parse(notificationBucketLayout, string(horizonAsBytes))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Without outboxes, every settled notification is pruned
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	settledAt := txTime.UTC().Add(-notificationSettleDelay)
+	oldest, err := notificationKey(APIstub, settledAt, "", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oldestAt := settledAt
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(outboxCursorObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		cursor := OutboxCursor{}
+		if err := json.Unmarshal(queryResponse.Value, &cursor); err != nil {
+			return shim.Error(err.Error())
+		}
+		if cursor.Position < oldest {
+			oldest = cursor.Position
+			if oldestAt, err = time.Parse(notificationTimeLayout, cursor.PositionAt); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	pruned := 0
+	bucket := horizon
+	for ; !bucket.After(oldestAt) && pruned < maxNotificationsPruned; bucket = bucket.Add(time.Hour) {
+		bucketIterator, err := APIstub.GetStateByPartialCompositeKey(notificationObjectType, []string{bucket.Format(notificationBucketLayout)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		complete := true
+		for bucketIterator.HasNext() {
+			queryResponse, err := bucketIterator.Next()
+			if err != nil {
+				bucketIterator.Close()
+				return shim.Error(err.Error())
+			}
+			// Keys are listed in order, the remaining notifications are not acknowledged by every outbox
+			if queryResponse.Key > oldest || pruned == maxNotificationsPruned {
+				complete = false
+				break
+			}
+			if err := APIstub.DelState(queryResponse.Key); err != nil {
+				bucketIterator.Close()
+				return shim.Error(err.Error())
+			}
+			pruned++
+		}
+		bucketIterator.Close()
+		if !complete {
+			break
+		}
+	}
+	// Notifications may still be written to the hour of the oldest cursor
+	if oldestBucket := oldestAt.Truncate(time.Hour); bucket.After(oldestBucket) {
+		bucket = oldestBucket
+	}
+	if err := APIstub.PutState(notificationHorizonKey, []byte(bucket.Format(notificationBucketLayout))); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.Itoa(pruned)))
+}
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Outbox tests
+ * Delivery to the outboxes indexed by event, and reads of the log resumed from their bookmark.
+ */
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Define the outbox read structure, as returned by readOutbox
+type outboxRead struct {
+	More          bool           `json:"more"`
+	Bookmark      string         `json:"bookmark"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// inTransactionAt runs the function in a transaction of its own timestamped at the time
+func (ledger *mockLedger) inTransactionAt(tb testing.TB, at time.Time, run func(APIstub shim.ChaincodeStubInterface)) {
+	ledger.inTransaction(func(APIstub shim.ChaincodeStubInterface) {
+		timestamp, err := ptypes.TimestampProto(at)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ledger.stub.TxTimestamp = timestamp
+		run(APIstub)
+	})
+}
+
+// emitAt emits the events in a transaction timestamped at the time, and delivers them
+func (ledger *mockLedger) emitAt(tb testing.TB, at time.Time, events ...string) {
+	ledger.inTransactionAt(tb, at, func(APIstub shim.ChaincodeStubInterface) {
+		for _, event := range events {
+			emitEvent(APIstub, event, nil)
+		}
+		if err := deliverNotifications(APIstub, "test"); err != nil {
+			tb.Fatal(err)
+		}
+	})
+}
+
+func (ledger *mockLedger) notificationCount() int {
+	count := 0
+	for key := range ledger.stub.State {
+		if strings.HasPrefix(key, "\x00"+notificationObjectType+"\x00") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestOutboxesAreNotifiedOfTheirEventsOnly(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.assignRoles(t, ledger.owner, roleSubscriber)
+	ledger.invoke(t, ledger.owner, "openOutbox", "houseCreated")
+
+	ledger.emitAt(t, time.Now(), "houseCreated", "houseSold")
+	if count := ledger.notificationCount(); count != 1 {
+		t.Errorf("%d notifications written, expected the one of houseCreated", count)
+	}
+
+	ledger.invoke(t, ledger.owner, "openOutbox", "houseSold")
+	ledger.emitAt(t, time.Now(), "houseCreated")
+	ledger.invoke(t, ledger.owner, "closeOutbox")
+	ledger.emitAt(t, time.Now(), "houseSold")
+	if count := ledger.notificationCount(); count != 1 {
+		t.Errorf("%d notifications written, expected none for the events of closed outboxes", count)
+	}
+}
+
+func TestReadOutboxResumesFromItsBookmark(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.assignRoles(t, ledger.owner, roleSubscriber)
+	ledger.invoke(t, ledger.owner, "openOutbox", "")
+	openedAt := time.Now()
+	ledger.emitAt(t, openedAt.Add(30*time.Hour), "houseCreated")
+
+	readAt := openedAt.Add(31 * time.Hour)
+	read := func(args ...string) outboxRead {
+		result := outboxRead{}
+		ledger.inTransactionAt(t, readAt, func(APIstub shim.ChaincodeStubInterface) {
+			response := new(SmartContract).readOutbox(APIstub, append([]string{"10"}, args...))
+			if err := json.Unmarshal(response.Payload, &result); err != nil {
+				t.Fatal(response.Message)
+			}
+		})
+		return result
+	}
+	first := read()
+	if !first.More || len(first.Notifications) != 0 {
+		t.Fatalf("First read returned %+v, expected the first 24 hours without notifications", first)
+	}
+	second := read(first.Bookmark)
+	if second.More || len(second.Notifications) != 1 || second.Notifications[0].Seq != 1 {
+		t.Fatalf("Read from the bookmark returned %+v, expected the notification", second)
+	}
+
+	// Acknowledging the hours read without notifications moves the cursor past them
+	ledger.inTransactionAt(t, readAt, func(APIstub shim.ChaincodeStubInterface) {
+		if response := new(SmartContract).ackNotifications(APIstub, []string{"0", first.Bookmark}); response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+	})
+	if third := read(); third.More || len(third.Notifications) != 1 {
+		t.Errorf("Read after the acknowledgement returned %+v, expected the notification", third)
+	}
+}
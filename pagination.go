@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Page number pagination
+ * Bookmarks only allow to move to the next page, while some clients need page numbers and the
+ * total count of the results. Houses are filtered on one dimension, the total count being read
+ * from the house counters. The index entries are read up to the requested page, every one of them
+ * when the houses are sorted, and the houses of the requested page only are read.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const statusIndex = "status~key"
+
+// House statuses, derived from the house record
+const (
+	statusRegistered = "registered"
+	statusListed     = "listed"
+	statusDisputed   = "disputed"
+)
+
+// Maximum number of houses of a page
+const maxPageSize = 200
+
+// houseStatus returns the status of the house, a dispute prevailing over a listing
+func houseStatus(house House) string {
+	if house.DisputeID != "" {
+		return statusDisputed
+	}
+	if house.AskingPrice > 0 {
+		return statusListed
+	}
+	return statusRegistered
+}
+
+func statusEntries(house House) [][]string {
+	return [][]string{{houseStatus(house)}}
+}
+
+// dimensionHouseKeys returns the keys of the first houses, up to limit (0 for no limit), matching the value of the
+// dimension, every house for an empty dimension
+func dimensionHouseKeys(APIstub shim.ChaincodeStubInterface, dimension string, value string, limit int) ([]string, error) {
+	switch dimension {
+	case "":
+		resultsIterator, err := APIstub.GetStateByRange(houseStartKey, houseEndKey)
+		if err != nil {
+			return nil, err
+		}
+		defer resultsIterator.Close()
+		keys := []string{}
+		for resultsIterator.HasNext() && (limit == 0 || len(keys) < limit) {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, queryResponse.Key)
+		}
+		return keys, nil
+	case "location":
+		location, err := resolveLocationAlias(APIstub, value)
+		if err != nil {
+			return nil, err
+		}
+		return queryIndexedHouseKeysUpTo(APIstub, locationIndex, append(locationIndexAttributes(normalizeLocation(location)), ""), limit)
+	case "status":
+		return queryIndexedHouseKeysUpTo(APIstub, statusIndex, []string{value}, limit)
+	case "owner":
+		return queryIndexedHouseKeysUpTo(APIstub, ownerIndex, []string{value}, limit)
+	}
+	return nil, fmt.Errorf("Unknown dimension %q, expecting location, status or owner", dimension)
+}
+
+/*
+ * queryHousesPage returns one numbered page of the houses matching a filter, with the total count, in key order or sorted
+ * args: dimension (location, status, owner or empty for every house), value, page number (from 1), page size,
+ * sort field (year, size, price or lastUpdated, optional), sort order (asc or desc, optional)
+ */
+func (s *SmartContract) queryHousesPage(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 4 || len(args) > 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 to 6")
+	}
+	page, err := strconv.Atoi(args[2])
+	if err != nil || page <= 0 {
+		return shim.Error("Page number must be a positive number")
+	}
+	pageSize, err := strconv.Atoi(args[3])
+	if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+		return shim.Error(fmt.Sprintf("Page size must be a number from 1 to %d", maxPageSize))
+	}
+
+	_, total, err := countHouses(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result = struct {
+		TotalCount int           `json:"totalCount"`
+		Page       int           `json:"page"`
+		PageSize   int           `json:"pageSize"`
+		PageCount  int           `json:"pageCount"`
+		Results    []houseResult `json:"results"`
+	}{TotalCount: total, Page: page, PageSize: pageSize, PageCount: (total + pageSize - 1) / pageSize, Results: []houseResult{}}
+
+	// Past the last page, no index entry is read
+	if page <= result.PageCount {
+		sorted := len(args) > 4 && args[4] != ""
+		limit := page * pageSize
+		if sorted {
+			limit = 0
+		}
+		keys, err := dimensionHouseKeys(APIstub, args[0], args[1], limit)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if sorted {
+			order := ""
+			if len(args) > 5 {
+				order = args[5]
+			}
+			if keys, err = sortHouseKeys(APIstub, keys, args[4], order); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		first, last := (page-1)*pageSize, page*pageSize
+		if first > len(keys) {
+			first = len(keys)
+		}
+		if last > len(keys) {
+			last = len(keys)
+		}
+		if result.Results, err = getHouseResults(APIstub, keys[first:last]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
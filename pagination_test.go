@@ -0,0 +1,61 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Page number pagination tests
+ * Pages of the houses of a dimension, counted from the house counters.
+ */
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Define the house page structure, as returned by queryHousesPage
+type housePage struct {
+	TotalCount int           `json:"totalCount"`
+	PageCount  int           `json:"pageCount"`
+	Results    []houseResult `json:"results"`
+}
+
+func TestHousesPageIsCountedFromTheCounters(t *testing.T) {
+	ledger := newMockLedger(t)
+	for _, key := range []string{"HOUSE1", "HOUSE2", "HOUSE3"} {
+		ledger.invoke(t, ledger.owner, "createHouse", key, "2004", "1200", "Paris", "alice")
+	}
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE4", "2004", "1200", "Lyon", "alice")
+
+	for _, test := range []struct {
+		dimension, value, page string
+		total, pages, results  int
+	}{
+		{"location", "paris", "2", 3, 2, 1},
+		{"location", "Paris", "3", 3, 2, 0},
+		{"", "", "1", 4, 2, 2},
+		{"owner", "bob", "1", 0, 0, 0},
+	} {
+		page := housePage{}
+		if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "queryHousesPage", test.dimension, test.value, test.page, "2"), &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.TotalCount != test.total || page.PageCount != test.pages || len(page.Results) != test.results {
+			t.Errorf("Page %s of %s %q is %+v, expected %d houses in %d pages and %d results", test.page, test.dimension, test.value, page, test.total, test.pages, test.results)
+		}
+	}
+}
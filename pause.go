@@ -0,0 +1,165 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Emergency pause
+ * Admins pause the contract during an incident. While it is paused, the functions writing to the
+ * ledger fail with a MAINTENANCE error and the queries keep working. Whether a function writes
+ * depends on its arguments and the state, so while paused every function runs on a simulating
+ * stub first: its response is returned only when it wrote nothing. The access records of the
+ * reads of personal details are not state changes: a function writing only those runs for real,
+ * so that the consented reads keep working and stay logged.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const contractPauseKey = "contractPause"
+
+// Prefix of the errors of the functions unavailable during maintenance
+const maintenanceError = "MAINTENANCE"
+
+// Functions available while the contract is paused
+var pauseExemptFunctions = map[string]bool{
+	"pauseContract":  true,
+	"resumeContract": true,
+}
+
+// Define the pause structure, the state of a paused contract
+type Pause struct {
+	Reason   string `json:"reason"`
+	PausedBy string `json:"pausedby"`
+	Since    string `json:"since"`
+}
+
+// getPause returns the pause of the contract, nil when it is not paused
+func getPause(APIstub shim.ChaincodeStubInterface) (*Pause, error) {
+	pauseAsBytes, err := APIstub.GetState(contractPauseKey)
+	if err != nil || pauseAsBytes == nil {
+		return nil, err
+	}
+	pause := Pause{}
+	if err := json.Unmarshal(pauseAsBytes, &pause); err != nil {
+		return nil, err
+	}
+	return &pause, nil
+}
+
+// invokeUnlessPaused runs the function, failing when it writes to the ledger of a paused contract
+func (s *SmartContract) invokeUnlessPaused(APIstub shim.ChaincodeStubInterface, function string, args []string) sc.Response {
+	pause, err := getPause(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pause == nil || pauseExemptFunctions[function] {
+		return s.invokeIdempotent(APIstub, function, args)
+	}
+
+	simulationStub := newSimulationStub(APIstub)
+	response := s.invokeIdempotent(simulationStub, function, args)
+	if len(simulationStub.privateWrites) > 0 {
+		return shim.Error(fmt.Sprintf("%s: the contract is paused since %s: %s", maintenanceError, pause.Since, pause.Reason))
+	}
+	accessRecordPrefix, err := APIstub.CreateCompositeKey(accessRecordObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for key := range simulationStub.writes {
+		if !strings.HasPrefix(key, accessRecordPrefix) {
+			return shim.Error(fmt.Sprintf("%s: the contract is paused since %s: %s", maintenanceError, pause.Since, pause.Reason))
+		}
+	}
+	if len(simulationStub.writes) > 0 {
+		return s.invokeIdempotent(APIstub, function, args)
+	}
+	return response
+}
+
+/*
+ * pauseContract pauses the contract, for admins. Pausing a paused contract replaces the reason
+ * args: reason
+ */
+func (s *SmartContract) pauseContract(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Reason must not be empty")
+	}
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pauseAsBytes, _ := json.Marshal(Pause{Reason: args[0], PausedBy: invokerID, Since: txTime.Format(timeLayout)})
+	if err := APIstub.PutState(contractPauseKey, pauseAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "contractPaused", pauseAsBytes)
+	return shim.Success(pauseAsBytes)
+}
+
+// resumeContract resumes a paused contract, for admins
+func (s *SmartContract) resumeContract(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	pause, err := getPause(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pause == nil {
+		return shim.Error("The contract is not paused")
+	}
+	if err := APIstub.DelState(contractPauseKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pauseAsBytes, _ := json.Marshal(pause)
+	emitEvent(APIstub, "contractResumed", pauseAsBytes)
+	return shim.Success(nil)
+}
+
+// queryPause returns the pause of the contract, null when it is not paused
+func (s *SmartContract) queryPause(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	pause, err := getPause(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pauseAsBytes, _ := json.Marshal(pause)
+	return shim.Success(pauseAsBytes)
+}
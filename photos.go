@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* House photos
+ * The photos of a house are stored on IPFS. The ledger only keeps their content identifier (CID)
+ * along with the metadata a gallery needs, each photo being a record of its own.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const photoObjectType = "photo"
+
+// Define the photo structure, an IPFS reference attached to a house
+type Photo struct {
+	CID     string `json:"cid"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Caption string `json:"caption,omitempty"`
+	Hash    string `json:"hash"`
+	AddedBy string `json:"addedby"`
+	AddedAt string `json:"addedat"`
+}
+
+// Alphabets of the CIDv0 (base58btc) and CIDv1 (base32 lower case, "b" multibase prefix) string forms
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	base32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+// validateCID checks the string form of a CID, without decoding its multihash
+func validateCID(cid string) error {
+	var alphabet, digits string
+	if len(cid) == 46 && strings.HasPrefix(cid, "Qm") {
+		alphabet, digits = base58Alphabet, cid
+	} else if len(cid) > 1 && strings.HasPrefix(cid, "b") {
+		alphabet, digits = base32Alphabet, cid[1:]
+	} else {
+		return fmt.Errorf("%s is not a CIDv0 or base32 CIDv1", cid)
+	}
+	for _, r := range digits {
+		if !strings.ContainsRune(alphabet, r) {
+			return fmt.Errorf("%s is not a CIDv0 or base32 CIDv1", cid)
+		}
+	}
+	return nil
+}
+
+/*
+ * addHousePhoto attaches a photo to the house, only the owner can do it
+ * args: house key, CID, width, height, caption, sha256 of the image (hex)
+ */
+func (s *SmartContract) addHousePhoto(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+	if err := validateCID(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	width, err := strconv.Atoi(args[2])
+	if err != nil || width <= 0 {
+		return shim.Error("Width must be a positive integer")
+	}
+	height, err := strconv.Atoi(args[3])
+	if err != nil || height <= 0 {
+		return shim.Error("Height must be a positive integer")
+	}
+	if err := validateSHA256(args[5]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	photoKey, err := APIstub.CreateCompositeKey(photoObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(photoKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("Photo " + args[1] + " is already attached to house " + args[0])
+	}
+
+	addedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	addedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var photo = Photo{
+		CID:     args[1],
+		Width:   width,
+		Height:  height,
+		Caption: args[4],
+		Hash:    strings.ToLower(args[5]),
+		AddedBy: addedBy,
+		AddedAt: addedAt.Format(timeLayout),
+	}
+
+	photoAsBytes, _ := json.Marshal(photo)
+	if err := APIstub.PutState(photoKey, photoAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(photoAsBytes)
+}
+
+/*
+ * removeHousePhoto detaches a photo from the house, only the owner can do it
+ * args: house key, CID
+ */
+func (s *SmartContract) removeHousePhoto(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	photoKey, err := APIstub.CreateCompositeKey(photoObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	photoAsBytes, err := APIstub.GetState(photoKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if photoAsBytes == nil {
+		return shim.Error("Photo " + args[1] + " is not attached to house " + args[0])
+	}
+	if err := APIstub.DelState(photoKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * queryHousePhotos lists the photos of the house
+ * args: house key
+ */
+func (s *SmartContract) queryHousePhotos(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(photoObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	photos := []Photo{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		photo := Photo{}
+		if err := json.Unmarshal(queryResponse.Value, &photo); err != nil {
+			return shim.Error(err.Error())
+		}
+		photos = append(photos, photo)
+	}
+
+	photosAsBytes, _ := json.Marshal(photos)
+	return shim.Success(photosAsBytes)
+}
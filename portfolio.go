@@ -0,0 +1,286 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Portfolio transfers
+ * Every house, or share of a house, held by an owner can be handed over to another owner, as in a
+ * corporate acquisition. A portfolio can exceed what a single transaction can write, so it is
+ * transferred in batches walking the "owner~key" index, the progress being checkpointed in a job
+ * record from which the next batch resumes. The shares go over by share transfers, houses that cannot
+ * change hands, or whose share transfer owes tax, are skipped and reported.
+ * The owner index is maintained by putHouse; on a ledger holding houses written before it existed,
+ * it must first be built with rebuildIndexes.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	ownerIndex              = "owner~key"
+	portfolioJobObjectType  = "portfolioJob"
+	portfolioJobRunning     = "running"
+	portfolioJobCompleted   = "completed"
+	maxPortfolioBatchHouses = 100
+)
+
+// Every co-owner of a house is indexed
+func ownerEntries(house House) [][]string {
+	entries := [][]string{}
+	for _, share := range houseShares(house) {
+		entries = append(entries, []string{share.Owner})
+	}
+	return entries
+}
+
+// Define the portfolio job structure, the progress of the transfer of a portfolio
+// LastKey is the last house processed, the next batch resuming after it
+type PortfolioJob struct {
+	ID          string          `json:"id"`
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Status      string          `json:"status"`
+	Transferred []string        `json:"transferred"`
+	Skipped     []PortfolioSkip `json:"skipped"`
+	LastKey     string          `json:"lastkey"`
+	StartedBy   string          `json:"startedby"`
+	StartedAt   string          `json:"startedat"`
+	UpdatedAt   string          `json:"updatedat"`
+}
+
+// Define the portfolio skip structure, a house left to its owner and why
+type PortfolioSkip struct {
+	HouseKey string `json:"housekey"`
+	Reason   string `json:"reason"`
+}
+
+func getPortfolioJob(APIstub shim.ChaincodeStubInterface, id string) (PortfolioJob, error) {
+	jobKey, err := APIstub.CreateCompositeKey(portfolioJobObjectType, []string{id})
+	if err != nil {
+		return PortfolioJob{}, err
+	}
+	jobAsBytes, err := APIstub.GetState(jobKey)
+	if err != nil {
+		return PortfolioJob{}, err
+	}
+	if jobAsBytes == nil {
+		return PortfolioJob{}, fmt.Errorf("Portfolio job %s does not exist", id)
+	}
+	job := PortfolioJob{}
+	err = json.Unmarshal(jobAsBytes, &job)
+	return job, err
+}
+
+func putPortfolioJob(APIstub shim.ChaincodeStubInterface, job PortfolioJob) ([]byte, error) {
+	jobKey, err := APIstub.CreateCompositeKey(portfolioJobObjectType, []string{job.ID})
+	if err != nil {
+		return nil, err
+	}
+	jobAsBytes, _ := json.Marshal(job)
+	return jobAsBytes, APIstub.PutState(jobKey, jobAsBytes)
+}
+
+// portfolioShares returns the shares of the house once those of the previous owner went to the new one
+func portfolioShares(house House, from string, to string) []OwnershipShare {
+	shares, position := []OwnershipShare{}, map[string]int{}
+	for _, share := range houseShares(house) {
+		if share.Owner == from {
+			share.Owner = to
+		}
+		if i, found := position[share.Owner]; found {
+			shares[i].Share += share.Share
+			continue
+		}
+		position[share.Owner] = len(shares)
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// transferPortfolioHouse hands the shares of the house of the previous owner over to the new one by a share transfer
+func transferPortfolioHouse(APIstub shim.ChaincodeStubInterface, key string, house House, from string, to string) error {
+	tokens := shareBalance(house, from)
+	if err := checkShareTransferAllowed(APIstub, key, house, tokens); err != nil {
+		return err
+	}
+	tax, _, _, err := shareTransferTax(APIstub, key, house, tokens)
+	if err != nil {
+		return err
+	}
+	if tax > 0 {
+		return fmt.Errorf("Share transfer of house %s owes %d of transfer tax, move its share tokens with transferShareTokens", key, tax)
+	}
+	return transferHouseShares(APIstub, key, house, portfolioShares(house, from, to), reasonShareTransfer, 0)
+}
+
+/*
+ * transferPortfolio transfers one batch of the houses of an owner to another owner, by the owner or the court.
+ * The first batch starts a job, whose ID resumes the transfer until the job is completed
+ * args: previous owner, new owner, maximum number of houses of the batch, job ID (empty to start)
+ */
+func (s *SmartContract) transferPortfolio(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invokerID != args[0] {
+		if err := requireRole(APIstub, roleCourt); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if args[0] == args[1] || args[1] == "" {
+		return shim.Error("New owner must be another identity")
+	}
+	pageSize, err := strconv.Atoi(args[2])
+	if err != nil || pageSize <= 0 || pageSize > maxPortfolioBatchHouses {
+		return shim.Error(fmt.Sprintf("Batch size must be a number from 1 to %d", maxPortfolioBatchHouses))
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	job := PortfolioJob{
+		ID:          APIstub.GetTxID(),
+		From:        args[0],
+		To:          args[1],
+		Status:      portfolioJobRunning,
+		Transferred: []string{},
+		Skipped:     []PortfolioSkip{},
+		StartedBy:   invokerID,
+		StartedAt:   txTime.Format(timeLayout),
+	}
+	if args[3] != "" {
+		if job, err = getPortfolioJob(APIstub, args[3]); err != nil {
+			return shim.Error(err.Error())
+		}
+		if job.From != args[0] || job.To != args[1] {
+			return shim.Error("Portfolio job " + args[3] + " transfers the houses of " + job.From + " to " + job.To)
+		}
+		if job.Status == portfolioJobCompleted {
+			return shim.Error("Portfolio job " + args[3] + " is already completed")
+		}
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(ownerIndex, []string{job.From})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// The houses transferred by the previous batches left the index, only the skipped ones remain before LastKey
+	processed, remaining := 0, false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		houseKey := attributes[len(attributes)-1]
+		if houseKey <= job.LastKey {
+			continue
+		}
+		if processed == pageSize {
+			remaining = true
+			break
+		}
+		processed++
+		job.LastKey = houseKey
+
+		house, err := getHouse(APIstub, houseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := transferPortfolioHouse(APIstub, houseKey, house, job.From, job.To); err != nil {
+			job.Skipped = append(job.Skipped, PortfolioSkip{HouseKey: houseKey, Reason: err.Error()})
+			continue
+		}
+		job.Transferred = append(job.Transferred, houseKey)
+	}
+
+	job.UpdatedAt = txTime.Format(timeLayout)
+	if !remaining {
+		job.Status = portfolioJobCompleted
+	}
+	jobAsBytes, err := putPortfolioJob(APIstub, job)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if job.Status == portfolioJobCompleted {
+		var summary = struct {
+			ID          string `json:"id"`
+			From        string `json:"from"`
+			To          string `json:"to"`
+			Transferred int    `json:"transferred"`
+			Skipped     int    `json:"skipped"`
+		}{job.ID, job.From, job.To, len(job.Transferred), len(job.Skipped)}
+		summaryAsBytes, _ := json.Marshal(summary)
+		emitEvent(APIstub, "portfolioTransferred", summaryAsBytes)
+	}
+
+	return shim.Success(jobAsBytes)
+}
+
+// queryPortfolioJob returns the progress of a portfolio transfer
+func (s *SmartContract) queryPortfolioJob(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	job, err := getPortfolioJob(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	jobAsBytes, _ := json.Marshal(job)
+	return shim.Success(jobAsBytes)
+}
+
+// queryHousesByOwner returns the houses the identity holds, alone or with co-owners
+func (s *SmartContract) queryHousesByOwner(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	keys, err := queryIndexedHouseKeys(APIstub, ownerIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
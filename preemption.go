@@ -0,0 +1,436 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Pre-emption rights
+ * Registrars register rights of first refusal: of a municipality on every house of a location, or
+ * of the sitting tenant of a house. A sale of a house subject to such rights is not completed at
+ * once: a pre-emption notice gives the holders a window to buy the house at the price of the
+ * offer. The first holder exercising the right buys the house in place of the buyer, and
+ * finalizeLapsedPreemptions, which anyone can call, completes the sales to the buyers once the
+ * window lapsed. Either way the transfer may still wait for co-signatures or the settlement of its tax.
+ * Every priced sale is notified: changes of owners, deed transfers, purchases of listed houses and
+ * exercises of options alike.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	preemptionRightObjectType  = "preemptionRight"
+	preemptionNoticeObjectType = "preemptionNotice"
+)
+
+// Scopes of the pre-emption rights
+const (
+	preemptionLocation = "location"
+	preemptionTenant   = "tenant"
+)
+
+// Pre-emption notice statuses
+const (
+	preemptionPending   = "pending"
+	preemptionExercised = "exercised"
+	preemptionLapsed    = "lapsed"
+	preemptionCancelled = "cancelled"
+)
+
+// Define the pre-emption right structure. Target is the normalized location or the house key, depending on the scope
+type PreemptionRight struct {
+	Scope      string `json:"scope"`
+	Target     string `json:"target"`
+	Holder     string `json:"holder"`
+	WindowDays int    `json:"windowdays"`
+}
+
+// Define the pre-emption notice structure, a sale waiting for the holders of pre-emption rights.
+// The price of a purchase of a listed house (OnAccounts) is settled on the accounts of whoever buys the house
+type PreemptionNotice struct {
+	ID           string   `json:"id"`
+	HouseKey     string   `json:"housekey"`
+	From         string   `json:"from"`
+	Buyer        string   `json:"buyer"`
+	Price        int64    `json:"price"`
+	Holders      []string `json:"holders"`
+	NotifiedAt   string   `json:"notifiedat"`
+	WindowEndsAt string   `json:"windowendsat"`
+	Status       string   `json:"status"`
+	NewOwner     string   `json:"newowner,omitempty"`
+	OnAccounts   bool     `json:"onaccounts,omitempty"`
+}
+
+func getPreemptionRights(APIstub shim.ChaincodeStubInterface, scope string, target string) ([]PreemptionRight, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(preemptionRightObjectType, []string{scope, target})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	rights := []PreemptionRight{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		right := PreemptionRight{}
+		if err := json.Unmarshal(queryResponse.Value, &right); err != nil {
+			return nil, err
+		}
+		rights = append(rights, right)
+	}
+	return rights, nil
+}
+
+// applicablePreemptionRights returns the rights on the house, the rights of tenants only while their lease is active
+func applicablePreemptionRights(APIstub shim.ChaincodeStubInterface, key string, house House) ([]PreemptionRight, error) {
+	rights, err := getPreemptionRights(APIstub, preemptionLocation, normalizeLocation(house.Location))
+	if err != nil {
+		return nil, err
+	}
+	tenantRights, err := getPreemptionRights(APIstub, preemptionTenant, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, right := range tenantRights {
+		active, err := isActiveTenant(APIstub, key, right.Holder)
+		if err != nil {
+			return nil, err
+		}
+		if active {
+			rights = append(rights, right)
+		}
+	}
+	return rights, nil
+}
+
+func getPreemptionNotice(APIstub shim.ChaincodeStubInterface, id string) (PreemptionNotice, error) {
+	noticeKey, err := APIstub.CreateCompositeKey(preemptionNoticeObjectType, []string{id})
+	if err != nil {
+		return PreemptionNotice{}, err
+	}
+	noticeAsBytes, err := APIstub.GetState(noticeKey)
+	if err != nil {
+		return PreemptionNotice{}, err
+	}
+	if noticeAsBytes == nil {
+		return PreemptionNotice{}, fmt.Errorf("Pre-emption notice %s does not exist", id)
+	}
+	notice := PreemptionNotice{}
+	err = json.Unmarshal(noticeAsBytes, &notice)
+	return notice, err
+}
+
+func putPreemptionNotice(APIstub shim.ChaincodeStubInterface, notice PreemptionNotice) ([]byte, error) {
+	noticeKey, err := APIstub.CreateCompositeKey(preemptionNoticeObjectType, []string{notice.ID})
+	if err != nil {
+		return nil, err
+	}
+	noticeAsBytes, _ := json.Marshal(notice)
+	return noticeAsBytes, APIstub.PutState(noticeKey, noticeAsBytes)
+}
+
+// notifyPreemption opens the pre-emption window of the sale when rights apply to the house. It returns the notice, nil if none applies
+func notifyPreemption(APIstub shim.ChaincodeStubInterface, key string, house House, buyer string, price int64) ([]byte, error) {
+	rights, err := applicablePreemptionRights(APIstub, key, house)
+	if err != nil || len(rights) == 0 {
+		return nil, err
+	}
+	// Fail early, the checks are run again once the window lapsed
+	if err := checkTransferAllowed(APIstub, key, house, buyer); err != nil {
+		return nil, err
+	}
+	notifiedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	holders, windowDays := []string{}, 0
+	for _, right := range rights {
+		holders = append(holders, right.Holder)
+		if right.WindowDays > windowDays {
+			windowDays = right.WindowDays
+		}
+	}
+	var notice = PreemptionNotice{
+		ID:           APIstub.GetTxID(),
+		HouseKey:     key,
+		From:         house.Owner,
+		Buyer:        buyer,
+		Price:        price,
+		Holders:      holders,
+		NotifiedAt:   notifiedAt.Format(timeLayout),
+		WindowEndsAt: notifiedAt.AddDate(0, 0, windowDays).Format(timeLayout),
+		Status:       preemptionPending,
+	}
+	noticeAsBytes, err := putPreemptionNotice(APIstub, notice)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(APIstub, "preemptionNotified", noticeAsBytes)
+	return noticeAsBytes, nil
+}
+
+// settleNoticeOnAccounts records that the sale of the notice is settled on the accounts once the house is bought
+func settleNoticeOnAccounts(APIstub shim.ChaincodeStubInterface, noticeAsBytes []byte) ([]byte, error) {
+	notice := PreemptionNotice{}
+	if err := json.Unmarshal(noticeAsBytes, &notice); err != nil {
+		return nil, err
+	}
+	notice.OnAccounts = true
+	return putPreemptionNotice(APIstub, notice)
+}
+
+// completeNotifiedSale sells the house of the notice to the new owner, settling the price on the accounts when the notice says so
+func completeNotifiedSale(APIstub shim.ChaincodeStubInterface, notice PreemptionNotice, house House, newOwner string) error {
+	if notice.OnAccounts {
+		if err := settleSale(APIstub, notice.HouseKey, house, newOwner, notice.Price); err != nil {
+			return err
+		}
+	}
+	_, err := proceedWithTransfer(APIstub, notice.HouseKey, house, newOwner, reasonSale, notice.Price)
+	return err
+}
+
+/*
+ * registerPreemptionRight registers a right of first refusal, for registrars. A zero window removes the right
+ * args: scope (location or tenant), location or house key, holder, window in days
+ */
+func (s *SmartContract) registerPreemptionRight(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[2] == "" {
+		return shim.Error("Holder must not be empty")
+	}
+	windowDays, err := strconv.Atoi(args[3])
+	if err != nil || windowDays < 0 {
+		return shim.Error("Window must be a positive number of days")
+	}
+
+	target := args[1]
+	switch args[0] {
+	case preemptionLocation:
+		if target = normalizeLocation(args[1]); target == "" {
+			return shim.Error("Location must not be empty")
+		}
+	case preemptionTenant:
+		active, err := isActiveTenant(APIstub, args[1], args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !active && windowDays > 0 {
+			return shim.Error(args[2] + " is not a sitting tenant of house " + args[1])
+		}
+	default:
+		return shim.Error("Scope must be " + preemptionLocation + " or " + preemptionTenant)
+	}
+
+	rightKey, err := APIstub.CreateCompositeKey(preemptionRightObjectType, []string{args[0], target, args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if windowDays == 0 {
+		if err := APIstub.DelState(rightKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	rightAsBytes, _ := json.Marshal(PreemptionRight{Scope: args[0], Target: target, Holder: args[2], WindowDays: windowDays})
+	if err := APIstub.PutState(rightKey, rightAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(rightAsBytes)
+}
+
+// exercisePreemption buys the house of a pending notice at the price of the offer, for a holder of the right within the window. args: notice ID
+func (s *SmartContract) exercisePreemption(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	notice, err := getPreemptionNotice(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if notice.Status != preemptionPending {
+		return shim.Error("Pre-emption notice " + notice.ID + " is " + notice.Status)
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	holder := false
+	for _, candidate := range notice.Holders {
+		holder = holder || candidate == invokerID
+	}
+	if !holder {
+		return shim.Error("Access denied. Only the holders of a pre-emption right on house " + notice.HouseKey + " can exercise it")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	windowEndsAt, err := time.Parse(timeLayout, notice.WindowEndsAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !txTime.Before(windowEndsAt) {
+		return shim.Error("Pre-emption window of notice " + notice.ID + " lapsed on " + notice.WindowEndsAt)
+	}
+
+	house, err := getHouse(APIstub, notice.HouseKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.Owner != notice.From {
+		return shim.Error(fmt.Sprintf("House %s changed hands since the sale was notified", notice.HouseKey))
+	}
+	if err := completeNotifiedSale(APIstub, notice, house, invokerID); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	notice.Status = preemptionExercised
+	notice.NewOwner = invokerID
+	noticeAsBytes, err := putPreemptionNotice(APIstub, notice)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "preemptionExercised", noticeAsBytes)
+	return shim.Success(noticeAsBytes)
+}
+
+// finalizeLapsedPreemptions completes the sales whose pre-emption window lapsed without the right being exercised.
+// A sale whose house changed hands in the meantime is cancelled, a sale that is not allowed yet stays pending
+func (s *SmartContract) finalizeLapsedPreemptions(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(preemptionNoticeObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type outcome struct {
+		NoticeID string `json:"noticeid"`
+		HouseKey string `json:"housekey"`
+		Status   string `json:"status"`
+		Message  string `json:"message,omitempty"`
+	}
+	outcomes := []outcome{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		notice := PreemptionNotice{}
+		if err := json.Unmarshal(queryResponse.Value, &notice); err != nil {
+			return shim.Error(err.Error())
+		}
+		windowEndsAt, err := time.Parse(timeLayout, notice.WindowEndsAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if notice.Status != preemptionPending || txTime.Before(windowEndsAt) {
+			continue
+		}
+
+		house, err := getHouse(APIstub, notice.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		message := ""
+		notice.Status = preemptionLapsed
+		if house.Owner != notice.From {
+			notice.Status, message = preemptionCancelled, "House "+notice.HouseKey+" changed hands since the sale was notified"
+		} else if err := checkTransferAllowed(APIstub, notice.HouseKey, house, notice.Buyer); err != nil {
+			outcomes = append(outcomes, outcome{NoticeID: notice.ID, HouseKey: notice.HouseKey, Status: preemptionPending, Message: err.Error()})
+			continue
+		} else if err := attempt(APIstub, func(stub shim.ChaincodeStubInterface) error {
+			return completeNotifiedSale(stub, notice, house, notice.Buyer)
+		}); err != nil {
+			// A purchase the buyer cannot pay for yet stays pending
+			outcomes = append(outcomes, outcome{NoticeID: notice.ID, HouseKey: notice.HouseKey, Status: preemptionPending, Message: err.Error()})
+			continue
+		} else {
+			notice.NewOwner = notice.Buyer
+		}
+
+		if _, err := putPreemptionNotice(APIstub, notice); err != nil {
+			return shim.Error(err.Error())
+		}
+		outcomes = append(outcomes, outcome{NoticeID: notice.ID, HouseKey: notice.HouseKey, Status: notice.Status, Message: message})
+	}
+
+	outcomesAsBytes, _ := json.Marshal(outcomes)
+	return shim.Success(outcomesAsBytes)
+}
+
+// queryPreemptionRights returns the pre-emption rights applying to a house. args: house key
+func (s *SmartContract) queryPreemptionRights(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rights, err := applicablePreemptionRights(APIstub, args[0], house)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rightsAsBytes, _ := json.Marshal(rights)
+	return shim.Success(rightsAsBytes)
+}
+
+// queryPreemptionNotice returns a pre-emption notice. args: notice ID
+func (s *SmartContract) queryPreemptionNotice(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	notice, err := getPreemptionNotice(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	noticeAsBytes, _ := json.Marshal(notice)
+	return shim.Success(noticeAsBytes)
+}
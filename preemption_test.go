@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Pre-emption tests
+ * Priced sales of a house of a location where the municipality holds a right of first refusal.
+ */
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// newPreemptionLedger returns a ledger where the city pre-empts the sales of the houses of Paris
+func newPreemptionLedger(t *testing.T) *mockLedger {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "registerPreemptionRight", preemptionLocation, "Paris", "city", "30")
+	return ledger
+}
+
+func expectPendingNotice(t *testing.T, ledger *mockLedger, noticeAsBytes []byte, buyer string, price int64) PreemptionNotice {
+	t.Helper()
+	notice := PreemptionNotice{}
+	if err := json.Unmarshal(noticeAsBytes, &notice); err != nil {
+		t.Fatal(err)
+	}
+	if notice.Status != preemptionPending || notice.Buyer != buyer || notice.Price != price {
+		t.Errorf("Sale notified %+v, expected a pending notice of the sale to %s at %d", notice, buyer, price)
+	}
+	house := House{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "queryHouse", "HOUSE1"), &house); err != nil {
+		t.Fatal(err)
+	}
+	if house.Owner != "alice" {
+		t.Errorf("House was transferred to %s during the pre-emption window", house.Owner)
+	}
+	return notice
+}
+
+func TestBuyHouseNotifiesPreemption(t *testing.T) {
+	ledger := newPreemptionLedger(t)
+	ledger.invoke(t, ledger.owner, "setEnergyCertificate", "HOUSE1", "B", "EPC1", time.Now().AddDate(1, 0, 0).Format(dayLayout))
+	ledger.invoke(t, ledger.owner, "listHouseForSale", "HOUSE1", "300000")
+
+	notice := expectPendingNotice(t, ledger, ledger.invoke(t, ledger.buyer, "buyHouse", "HOUSE1"), "bob", 300000)
+	if !notice.OnAccounts {
+		t.Errorf("Notice of a purchase of a listed house is not settled on the accounts")
+	}
+	account := Account{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.buyer, "getBalance", "bob"), &account); err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 0 {
+		t.Errorf("Buyer was debited %d before the pre-emption window closed", -account.Balance)
+	}
+}
+
+func TestExerciseOptionNotifiesPreemption(t *testing.T) {
+	ledger := newPreemptionLedger(t)
+	option := Option{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "grantOption", "HOUSE1", "bob", "150000", time.Now().AddDate(1, 0, 0).Format(timeLayout)), &option); err != nil {
+		t.Fatal(err)
+	}
+	ledger.invoke(t, ledger.buyer, "exerciseOption", option.ID)
+
+	// The notice is identified by the transaction of the sale
+	noticeAsBytes := ledger.invoke(t, ledger.owner, "queryPreemptionNotice", ledger.lastTxID())
+	expectPendingNotice(t, ledger, noticeAsBytes, "bob", 150000)
+}
@@ -0,0 +1,242 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Owner personal data
+ * The personal details of the owners (full name, contact, postal address) are only stored in
+ * the ownerDetailsCollection private data collection, and sent in the transient map so that they
+ * never appear in a transaction. The public ledger only holds a salted hash of the details, the
+ * salt being private as well. On an erasure request redactOwnerData deletes the private details
+ * and replaces the public anchor with a salted hash of the owner ID.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	ownerDetailsCollection       = "ownerDetailsCollection"
+	ownerDetailsObjectType       = "ownerDetails"
+	ownerDetailsAnchorObjectType = "ownerDetailsAnchor"
+	redactedOwnerObjectType      = "redactedOwner"
+)
+
+// Transient fields of setOwnerDetails
+const (
+	ownerDetailsField = "ownerDetails"
+	saltField         = "salt"
+)
+
+// Minimum length of the salts, so that hashes cannot be brute forced
+const minSaltLength = 16
+
+// Define the owner details structure, only stored in the private data collection
+type OwnerDetails struct {
+	Owner    string `json:"owner"`
+	FullName string `json:"fullname"`
+	Email    string `json:"email,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Salt     string `json:"salt"`
+}
+
+// Define the anchor structure, the public trace of the private details of an owner
+type OwnerDetailsAnchor struct {
+	Hash      string `json:"hash"`
+	UpdatedAt string `json:"updatedat"`
+	Redacted  bool   `json:"redacted,omitempty"`
+}
+
+func saltedHash(salt string, value []byte) string {
+	hash := sha256.Sum256(append([]byte(salt), value...))
+	return hex.EncodeToString(hash[:])
+}
+
+func getOwnerDetails(APIstub shim.ChaincodeStubInterface, owner string) (OwnerDetails, error) {
+	details := OwnerDetails{}
+
+	detailsKey, err := APIstub.CreateCompositeKey(ownerDetailsObjectType, []string{owner})
+	if err != nil {
+		return details, err
+	}
+	detailsAsBytes, err := APIstub.GetPrivateData(ownerDetailsCollection, detailsKey)
+	if err != nil {
+		return details, err
+	}
+	if detailsAsBytes == nil {
+		return details, fmt.Errorf("No personal details of %s", owner)
+	}
+
+	err = json.Unmarshal(detailsAsBytes, &details)
+	return details, err
+}
+
+// requireSelfOrRole returns an error unless the invoker is the owner or holds one of the roles
+func requireSelfOrRole(APIstub shim.ChaincodeStubInterface, owner string, roles ...string) error {
+	invoker, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	if invoker == owner {
+		return nil
+	}
+	return requireRole(APIstub, roles...)
+}
+
+// setOwnerDetails stores the personal details of the invoker, given in the ownerDetails and salt transient fields
+func (s *SmartContract) setOwnerDetails(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	details := OwnerDetails{}
+	if err := json.Unmarshal(transient[ownerDetailsField], &details); err != nil {
+		return shim.Error("The " + ownerDetailsField + " transient field must hold the personal details as JSON")
+	}
+	if details.FullName == "" {
+		return shim.Error("Full name must not be empty")
+	}
+	details.Salt = string(transient[saltField])
+	if len(details.Salt) < minSaltLength {
+		return shim.Error(fmt.Sprintf("The %s transient field must hold at least %d characters", saltField, minSaltLength))
+	}
+
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	details.Owner = owner
+	updatedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	detailsKey, err := APIstub.CreateCompositeKey(ownerDetailsObjectType, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	detailsAsBytes, _ := json.Marshal(details)
+	if err := APIstub.PutPrivateData(ownerDetailsCollection, detailsKey, detailsAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	anchorKey, err := APIstub.CreateCompositeKey(ownerDetailsAnchorObjectType, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	anchorAsBytes, _ := json.Marshal(OwnerDetailsAnchor{Hash: saltedHash(details.Salt, detailsAsBytes), UpdatedAt: updatedAt.Format(timeLayout)})
+	if err := APIstub.PutState(anchorKey, anchorAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(anchorAsBytes)
+}
+
+/*
+ * queryOwnerDetails returns the personal details of an owner, or the selected fields of them, for the owner and the MSPs it consented to
+ * args: owner, fields (optional)
+ */
+func (s *SmartContract) queryOwnerDetails(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	var paths [][]string
+	if len(args) > 1 {
+		var err error
+		if paths, err = parseFieldSelection(args[1], OwnerDetails{}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := checkConsent(APIstub, args[0], consentPurposeOwnerData); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	details, err := getOwnerDetails(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	detailsAsBytes, _ := json.Marshal(details)
+	detailsAsBytes, err = projectJSON(detailsAsBytes, paths)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(detailsAsBytes)
+}
+
+/*
+ * redactOwnerData erases the personal details of an owner, for the owner and compliance officers.
+ * The private details are deleted, the public anchor keyed by the owner ID is replaced by a record
+ * keyed by a salted hash of the owner ID
+ * args: owner
+ */
+func (s *SmartContract) redactOwnerData(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireSelfOrRole(APIstub, args[0], roleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	details, err := getOwnerDetails(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	redactedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Peers keep no copy of deleted private data, only its hash remains in the blocks
+	detailsKey, err := APIstub.CreateCompositeKey(ownerDetailsObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelPrivateData(ownerDetailsCollection, detailsKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	anchorKey, err := APIstub.CreateCompositeKey(ownerDetailsAnchorObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(anchorKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ownerHash := saltedHash(details.Salt, []byte(args[0]))
+	redactedKey, err := APIstub.CreateCompositeKey(redactedOwnerObjectType, []string{ownerHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	redactedAsBytes, _ := json.Marshal(OwnerDetailsAnchor{Hash: ownerHash, UpdatedAt: redactedAt.Format(timeLayout), Redacted: true})
+	if err := APIstub.PutState(redactedKey, redactedAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(redactedAsBytes)
+}
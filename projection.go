@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Field selection
+ * Queries returning records accept an optional selection of the fields to return, as a comma
+ * separated list of JSON field names. Nested fields are selected with dotted paths, such as
+ * address.city or shares.owner, a path through a list applying to each of its elements. Only
+ * the selected fields are returned, so that mobile clients download less and private records
+ * can be disclosed in part.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// jsonFieldType returns the type of the field of the structure with the JSON name, through pointers and lists
+func jsonFieldType(structType reflect.Type, name string) (reflect.Type, bool) {
+	for structType.Kind() == reflect.Ptr || structType.Kind() == reflect.Slice {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+// parseFieldSelection parses a selection of fields of the type of the record, nil when empty for the whole record
+func parseFieldSelection(selection string, record interface{}) ([][]string, error) {
+	fields := splitList(selection)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	paths := [][]string{}
+	for _, field := range fields {
+		path := strings.Split(field, ".")
+		fieldType := reflect.TypeOf(record)
+		for _, name := range path {
+			var known bool
+			if fieldType, known = jsonFieldType(fieldType, name); !known {
+				return nil, fmt.Errorf("Unknown field %s", field)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// projectJSON returns the selected fields of a JSON record, all of it when no field is selected
+func projectJSON(recordAsJSON []byte, paths [][]string) ([]byte, error) {
+	if paths == nil {
+		return recordAsJSON, nil
+	}
+	projected, err := projectValue(recordAsJSON, paths)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(projected)
+}
+
+func projectValue(valueAsJSON json.RawMessage, paths [][]string) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(valueAsJSON))
+	if strings.HasPrefix(trimmed, "[") {
+		elements := []json.RawMessage{}
+		if err := json.Unmarshal(valueAsJSON, &elements); err != nil {
+			return nil, err
+		}
+		for i := range elements {
+			projected, err := projectValue(elements[i], paths)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = projected
+		}
+		return json.Marshal(elements)
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		return valueAsJSON, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(valueAsJSON, &fields); err != nil {
+		return nil, err
+	}
+	whole := map[string]bool{}
+	nested := map[string][][]string{}
+	for _, path := range paths {
+		if len(path) == 1 {
+			whole[path[0]] = true
+		} else {
+			nested[path[0]] = append(nested[path[0]], path[1:])
+		}
+	}
+
+	projected := map[string]json.RawMessage{}
+	for name, value := range fields {
+		if whole[name] {
+			projected[name] = value
+		} else if subPaths, found := nested[name]; found {
+			subValue, err := projectValue(value, subPaths)
+			if err != nil {
+				return nil, err
+			}
+			projected[name] = subValue
+		}
+	}
+	return json.Marshal(projected)
+}
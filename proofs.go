@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Provenance proofs
+ * A light client verifying a house against the blocks needs to know which transaction wrote it.
+ * putHouse records the MSP and the identity writing every house, and queryHouseWithProof returns
+ * the house with its last write as found in the history of its key: transaction ID, timestamp,
+ * writer and SHA-256 of the stored value. Chaincode has no access to block numbers, so the client
+ * gets the block of the transaction from the GetBlockByTxID query of the qscc system chaincode,
+ * checks its header against the chain and finds in its write set the key with the value hash.
+ * The proof itself is signed by the endorsing peers, as any proposal response.
+ */
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const houseWriterObjectType = "houseWriter"
+
+// Define the house writer structure, the identity of the last transaction writing a house
+type HouseWriter struct {
+	TxID     string `json:"txid"`
+	MSPID    string `json:"mspid"`
+	Writer   string `json:"writer"`
+	Function string `json:"function"`
+}
+
+// Define the house write structure, the last write of a house in the history of its key
+type HouseWrite struct {
+	TxID      string `json:"txid"`
+	Timestamp string `json:"timestamp"`
+	ValueHash string `json:"valuehash"`
+	MSPID     string `json:"mspid,omitempty"`
+	Writer    string `json:"writer,omitempty"`
+	Function  string `json:"function,omitempty"`
+}
+
+// recordHouseWriter records the invoker as the writer of the house in the transaction
+func recordHouseWriter(APIstub shim.ChaincodeStubInterface, key string) error {
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	writer, err := getInvokerID(APIstub)
+	if err != nil {
+		return err
+	}
+	function, _ := APIstub.GetFunctionAndParameters()
+	writerKey, err := APIstub.CreateCompositeKey(houseWriterObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	writerAsBytes, _ := json.Marshal(HouseWriter{TxID: APIstub.GetTxID(), MSPID: mspID, Writer: writer, Function: function})
+	return APIstub.PutState(writerKey, writerAsBytes)
+}
+
+// lastHouseWrite returns the last write of the key in its history, nil if none
+func lastHouseWrite(APIstub shim.ChaincodeStubInterface, key string) (*HouseWrite, error) {
+	historyIterator, err := APIstub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer historyIterator.Close()
+
+	var last *HouseWrite
+	lastSeconds, lastNanos := int64(0), int32(0)
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.IsDelete || modification.Timestamp == nil {
+			continue
+		}
+		seconds, nanos := modification.Timestamp.Seconds, modification.Timestamp.Nanos
+		if last != nil && (seconds < lastSeconds || (seconds == lastSeconds && nanos < lastNanos)) {
+			continue
+		}
+		last = &HouseWrite{
+			TxID:      modification.TxId,
+			Timestamp: time.Unix(seconds, int64(nanos)).UTC().Format(time.RFC3339Nano),
+			ValueHash: recordHash(modification.Value),
+		}
+		lastSeconds, lastNanos = seconds, nanos
+	}
+	return last, nil
+}
+
+/*
+ * queryHouseWithProof returns a house with the provenance of its last write. The writer is only known for
+ * the writes recorded by putHouse
+ * args: house key
+ */
+func (s *SmartContract) queryHouseWithProof(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	houseAsBytes, err := APIstub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if houseAsBytes == nil {
+		return shim.Error("House " + args[0] + " does not exist")
+	}
+	record, err := houseAsJSON(houseAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	write, err := lastHouseWrite(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if write == nil || write.ValueHash != recordHash(houseAsBytes) {
+		return shim.Error("The history of house " + args[0] + " does not hold its current value")
+	}
+	writerKey, err := APIstub.CreateCompositeKey(houseWriterObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	writerAsBytes, err := APIstub.GetState(writerKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if writerAsBytes != nil {
+		writer := HouseWriter{}
+		if err := json.Unmarshal(writerAsBytes, &writer); err != nil {
+			return shim.Error(err.Error())
+		}
+		// A write outside putHouse, such as a migration of encoding, leaves the writer unknown
+		if writer.TxID == write.TxID {
+			write.MSPID, write.Writer, write.Function = writer.MSPID, writer.Writer, writer.Function
+		}
+	}
+
+	var proof = struct {
+		Channel   string          `json:"channel"`
+		Key       string          `json:"key"`
+		Record    json.RawMessage `json:"record"`
+		LastWrite HouseWrite      `json:"lastwrite"`
+	}{Channel: APIstub.GetChannelID(), Key: args[0], Record: record, LastWrite: *write}
+
+	proofAsBytes, _ := json.Marshal(proof)
+	return shim.Success(proofAsBytes)
+}
@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Creation quota
+ * Each identity may create a limited number of houses per day (UTC, from the transaction
+ * timestamp). Counters are kept per identity and day, a quota of 0 means no limit.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	creationQuotaKey        = "CONFIG_CREATIONQUOTA"
+	creationCountObjectType = "creationCount"
+)
+
+// Layout of calendar days, also used for the daily bucket of the creation counters
+const dayLayout = "2006-01-02"
+
+func getCreationQuota(APIstub shim.ChaincodeStubInterface) (int, error) {
+	quotaAsBytes, err := APIstub.GetState(creationQuotaKey)
+	if err != nil {
+		return 0, err
+	}
+	if quotaAsBytes == nil {
+		return 0, nil
+	}
+
+	quota := 0
+	err = json.Unmarshal(quotaAsBytes, &quota)
+	return quota, err
+}
+
+// consumeCreationQuota counts one more house created by the invoker today, failing once the quota is reached
+func consumeCreationQuota(APIstub shim.ChaincodeStubInterface) error {
+	quota, err := getCreationQuota(APIstub)
+	if err != nil {
+		return err
+	}
+	if quota == 0 {
+		return nil
+	}
+
+	invoker, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+
+	countKey, err := APIstub.CreateCompositeKey(creationCountObjectType, []string{invoker, txTime.Format(dayLayout)})
+	if err != nil {
+		return err
+	}
+	countAsBytes, err := APIstub.GetState(countKey)
+	if err != nil {
+		return err
+	}
+	count := 0
+	if countAsBytes != nil {
+		if err := json.Unmarshal(countAsBytes, &count); err != nil {
+			return err
+		}
+	}
+	if count >= quota {
+		return fmt.Errorf("Creation quota of %d houses per day reached", quota)
+	}
+
+	countAsBytes, _ = json.Marshal(count + 1)
+	return APIstub.PutState(countKey, countAsBytes)
+}
+
+/*
+ * setCreationQuota sets how many houses an identity may create per day
+ * args: quota (0 for no limit)
+ */
+func (s *SmartContract) setCreationQuota(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quota, err := strconv.Atoi(args[0])
+	if err != nil || quota < 0 {
+		return shim.Error("Quota must be a positive number")
+	}
+
+	quotaAsBytes, _ := json.Marshal(quota)
+	if err := APIstub.PutState(creationQuotaKey, quotaAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryCreationQuota(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	quota, err := getCreationQuota(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	quotaAsBytes, _ := json.Marshal(quota)
+	return shim.Success(quotaAsBytes)
+}
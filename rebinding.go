@@ -0,0 +1,321 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Owner identity rebinding
+ * Owners are known by the common name of their certificate. When an owner lost their certificate
+ * or was enrolled again under another name, a registrar can request to rebind the owner name to
+ * the new certificate, given by its sha256. The owner can contest the request with their current
+ * certificate during the challenge period, after which the holder of the new certificate confirms
+ * it. From then on the new certificate acts as the owner, and the old certificates of the owner
+ * name are refused.
+ */
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	rebindRequestObjectType   = "rebindRequest"
+	identityBindingObjectType = "identityBinding"
+	certBindingObjectType     = "certBinding"
+)
+
+// Days during which the owner can contest a rebinding
+const rebindChallengeDays = 14
+
+// Rebinding request statuses
+const (
+	rebindPending   = "pending"
+	rebindContested = "contested"
+	rebindCompleted = "completed"
+)
+
+// Define the rebinding request structure
+type RebindRequest struct {
+	ID              string `json:"id"`
+	OwnerID         string `json:"ownerid"`
+	NewCertHash     string `json:"newcerthash"`
+	RequestedBy     string `json:"requestedby"`
+	RequestedAt     string `json:"requestedat"`
+	ChallengeEndsAt string `json:"challengeendsat"`
+	Status          string `json:"status"`
+	ClosedAt        string `json:"closedat,omitempty"`
+}
+
+// certificateHash returns the hex encoded sha256 of the DER encoding of the certificate
+func certificateHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// boundIdentity returns the owner name the certificate acts as: the name it was rebound to, its common
+// name otherwise. It fails when the common name was rebound to another certificate
+func boundIdentity(APIstub shim.ChaincodeStubInterface, cert *x509.Certificate) (string, error) {
+	hash := certificateHash(cert)
+	certKey, err := APIstub.CreateCompositeKey(certBindingObjectType, []string{hash})
+	if err != nil {
+		return "", err
+	}
+	ownerAsBytes, err := APIstub.GetState(certKey)
+	if err != nil {
+		return "", err
+	}
+	if ownerAsBytes != nil {
+		return string(ownerAsBytes), nil
+	}
+
+	bindingKey, err := APIstub.CreateCompositeKey(identityBindingObjectType, []string{cert.Subject.CommonName})
+	if err != nil {
+		return "", err
+	}
+	boundHashAsBytes, err := APIstub.GetState(bindingKey)
+	if err != nil {
+		return "", err
+	}
+	if boundHashAsBytes != nil && string(boundHashAsBytes) != hash {
+		return "", fmt.Errorf("Identity %s was rebound to another certificate", cert.Subject.CommonName)
+	}
+	return cert.Subject.CommonName, nil
+}
+
+func getRebindRequest(APIstub shim.ChaincodeStubInterface, id string) (RebindRequest, error) {
+	requestKey, err := APIstub.CreateCompositeKey(rebindRequestObjectType, []string{id})
+	if err != nil {
+		return RebindRequest{}, err
+	}
+	requestAsBytes, err := APIstub.GetState(requestKey)
+	if err != nil {
+		return RebindRequest{}, err
+	}
+	if requestAsBytes == nil {
+		return RebindRequest{}, fmt.Errorf("Rebinding request %s does not exist", id)
+	}
+	request := RebindRequest{}
+	err = json.Unmarshal(requestAsBytes, &request)
+	return request, err
+}
+
+func putRebindRequest(APIstub shim.ChaincodeStubInterface, request RebindRequest) ([]byte, error) {
+	requestKey, err := APIstub.CreateCompositeKey(rebindRequestObjectType, []string{request.ID})
+	if err != nil {
+		return nil, err
+	}
+	requestAsBytes, _ := json.Marshal(request)
+	return requestAsBytes, APIstub.PutState(requestKey, requestAsBytes)
+}
+
+/*
+ * rebindOwnerIdentity requests to bind an owner name to a new certificate, for registrars
+ * args: owner ID, sha256 of the DER encoded new certificate
+ */
+func (s *SmartContract) rebindOwnerIdentity(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Owner ID must not be empty")
+	}
+	if err := validateSHA256(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	requestedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if requestedBy == args[0] {
+		return shim.Error("A registrar cannot rebind their own identity")
+	}
+	requestedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var request = RebindRequest{
+		ID:              APIstub.GetTxID(),
+		OwnerID:         args[0],
+		NewCertHash:     strings.ToLower(args[1]),
+		RequestedBy:     requestedBy,
+		RequestedAt:     requestedAt.Format(timeLayout),
+		ChallengeEndsAt: requestedAt.AddDate(0, 0, rebindChallengeDays).Format(timeLayout),
+		Status:          rebindPending,
+	}
+	requestAsBytes, err := putRebindRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "identityRebindingRequested", requestAsBytes)
+	return shim.Success(requestAsBytes)
+}
+
+// contestIdentityRebinding cancels a pending rebinding of the invoker's identity. args: request ID
+func (s *SmartContract) contestIdentityRebinding(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getRebindRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invokerID != request.OwnerID {
+		return shim.Error("Access denied. Only " + request.OwnerID + " can contest the rebinding of their identity")
+	}
+	if request.Status != rebindPending {
+		return shim.Error("Rebinding request " + request.ID + " is " + request.Status)
+	}
+
+	closedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	request.Status = rebindContested
+	request.ClosedAt = closedAt.Format(timeLayout)
+	requestAsBytes, err := putRebindRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "identityRebindingContested", requestAsBytes)
+	return shim.Success(requestAsBytes)
+}
+
+// confirmIdentityRebinding completes a rebinding once its challenge period is over, for the holder of the new certificate. args: request ID
+func (s *SmartContract) confirmIdentityRebinding(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getRebindRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request.Status != rebindPending {
+		return shim.Error("Rebinding request " + request.ID + " is " + request.Status)
+	}
+	cert, err := getInvokerCertificate(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificateHash(cert) != request.NewCertHash {
+		return shim.Error("Access denied. Only the holder of the new certificate can confirm the rebinding")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	challengeEndsAt, err := time.Parse(timeLayout, request.ChallengeEndsAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if txTime.Before(challengeEndsAt) {
+		return shim.Error("Rebinding request " + request.ID + " can be contested until " + request.ChallengeEndsAt)
+	}
+
+	// A previous rebinding of the owner no longer applies to its certificate
+	bindingKey, err := APIstub.CreateCompositeKey(identityBindingObjectType, []string{request.OwnerID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	previousHashAsBytes, err := APIstub.GetState(bindingKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if previousHashAsBytes != nil {
+		previousKey, err := APIstub.CreateCompositeKey(certBindingObjectType, []string{string(previousHashAsBytes)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.DelState(previousKey); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	certKey, err := APIstub.CreateCompositeKey(certBindingObjectType, []string{request.NewCertHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(certKey, []byte(request.OwnerID)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(bindingKey, []byte(request.NewCertHash)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	request.Status = rebindCompleted
+	request.ClosedAt = txTime.Format(timeLayout)
+	requestAsBytes, err := putRebindRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "identityRebound", requestAsBytes)
+	return shim.Success(requestAsBytes)
+}
+
+// queryRebindRequests returns the rebinding requests of an owner. args: owner ID
+func (s *SmartContract) queryRebindRequests(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rebindRequestObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	requests := []RebindRequest{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		request := RebindRequest{}
+		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
+			return shim.Error(err.Error())
+		}
+		if request.OwnerID == args[0] {
+			requests = append(requests, request)
+		}
+	}
+
+	requestsAsBytes, _ := json.Marshal(requests)
+	return shim.Success(requestsAsBytes)
+}
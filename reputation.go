@@ -0,0 +1,267 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Reputation
+ * Once a lease is over, its landlord and its tenant can each rate the other, once, on the
+ * criteria of the role of the other party. Ratings are only accepted from the parties of the
+ * lease, so that nobody can rate an identity without having dealt with it, and an identity cannot
+ * rate itself. The scores of an identity are aggregated per role from its ratings.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	ratingObjectType = "rating"
+	rateeRatingIndex = "ratee~rating"
+)
+
+// Roles of the rated parties of a lease
+const (
+	ratedLandlord = "landlord"
+	ratedTenant   = "tenant"
+)
+
+// Criteria of the ratings per role of the rated party, scored from 1 to 5
+var ratingCriteria = map[string][]string{
+	ratedLandlord: {"maintenance", "communication", "fairness"},
+	ratedTenant:   {"payment", "care", "communication"},
+}
+
+const maxRatingScore = 5
+
+// Define the rating structure, the scores given by a party of a lease to the other
+type Rating struct {
+	LeaseID     string         `json:"leaseid"`
+	Rater       string         `json:"rater"`
+	Ratee       string         `json:"ratee"`
+	RateeRole   string         `json:"rateerole"`
+	Scores      map[string]int `json:"scores"`
+	Comment     string         `json:"comment,omitempty"`
+	SubmittedAt string         `json:"submittedat"`
+}
+
+// Define the score structure, the aggregate of the ratings of an identity in a role
+type ReputationScore struct {
+	Role     string             `json:"role"`
+	Count    int                `json:"count"`
+	Criteria map[string]float64 `json:"criteria"`
+	Overall  float64            `json:"overall"`
+}
+
+// leaseOver tells whether the lease was terminated or its last period elapsed at the given time
+func leaseOver(lease Lease, at time.Time) bool {
+	_, leaseEnd := leaseDays(lease)
+	return lease.Status == leaseTerminated || !at.Before(leaseEnd)
+}
+
+/*
+ * submitRating rates the other party of a lease once it is over, for its landlord or its tenant. Every criterion of the role of
+ * the other party must be scored, once per lease
+ * args: lease ID, scores as a JSON object of criterion to score (1 to 5), comment
+ */
+func (s *SmartContract) submitRating(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	lease, err := getLease(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rater, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var rating = Rating{LeaseID: lease.ID, Rater: rater, Comment: args[2]}
+	switch rater {
+	case lease.Landlord:
+		rating.Ratee, rating.RateeRole = lease.Tenant, ratedTenant
+	case lease.Tenant:
+		rating.Ratee, rating.RateeRole = lease.Landlord, ratedLandlord
+	default:
+		return shim.Error("Only the landlord " + lease.Landlord + " and the tenant " + lease.Tenant + " can rate lease " + lease.ID)
+	}
+	if rating.Ratee == rater {
+		return shim.Error("An identity cannot rate itself")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !leaseOver(lease, txTime) {
+		return shim.Error("Lease " + lease.ID + " is not over yet")
+	}
+
+	if err := json.Unmarshal([]byte(args[1]), &rating.Scores); err != nil {
+		return shim.Error("Scores must be a JSON object of criterion to score")
+	}
+	criteria := ratingCriteria[rating.RateeRole]
+	if len(rating.Scores) != len(criteria) {
+		return shim.Error(fmt.Sprintf("A %s is scored on %s", rating.RateeRole, strings.Join(criteria, ", ")))
+	}
+	for _, criterion := range criteria {
+		score, found := rating.Scores[criterion]
+		if !found {
+			return shim.Error(fmt.Sprintf("A %s is scored on %s", rating.RateeRole, strings.Join(criteria, ", ")))
+		}
+		if score < 1 || score > maxRatingScore {
+			return shim.Error(fmt.Sprintf("Score of %s must be from 1 to %d", criterion, maxRatingScore))
+		}
+	}
+
+	ratingKey, err := APIstub.CreateCompositeKey(ratingObjectType, []string{lease.ID, rater})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := APIstub.GetState(ratingKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error(rater + " already rated lease " + lease.ID)
+	}
+	rating.SubmittedAt = txTime.Format(timeLayout)
+	ratingAsBytes, _ := json.Marshal(rating)
+	if err := APIstub.PutState(ratingKey, ratingAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(rateeRatingIndex, []string{rating.Ratee, lease.ID, rater})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "ratingSubmitted", ratingAsBytes)
+	return shim.Success(ratingAsBytes)
+}
+
+// ratingsOf returns the ratings received by the identity, in order of lease
+func ratingsOf(APIstub shim.ChaincodeStubInterface, ratee string) ([]Rating, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rateeRatingIndex, []string{ratee})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	ratings := []Rating{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		ratingKey, err := APIstub.CreateCompositeKey(ratingObjectType, attributes[1:])
+		if err != nil {
+			return nil, err
+		}
+		ratingAsBytes, err := APIstub.GetState(ratingKey)
+		if err != nil {
+			return nil, err
+		}
+		rating := Rating{}
+		if err := json.Unmarshal(ratingAsBytes, &rating); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+	return ratings, nil
+}
+
+// roundScore rounds an average score to two decimals
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+/*
+ * queryReputation returns the scores of an identity as landlord and as tenant, averaged per criterion over its ratings
+ * args: identity
+ */
+func (s *SmartContract) queryReputation(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	ratings, err := ratingsOf(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scores := []ReputationScore{}
+	for _, role := range []string{ratedLandlord, ratedTenant} {
+		score := ReputationScore{Role: role, Criteria: map[string]float64{}}
+		totals := map[string]int{}
+		for _, rating := range ratings {
+			if rating.RateeRole != role {
+				continue
+			}
+			score.Count++
+			for criterion, value := range rating.Scores {
+				totals[criterion] += value
+			}
+		}
+		if score.Count == 0 {
+			continue
+		}
+		overall := 0
+		for _, criterion := range ratingCriteria[role] {
+			score.Criteria[criterion] = roundScore(float64(totals[criterion]) / float64(score.Count))
+			overall += totals[criterion]
+		}
+		score.Overall = roundScore(float64(overall) / float64(score.Count*len(ratingCriteria[role])))
+		scores = append(scores, score)
+	}
+
+	var reputation = struct {
+		Identity string            `json:"identity"`
+		Scores   []ReputationScore `json:"scores"`
+	}{Identity: args[0], Scores: scores}
+
+	reputationAsBytes, _ := json.Marshal(reputation)
+	return shim.Success(reputationAsBytes)
+}
+
+// queryRatings returns the ratings received by an identity. args: identity
+func (s *SmartContract) queryRatings(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	ratings, err := ratingsOf(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ratingsAsBytes, _ := json.Marshal(ratings)
+	return shim.Success(ratingsAsBytes)
+}
@@ -0,0 +1,430 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Energy retrofit credits
+ * Grantors run retrofit programs awarding efficiency credits for the energy classes gained by a
+ * house. The owner records the retrofit works, which snapshot the energy performance certificate
+ * of the house, and once a new certificate is recorded the authority of the program awards the
+ * credits of every class gained to the owner. Credits are journaled like money, on a credit account
+ * of their own per identity, and holders can transfer them.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	retrofitProgramObjectType = "retrofitProgram"
+	retrofitWorksObjectType   = "retrofitWorks"
+	programRetrofitIndex      = "program~retrofit"
+)
+
+// Prefix of the accounts holding the efficiency credits of an identity
+const creditAccountPrefix = "#credits:"
+
+// Kinds of the credit account entries
+const (
+	entryCreditAward    = "creditAward"
+	entryCreditTransfer = "creditTransfer"
+)
+
+// Retrofit works statuses
+const (
+	retrofitRecorded = "recorded"
+	retrofitAwarded  = "awarded"
+)
+
+// Define the retrofit program structure. Authority is the identity awarding its credits
+type RetrofitProgram struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Authority       string `json:"authority"`
+	CreditsPerClass int64  `json:"creditsperclass"`
+}
+
+// Define the retrofit works structure, keyed by house key and works ID (the recording txID)
+type RetrofitWorks struct {
+	ID                string `json:"id"`
+	HouseKey          string `json:"housekey"`
+	Program           string `json:"program"`
+	Description       string `json:"description"`
+	DocumentHash      string `json:"documenthash"`
+	RatingBefore      string `json:"ratingbefore"`
+	CertificateBefore string `json:"certificatebefore"`
+	RecordedBy        string `json:"recordedby"`
+	RecordedAt        string `json:"recordedat"`
+	Status            string `json:"status"`
+	RatingAfter       string `json:"ratingafter,omitempty"`
+	CertificateAfter  string `json:"certificateafter,omitempty"`
+	Credits           int64  `json:"credits,omitempty"`
+	AwardedTo         string `json:"awardedto,omitempty"`
+	AwardedAt         string `json:"awardedat,omitempty"`
+}
+
+func creditAccount(holder string) string {
+	return creditAccountPrefix + holder
+}
+
+// checkMoneyAccount returns an error for a credit account, which money cannot be deposited or transferred to
+func checkMoneyAccount(id string) error {
+	if strings.HasPrefix(id, creditAccountPrefix) {
+		return fmt.Errorf("%s holds efficiency credits, not money", id)
+	}
+	return nil
+}
+
+// energyRatingRank returns the rank of an energy rating, 0 for A
+func energyRatingRank(rating string) int {
+	for rank, energyRating := range energyRatings {
+		if energyRating == rating {
+			return rank
+		}
+	}
+	return len(energyRatings)
+}
+
+func getRetrofitProgram(APIstub shim.ChaincodeStubInterface, id string) (RetrofitProgram, error) {
+	programKey, err := APIstub.CreateCompositeKey(retrofitProgramObjectType, []string{id})
+	if err != nil {
+		return RetrofitProgram{}, err
+	}
+	programAsBytes, err := APIstub.GetState(programKey)
+	if err != nil {
+		return RetrofitProgram{}, err
+	}
+	if programAsBytes == nil {
+		return RetrofitProgram{}, fmt.Errorf("Retrofit program %s does not exist", id)
+	}
+	program := RetrofitProgram{}
+	err = json.Unmarshal(programAsBytes, &program)
+	return program, err
+}
+
+func getRetrofitWorks(APIstub shim.ChaincodeStubInterface, key string, id string) (RetrofitWorks, error) {
+	worksKey, err := APIstub.CreateCompositeKey(retrofitWorksObjectType, []string{key, id})
+	if err != nil {
+		return RetrofitWorks{}, err
+	}
+	worksAsBytes, err := APIstub.GetState(worksKey)
+	if err != nil {
+		return RetrofitWorks{}, err
+	}
+	if worksAsBytes == nil {
+		return RetrofitWorks{}, fmt.Errorf("Retrofit works %s of house %s do not exist", id, key)
+	}
+	works := RetrofitWorks{}
+	err = json.Unmarshal(worksAsBytes, &works)
+	return works, err
+}
+
+func putRetrofitWorks(APIstub shim.ChaincodeStubInterface, works RetrofitWorks) ([]byte, error) {
+	worksKey, err := APIstub.CreateCompositeKey(retrofitWorksObjectType, []string{works.HouseKey, works.ID})
+	if err != nil {
+		return nil, err
+	}
+	worksAsBytes, _ := json.Marshal(works)
+	return worksAsBytes, APIstub.PutState(worksKey, worksAsBytes)
+}
+
+/*
+ * createRetrofitProgram creates a retrofit program whose credits the invoker awards, for grantors
+ * args: program ID, name, credits per energy class gained
+ */
+func (s *SmartContract) createRetrofitProgram(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleGrantor); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("Program ID and name must not be empty")
+	}
+	credits, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getRetrofitProgram(APIstub, args[0]); err == nil {
+		return shim.Error("Retrofit program " + args[0] + " already exists")
+	}
+
+	authority, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	programKey, err := APIstub.CreateCompositeKey(retrofitProgramObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	programAsBytes, _ := json.Marshal(RetrofitProgram{ID: args[0], Name: args[1], Authority: authority, CreditsPerClass: credits})
+	if err := APIstub.PutState(programKey, programAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(programAsBytes)
+}
+
+/*
+ * recordRetrofitWorks records energy retrofit works on a house under a program, only the owner can do it.
+ * The house must have a valid energy performance certificate, the rating before the works
+ * args: house key, program ID, description, hash of the works invoice
+ */
+func (s *SmartContract) recordRetrofitWorks(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if args[2] == "" || args[3] == "" {
+		return shim.Error("Description and invoice hash must not be empty")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getRetrofitProgram(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkEnergyCertificate(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recordedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recordedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	worksAsBytes, err := putRetrofitWorks(APIstub, RetrofitWorks{
+		ID:                APIstub.GetTxID(),
+		HouseKey:          args[0],
+		Program:           args[1],
+		Description:       args[2],
+		DocumentHash:      args[3],
+		RatingBefore:      house.EnergyRating,
+		CertificateBefore: house.EnergyCertificateID,
+		RecordedBy:        recordedBy,
+		RecordedAt:        recordedAt.Format(timeLayout),
+		Status:            retrofitRecorded,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(programRetrofitIndex, []string{args[1], args[0], APIstub.GetTxID()})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(worksAsBytes)
+}
+
+/*
+ * awardRetrofitCredits awards the credits of the energy classes gained by retrofit works to the owner of the house,
+ * for the authority of the program. The house must have a new valid certificate since the works were recorded
+ * args: house key, works ID
+ */
+func (s *SmartContract) awardRetrofitCredits(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	works, err := getRetrofitWorks(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if works.Status != retrofitRecorded {
+		return shim.Error("Credits of retrofit works " + works.ID + " were already awarded")
+	}
+	program, err := getRetrofitProgram(APIstub, works.Program)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	authority, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if authority != program.Authority {
+		return shim.Error("Access denied. Only " + program.Authority + " awards the credits of program " + program.ID)
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkEnergyCertificate(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if house.EnergyCertificateID == works.CertificateBefore {
+		return shim.Error("House " + args[0] + " has no new energy performance certificate since the works")
+	}
+	gained := energyRatingRank(works.RatingBefore) - energyRatingRank(house.EnergyRating)
+	if gained <= 0 {
+		return shim.Error(fmt.Sprintf("House %s gained no energy class, rated %s before the works and %s after", args[0], works.RatingBefore, house.EnergyRating))
+	}
+
+	works.Credits = int64(gained) * program.CreditsPerClass
+	var entry = AccountEntry{Account: creditAccount(house.Owner), Amount: works.Credits, Kind: entryCreditAward, Reference: args[0], Operation: program.ID}
+	if err := postEntries(APIstub, []AccountEntry{entry}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	awardedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	works.Status = retrofitAwarded
+	works.RatingAfter = house.EnergyRating
+	works.CertificateAfter = house.EnergyCertificateID
+	works.AwardedTo = house.Owner
+	works.AwardedAt = awardedAt.Format(timeLayout)
+	worksAsBytes, err := putRetrofitWorks(APIstub, works)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "retrofitCreditsAwarded", worksAsBytes)
+	return shim.Success(worksAsBytes)
+}
+
+/*
+ * transferEfficiencyCredits transfers efficiency credits of the invoker to another identity
+ * args: recipient, amount, memo
+ */
+func (s *SmartContract) transferEfficiencyCredits(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	invokerID, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[0] == invokerID {
+		return shim.Error("Recipient must be another identity")
+	}
+
+	if err := postEntries(APIstub, []AccountEntry{
+		{Account: creditAccount(invokerID), Amount: -amount, Kind: entryCreditTransfer, Reference: args[2]},
+		{Account: creditAccount(args[0]), Amount: amount, Kind: entryCreditTransfer, Reference: args[2]},
+	}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryEfficiencyCredits returns the credit account of an identity, for the holder, admins and the custodian. args: identity
+func (s *SmartContract) queryEfficiencyCredits(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireAccountAccess(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	account, err := getAccount(APIstub, creditAccount(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accountAsBytes, _ := json.Marshal(account)
+	return shim.Success(accountAsBytes)
+}
+
+/*
+ * queryRetrofitProgramReport sums up the works of a program, for its authority: works recorded and awarded,
+ * credits awarded and the count of awards per rating before and after the works
+ * args: program ID
+ */
+func (s *SmartContract) queryRetrofitProgramReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	program, err := getRetrofitProgram(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	authority, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if authority != program.Authority {
+		return shim.Error("Access denied. Only " + program.Authority + " can report on program " + program.ID)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(programRetrofitIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var report = struct {
+		Program      string         `json:"program"`
+		Recorded     int            `json:"recorded"`
+		Awarded      int            `json:"awarded"`
+		Credits      int64          `json:"credits"`
+		Improvements map[string]int `json:"improvements"`
+	}{Program: args[0], Improvements: map[string]int{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		works, err := getRetrofitWorks(APIstub, attributes[1], attributes[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		report.Recorded++
+		if works.Status == retrofitAwarded {
+			report.Awarded++
+			report.Credits += works.Credits
+			report.Improvements[works.RatingBefore+"->"+works.RatingAfter]++
+		}
+	}
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,379 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Risk zones
+ * Every location can be classified for natural hazards, from none to high risk. Flood zones are
+ * fed by the oracles of the floodZone feed, and planners designate the class of any hazard of a
+ * location, their designation prevailing over the oracles'. A sale of a house located in a zone
+ * of medium or high risk only completes once every buyer acknowledged the disclosure of the risks
+ * of the house, as they stand when the sale completes.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	riskZoneObjectType       = "riskZone"
+	riskDisclosureObjectType = "riskDisclosure"
+)
+
+// Hazards classified, flood zones being fed by the oracles as well
+var riskHazards = []string{"flood", "landslide", "seismic", "wildfire"}
+
+// Lowest risk class requiring a disclosure to the buyers
+const disclosedRiskClass = "medium"
+
+// Sources of the classifications
+const (
+	riskSourceAuthority = "authority"
+	riskSourceOracle    = "oracle"
+)
+
+// Define the risk zone structure, the class of a hazard at a location
+type RiskZone struct {
+	Location string `json:"location"`
+	Hazard   string `json:"hazard"`
+	Class    string `json:"class"`
+	Source   string `json:"source"`
+	SetBy    string `json:"setby"`
+	SetAt    string `json:"setat"`
+}
+
+// Define the risk disclosure structure, the risks of a house acknowledged by a buyer
+type RiskDisclosure struct {
+	HouseKey       string     `json:"housekey"`
+	Buyer          string     `json:"buyer"`
+	Risks          []RiskZone `json:"risks"`
+	DocumentHash   string     `json:"documenthash"`
+	AcknowledgedAt string     `json:"acknowledgedat"`
+}
+
+// riskClassRank returns the rank of a risk class, from 0 for none, -1 for an unknown class
+func riskClassRank(class string) int {
+	for rank, known := range riskClasses {
+		if known == class {
+			return rank
+		}
+	}
+	return -1
+}
+
+// riskProfile returns the classifications of the hazards of the location, by hazard
+func riskProfile(APIstub shim.ChaincodeStubInterface, location string) (map[string]RiskZone, error) {
+	location = normalizeLocation(location)
+	profile := map[string]RiskZone{}
+	attestation, err := latestAttestation(APIstub, feedFloodZone, location)
+	if err != nil {
+		return nil, err
+	}
+	if attestation != nil {
+		var designation struct {
+			Zone string `json:"zone"`
+		}
+		if err := json.Unmarshal(attestation.Value, &designation); err != nil {
+			return nil, err
+		}
+		profile["flood"] = RiskZone{Location: location, Hazard: "flood", Class: designation.Zone, Source: riskSourceOracle, SetBy: attestation.Oracle, SetAt: attestation.ObservedAt}
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(riskZoneObjectType, []string{location})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		zone := RiskZone{}
+		if err := json.Unmarshal(queryResponse.Value, &zone); err != nil {
+			return nil, err
+		}
+		profile[zone.Hazard] = zone
+	}
+	return profile, nil
+}
+
+// disclosedRisks returns the risks of the location to disclose to the buyers, in order of hazard
+func disclosedRisks(APIstub shim.ChaincodeStubInterface, location string) ([]RiskZone, error) {
+	profile, err := riskProfile(APIstub, location)
+	if err != nil {
+		return nil, err
+	}
+	risks := []RiskZone{}
+	for _, hazard := range riskHazards {
+		if zone, found := profile[hazard]; found && riskClassRank(zone.Class) >= riskClassRank(disclosedRiskClass) {
+			risks = append(risks, zone)
+		}
+	}
+	return risks, nil
+}
+
+// checkRiskDisclosures returns an error unless every new owner of the house acknowledged its risks as they stand
+func checkRiskDisclosures(APIstub shim.ChaincodeStubInterface, key string, house House, shares []OwnershipShare) error {
+	risks, err := disclosedRisks(APIstub, house.Location)
+	if err != nil || len(risks) == 0 {
+		return err
+	}
+	holders := map[string]bool{}
+	for _, share := range houseShares(house) {
+		holders[share.Owner] = true
+	}
+
+	for _, share := range shares {
+		if holders[share.Owner] {
+			continue
+		}
+		disclosure, err := getRiskDisclosure(APIstub, key, share.Owner)
+		if err != nil {
+			return err
+		}
+		acknowledged := map[string]string{}
+		if disclosure != nil {
+			for _, risk := range disclosure.Risks {
+				acknowledged[risk.Hazard] = risk.Class
+			}
+		}
+		for _, risk := range risks {
+			if acknowledged[risk.Hazard] != risk.Class {
+				return fmt.Errorf("%s must acknowledge the %s risk (%s) of house %s before buying it", share.Owner, risk.Hazard, risk.Class, key)
+			}
+		}
+	}
+	return nil
+}
+
+func getRiskDisclosure(APIstub shim.ChaincodeStubInterface, key string, buyer string) (*RiskDisclosure, error) {
+	disclosureKey, err := APIstub.CreateCompositeKey(riskDisclosureObjectType, []string{key, buyer})
+	if err != nil {
+		return nil, err
+	}
+	disclosureAsBytes, err := APIstub.GetState(disclosureKey)
+	if err != nil || disclosureAsBytes == nil {
+		return nil, err
+	}
+	disclosure := RiskDisclosure{}
+	if err := json.Unmarshal(disclosureAsBytes, &disclosure); err != nil {
+		return nil, err
+	}
+	return &disclosure, nil
+}
+
+/*
+ * setRiskZone designates the class of a hazard at a location, for planners
+ * args: location, hazard (flood, landslide, seismic or wildfire), class (none, low, medium or high)
+ */
+func (s *SmartContract) setRiskZone(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+	canonical, err := resolveLocationAlias(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location := normalizeLocation(canonical)
+	if location == "" {
+		return shim.Error("Location must not be empty")
+	}
+	known := false
+	for _, hazard := range riskHazards {
+		known = known || hazard == args[1]
+	}
+	if !known {
+		return shim.Error(fmt.Sprintf("Hazard must be one of %v", riskHazards))
+	}
+	if riskClassRank(args[2]) < 0 {
+		return shim.Error(fmt.Sprintf("Class must be one of %v", riskClasses))
+	}
+
+	setBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	setAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	zoneKey, err := APIstub.CreateCompositeKey(riskZoneObjectType, []string{location, args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	zoneAsBytes, _ := json.Marshal(RiskZone{Location: location, Hazard: args[1], Class: args[2], Source: riskSourceAuthority, SetBy: setBy, SetAt: setAt.Format(timeLayout)})
+	if err := APIstub.PutState(zoneKey, zoneAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "riskZoneDesignated", zoneAsBytes)
+	return shim.Success(zoneAsBytes)
+}
+
+/*
+ * acknowledgeRiskDisclosure records that the invoker, buyer of a house, acknowledged the disclosure of its current risks
+ * args: house key, hash of the disclosure document
+ */
+func (s *SmartContract) acknowledgeRiskDisclosure(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("Document hash must not be empty")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	risks, err := disclosedRisks(APIstub, house.Location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(risks) == 0 {
+		return shim.Error("House " + args[0] + " is not located in a risk zone")
+	}
+	buyer, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	acknowledgedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	disclosureKey, err := APIstub.CreateCompositeKey(riskDisclosureObjectType, []string{args[0], buyer})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	disclosureAsBytes, _ := json.Marshal(RiskDisclosure{HouseKey: args[0], Buyer: buyer, Risks: risks, DocumentHash: args[1], AcknowledgedAt: acknowledgedAt.Format(timeLayout)})
+	if err := APIstub.PutState(disclosureKey, disclosureAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(disclosureAsBytes)
+}
+
+// queryHouseRisks returns the classifications of the hazards of the location of a house. args: house key
+func (s *SmartContract) queryHouseRisks(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	profile, err := riskProfile(APIstub, house.Location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+/*
+ * queryHousesByRiskClass returns the houses of the locations where the hazard is of the class
+ * args: hazard, class
+ */
+func (s *SmartContract) queryHousesByRiskClass(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if riskClassRank(args[1]) < 0 {
+		return shim.Error(fmt.Sprintf("Class must be one of %v", riskClasses))
+	}
+
+	// The locations classified for the hazard, by the oracles for floods or by the planners
+	locations := map[string]bool{}
+	if args[0] == "flood" {
+		resultsIterator, err := APIstub.GetStateByPartialCompositeKey(oracleLatestObjectType, []string{feedFloodZone})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer resultsIterator.Close()
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			attestation := OracleAttestation{}
+			if err := json.Unmarshal(queryResponse.Value, &attestation); err != nil {
+				return shim.Error(err.Error())
+			}
+			locations[attestation.Subject] = true
+		}
+	}
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(riskZoneObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		zone := RiskZone{}
+		if err := json.Unmarshal(queryResponse.Value, &zone); err != nil {
+			return shim.Error(err.Error())
+		}
+		if zone.Hazard == args[0] {
+			locations[zone.Location] = true
+		}
+	}
+
+	keys := []string{}
+	for _, location := range sortedKeys(locations) {
+		profile, err := riskProfile(APIstub, location)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if profile[args[0]].Class != args[1] || location == nationalSubject {
+			continue
+		}
+		locationKeys, err := queryIndexedHouseKeys(APIstub, locationIndex, append(locationIndexAttributes(location), ""))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, locationKeys...)
+	}
+	sort.Strings(keys)
+
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
@@ -0,0 +1,255 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Role assignments
+ * Besides the roles of the certificates and of the MSPs, admins assign roles on the ledger to
+ * single identities, named by the unique ID returned by whoAmI. The first admin is designated at
+ * instantiation, with its ID as the first argument of Init, or else is the first caller of
+ * bootstrapAdmin from the MSP named by the second argument of Init. Without that MSP, bootstrapAdmin
+ * is refused, so that no member of another organization can make itself admin.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	roleAssignmentObjectType = "roleAssignment"
+	adminBootstrapKey        = "adminBootstrap"
+	adminBootstrapMSPKey     = "adminBootstrapMSP"
+)
+
+// Roles that can be assigned
+var knownRoles = []string{roleAdmin, rolePlanner, roleCourt, roleRegistrar, roleNotary, roleCompliance, roleArbitrator,
+	roleUtility, roleCustodian, roleTaxAuthority, roleGrantor, roleInspector, roleInsurer, roleSubscriber}
+
+// Define the role assignment structure, a role assigned by an admin to an identity
+type RoleAssignment struct {
+	Identity   string `json:"identity"`
+	Role       string `json:"role"`
+	AssignedBy string `json:"assignedby"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// getAssignedRoles returns the roles assigned on the ledger to the identity
+func getAssignedRoles(APIstub shim.ChaincodeStubInterface, identity string) ([]string, error) {
+	assignments, err := getRoleAssignments(APIstub, []string{identity})
+	if err != nil {
+		return nil, err
+	}
+	roles := []string{}
+	for _, assignment := range assignments {
+		roles = append(roles, assignment.Role)
+	}
+	return roles, nil
+}
+
+func getRoleAssignments(APIstub shim.ChaincodeStubInterface, attributes []string) ([]RoleAssignment, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(roleAssignmentObjectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assignments := []RoleAssignment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		assignment := RoleAssignment{}
+		if err := json.Unmarshal(queryResponse.Value, &assignment); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, nil
+}
+
+func putRoleAssignment(APIstub shim.ChaincodeStubInterface, identity string, role string, assignedBy string) ([]byte, error) {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	assignmentKey, err := APIstub.CreateCompositeKey(roleAssignmentObjectType, []string{identity, role})
+	if err != nil {
+		return nil, err
+	}
+	assignmentAsBytes, _ := json.Marshal(RoleAssignment{Identity: identity, Role: role, AssignedBy: assignedBy, Timestamp: txTime.Format(timeLayout)})
+	return assignmentAsBytes, APIstub.PutState(assignmentKey, assignmentAsBytes)
+}
+
+// bootstrapAdminIdentity assigns the admin role to the first admin, once per deployment
+func bootstrapAdminIdentity(APIstub shim.ChaincodeStubInterface, identity string, assignedBy string) ([]byte, error) {
+	bootstrapAsBytes, err := APIstub.GetState(adminBootstrapKey)
+	if err != nil {
+		return nil, err
+	}
+	if bootstrapAsBytes != nil {
+		return nil, fmt.Errorf("The admin was already bootstrapped")
+	}
+	assignmentAsBytes, err := putRoleAssignment(APIstub, identity, roleAdmin, assignedBy)
+	if err != nil {
+		return nil, err
+	}
+	return assignmentAsBytes, APIstub.PutState(adminBootstrapKey, assignmentAsBytes)
+}
+
+// bootstrapAdmin assigns the admin role to the invoker, a member of the MSP named at instantiation, when no admin was
+// designated at instantiation or bootstrapped before
+func (s *SmartContract) bootstrapAdmin(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	mspAsBytes, err := APIstub.GetState(adminBootstrapMSPKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mspAsBytes == nil {
+		return shim.Error("Access denied. No MSP was named at instantiation to bootstrap the admin")
+	}
+	invokerMSP, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if invokerMSP != string(mspAsBytes) {
+		return shim.Error(fmt.Sprintf("Access denied. The admin is bootstrapped by a member of %s", mspAsBytes))
+	}
+	identity, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assignmentAsBytes, err := bootstrapAdminIdentity(APIstub, identity, identity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "roleAssigned", assignmentAsBytes)
+	return shim.Success(assignmentAsBytes)
+}
+
+func checkKnownRole(role string) error {
+	for _, knownRole := range knownRoles {
+		if role == knownRole {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown role %q, expecting one of %v", role, knownRoles)
+}
+
+/*
+ * assignRole assigns a role to an identity, for admins
+ * args: identity ID (as returned by whoAmI), role
+ */
+func (s *SmartContract) assignRole(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Identity must not be empty")
+	}
+	if err := checkKnownRole(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	invokerID, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assignmentAsBytes, err := putRoleAssignment(APIstub, args[0], args[1], invokerID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "roleAssigned", assignmentAsBytes)
+	return shim.Success(assignmentAsBytes)
+}
+
+/*
+ * revokeRole revokes a role assigned to an identity, for admins. An admin cannot revoke its own admin role
+ * args: identity ID, role
+ */
+func (s *SmartContract) revokeRole(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	invokerID, err := getInvokerUniqueID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// The ledger must not be left without an admin by mistake
+	if args[0] == invokerID && args[1] == roleAdmin {
+		return shim.Error("An admin cannot revoke its own admin role")
+	}
+
+	assignmentKey, err := APIstub.CreateCompositeKey(roleAssignmentObjectType, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	assignmentAsBytes, err := APIstub.GetState(assignmentKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if assignmentAsBytes == nil {
+		return shim.Error(fmt.Sprintf("Role %s is not assigned to %s", args[1], args[0]))
+	}
+	if err := APIstub.DelState(assignmentKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "roleRevoked", assignmentAsBytes)
+	return shim.Success(nil)
+}
+
+/*
+ * listRoleAssignments returns the roles assigned on the ledger, for admins
+ * args: identity ID (empty for every identity)
+ */
+func (s *SmartContract) listRoleAssignments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attributes := []string{}
+	if args[0] != "" {
+		attributes = append(attributes, args[0])
+	}
+	assignments, err := getRoleAssignments(APIstub, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	assignmentsAsBytes, _ := json.Marshal(assignments)
+	return shim.Success(assignmentsAsBytes)
+}
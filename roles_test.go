@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Role assignment tests
+ * Bootstrap of the first admin, limited to the MSP named at instantiation.
+ */
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestBootstrapAdminIsLimitedToTheMSPNamedAtInit(t *testing.T) {
+	outsider := serializedIdentity(t, "Org2MSP", "mallory")
+	member := serializedIdentity(t, "Org1MSP", "alice")
+	for _, test := range []struct {
+		initArgs []string
+		refused  map[string][]byte
+		admin    []byte
+	}{
+		{[]string{"init"}, map[string][]byte{"No MSP was named": member}, nil},
+		{[]string{"init", "", "Org1MSP"}, map[string][]byte{"bootstrapped by a member of Org1MSP": outsider}, member},
+	} {
+		stub := shimtest.NewMockStub("fabhouse", new(SmartContract))
+		args := [][]byte{}
+		for _, arg := range test.initArgs {
+			args = append(args, []byte(arg))
+		}
+		if response := stub.MockInit("init", args); response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+		for message, creator := range test.refused {
+			stub.Creator = creator
+			if response := stub.MockInvoke("refused", [][]byte{[]byte("bootstrapAdmin")}); response.Status == shim.OK || !strings.Contains(response.Message, message) {
+				t.Errorf("bootstrapAdmin after Init%q returned %q, expected it to be refused with %q", test.initArgs, response.Message, message)
+			}
+		}
+		if test.admin != nil {
+			stub.Creator = test.admin
+			if response := stub.MockInvoke("bootstrapped", [][]byte{[]byte("bootstrapAdmin")}); response.Status != shim.OK {
+				t.Errorf("bootstrapAdmin after Init%q failed with %q", test.initArgs, response.Message)
+			}
+		}
+	}
+}
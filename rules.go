@@ -0,0 +1,334 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Business rules
+ * Policies are stored on the ledger as a table of rules managed by admins, so that they can
+ * change without upgrading the chaincode. Every rule has a type, implemented by an evaluator
+ * below, and parameters. Rules restricted to a list of functions are evaluated before those
+ * functions run, the others on every transfer.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const ruleObjectType = "rule"
+
+// Define the rule structure, one line of the rules table
+type Rule struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Params    map[string]string `json:"params"`
+	Functions []string          `json:"functions,omitempty"`
+}
+
+// Context of the evaluation of the rules. House, shares and reason are only set for a transfer
+type ruleContext struct {
+	function string
+	key      string
+	house    *House
+	shares   []OwnershipShare
+	reason   string
+	txTime   time.Time
+}
+
+// Define the rule evaluator structure: validate checks the parameters, evaluate returns an error when the rule is broken
+type ruleEvaluator struct {
+	validate func(params map[string]string) error
+	evaluate func(APIstub shim.ChaincodeStubInterface, rule Rule, context ruleContext) error
+}
+
+var ruleEvaluators = map[string]ruleEvaluator{
+	// Minimum number of days between two transfers of the same house. params: days
+	"minDaysBetweenTransfers": {
+		validate: func(params map[string]string) error {
+			return validatePositiveParam(params, "days")
+		},
+		evaluate: func(APIstub shim.ChaincodeStubInterface, rule Rule, context ruleContext) error {
+			if context.house == nil {
+				return nil
+			}
+			days, _ := strconv.Atoi(rule.Params["days"])
+			last, err := lastTransferTime(APIstub, context.key)
+			if err != nil || last.IsZero() {
+				return err
+			}
+			if context.txTime.Before(last.AddDate(0, 0, days)) {
+				return fmt.Errorf("Rule %s: house %s was transferred on %s, less than %d days ago", rule.ID, context.key, last.Format(timeLayout), days)
+			}
+			return nil
+		},
+	},
+	// Minimum number of days a house is held before it can be sold again, unless the regulator approved the resale. params: days
+	minHoldingPeriodRule: {
+		validate: func(params map[string]string) error {
+			return validatePositiveParam(params, "days")
+		},
+		evaluate: func(APIstub shim.ChaincodeStubInterface, rule Rule, context ruleContext) error {
+			if context.house == nil || context.reason != reasonSale && context.reason != reasonShareTransfer {
+				return nil
+			}
+			days, _ := strconv.Atoi(rule.Params["days"])
+			endsAt, err := cooldownEnd(APIstub, context.key, days)
+			if err != nil || !context.txTime.Before(endsAt) {
+				return err
+			}
+			approved, err := consumeResaleApproval(APIstub, context.key)
+			if err != nil || approved {
+				return err
+			}
+			return fmt.Errorf("Rule %s: house %s cannot be sold again before %s without the approval of the regulator", rule.ID, context.key, endsAt.Format(timeLayout))
+		},
+	},
+	// Maximum share of a house held by a single identity, in basis points. params: share
+	"maxOwnershipShare": {
+		validate: func(params map[string]string) error {
+			return validatePositiveParam(params, "share")
+		},
+		evaluate: func(APIstub shim.ChaincodeStubInterface, rule Rule, context ruleContext) error {
+			maxShare, _ := strconv.Atoi(rule.Params["share"])
+			for _, share := range context.shares {
+				if share.Share > maxShare {
+					return fmt.Errorf("Rule %s: %s cannot hold more than %d basis points of house %s", rule.ID, share.Owner, maxShare, context.key)
+				}
+			}
+			return nil
+		},
+	},
+	// Period during which transfers, or the functions of the rule, are suspended. params: from, to (YYYY-MM-DD, inclusive)
+	"blackoutPeriod": {
+		validate: func(params map[string]string) error {
+			from, err := time.Parse(dayLayout, params["from"])
+			if err != nil {
+				return fmt.Errorf("Parameter from must be formatted YYYY-MM-DD")
+			}
+			to, err := time.Parse(dayLayout, params["to"])
+			if err != nil {
+				return fmt.Errorf("Parameter to must be formatted YYYY-MM-DD")
+			}
+			if to.Before(from) {
+				return fmt.Errorf("Parameter to must not be before from")
+			}
+			return nil
+		},
+		evaluate: func(APIstub shim.ChaincodeStubInterface, rule Rule, context ruleContext) error {
+			from, _ := time.Parse(dayLayout, rule.Params["from"])
+			to, _ := time.Parse(dayLayout, rule.Params["to"])
+			if !context.txTime.Before(from) && context.txTime.Before(to.AddDate(0, 0, 1)) {
+				return fmt.Errorf("Rule %s: %s is suspended from %s to %s", rule.ID, context.function, rule.Params["from"], rule.Params["to"])
+			}
+			return nil
+		},
+	},
+}
+
+func validatePositiveParam(params map[string]string, name string) error {
+	value, err := strconv.Atoi(params[name])
+	if err != nil || value <= 0 {
+		return fmt.Errorf("Parameter %s must be a positive number", name)
+	}
+	return nil
+}
+
+// lastTransferTime returns the time of the last recorded transfer of the house, zero if none
+func lastTransferTime(APIstub shim.ChaincodeStubInterface, key string) (time.Time, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferObjectType, []string{key})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resultsIterator.Close()
+
+	last := time.Time{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return time.Time{}, err
+		}
+		transfer := Transfer{}
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			return time.Time{}, err
+		}
+		timestamp, err := time.Parse(timeLayout, transfer.Timestamp)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if timestamp.After(last) {
+			last = timestamp
+		}
+	}
+	return last, nil
+}
+
+func getRules(APIstub shim.ChaincodeStubInterface) ([]Rule, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(ruleObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	rules := []Rule{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		rule := Rule{}
+		if err := json.Unmarshal(queryResponse.Value, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// evaluateRules returns the error of the first broken rule applying to the context. Rules are evaluated in the order of their IDs
+func evaluateRules(APIstub shim.ChaincodeStubInterface, context ruleContext) error {
+	rules, err := getRules(APIstub)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	if context.txTime, err = getTxTime(APIstub); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		applies := len(rule.Functions) == 0 && context.house != nil
+		for _, function := range rule.Functions {
+			if function == context.function && context.house == nil {
+				applies = true
+			}
+		}
+		evaluator, known := ruleEvaluators[rule.Type]
+		if !applies || !known {
+			continue
+		}
+		if err := evaluator.evaluate(APIstub, rule, context); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateTransferRules evaluates the rules applying to the transfer of the house to the shares
+func evaluateTransferRules(APIstub shim.ChaincodeStubInterface, key string, house House, shares []OwnershipShare, reason string) error {
+	function, _ := APIstub.GetFunctionAndParameters()
+	return evaluateRules(APIstub, ruleContext{function: function, key: key, house: &house, shares: shares, reason: reason})
+}
+
+/*
+ * setRule adds or replaces a rule of the rules table, for admins
+ * args: rule ID, type, parameters as a JSON object of strings, functions as a comma separated list (empty for transfers)
+ */
+func (s *SmartContract) setRule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Rule ID must not be empty")
+	}
+	evaluator, known := ruleEvaluators[args[1]]
+	if !known {
+		types := []string{}
+		for ruleType := range ruleEvaluators {
+			types = append(types, ruleType)
+		}
+		sort.Strings(types)
+		return shim.Error(fmt.Sprintf("Unknown rule type %q, expecting one of %v", args[1], types))
+	}
+	params := map[string]string{}
+	if err := json.Unmarshal([]byte(args[2]), &params); err != nil {
+		return shim.Error("Parameters must be a JSON object of strings")
+	}
+	if err := evaluator.validate(params); err != nil {
+		return shim.Error(err.Error())
+	}
+	functions := splitList(args[3])
+	for _, function := range functions {
+		// The rules table must stay manageable whatever the rules
+		if function == "setRule" || function == "deleteRule" {
+			return shim.Error("A rule cannot apply to " + function)
+		}
+	}
+
+	ruleKey, err := APIstub.CreateCompositeKey(ruleObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, _ := json.Marshal(Rule{ID: args[0], Type: args[1], Params: params, Functions: functions})
+	if err := APIstub.PutState(ruleKey, ruleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(ruleAsBytes)
+}
+
+/*
+ * deleteRule removes a rule from the rules table, for admins
+ * args: rule ID
+ */
+func (s *SmartContract) deleteRule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ruleKey, err := APIstub.CreateCompositeKey(ruleObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, err := APIstub.GetState(ruleKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ruleAsBytes == nil {
+		return shim.Error("Rule " + args[0] + " does not exist")
+	}
+	if err := APIstub.DelState(ruleKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryRules returns the rules table
+func (s *SmartContract) queryRules(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	rules, err := getRules(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rulesAsBytes, _ := json.Marshal(rules)
+	return shim.Success(rulesAsBytes)
+}
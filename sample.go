@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Audit samples
+ * Spot audits work on a random subset of the houses which every endorser, and any later auditor,
+ * must draw identically. Every house key is ranked by the sha256 of the seed and the key, and the
+ * sample is made of the lowest ranked ones, so that the same seed over the same houses always
+ * draws the same sample.
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Maximum size of an audit sample
+const maxSampleSize = 1000
+
+// sampleRank ranks the house key for the seed
+func sampleRank(seed string, key string) string {
+	hash := sha256.Sum256([]byte(seed + "\x00" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * sampleHouses draws a reproducible sample of house keys for spot audits
+ * args: sample size, seed (empty to use the transaction ID)
+ */
+func (s *SmartContract) sampleHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size <= 0 || size > maxSampleSize {
+		return shim.Error(fmt.Sprintf("Sample size must be a number from 1 to %d", maxSampleSize))
+	}
+	seed := args[1]
+	if seed == "" {
+		seed = APIstub.GetTxID()
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(houseStartKey, houseEndKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	keys, ranks := []string{}, map[string]string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, queryResponse.Key)
+		ranks[queryResponse.Key] = sampleRank(seed, queryResponse.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return ranks[keys[i]] < ranks[keys[j]] })
+	if len(keys) > size {
+		keys = keys[:size]
+	}
+	sort.Strings(keys)
+
+	var sample = struct {
+		Seed    string   `json:"seed"`
+		Size    int      `json:"size"`
+		Keys    []string `json:"keys"`
+		DrawnOf int      `json:"drawnof"`
+	}{Seed: seed, Size: len(keys), Keys: keys, DrawnOf: len(ranks)}
+
+	sampleAsBytes, _ := json.Marshal(sample)
+	return shim.Success(sampleAsBytes)
+}
@@ -0,0 +1,233 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Sanctions screening
+ * The regulator maintains a blocklist of identities on the ledger. Both parties of every
+ * transfer are screened against it, a match failing the transaction with a COMPLIANCE_BLOCKED
+ * error whose message is a JSON object, so that clients can tell it apart from other errors.
+ * The blocklist can only be changed by admins of the regulator MSP.
+ */
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	regulatorMSPKey     = "CONFIG_REGULATORMSP"
+	blocklistObjectType = "blocklist"
+)
+
+// Code of the errors raised when a party is on the blocklist
+const complianceBlocked = "COMPLIANCE_BLOCKED"
+
+// Define the blocked identity structure, one entry of the blocklist
+type BlockedIdentity struct {
+	Identity string `json:"identity"`
+	Reason   string `json:"reason"`
+	AddedBy  string `json:"addedby"`
+	AddedAt  string `json:"addedat"`
+}
+
+// Define the compliance error structure, marshalled as the error message
+type ComplianceError struct {
+	Code     string `json:"code"`
+	Identity string `json:"identity"`
+	Reason   string `json:"reason"`
+}
+
+func (e ComplianceError) Error() string {
+	errorAsBytes, _ := json.Marshal(e)
+	return string(errorAsBytes)
+}
+
+// checkNotBlocked returns a COMPLIANCE_BLOCKED error when the identity is on the blocklist
+func checkNotBlocked(APIstub shim.ChaincodeStubInterface, identity string) error {
+	blockedKey, err := APIstub.CreateCompositeKey(blocklistObjectType, []string{identity})
+	if err != nil {
+		return err
+	}
+	blockedAsBytes, err := APIstub.GetState(blockedKey)
+	if err != nil || blockedAsBytes == nil {
+		return err
+	}
+
+	blocked := BlockedIdentity{}
+	if err := json.Unmarshal(blockedAsBytes, &blocked); err != nil {
+		return err
+	}
+	return ComplianceError{Code: complianceBlocked, Identity: identity, Reason: blocked.Reason}
+}
+
+// requireRegulator returns an error unless the invoker is an admin of the regulator MSP
+func requireRegulator(APIstub shim.ChaincodeStubInterface) error {
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return err
+	}
+	regulatorMSPAsBytes, err := APIstub.GetState(regulatorMSPKey)
+	if err != nil {
+		return err
+	}
+	if regulatorMSPAsBytes == nil {
+		return fmt.Errorf("No regulator MSP is configured")
+	}
+	mspID, err := getInvokerMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	if mspID != string(regulatorMSPAsBytes) {
+		return fmt.Errorf("Only the regulator MSP %s can do this", regulatorMSPAsBytes)
+	}
+	return nil
+}
+
+/*
+ * setRegulatorMSP designates the MSP of the regulator. Once designated, only the regulator can change it
+ * args: MSP ID
+ */
+func (s *SmartContract) setRegulatorMSP(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if args[0] == "" {
+		return shim.Error("MSP ID must not be empty")
+	}
+
+	regulatorMSPAsBytes, err := APIstub.GetState(regulatorMSPKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if regulatorMSPAsBytes == nil {
+		err = requireRole(APIstub, roleAdmin)
+	} else {
+		err = requireRegulator(APIstub)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := APIstub.PutState(regulatorMSPKey, []byte(args[0])); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * addToBlocklist blocks an identity from any transfer, for the regulator
+ * args: identity, reason
+ */
+func (s *SmartContract) addToBlocklist(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[0] == "" {
+		return shim.Error("Identity must not be empty")
+	}
+	if err := requireRegulator(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	addedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	addedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	blockedKey, err := APIstub.CreateCompositeKey(blocklistObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	blockedAsBytes, _ := json.Marshal(BlockedIdentity{Identity: args[0], Reason: args[1], AddedBy: addedBy, AddedAt: addedAt.Format(timeLayout)})
+	if err := APIstub.PutState(blockedKey, blockedAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(blockedAsBytes)
+}
+
+/*
+ * removeFromBlocklist lifts the block on an identity, for the regulator
+ * args: identity
+ */
+func (s *SmartContract) removeFromBlocklist(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRegulator(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	blockedKey, err := APIstub.CreateCompositeKey(blocklistObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	blockedAsBytes, err := APIstub.GetState(blockedKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if blockedAsBytes == nil {
+		return shim.Error(args[0] + " is not on the blocklist")
+	}
+	if err := APIstub.DelState(blockedKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryBlocklist lists the blocked identities, for the regulator
+func (s *SmartContract) queryBlocklist(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	if err := requireRegulator(APIstub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(blocklistObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	blocklist := []BlockedIdentity{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		blocked := BlockedIdentity{}
+		if err := json.Unmarshal(queryResponse.Value, &blocked); err != nil {
+			return shim.Error(err.Error())
+		}
+		blocklist = append(blocklist, blocked)
+	}
+
+	blocklistAsBytes, _ := json.Marshal(blocklist)
+	return shim.Success(blocklistAsBytes)
+}
@@ -0,0 +1,299 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Saved queries
+ * Rich queries are only run from definitions registered by admins, instead of selectors written
+ * by clients. A definition is a CouchDB query whose values can be placeholders, strings of the form
+ * "{{name}}", replaced when the query runs by the parameters given by the client. Parameters must
+ * be strings, numbers or booleans and only replace whole values, so that a client cannot inject
+ * operators into the selector. Saved queries need a CouchDB state database.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const savedQueryObjectType = "savedQuery"
+
+// Placeholders of the saved queries
+var placeholderPattern = regexp.MustCompile(`^\{\{([A-Za-z][A-Za-z0-9_]*)\}\}$`)
+
+// Define the saved query structure, a named query definition with placeholders
+type SavedQuery struct {
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Query        json.RawMessage `json:"query"`
+	Params       []string        `json:"params"`
+	RegisteredBy string          `json:"registeredby"`
+	RegisteredAt string          `json:"registeredat"`
+}
+
+func getSavedQuery(APIstub shim.ChaincodeStubInterface, name string) (SavedQuery, error) {
+	queryKey, err := APIstub.CreateCompositeKey(savedQueryObjectType, []string{name})
+	if err != nil {
+		return SavedQuery{}, err
+	}
+	queryAsBytes, err := APIstub.GetState(queryKey)
+	if err != nil {
+		return SavedQuery{}, err
+	}
+	if queryAsBytes == nil {
+		return SavedQuery{}, fmt.Errorf("Saved query %s does not exist", name)
+	}
+	query := SavedQuery{}
+	err = json.Unmarshal(queryAsBytes, &query)
+	return query, err
+}
+
+// queryPlaceholders collects the names of the placeholders of a decoded query
+func queryPlaceholders(value interface{}, names map[string]bool) {
+	switch typed := value.(type) {
+	case string:
+		if match := placeholderPattern.FindStringSubmatch(typed); match != nil {
+			names[match[1]] = true
+		}
+	case []interface{}:
+		for _, element := range typed {
+			queryPlaceholders(element, names)
+		}
+	case map[string]interface{}:
+		for _, element := range typed {
+			queryPlaceholders(element, names)
+		}
+	}
+}
+
+// bindPlaceholders returns the decoded query with its placeholders replaced by the parameters
+func bindPlaceholders(value interface{}, params map[string]interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		if match := placeholderPattern.FindStringSubmatch(typed); match != nil {
+			return params[match[1]]
+		}
+	case []interface{}:
+		bound := []interface{}{}
+		for _, element := range typed {
+			bound = append(bound, bindPlaceholders(element, params))
+		}
+		return bound
+	case map[string]interface{}:
+		bound := map[string]interface{}{}
+		for key, element := range typed {
+			bound[key] = bindPlaceholders(element, params)
+		}
+		return bound
+	}
+	return value
+}
+
+/*
+ * registerSavedQuery registers or replaces a saved query, for admins
+ * args: name, description, CouchDB query as JSON with "{{name}}" placeholders
+ */
+func (s *SmartContract) registerSavedQuery(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" {
+		return shim.Error("Saved query name must not be empty")
+	}
+	var definition map[string]interface{}
+	if err := json.Unmarshal([]byte(args[2]), &definition); err != nil {
+		return shim.Error("Query must be a JSON object")
+	}
+	if _, found := definition["selector"]; !found {
+		return shim.Error("Query must have a selector")
+	}
+	names := map[string]bool{}
+	queryPlaceholders(definition, names)
+	registeredBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The query is stored re-encoded, so that its text does not depend on the client
+	queryJSON, _ := json.Marshal(definition)
+	var query = SavedQuery{
+		Name:         args[0],
+		Description:  args[1],
+		Query:        queryJSON,
+		Params:       sortedKeys(names),
+		RegisteredBy: registeredBy,
+		RegisteredAt: txTime.Format(timeLayout),
+	}
+	queryKey, err := APIstub.CreateCompositeKey(savedQueryObjectType, []string{query.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryAsBytes, _ := json.Marshal(query)
+	if err := APIstub.PutState(queryKey, queryAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(queryAsBytes)
+}
+
+/*
+ * deleteSavedQuery removes a saved query, for admins
+ * args: name
+ */
+func (s *SmartContract) deleteSavedQuery(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getSavedQuery(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	queryKey, err := APIstub.CreateCompositeKey(savedQueryObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(queryKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// querySavedQueries returns the saved queries, in order of name
+func (s *SmartContract) querySavedQueries(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(savedQueryObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	queries := []SavedQuery{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		query := SavedQuery{}
+		if err := json.Unmarshal(queryResponse.Value, &query); err != nil {
+			return shim.Error(err.Error())
+		}
+		queries = append(queries, query)
+	}
+
+	queriesAsBytes, _ := json.Marshal(queries)
+	return shim.Success(queriesAsBytes)
+}
+
+/*
+ * runSavedQuery runs a saved query with the parameters, returning one page of the records it selects
+ * args: name, parameters as a JSON object, page size (optional, 200 by default), bookmark (optional)
+ */
+func (s *SmartContract) runSavedQuery(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 4")
+	}
+	pageSize, bookmark := maxPageSize, ""
+	if len(args) > 2 {
+		var err error
+		if pageSize, err = strconv.Atoi(args[2]); err != nil || pageSize <= 0 || pageSize > maxPageSize {
+			return shim.Error(fmt.Sprintf("Page size must be a number from 1 to %d", maxPageSize))
+		}
+	}
+	if len(args) > 3 {
+		bookmark = args[3]
+	}
+	saved, err := getSavedQuery(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	params := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(args[1]), &params); err != nil {
+		return shim.Error("Parameters must be a JSON object")
+	}
+	for _, name := range saved.Params {
+		value, found := params[name]
+		if !found {
+			return shim.Error(fmt.Sprintf("Saved query %s expects parameters %v", saved.Name, saved.Params))
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return shim.Error("Parameter " + name + " must be a string, a number or a boolean")
+		}
+	}
+	if len(params) != len(saved.Params) {
+		unexpected := []string{}
+		for name := range params {
+			if !containsString(saved.Params, name) {
+				unexpected = append(unexpected, name)
+			}
+		}
+		sort.Strings(unexpected)
+		return shim.Error(fmt.Sprintf("Saved query %s has no parameters %v", saved.Name, unexpected))
+	}
+
+	var definition interface{}
+	if err := json.Unmarshal(saved.Query, &definition); err != nil {
+		return shim.Error(err.Error())
+	}
+	queryString, _ := json.Marshal(bindPlaceholders(definition, params))
+	resultsIterator, responseMetadata, err := APIstub.GetQueryResultWithPagination(string(queryString), int32(pageSize), bookmark)
+	if err != nil {
+		return shim.Error("Saved queries need a CouchDB state database: " + err.Error())
+	}
+	defer resultsIterator.Close()
+
+	type record struct {
+		Key    string          `json:"Key"`
+		Record json.RawMessage `json:"Record"`
+	}
+	var result = struct {
+		Records  []record `json:"records"`
+		Bookmark string   `json:"bookmark"`
+	}{Records: []record{}, Bookmark: responseMetadata.Bookmark}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Records = append(result.Records, record{Key: queryResponse.Key, Record: queryResponse.Value})
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
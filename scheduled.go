@@ -0,0 +1,284 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Time-locked transfers
+ * The owner can schedule the transfer of a house at a future date. The scheduled transfer is
+ * recorded at once, and completed by finalizeDueTransfers, which anyone can call, once the
+ * timestamp of the calling transaction has passed its effective date. A scheduled sale then goes
+ * through the same path as changeHouseOwner: its pre-emption window opens at the effective date,
+ * and it may wait for the co-signature of registrars or the settlement of its tax.
+ */
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const scheduledTransferObjectType = "scheduledTransfer"
+
+// Define the scheduled transfer structure, at most one per house
+type ScheduledTransfer struct {
+	HouseKey    string `json:"housekey"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Reason      string `json:"reason"`
+	Price       int64  `json:"price"`
+	EffectiveAt string `json:"effectiveat"`
+	ScheduledBy string `json:"scheduledby"`
+	TxID        string `json:"txid"`
+	LastFailure string `json:"lastfailure,omitempty"`
+}
+
+/*
+ * scheduleHouseTransfer records a transfer of the house effective at a future date, only the owner can do it
+ * args: house key, new owner, effective date (RFC 3339), [reason], [price]
+ */
+func (s *SmartContract) scheduleHouseTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 to 5")
+	}
+
+	effectiveAt, err := time.Parse(timeLayout, args[2])
+	if err != nil {
+		return shim.Error("Effective date must be formatted as RFC 3339")
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !effectiveAt.After(txTime) {
+		return shim.Error("Effective date must be in the future")
+	}
+
+	reason, price := reasonSale, ""
+	if len(args) > 3 {
+		reason = args[3]
+	}
+	if len(args) > 4 {
+		price = args[4]
+	}
+	reason, amount, err := parseTransferReason(reason, price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	// Fail early, the checks are run again when the transfer becomes effective
+	if err := checkTransferAllowed(APIstub, args[0], house, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scheduledKey, err := APIstub.CreateCompositeKey(scheduledTransferObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existingAsBytes, err := APIstub.GetState(scheduledKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existingAsBytes != nil {
+		return shim.Error("A transfer of house " + args[0] + " is already scheduled")
+	}
+
+	scheduledBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var scheduled = ScheduledTransfer{
+		HouseKey:    args[0],
+		From:        house.Owner,
+		To:          args[1],
+		Reason:      reason,
+		Price:       amount,
+		EffectiveAt: effectiveAt.UTC().Format(timeLayout),
+		ScheduledBy: scheduledBy,
+		TxID:        APIstub.GetTxID(),
+	}
+
+	scheduledAsBytes, _ := json.Marshal(scheduled)
+	if err := APIstub.PutState(scheduledKey, scheduledAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "transferScheduled", scheduledAsBytes)
+	return shim.Success(scheduledAsBytes)
+}
+
+/*
+ * cancelScheduledTransfer cancels the scheduled transfer of the house, only the owner can do it
+ * args: house key
+ */
+func (s *SmartContract) cancelScheduledTransfer(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scheduledKey, err := APIstub.CreateCompositeKey(scheduledTransferObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scheduledAsBytes, err := APIstub.GetState(scheduledKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if scheduledAsBytes == nil {
+		return shim.Error("No transfer of house " + args[0] + " is scheduled")
+	}
+	if err := APIstub.DelState(scheduledKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryScheduledTransfers lists the transfers waiting for their effective date
+func (s *SmartContract) queryScheduledTransfers(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	scheduledTransfers, err := getScheduledTransfers(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scheduledAsBytes, _ := json.Marshal(scheduledTransfers)
+	return shim.Success(scheduledAsBytes)
+}
+
+func getScheduledTransfers(APIstub shim.ChaincodeStubInterface) ([]ScheduledTransfer, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(scheduledTransferObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	scheduledTransfers := []ScheduledTransfer{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		scheduled := ScheduledTransfer{}
+		if err := json.Unmarshal(queryResponse.Value, &scheduled); err != nil {
+			return nil, err
+		}
+		scheduledTransfers = append(scheduledTransfers, scheduled)
+	}
+	return scheduledTransfers, nil
+}
+
+// finalizeDueTransfers completes the scheduled transfers whose effective date has passed.
+// A transfer whose house changed hands in the meantime is cancelled, a sale waiting for its
+// pre-emption window, co-signature or tax is queued. A transfer that is not allowed yet (e.g.
+// house in dispute) or that failed stays scheduled with the reason, the others still go through
+func (s *SmartContract) finalizeDueTransfers(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scheduledTransfers, err := getScheduledTransfers(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type outcome struct {
+		HouseKey string `json:"housekey"`
+		Status   string `json:"status"`
+		Message  string `json:"message,omitempty"`
+	}
+
+	outcomes := []outcome{}
+	for _, scheduled := range scheduledTransfers {
+		effectiveAt, err := time.Parse(timeLayout, scheduled.EffectiveAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if effectiveAt.After(txTime) {
+			continue
+		}
+
+		scheduledKey, err := APIstub.CreateCompositeKey(scheduledTransferObjectType, []string{scheduled.HouseKey})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		status, message := "finalized", ""
+		house, err := getHouse(APIstub, scheduled.HouseKey)
+		if err != nil {
+			// The house was retired or merged since the transfer was scheduled
+			status, message = "cancelled", err.Error()
+		} else if house.Owner != scheduled.From {
+			status, message = "cancelled", "House "+scheduled.HouseKey+" changed hands since the transfer was scheduled"
+		} else {
+			if err := checkTransferAllowed(APIstub, scheduled.HouseKey, house, scheduled.To); err != nil {
+				status, message = "pending", err.Error()
+			} else if err := checkHouseLock(APIstub, scheduled.HouseKey); err != nil {
+				// The transfer waits for the workflow locking the house
+				status, message = "pending", err.Error()
+			} else if holder := multisigHolder(house); holder != "" {
+				status, message = "failed", "House "+scheduled.HouseKey+" is held by the multi-signature account "+holder
+			} else if err := attempt(APIstub, func(stub shim.ChaincodeStubInterface) error {
+				queuedAsBytes, err := queueOrExecuteTransfer(stub, scheduled.HouseKey, house, scheduled.To, scheduled.Reason, scheduled.Price)
+				if queuedAsBytes != nil {
+					status = "queued"
+				}
+				return err
+			}); err != nil {
+				status, message = "failed", err.Error()
+			}
+			if status == "pending" || status == "failed" {
+				// The transfer stays scheduled, the failure is recorded for its owner
+				scheduled.LastFailure = message
+				scheduledAsBytes, _ := json.Marshal(scheduled)
+				if err := APIstub.PutState(scheduledKey, scheduledAsBytes); err != nil {
+					return shim.Error(err.Error())
+				}
+				outcomes = append(outcomes, outcome{HouseKey: scheduled.HouseKey, Status: status, Message: message})
+				continue
+			}
+		}
+
+		if err := APIstub.DelState(scheduledKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		outcomes = append(outcomes, outcome{HouseKey: scheduled.HouseKey, Status: status, Message: message})
+	}
+
+	outcomesAsBytes, _ := json.Marshal(outcomes)
+	return shim.Success(outcomesAsBytes)
+}
@@ -0,0 +1,228 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Full-text and location search
+ * The location, address and description of every house are split into lowercased terms, each term
+ * being indexed in the "term~key" index. CouchDB has no text index usable from chaincode,
+ * so the same index serves LevelDB and CouchDB networks.
+ * The normalized location is indexed as well in the "location~key" index, for prefix queries.
+ */
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	searchTermIndex = "term~key"
+	locationIndex   = "location~key"
+)
+
+// Terms shorter than this are not indexed
+const minTermLength = 2
+
+// tokenize splits a text into distinct lowercased terms without accents, in order of first appearance
+func tokenize(text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, term := range strings.FieldsFunc(foldAccents(strings.ToLower(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(term)) >= minTermLength && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func searchTermEntries(house House) [][]string {
+	entries := [][]string{}
+	for _, term := range tokenize(house.Location + " " + formatAddress(house.Address) + " " + house.Description) {
+		entries = append(entries, []string{term})
+	}
+	return entries
+}
+
+/*
+ * setHouseDescription replaces the free-text description of a house, only the owner can do it
+ * args: house key, description
+ */
+func (s *SmartContract) setHouseDescription(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house.Description = args[1]
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * searchHouses returns the houses whose location or description contain all the terms
+ * args: search terms
+ */
+func (s *SmartContract) searchHouses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	terms, err := resolveSearchTerms(APIstub, tokenize(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(terms) == 0 {
+		return shim.Error("No search term of at least 2 characters")
+	}
+
+	var matches map[string]bool
+	for _, term := range terms {
+		keys, err := queryIndexedHouseKeys(APIstub, searchTermIndex, []string{term})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		termMatches := map[string]bool{}
+		for _, key := range keys {
+			if matches == nil || matches[key] {
+				termMatches[key] = true
+			}
+		}
+		matches = termMatches
+	}
+
+	keys := []string{}
+	for key := range matches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
+
+// normalizeLocation lowercases a location, folds its accents and collapses its white space, for matching
+func normalizeLocation(location string) string {
+	return strings.Join(strings.Fields(foldAccents(strings.ToLower(location))), " ")
+}
+
+// locationIndexAttributes splits a normalized location into one attribute per character, so that a
+// partial composite key query on the characters of a prefix matches every location starting with it.
+// The terminal empty attribute marks the end of the location, for exact matches.
+func locationIndexAttributes(location string) []string {
+	attributes := []string{}
+	for _, r := range location {
+		attributes = append(attributes, string(r))
+	}
+	return attributes
+}
+
+func locationEntries(house House) [][]string {
+	location := normalizeLocation(house.Location)
+	if location == "" {
+		return [][]string{}
+	}
+	return [][]string{append(locationIndexAttributes(location), "")}
+}
+
+/*
+ * queryHousesByLocationPrefix returns the houses whose location starts with the prefix, ignoring case
+ * args: location prefix
+ */
+func (s *SmartContract) queryHousesByLocationPrefix(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	// A complete alias stands for its canonical name, a mere prefix is matched as is
+	canonical, err := resolveLocationAlias(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	prefix := normalizeLocation(canonical)
+	if prefix == "" {
+		return shim.Error("Location prefix must not be empty")
+	}
+
+	return queryHousesByLocationAttributes(APIstub, locationIndexAttributes(prefix))
+}
+
+/*
+ * queryHousesByLocation returns the houses located exactly at the location, ignoring case
+ * args: location
+ */
+func (s *SmartContract) queryHousesByLocation(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	canonical, err := resolveLocationAlias(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location := normalizeLocation(canonical)
+	if location == "" {
+		return shim.Error("Location must not be empty")
+	}
+
+	return queryHousesByLocationAttributes(APIstub, append(locationIndexAttributes(location), ""))
+}
+
+func queryHousesByLocationAttributes(APIstub shim.ChaincodeStubInterface, attributes []string) sc.Response {
+	keys, err := queryIndexedHouseKeys(APIstub, locationIndex, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
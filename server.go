@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Chaincode as a service
+ * When CHAINCODE_SERVER_ADDRESS is set the Smart Contract listens for the peer instead of
+ * being launched by it, so it can run as an external service (external builder, Kubernetes).
+ * Environment:
+ *   CHAINCODE_SERVER_ADDRESS  listen address, e.g. 0.0.0.0:9999
+ *   CORE_CHAINCODE_ID_NAME    chaincode package ID, as returned when installing the package
+ *   CHAINCODE_TLS_DISABLED    "true" to serve without TLS
+ *   CHAINCODE_TLS_KEY         path of the server private key (PEM)
+ *   CHAINCODE_TLS_CERT        path of the server certificate (PEM)
+ *   CHAINCODE_CLIENT_CA_CERT  path of the CA certificate of the peers, enables mutual TLS (optional)
+ */
+import (
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const (
+	serverAddressEnv = "CHAINCODE_SERVER_ADDRESS"
+	chaincodeIDEnv   = "CORE_CHAINCODE_ID_NAME"
+	tlsDisabledEnv   = "CHAINCODE_TLS_DISABLED"
+	tlsKeyEnv        = "CHAINCODE_TLS_KEY"
+	tlsCertEnv       = "CHAINCODE_TLS_CERT"
+	clientCACertEnv  = "CHAINCODE_CLIENT_CA_CERT"
+)
+
+// getTLSProperties loads the TLS material of the chaincode server from the files given in the environment
+func getTLSProperties() (shim.TLSProperties, error) {
+	if os.Getenv(tlsDisabledEnv) == "true" {
+		return shim.TLSProperties{Disabled: true}, nil
+	}
+
+	tlsProps := shim.TLSProperties{}
+	var err error
+
+	if os.Getenv(tlsKeyEnv) == "" || os.Getenv(tlsCertEnv) == "" {
+		return tlsProps, fmt.Errorf("%s and %s are required unless %s is true", tlsKeyEnv, tlsCertEnv, tlsDisabledEnv)
+	}
+	if tlsProps.Key, err = os.ReadFile(os.Getenv(tlsKeyEnv)); err != nil {
+		return tlsProps, err
+	}
+	if tlsProps.Cert, err = os.ReadFile(os.Getenv(tlsCertEnv)); err != nil {
+		return tlsProps, err
+	}
+	if path := os.Getenv(clientCACertEnv); path != "" {
+		if tlsProps.ClientCACerts, err = os.ReadFile(path); err != nil {
+			return tlsProps, err
+		}
+	}
+	return tlsProps, nil
+}
+
+// startChaincodeServer serves the Smart Contract on the address until the server fails
+func startChaincodeServer(address string, cc shim.Chaincode) error {
+	tlsProps, err := getTLSProperties()
+	if err != nil {
+		return err
+	}
+
+	server := &shim.ChaincodeServer{
+		CCID:     os.Getenv(chaincodeIDEnv),
+		Address:  address,
+		CC:       cc,
+		TLSProps: tlsProps,
+	}
+	return server.Start()
+}
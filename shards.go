@@ -0,0 +1,132 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Sharded counters
+ * A counter stored in a single key is a hotspot: concurrent transactions incrementing it read
+ * and write the same key, and all but one fail with an MVCC conflict. Sharded counters spread
+ * a count over sub-keys, the shard being the last attribute of their composite key. A transaction
+ * only reads and writes the shard selected by the hash of its ID, the same on every endorser,
+ * and reads sum the shards. A shard can go negative when a transaction decrements a count that
+ * another one incremented in another shard, only the sum is meaningful. A key without the shard
+ * attribute, written before the counters were sharded, counts as a shard of its counter.
+ */
+import (
+	"hash/fnv"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Number of shards of a counter
+const counterShards = 16
+
+// Define the sharded count structure, the sum of the shards of a counter
+type shardedCount struct {
+	attributes []string
+	count      int64
+}
+
+// counterShard returns the shard written by the transaction
+func counterShard(APIstub shim.ChaincodeStubInterface) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(APIstub.GetTxID()))
+	return strconv.Itoa(int(hash.Sum32() % counterShards))
+}
+
+// addShardedCount adds the delta to the shard of the transaction of the counter, deleting the shard falling to zero
+func addShardedCount(APIstub shim.ChaincodeStubInterface, objectType string, attributes []string, delta int64) error {
+	shardKey, err := APIstub.CreateCompositeKey(objectType, append(append([]string{}, attributes...), counterShard(APIstub)))
+	if err != nil {
+		return err
+	}
+	shardAsBytes, err := APIstub.GetState(shardKey)
+	if err != nil {
+		return err
+	}
+	count := int64(0)
+	if shardAsBytes != nil {
+		if count, err = strconv.ParseInt(string(shardAsBytes), 10, 64); err != nil {
+			return err
+		}
+	}
+	if count+delta == 0 {
+		return APIstub.DelState(shardKey)
+	}
+	return APIstub.PutState(shardKey, []byte(strconv.FormatInt(count+delta, 10)))
+}
+
+// getShardedCounts returns the counters whose attributes start with the given ones, in key order.
+// Width is the number of attributes of a counter, its shard excluded
+func getShardedCounts(APIstub shim.ChaincodeStubInterface, objectType string, attributes []string, width int) ([]shardedCount, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	// The shards of a counter are contiguous in key order
+	counts := []shardedCount{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyAttributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyAttributes) < width {
+			continue
+		}
+		count, err := strconv.ParseInt(string(queryResponse.Value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		last := len(counts) - 1
+		if last >= 0 && equalStrings(counts[last].attributes, keyAttributes[:width]) {
+			counts[last].count += count
+		} else {
+			counts = append(counts, shardedCount{attributes: keyAttributes[:width], count: count})
+		}
+	}
+	return counts, nil
+}
+
+// getShardedCount returns the sum of the shards of a counter
+func getShardedCount(APIstub shim.ChaincodeStubInterface, objectType string, attributes []string) (int64, error) {
+	counts, err := getShardedCounts(APIstub, objectType, attributes, len(attributes))
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].count, nil
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
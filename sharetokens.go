@@ -0,0 +1,600 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Share tokens
+ * The ownership of every house is a fixed supply of wholeShare fungible share tokens, a token being
+ * one basis point of the house. Balances are the ownership shares of the house, so that moving
+ * tokens transfers a part of the house through the usual transfer checks and history. Moving tokens
+ * is a share transfer, not a sale: it records no sale price, owes the tax assessed on the value of
+ * the tokens, and is refused when the house is subject to pre-emption rights or the value of the
+ * tokens requires co-signatures, those shares only changing hands by a sale of the house. A holder can
+ * allow a spender to move up to an amount of its tokens. Dividends (rent income) paid on a house
+ * are recorded per holder in proportion of their tokens, and indexed per holder and period for the
+ * income statements. Rent income distributed on the ledger is also credited to the holder accounts.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	shareAllowanceObjectType = "shareAllowance"
+	dividendObjectType       = "dividend"
+	holderDividendIndex      = "holder~period~key~dividend"
+)
+
+// Define the dividend structure, the part of a distribution paid to one holder
+type Dividend struct {
+	HouseKey       string `json:"housekey"`
+	DistributionID string `json:"distributionid"`
+	Holder         string `json:"holder"`
+	Tokens         int    `json:"tokens"`
+	Amount         int64  `json:"amount"`
+	Period         string `json:"period"`
+	PaidAt         string `json:"paidat"`
+}
+
+// shareBalance returns the share tokens of the house held by the identity
+func shareBalance(house House, holder string) int {
+	balance := 0
+	for _, share := range houseShares(house) {
+		if share.Owner == holder {
+			balance += share.Share
+		}
+	}
+	return balance
+}
+
+// movedShares returns the shares of the house once amount tokens moved from one holder to another.
+// The registered owner stays first unless it sold all its tokens
+func movedShares(house House, from string, to string, amount int) []OwnershipShare {
+	shares := []OwnershipShare{}
+	received := false
+	for _, share := range houseShares(house) {
+		if share.Owner == from {
+			share.Share -= amount
+		}
+		if share.Owner == to {
+			share.Share += amount
+			received = true
+		}
+		if share.Share > 0 {
+			shares = append(shares, share)
+		}
+	}
+	if !received {
+		shares = append(shares, OwnershipShare{Owner: to, Share: amount})
+	}
+	return shares
+}
+
+// shareValue returns the value of tokens of the house, pro rata of the valuation of the house
+func shareValue(APIstub shim.ChaincodeStubInterface, key string, house House, tokens int) (int64, error) {
+	valuation, valued, err := houseValuation(APIstub, key, house)
+	if err != nil {
+		return 0, err
+	}
+	if !valued {
+		return 0, fmt.Errorf("House %s has no recorded sale to value its share tokens on", key)
+	}
+	return valuation * int64(tokens) / wholeShare, nil
+}
+
+// checkShareTransferAllowed returns an error when tokens of the house can only change hands by a sale of the house:
+// the house is subject to pre-emption rights, or the value of the tokens requires the co-signature of registrars
+func checkShareTransferAllowed(APIstub shim.ChaincodeStubInterface, key string, house House, tokens int) error {
+	rights, err := applicablePreemptionRights(APIstub, key, house)
+	if err != nil {
+		return err
+	}
+	if len(rights) > 0 {
+		return fmt.Errorf("House %s is subject to pre-emption rights, its shares only change hands by a sale of the house", key)
+	}
+	policy, err := getCosignPolicy(APIstub)
+	if err != nil || policy.Threshold == 0 {
+		return err
+	}
+	value, err := shareValue(APIstub, key, house, tokens)
+	if err != nil {
+		return err
+	}
+	if value > policy.Threshold {
+		return fmt.Errorf("Share tokens of house %s worth %d require the co-signature of two registrars, they only change hands by a sale of the house", key, value)
+	}
+	return nil
+}
+
+// shareTransferTax returns the transfer tax owed on a share transfer of tokens of the house, assessed on their value,
+// with the value and the location of the table applied
+func shareTransferTax(APIstub shim.ChaincodeStubInterface, key string, house House, tokens int) (int64, int64, string, error) {
+	table, found, err := taxRateTableOf(APIstub, house)
+	if err != nil || !found {
+		return 0, 0, "", err
+	}
+	value, err := shareValue(APIstub, key, house, tokens)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return bandedTax(table.Bands, value), value, table.Location, nil
+}
+
+// moveShareTokens moves tokens between holders by a share transfer, unless it owes tax: the tax obligation is then
+// recorded and returned, the share transfer waiting for its settlement
+func moveShareTokens(APIstub shim.ChaincodeStubInterface, key string, house House, from string, to string, amount int) ([]byte, error) {
+	if to == "" || to == from {
+		return nil, fmt.Errorf("Share tokens must move to another identity")
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("Amount must be a positive number of tokens")
+	}
+	if balance := shareBalance(house, from); balance < amount {
+		return nil, fmt.Errorf("%s holds %d share tokens of house %s, cannot move %d", from, balance, key, amount)
+	}
+	if err := checkShareTransferAllowed(APIstub, key, house, amount); err != nil {
+		return nil, err
+	}
+	tax, value, location, err := shareTransferTax(APIstub, key, house, amount)
+	if err != nil {
+		return nil, err
+	}
+	if tax == 0 {
+		return nil, transferHouseShares(APIstub, key, house, movedShares(house, from, to, amount), reasonShareTransfer, 0)
+	}
+
+	// Fail early, the checks are run again once the tax is settled
+	if err := checkTransferAllowed(APIstub, key, house, to); err != nil {
+		return nil, err
+	}
+	recordedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	var obligation = TaxObligation{
+		ID:         APIstub.GetTxID(),
+		HouseKey:   key,
+		From:       from,
+		To:         to,
+		Reason:     reasonShareTransfer,
+		Tokens:     amount,
+		AssessedOn: value,
+		Location:   location,
+		Tax:        tax,
+		Status:     taxDue,
+		RecordedAt: recordedAt.Format(timeLayout),
+	}
+	obligationAsBytes, err := putTaxObligation(APIstub, obligation)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(APIstub, "transferTaxDue", obligationAsBytes)
+	return obligationAsBytes, nil
+}
+
+func shareAllowanceKey(APIstub shim.ChaincodeStubInterface, key string, holder string, spender string) (string, error) {
+	return APIstub.CreateCompositeKey(shareAllowanceObjectType, []string{key, holder, spender})
+}
+
+// getShareAllowance returns the number of tokens of the holder the spender may still move
+func getShareAllowance(APIstub shim.ChaincodeStubInterface, key string, holder string, spender string) (int, error) {
+	allowanceKey, err := shareAllowanceKey(APIstub, key, holder, spender)
+	if err != nil {
+		return 0, err
+	}
+	allowanceAsBytes, err := APIstub.GetState(allowanceKey)
+	if err != nil || allowanceAsBytes == nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(allowanceAsBytes))
+}
+
+func putShareAllowance(APIstub shim.ChaincodeStubInterface, key string, holder string, spender string, amount int) error {
+	allowanceKey, err := shareAllowanceKey(APIstub, key, holder, spender)
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		return APIstub.DelState(allowanceKey)
+	}
+	return APIstub.PutState(allowanceKey, []byte(strconv.Itoa(amount)))
+}
+
+/*
+ * transferShareTokens moves share tokens of a house from the invoker to another identity, returning the tax obligation
+ * the share transfer waits for when it owes tax
+ * args: house key, recipient, number of tokens
+ */
+func (s *SmartContract) transferShareTokens(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	amount, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Amount must be a positive number of tokens")
+	}
+	holder, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	obligationAsBytes, err := moveShareTokens(APIstub, args[0], house, holder, args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(obligationAsBytes)
+}
+
+/*
+ * approveShareTokens allows a spender to move up to an amount of the share tokens of a house held by the invoker
+ * args: house key, spender, number of tokens (0 to revoke)
+ */
+func (s *SmartContract) approveShareTokens(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount < 0 || amount > wholeShare {
+		return shim.Error(fmt.Sprintf("Amount must be a number of tokens from 0 to %d", wholeShare))
+	}
+	holder, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" || args[1] == holder {
+		return shim.Error("Spender must be another identity")
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := putShareAllowance(APIstub, args[0], holder, args[1], amount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * transferShareTokensFrom moves share tokens of a holder within the allowance of the invoker, returning the tax
+ * obligation the share transfer waits for when it owes tax
+ * args: house key, holder, recipient, number of tokens
+ */
+func (s *SmartContract) transferShareTokensFrom(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	amount, err := strconv.Atoi(args[3])
+	if err != nil {
+		return shim.Error("Amount must be a positive number of tokens")
+	}
+	spender, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	allowance, err := getShareAllowance(APIstub, args[0], args[1], spender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if allowance < amount {
+		return shim.Error(fmt.Sprintf("%s may move %d share tokens of %s, not %d", spender, allowance, args[1], amount))
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	obligationAsBytes, err := moveShareTokens(APIstub, args[0], house, args[1], args[2], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putShareAllowance(APIstub, args[0], args[1], spender, allowance-amount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(obligationAsBytes)
+}
+
+/*
+ * queryShareBalance returns the share tokens of a house held by an identity, and what a spender may still move of them
+ * args: house key, holder, spender (empty to skip the allowance)
+ */
+func (s *SmartContract) queryShareBalance(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var balance = struct {
+		HouseKey  string `json:"housekey"`
+		Holder    string `json:"holder"`
+		Balance   int    `json:"balance"`
+		Supply    int    `json:"supply"`
+		Allowance int    `json:"allowance"`
+	}{HouseKey: args[0], Holder: args[1], Balance: shareBalance(house, args[1]), Supply: wholeShare}
+	if args[2] != "" {
+		if balance.Allowance, err = getShareAllowance(APIstub, args[0], args[1], args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	balanceAsBytes, _ := json.Marshal(balance)
+	return shim.Success(balanceAsBytes)
+}
+
+// queryCapTable returns the holders of the share tokens of a house and their part of the supply
+func (s *SmartContract) queryCapTable(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type capTableLine struct {
+		Holder  string  `json:"holder"`
+		Tokens  int     `json:"tokens"`
+		Percent float64 `json:"percent"`
+	}
+	lines := []capTableLine{}
+	for _, share := range houseShares(house) {
+		lines = append(lines, capTableLine{Holder: share.Owner, Tokens: share.Share, Percent: float64(share.Share) * 100 / wholeShare})
+	}
+
+	linesAsBytes, _ := json.Marshal(lines)
+	return shim.Success(linesAsBytes)
+}
+
+// allocateProRata divides the amount between the holders in proportion of their tokens, the rounding remainder going to the first one
+func allocateProRata(shares []OwnershipShare, amount int64) []int64 {
+	parts := make([]int64, len(shares))
+	allocated := int64(0)
+	for i, share := range shares {
+		parts[i] = amount * int64(share.Share) / wholeShare
+		allocated += parts[i]
+	}
+	parts[0] += amount - allocated
+	return parts
+}
+
+// recordDividends records the part of the distribution of amount paid to every holder of the house
+func recordDividends(APIstub shim.ChaincodeStubInterface, key string, house House, period string, amount int64) ([]Dividend, error) {
+	paidAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := houseShares(house)
+	dividends := []Dividend{}
+	for i, part := range allocateProRata(shares, amount) {
+		var dividend = Dividend{
+			HouseKey:       key,
+			DistributionID: APIstub.GetTxID(),
+			Holder:         shares[i].Owner,
+			Tokens:         shares[i].Share,
+			Amount:         part,
+			Period:         period,
+			PaidAt:         paidAt.Format(timeLayout),
+		}
+		dividendKey, err := APIstub.CreateCompositeKey(dividendObjectType, []string{dividend.HouseKey, dividend.DistributionID, dividend.Holder})
+		if err != nil {
+			return nil, err
+		}
+		dividendAsBytes, _ := json.Marshal(dividend)
+		if err := APIstub.PutState(dividendKey, dividendAsBytes); err != nil {
+			return nil, err
+		}
+		indexKey, err := APIstub.CreateCompositeKey(holderDividendIndex, []string{dividend.Holder, dividend.Period, dividend.HouseKey, dividend.DistributionID})
+		if err != nil {
+			return nil, err
+		}
+		if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+			return nil, err
+		}
+		dividends = append(dividends, dividend)
+	}
+	return dividends, nil
+}
+
+/*
+ * distributeDividend records a distribution of income of the house to the holders of its share tokens,
+ * for the owner or a manager with the leases permission
+ * args: house key, period (YYYY-MM), amount
+ */
+func (s *SmartContract) distributeDividend(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if _, err := time.Parse(periodLayout, args[1]); err != nil {
+		return shim.Error("Period must be formatted YYYY-MM")
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dividends, err := recordDividends(APIstub, args[0], house, args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dividendsAsBytes, _ := json.Marshal(dividends)
+	emitEvent(APIstub, "dividendDistributed", dividendsAsBytes)
+	return shim.Success(dividendsAsBytes)
+}
+
+/*
+ * queryDividends returns the dividends paid on a house, to every holder or to one of them
+ * args: house key, holder (empty for every holder)
+ */
+func (s *SmartContract) queryDividends(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(dividendObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	dividends := []Dividend{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dividend := Dividend{}
+		if err := json.Unmarshal(queryResponse.Value, &dividend); err != nil {
+			return shim.Error(err.Error())
+		}
+		if args[1] == "" || dividend.Holder == args[1] {
+			dividends = append(dividends, dividend)
+		}
+	}
+	sort.SliceStable(dividends, func(i, j int) bool { return dividends[i].PaidAt < dividends[j].PaidAt })
+
+	dividendsAsBytes, _ := json.Marshal(dividends)
+	return shim.Success(dividendsAsBytes)
+}
+
+/*
+ * distributeRentIncome credits the rent income of a period to the accounts of the holders of the
+ * share tokens of the house, in proportion of their tokens, for the owner or a manager with the leases permission
+ * args: house key, period (YYYY-MM), amount
+ */
+func (s *SmartContract) distributeRentIncome(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if _, err := time.Parse(periodLayout, args[1]); err != nil {
+		return shim.Error("Period must be formatted YYYY-MM")
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("Amount must be a positive number")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dividends, err := recordDividends(APIstub, args[0], house, args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entries := []AccountEntry{}
+	for _, dividend := range dividends {
+		entries = append(entries, AccountEntry{Account: dividend.Holder, Amount: dividend.Amount, Kind: entryRentIncome, Reference: args[0], Period: args[1]})
+	}
+	if err := postEntries(APIstub, entries); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dividendsAsBytes, _ := json.Marshal(dividends)
+	emitEvent(APIstub, "rentIncomeDistributed", dividendsAsBytes)
+	return shim.Success(dividendsAsBytes)
+}
+
+/*
+ * queryIncomeStatement returns the income paid to a holder on every house for a period, with its total
+ * args: holder, period (YYYY-MM)
+ */
+func (s *SmartContract) queryIncomeStatement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(holderDividendIndex, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var statement = struct {
+		Holder    string     `json:"holder"`
+		Period    string     `json:"period"`
+		Total     int64      `json:"total"`
+		Dividends []Dividend `json:"dividends"`
+	}{Holder: args[0], Period: args[1], Dividends: []Dividend{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dividendKey, err := APIstub.CreateCompositeKey(dividendObjectType, []string{attributes[2], attributes[3], args[0]})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dividendAsBytes, err := APIstub.GetState(dividendKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		dividend := Dividend{}
+		if err := json.Unmarshal(dividendAsBytes, &dividend); err != nil {
+			return shim.Error(err.Error())
+		}
+		statement.Total += dividend.Amount
+		statement.Dividends = append(statement.Dividends, dividend)
+	}
+
+	statementAsBytes, _ := json.Marshal(statement)
+	return shim.Success(statementAsBytes)
+}
@@ -0,0 +1,90 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Share token tests
+ * Share transfers of a house valued at its last sale, run directly on the stub for the valuation.
+ */
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func TestShareTransferOwesTaxOnTheValueOfTheTokens(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.assignRoles(t, ledger.owner, roleTaxAuthority)
+	ledger.invoke(t, ledger.owner, "setKYCStatus", "carol", kycVerified)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "changeHouseOwner", "HOUSE1", "bob", reasonSale, "200000")
+	ledger.invoke(t, ledger.owner, "setTaxRateTable", defaultTaxLocation, `[{"ceiling": 0, "rate": 500}]`)
+
+	obligation := TaxObligation{}
+	ledger.inTransaction(func(APIstub shim.ChaincodeStubInterface) {
+		house, err := getHouse(APIstub, "HOUSE1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		obligationAsBytes, err := moveShareTokens(APIstub, "HOUSE1", house, "bob", "carol", 2500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(obligationAsBytes, &obligation); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if obligation.Reason != reasonShareTransfer || obligation.AssessedOn != 50000 || obligation.Tax != 2500 {
+		t.Fatalf("Share transfer of a quarter of the house recorded %+v, expected 2500 of tax on 50000", obligation)
+	}
+	house := House{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "queryHouse", "HOUSE1"), &house); err != nil {
+		t.Fatal(err)
+	}
+	if len(house.Shares) != 0 {
+		t.Errorf("Share tokens moved before the tax was settled: %v", house.Shares)
+	}
+
+	ledger.invoke(t, ledger.owner, "recordTaxSettlement", obligation.ID, "PAYMENT1")
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "queryHouse", "HOUSE1"), &house); err != nil {
+		t.Fatal(err)
+	}
+	if shareBalance(house, "bob") != 7500 || shareBalance(house, "carol") != 2500 {
+		t.Errorf("Settled share transfer left the shares %v, expected 7500 to bob and 2500 to carol", house.Shares)
+	}
+	transfers := []Transfer{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "queryTransferHistory", "HOUSE1"), &transfers); err != nil {
+		t.Fatal(err)
+	}
+	if last := transfers[len(transfers)-1]; last.Reason != reasonShareTransfer || last.Price != 0 {
+		t.Errorf("Share transfer recorded as %s at %d, expected a share transfer without a price", last.Reason, last.Price)
+	}
+}
+
+func TestShareTransferIsRefusedUnderPreemption(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "registerPreemptionRight", preemptionLocation, "Paris", "city", "30")
+
+	if message := ledger.refuse(t, ledger.owner, "transferShareTokens", "HOUSE1", "bob", "10000"); !strings.Contains(message, "pre-emption") {
+		t.Errorf("Share transfer failed with %q, expected it to be refused for the pre-emption right", message)
+	}
+}
@@ -0,0 +1,464 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Short-term rentals
+ * Letting a house for short stays requires a license from the municipality, which caps the number
+ * of nights the house may be let per calendar year. The nights of every stay are counted in the
+ * year they fall in, and a stay taking a house beyond its cap is rejected. Planners lowering a cap
+ * during the year may leave houses over their allowance, which their report lists.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	rentalLicenseObjectType = "rentalLicense"
+	rentalStayObjectType    = "rentalStay"
+	rentalNightsObjectType  = "rentalNights"
+)
+
+// Rental license statuses
+const (
+	licenseActive  = "active"
+	licenseRevoked = "revoked"
+)
+
+// Define the short-term rental license structure, the current license of a house
+type RentalLicense struct {
+	ID             string `json:"id"`
+	HouseKey       string `json:"housekey"`
+	Holder         string `json:"holder"`
+	AnnualNightCap int    `json:"annualnightcap"`
+	Expiry         string `json:"expiry"`
+	Status         string `json:"status"`
+	IssuedBy       string `json:"issuedby"`
+	IssuedAt       string `json:"issuedat"`
+	RevokedReason  string `json:"revokedreason,omitempty"`
+}
+
+// Define the rental stay structure, the nights a house is let from the check-in day
+type RentalStay struct {
+	HouseKey   string `json:"housekey"`
+	LicenseID  string `json:"licenseid"`
+	CheckIn    string `json:"checkin"`
+	Nights     int    `json:"nights"`
+	RecordedBy string `json:"recordedby"`
+	RecordedAt string `json:"recordedat"`
+	TxID       string `json:"txid"`
+}
+
+// Define the night allowance structure, the nights let in a year against the cap of the license
+type NightAllowance struct {
+	HouseKey  string `json:"housekey"`
+	LicenseID string `json:"licenseid"`
+	Year      string `json:"year"`
+	Nights    int    `json:"nights"`
+	Cap       int    `json:"cap"`
+	Remaining int    `json:"remaining"`
+}
+
+func getRentalLicense(APIstub shim.ChaincodeStubInterface, key string) (RentalLicense, error) {
+	licenseKey, err := APIstub.CreateCompositeKey(rentalLicenseObjectType, []string{key})
+	if err != nil {
+		return RentalLicense{}, err
+	}
+	licenseAsBytes, err := APIstub.GetState(licenseKey)
+	if err != nil {
+		return RentalLicense{}, err
+	}
+	if licenseAsBytes == nil {
+		return RentalLicense{}, fmt.Errorf("House %s has no short-term rental license", key)
+	}
+	license := RentalLicense{}
+	err = json.Unmarshal(licenseAsBytes, &license)
+	return license, err
+}
+
+func putRentalLicense(APIstub shim.ChaincodeStubInterface, license RentalLicense) ([]byte, error) {
+	licenseKey, err := APIstub.CreateCompositeKey(rentalLicenseObjectType, []string{license.HouseKey})
+	if err != nil {
+		return nil, err
+	}
+	licenseAsBytes, _ := json.Marshal(license)
+	return licenseAsBytes, APIstub.PutState(licenseKey, licenseAsBytes)
+}
+
+// rentalNights returns the nights the house was let in the year
+func rentalNights(APIstub shim.ChaincodeStubInterface, key string, year string) (int, error) {
+	nightsKey, err := APIstub.CreateCompositeKey(rentalNightsObjectType, []string{key, year})
+	if err != nil {
+		return 0, err
+	}
+	nightsAsBytes, err := APIstub.GetState(nightsKey)
+	if err != nil || nightsAsBytes == nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(nightsAsBytes))
+}
+
+// nightsByYear splits the nights of a stay by the calendar year they fall in
+func nightsByYear(checkIn time.Time, nights int) map[string]int {
+	byYear := map[string]int{}
+	for night := 0; night < nights; night++ {
+		byYear[strconv.Itoa(checkIn.AddDate(0, 0, night).Year())]++
+	}
+	return byYear
+}
+
+// addRentalStay records a stay under the license of the house, rejecting it when the license does not
+// cover it or when it takes the house beyond the night cap of a year
+func addRentalStay(APIstub shim.ChaincodeStubInterface, key string, checkIn time.Time, nights int) (RentalStay, error) {
+	license, err := getRentalLicense(APIstub, key)
+	if err != nil {
+		return RentalStay{}, err
+	}
+	if license.Status != licenseActive {
+		return RentalStay{}, fmt.Errorf("Short-term rental license %s of house %s was revoked: %s", license.ID, key, license.RevokedReason)
+	}
+	expiry, _ := time.Parse(dayLayout, license.Expiry)
+	if checkIn.AddDate(0, 0, nights-1).After(expiry) {
+		return RentalStay{}, fmt.Errorf("Short-term rental license %s of house %s expires on %s", license.ID, key, license.Expiry)
+	}
+	if err := checkHabitable(APIstub, key); err != nil {
+		return RentalStay{}, err
+	}
+
+	byYear := nightsByYear(checkIn, nights)
+	for _, year := range sortedIntKeys(byYear) {
+		used, err := rentalNights(APIstub, key, year)
+		if err != nil {
+			return RentalStay{}, err
+		}
+		if used+byYear[year] > license.AnnualNightCap {
+			return RentalStay{}, fmt.Errorf("House %s was let %d nights in %s, %d more would exceed its cap of %d", key, used, year, byYear[year], license.AnnualNightCap)
+		}
+		nightsKey, err := APIstub.CreateCompositeKey(rentalNightsObjectType, []string{key, year})
+		if err != nil {
+			return RentalStay{}, err
+		}
+		if err := APIstub.PutState(nightsKey, []byte(strconv.Itoa(used+byYear[year]))); err != nil {
+			return RentalStay{}, err
+		}
+	}
+
+	recordedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return RentalStay{}, err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return RentalStay{}, err
+	}
+	var stay = RentalStay{
+		HouseKey:   key,
+		LicenseID:  license.ID,
+		CheckIn:    checkIn.Format(dayLayout),
+		Nights:     nights,
+		RecordedBy: recordedBy,
+		RecordedAt: txTime.Format(timeLayout),
+		TxID:       APIstub.GetTxID(),
+	}
+	stayKey, err := APIstub.CreateCompositeKey(rentalStayObjectType, []string{key, stay.CheckIn, stay.TxID})
+	if err != nil {
+		return RentalStay{}, err
+	}
+	stayAsBytes, _ := json.Marshal(stay)
+	return stay, APIstub.PutState(stayKey, stayAsBytes)
+}
+
+// releaseRentalStay deletes a stay recorded by the transaction, giving its nights back to the allowance of their year
+func releaseRentalStay(APIstub shim.ChaincodeStubInterface, key string, checkIn time.Time, nights int, txID string) error {
+	stayKey, err := APIstub.CreateCompositeKey(rentalStayObjectType, []string{key, checkIn.Format(dayLayout), txID})
+	if err != nil {
+		return err
+	}
+	if err := APIstub.DelState(stayKey); err != nil {
+		return err
+	}
+	byYear := nightsByYear(checkIn, nights)
+	for _, year := range sortedIntKeys(byYear) {
+		used, err := rentalNights(APIstub, key, year)
+		if err != nil {
+			return err
+		}
+		nightsKey, err := APIstub.CreateCompositeKey(rentalNightsObjectType, []string{key, year})
+		if err != nil {
+			return err
+		}
+		if err := APIstub.PutState(nightsKey, []byte(strconv.Itoa(used-byYear[year]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedIntKeys(values map[string]int) []string {
+	set := map[string]bool{}
+	for key := range values {
+		set[key] = true
+	}
+	return sortedKeys(set)
+}
+
+/*
+ * issueRentalLicense licenses the house for short-term rentals, replacing its previous license, for planners.
+ * The owner of the house holds the license
+ * args: house key, license ID, annual night cap, expiry date (YYYY-MM-DD)
+ */
+func (s *SmartContract) issueRentalLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("License ID must not be empty")
+	}
+	nightCap, err := strconv.Atoi(args[2])
+	if err != nil || nightCap <= 0 || nightCap > 366 {
+		return shim.Error("Annual night cap must be a number from 1 to 366")
+	}
+	if _, err := time.Parse(dayLayout, args[3]); err != nil {
+		return shim.Error("Expiry date must be formatted YYYY-MM-DD")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkHabitable(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	issuedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	licenseAsBytes, err := putRentalLicense(APIstub, RentalLicense{
+		ID:             args[1],
+		HouseKey:       args[0],
+		Holder:         house.Owner,
+		AnnualNightCap: nightCap,
+		Expiry:         args[3],
+		Status:         licenseActive,
+		IssuedBy:       issuedBy,
+		IssuedAt:       txTime.Format(timeLayout),
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "rentalLicenseIssued", licenseAsBytes)
+	return shim.Success(licenseAsBytes)
+}
+
+/*
+ * setRentalNightCap changes the annual night cap of the license of a house, for planners
+ * args: house key, annual night cap
+ */
+func (s *SmartContract) setRentalNightCap(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+	nightCap, err := strconv.Atoi(args[1])
+	if err != nil || nightCap <= 0 || nightCap > 366 {
+		return shim.Error("Annual night cap must be a number from 1 to 366")
+	}
+	license, err := getRentalLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	license.AnnualNightCap = nightCap
+	licenseAsBytes, err := putRentalLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+/*
+ * revokeRentalLicense revokes the short-term rental license of a house, for planners
+ * args: house key, reason
+ */
+func (s *SmartContract) revokeRentalLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("Reason must not be empty")
+	}
+	license, err := getRentalLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if license.Status == licenseRevoked {
+		return shim.Error("Short-term rental license " + license.ID + " is already revoked")
+	}
+
+	license.Status = licenseRevoked
+	license.RevokedReason = args[1]
+	licenseAsBytes, err := putRentalLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "rentalLicenseRevoked", licenseAsBytes)
+	return shim.Success(licenseAsBytes)
+}
+
+/*
+ * recordRentalStay records the nights of a short stay, for the owner or a manager with the leases permission
+ * args: house key, check-in day (YYYY-MM-DD), number of nights
+ */
+func (s *SmartContract) recordRentalStay(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	checkIn, err := time.Parse(dayLayout, args[1])
+	if err != nil {
+		return shim.Error("Check-in day must be formatted YYYY-MM-DD")
+	}
+	nights, err := strconv.Atoi(args[2])
+	if err != nil || nights <= 0 {
+		return shim.Error("Number of nights must be a positive number")
+	}
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwnerOrManager(APIstub, args[0], house, permissionLeases); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	stay, err := addRentalStay(APIstub, args[0], checkIn, nights)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	stayAsBytes, _ := json.Marshal(stay)
+	return shim.Success(stayAsBytes)
+}
+
+// nightAllowance returns the nights let in the year by the house against the cap of its license
+func nightAllowance(APIstub shim.ChaincodeStubInterface, license RentalLicense, year string) (NightAllowance, error) {
+	nights, err := rentalNights(APIstub, license.HouseKey, year)
+	if err != nil {
+		return NightAllowance{}, err
+	}
+	return NightAllowance{
+		HouseKey:  license.HouseKey,
+		LicenseID: license.ID,
+		Year:      year,
+		Nights:    nights,
+		Cap:       license.AnnualNightCap,
+		Remaining: license.AnnualNightCap - nights,
+	}, nil
+}
+
+// queryRentalNights returns the nights let by a house in a year against its cap. args: house key, year (YYYY)
+func (s *SmartContract) queryRentalNights(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if _, err := time.Parse("2006", args[1]); err != nil {
+		return shim.Error("Year must be formatted YYYY")
+	}
+	license, err := getRentalLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	allowance, err := nightAllowance(APIstub, license, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	allowanceAsBytes, _ := json.Marshal(allowance)
+	return shim.Success(allowanceAsBytes)
+}
+
+/*
+ * queryHousesOverNightCap returns the licensed houses which used up their night cap of the year, or went over it, for planners
+ * args: year (YYYY)
+ */
+func (s *SmartContract) queryHousesOverNightCap(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := time.Parse("2006", args[0]); err != nil {
+		return shim.Error("Year must be formatted YYYY")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(rentalLicenseObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	allowances := []NightAllowance{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		license := RentalLicense{}
+		if err := json.Unmarshal(queryResponse.Value, &license); err != nil {
+			return shim.Error(err.Error())
+		}
+		allowance, err := nightAllowance(APIstub, license, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if allowance.Remaining <= 0 {
+			allowances = append(allowances, allowance)
+		}
+	}
+
+	allowancesAsBytes, _ := json.Marshal(allowances)
+	return shim.Success(allowancesAsBytes)
+}
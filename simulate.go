@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Simulation
+ * simulate runs a function with all its checks against a stub keeping its writes and events to
+ * itself, so that nothing is written whatever happens to the transaction. It returns the response
+ * of the function with the writes and events it would have issued, for clients to preview the
+ * outcome of a transaction and show its validation errors before submitting it.
+ */
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Define the simulated write structure. Composite keys are split into their object type and attributes
+type SimulatedWrite struct {
+	Key        string          `json:"key"`
+	ObjectType string          `json:"objecttype,omitempty"`
+	Attributes []string        `json:"attributes,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Bytes      []byte          `json:"bytes,omitempty"`
+	Deleted    bool            `json:"deleted,omitempty"`
+}
+
+// Define the simulated event structure
+type SimulatedEvent struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Bytes   []byte          `json:"bytes,omitempty"`
+}
+
+/*
+ * simulate runs a function without writing anything, returning its response and the writes and events it would issue.
+ * A failing function is reported in the result, not as an error
+ * args: function, arguments as a JSON array of strings
+ */
+func (s *SmartContract) simulate(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[0] == "simulate" {
+		return shim.Error("A simulation cannot be simulated")
+	}
+	functionArgs := []string{}
+	if err := json.Unmarshal([]byte(args[1]), &functionArgs); err != nil {
+		return shim.Error("Arguments must be a JSON array of strings")
+	}
+
+	simulationStub := newSimulationStub(APIstub)
+	var response sc.Response
+	if err := evaluateRules(simulationStub, ruleContext{function: args[0]}); err != nil {
+		response = shim.Error(err.Error())
+	} else {
+		response = s.route(simulationStub, args[0], functionArgs)
+	}
+
+	var result = struct {
+		Function      string           `json:"function"`
+		Status        int32            `json:"status"`
+		Message       string           `json:"message,omitempty"`
+		Payload       json.RawMessage  `json:"payload,omitempty"`
+		Writes        []SimulatedWrite `json:"writes"`
+		PrivateWrites []string         `json:"privatewrites"`
+		Events        []SimulatedEvent `json:"events"`
+	}{Function: args[0], Status: response.Status, Message: response.Message, Writes: []SimulatedWrite{}, Events: []SimulatedEvent{}}
+	if json.Valid(response.Payload) {
+		result.Payload = response.Payload
+	}
+	if response.Status < shim.ERRORTHRESHOLD {
+		for _, key := range sortedKeys(writtenKeys(simulationStub.writes)) {
+			write := SimulatedWrite{Key: key, Deleted: simulationStub.writes[key] == nil}
+			if objectType, attributes, err := APIstub.SplitCompositeKey(key); err == nil && strings.HasPrefix(key, compositeKeyNamespace) {
+				write.ObjectType, write.Attributes = objectType, attributes
+			}
+			if value := simulationStub.writes[key]; json.Valid(value) {
+				write.Value = value
+			} else {
+				write.Bytes = value
+			}
+			result.Writes = append(result.Writes, write)
+		}
+		result.PrivateWrites = sortedKeys(simulationStub.privateWrites)
+		for _, event := range simulationStub.events {
+			simulated := SimulatedEvent{Name: event.name}
+			if json.Valid(event.payload) {
+				simulated.Payload = event.payload
+			} else {
+				simulated.Bytes = event.payload
+			}
+			result.Events = append(result.Events, simulated)
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+func writtenKeys(writes map[string][]byte) map[string]bool {
+	keys := map[string]bool{}
+	for key := range writes {
+		keys[key] = true
+	}
+	return keys
+}
@@ -0,0 +1,187 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Sort orders
+ * Lists of houses can be sorted by year, size, asking price or time of last update, in ascending
+ * or descending order, ties being broken by house key. On CouchDB the fields stored as numbers or
+ * timestamps are sorted by the state database, with the indexes of META-INF/statedb/couchdb. The
+ * houses it cannot sort (houses without the field, houses encoded with protobuf) are sorted in the
+ * chaincode and merged into its results. On LevelDB, which has no sort, every house is sorted in
+ * the chaincode.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Sort orders
+const (
+	sortAscending  = "asc"
+	sortDescending = "desc"
+)
+
+// Define the sort value structure, compared by number then text
+type sortValue struct {
+	number int64
+	text   string
+}
+
+// Define the house sort field structure. Index is the CouchDB index of the field, empty when CouchDB cannot sort it
+// (year and size are stored as text)
+type houseSortField struct {
+	field string
+	index string
+	value func(house House) sortValue
+}
+
+var houseSortFields = map[string]houseSortField{
+	"year": {field: "year", value: func(house House) sortValue {
+		year, _ := strconv.ParseInt(house.Year, 10, 64)
+		return sortValue{number: year}
+	}},
+	"size": {field: "squarefeets", value: func(house House) sortValue {
+		size, _ := strconv.ParseInt(house.SquareFeets, 10, 64)
+		return sortValue{number: size}
+	}},
+	"price": {field: "askingprice", index: "indexHousePrice", value: func(house House) sortValue {
+		return sortValue{number: house.AskingPrice}
+	}},
+	"lastUpdated": {field: "updatedat", index: "indexHouseUpdatedAt", value: func(house House) sortValue {
+		return sortValue{text: house.UpdatedAt}
+	}},
+}
+
+type sortedHouse struct {
+	key   string
+	value sortValue
+}
+
+// before tells whether the house comes before the other in the order
+func (house sortedHouse) before(other sortedHouse, descending bool) bool {
+	if house.value != other.value {
+		less := house.value.number < other.value.number || (house.value.number == other.value.number && house.value.text < other.value.text)
+		return less != descending
+	}
+	return house.key < other.key
+}
+
+// couchSortedHouses returns the wanted houses holding the field, as sorted by CouchDB, nil on LevelDB
+func couchSortedHouses(APIstub shim.ChaincodeStubInterface, sortField houseSortField, order string, wanted map[string]bool) ([]sortedHouse, error) {
+	query, _ := json.Marshal(map[string]interface{}{
+		"selector":  map[string]interface{}{sortField.field: map[string]interface{}{"$gt": nil}},
+		"sort":      []map[string]string{{sortField.field: order}},
+		"use_index": []string{"_design/" + sortField.index + "Doc", sortField.index},
+	})
+	resultsIterator, err := APIstub.GetQueryResult(string(query))
+	if err != nil {
+		// Rich queries are not supported by LevelDB
+		logFor(APIstub).Debugf("Sorting by %s in the chaincode: %s", sortField.field, err)
+		return nil, nil
+	}
+	defer resultsIterator.Close()
+
+	houses := []sortedHouse{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !wanted[queryResponse.Key] {
+			continue
+		}
+		house, err := decodeHouse(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		houses = append(houses, sortedHouse{key: queryResponse.Key, value: sortField.value(house)})
+	}
+	return houses, nil
+}
+
+// mergeSortedHouses merges two lists of houses sorted in the order
+func mergeSortedHouses(left []sortedHouse, right []sortedHouse, descending bool) []sortedHouse {
+	merged := make([]sortedHouse, 0, len(left)+len(right))
+	for len(left) > 0 && len(right) > 0 {
+		if right[0].before(left[0], descending) {
+			merged, right = append(merged, right[0]), right[1:]
+		} else {
+			merged, left = append(merged, left[0]), left[1:]
+		}
+	}
+	return append(append(merged, left...), right...)
+}
+
+// sortHouseKeys sorts the keys of houses by the sort field, in the order (asc or desc)
+func sortHouseKeys(APIstub shim.ChaincodeStubInterface, keys []string, field string, order string) ([]string, error) {
+	sortField, known := houseSortFields[field]
+	if !known {
+		return nil, fmt.Errorf("Unknown sort field %q, expecting year, size, price or lastUpdated", field)
+	}
+	if order == "" {
+		order = sortAscending
+	}
+	if order != sortAscending && order != sortDescending {
+		return nil, fmt.Errorf("Sort order must be %s or %s", sortAscending, sortDescending)
+	}
+	descending := order == sortDescending
+
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	var couchSorted []sortedHouse
+	if sortField.index != "" {
+		var err error
+		if couchSorted, err = couchSortedHouses(APIstub, sortField, order, wanted); err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := map[string]bool{}
+	for _, house := range couchSorted {
+		sorted[house.key] = true
+	}
+	rest := []sortedHouse{}
+	for _, key := range keys {
+		if sorted[key] {
+			continue
+		}
+		house, err := getHouse(APIstub, key)
+		if err != nil {
+			return nil, err
+		}
+		rest = append(rest, sortedHouse{key: key, value: sortField.value(house)})
+	}
+	// sort.SliceStable is a merge sort, stable whatever the order of the keys
+	sort.SliceStable(rest, func(i, j int) bool {
+		return rest[i].before(rest[j], descending)
+	})
+
+	result := []string{}
+	for _, house := range mergeSortedHouses(couchSorted, rest, descending) {
+		result = append(result, house.key)
+	}
+	return result, nil
+}
@@ -0,0 +1,228 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Webhook subscriptions
+ * Applications register on the ledger the webhooks they want called, with filters on the event
+ * type, the location and the owner of the house concerned. An off-chain dispatcher listening to
+ * the events asks which subscriptions match each event and calls their webhooks; as the registry
+ * is on the ledger, every dispatcher fans an event out to the same subscriptions, in the order of
+ * their IDs.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const subscriptionObjectType = "subscription"
+
+// Define the subscription filter structure. An empty list matches any value
+type SubscriptionFilter struct {
+	Events    []string `json:"events"`
+	Locations []string `json:"locations"`
+	Owners    []string `json:"owners"`
+}
+
+// Define the subscription structure, a webhook called for the events matching its filter
+type Subscription struct {
+	ID        string             `json:"id"`
+	Owner     string             `json:"owner"`
+	URL       string             `json:"url"`
+	Filter    SubscriptionFilter `json:"filter"`
+	CreatedAt string             `json:"createdat"`
+}
+
+// Define the subscribed event structure, the event as described by the dispatcher
+type SubscribedEvent struct {
+	Event    string `json:"event"`
+	HouseKey string `json:"housekey"`
+	Location string `json:"location"`
+	Owner    string `json:"owner"`
+}
+
+func getSubscription(APIstub shim.ChaincodeStubInterface, id string) (Subscription, error) {
+	subscriptionKey, err := APIstub.CreateCompositeKey(subscriptionObjectType, []string{id})
+	if err != nil {
+		return Subscription{}, err
+	}
+	subscriptionAsBytes, err := APIstub.GetState(subscriptionKey)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if subscriptionAsBytes == nil {
+		return Subscription{}, fmt.Errorf("Subscription %s does not exist", id)
+	}
+	subscription := Subscription{}
+	err = json.Unmarshal(subscriptionAsBytes, &subscription)
+	return subscription, err
+}
+
+// matches tells whether the event passes the filter. Locations are compared normalized
+func (filter SubscriptionFilter) matches(event SubscribedEvent) bool {
+	if len(filter.Events) > 0 && !containsString(filter.Events, event.Event) {
+		return false
+	}
+	if len(filter.Owners) > 0 && !containsString(filter.Owners, event.Owner) {
+		return false
+	}
+	if len(filter.Locations) > 0 {
+		found := false
+		for _, location := range filter.Locations {
+			found = found || normalizeLocation(location) == normalizeLocation(event.Location)
+		}
+		return found
+	}
+	return true
+}
+
+/*
+ * registerSubscription registers a webhook for the events matching the filter, or replaces the subscription of the invoker
+ * args: subscription ID, webhook URL (http or https), filter as a JSON object of events, locations and owners lists
+ */
+func (s *SmartContract) registerSubscription(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] == "" {
+		return shim.Error("Subscription ID must not be empty")
+	}
+	webhook, err := url.Parse(args[1])
+	if err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") || webhook.Host == "" {
+		return shim.Error("Webhook URL must be an absolute http or https URL")
+	}
+	filter := SubscriptionFilter{}
+	if err := json.Unmarshal([]byte(args[2]), &filter); err != nil {
+		return shim.Error("Filter must be a JSON object of events, locations and owners lists")
+	}
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing, err := getSubscription(APIstub, args[0]); err == nil && existing.Owner != owner {
+		return shim.Error("Subscription " + args[0] + " belongs to " + existing.Owner)
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subscriptionKey, err := APIstub.CreateCompositeKey(subscriptionObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	subscriptionAsBytes, _ := json.Marshal(Subscription{ID: args[0], Owner: owner, URL: webhook.String(), Filter: filter, CreatedAt: txTime.Format(timeLayout)})
+	if err := APIstub.PutState(subscriptionKey, subscriptionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(subscriptionAsBytes)
+}
+
+/*
+ * deleteSubscription removes a subscription, only its owner can do it
+ * args: subscription ID
+ */
+func (s *SmartContract) deleteSubscription(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	subscription, err := getSubscription(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if subscription.Owner != owner {
+		return shim.Error("Only the owner " + subscription.Owner + " can delete subscription " + subscription.ID)
+	}
+
+	subscriptionKey, err := APIstub.CreateCompositeKey(subscriptionObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(subscriptionKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * getSubscriptionsMatching returns the subscriptions matching an event, in order of ID. The location and owner
+ * of the house of the event are read from the ledger when not given
+ * args: event as a JSON object of event, housekey, location and owner
+ */
+func (s *SmartContract) getSubscriptionsMatching(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	event := SubscribedEvent{}
+	if err := json.Unmarshal([]byte(args[0]), &event); err != nil {
+		return shim.Error("Event must be a JSON object of event, housekey, location and owner")
+	}
+	if event.Event == "" {
+		return shim.Error("Event type must not be empty")
+	}
+	if event.HouseKey != "" && (event.Location == "" || event.Owner == "") {
+		house, err := getHouse(APIstub, event.HouseKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if event.Location == "" {
+			event.Location = house.Location
+		}
+		if event.Owner == "" {
+			event.Owner = house.Owner
+		}
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(subscriptionObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	subscriptions := []Subscription{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		subscription := Subscription{}
+		if err := json.Unmarshal(queryResponse.Value, &subscription); err != nil {
+			return shim.Error(err.Error())
+		}
+		if subscription.Filter.matches(event) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	subscriptionsAsBytes, _ := json.Marshal(subscriptions)
+	return shim.Success(subscriptionsAsBytes)
+}
@@ -0,0 +1,300 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Subsidies
+ * Renovation and energy subsidies granted to houses are recorded by the granting agencies with
+ * their program and conditions. A subsidy is repayable when the house is sold within its clawback
+ * period: the sale is refused until the agency recorded the clawback.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	subsidyObjectType   = "subsidy"
+	houseSubsidyIndex   = "key~subsidy"
+	programSubsidyIndex = "program~subsidy"
+)
+
+// Subsidy statuses
+const (
+	subsidyGranted    = "granted"
+	subsidyClawedBack = "clawedback"
+)
+
+// Define the subsidy structure, a grant of a program to a house
+type Subsidy struct {
+	ID            string `json:"id"`
+	HouseKey      string `json:"housekey"`
+	Program       string `json:"program"`
+	Amount        int64  `json:"amount"`
+	Conditions    string `json:"conditions"`
+	Beneficiary   string `json:"beneficiary"`
+	GrantedBy     string `json:"grantedby"`
+	GrantedAt     string `json:"grantedat"`
+	ClawbackUntil string `json:"clawbackuntil"`
+	Status        string `json:"status"`
+	ClawedBackAt  string `json:"clawedbackat,omitempty"`
+	ClawbackRef   string `json:"clawbackref,omitempty"`
+}
+
+func getSubsidy(APIstub shim.ChaincodeStubInterface, id string) (Subsidy, error) {
+	subsidyKey, err := APIstub.CreateCompositeKey(subsidyObjectType, []string{id})
+	if err != nil {
+		return Subsidy{}, err
+	}
+	subsidyAsBytes, err := APIstub.GetState(subsidyKey)
+	if err != nil {
+		return Subsidy{}, err
+	}
+	if subsidyAsBytes == nil {
+		return Subsidy{}, fmt.Errorf("Subsidy %s does not exist", id)
+	}
+	subsidy := Subsidy{}
+	err = json.Unmarshal(subsidyAsBytes, &subsidy)
+	return subsidy, err
+}
+
+func putSubsidy(APIstub shim.ChaincodeStubInterface, subsidy Subsidy) ([]byte, error) {
+	subsidyKey, err := APIstub.CreateCompositeKey(subsidyObjectType, []string{subsidy.ID})
+	if err != nil {
+		return nil, err
+	}
+	subsidyAsBytes, _ := json.Marshal(subsidy)
+	return subsidyAsBytes, APIstub.PutState(subsidyKey, subsidyAsBytes)
+}
+
+// indexedSubsidies returns the subsidies of the index entries starting with the attribute
+func indexedSubsidies(APIstub shim.ChaincodeStubInterface, indexName string, attribute string) ([]Subsidy, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(indexName, []string{attribute})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	subsidies := []Subsidy{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		subsidy, err := getSubsidy(APIstub, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		subsidies = append(subsidies, subsidy)
+	}
+	sort.Slice(subsidies, func(i, j int) bool { return subsidies[i].GrantedAt < subsidies[j].GrantedAt })
+	return subsidies, nil
+}
+
+// checkSubsidyClawback returns an error when the house benefited from a subsidy still repayable on a sale
+func checkSubsidyClawback(APIstub shim.ChaincodeStubInterface, key string) error {
+	subsidies, err := indexedSubsidies(APIstub, houseSubsidyIndex, key)
+	if err != nil || len(subsidies) == 0 {
+		return err
+	}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, subsidy := range subsidies {
+		until, err := time.Parse(dayLayout, subsidy.ClawbackUntil)
+		if err != nil {
+			return err
+		}
+		if subsidy.Status == subsidyGranted && txTime.Before(until.AddDate(0, 0, 1)) {
+			return fmt.Errorf("House %s received subsidy %s of program %s, repayable on a sale until %s", key, subsidy.ID, subsidy.Program, subsidy.ClawbackUntil)
+		}
+	}
+	return nil
+}
+
+/*
+ * grantSubsidy records a subsidy granted to a house, for grantors
+ * args: house key, program, amount, conditions, clawback period in months
+ */
+func (s *SmartContract) grantSubsidy(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	if err := requireRole(APIstub, roleGrantor); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[1] == "" {
+		return shim.Error("Program must not be empty")
+	}
+	amount, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	months, err := strconv.Atoi(args[4])
+	if err != nil || months < 0 {
+		return shim.Error("Clawback period must be a positive number of months")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var subsidy = Subsidy{
+		ID:            APIstub.GetTxID(),
+		HouseKey:      args[0],
+		Program:       args[1],
+		Amount:        amount,
+		Conditions:    args[3],
+		Beneficiary:   house.Owner,
+		GrantedBy:     grantedBy,
+		GrantedAt:     grantedAt.Format(timeLayout),
+		ClawbackUntil: grantedAt.AddDate(0, months, 0).Format(dayLayout),
+		Status:        subsidyGranted,
+	}
+	subsidyAsBytes, err := putSubsidy(APIstub, subsidy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, index := range [][]string{{houseSubsidyIndex, subsidy.HouseKey}, {programSubsidyIndex, subsidy.Program}} {
+		indexKey, err := APIstub.CreateCompositeKey(index[0], []string{index[1], subsidy.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	emitEvent(APIstub, "subsidyGranted", subsidyAsBytes)
+	return shim.Success(subsidyAsBytes)
+}
+
+/*
+ * recordSubsidyClawback records the repayment of a subsidy, for grantors, releasing the sale of the house
+ * args: subsidy ID, reference of the repayment
+ */
+func (s *SmartContract) recordSubsidyClawback(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleGrantor); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subsidy, err := getSubsidy(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if subsidy.Status != subsidyGranted {
+		return shim.Error("Subsidy " + subsidy.ID + " was already clawed back")
+	}
+	clawedBackAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subsidy.Status = subsidyClawedBack
+	subsidy.ClawedBackAt = clawedBackAt.Format(timeLayout)
+	subsidy.ClawbackRef = args[1]
+	subsidyAsBytes, err := putSubsidy(APIstub, subsidy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(subsidyAsBytes)
+}
+
+// queryHouseSubsidies returns the subsidies granted to a house. args: house key
+func (s *SmartContract) queryHouseSubsidies(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	subsidies, err := indexedSubsidies(APIstub, houseSubsidyIndex, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subsidiesAsBytes, _ := json.Marshal(subsidies)
+	return shim.Success(subsidiesAsBytes)
+}
+
+// querySubsidyReport returns the subsidies of a program with the amounts granted and clawed back. args: program
+func (s *SmartContract) querySubsidyReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	subsidies, err := indexedSubsidies(APIstub, programSubsidyIndex, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var report = struct {
+		Program    string    `json:"program"`
+		Count      int       `json:"count"`
+		Houses     int       `json:"houses"`
+		Granted    int64     `json:"granted"`
+		ClawedBack int64     `json:"clawedback"`
+		Repayable  int64     `json:"repayable"`
+		Subsidies  []Subsidy `json:"subsidies"`
+	}{Program: args[0], Count: len(subsidies), Subsidies: subsidies}
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	houses := map[string]bool{}
+	for _, subsidy := range subsidies {
+		houses[subsidy.HouseKey] = true
+		report.Granted += subsidy.Amount
+		if subsidy.Status == subsidyClawedBack {
+			report.ClawedBack += subsidy.Amount
+		} else if subsidy.ClawbackUntil >= txTime.Format(dayLayout) {
+			report.Repayable += subsidy.Amount
+		}
+	}
+	report.Houses = len(houses)
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
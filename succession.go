@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Inheritance
+ * The owner registers the beneficiaries of a house. On the owner's death a registrar or
+ * notary executes the succession, handing the house to the beneficiaries in equal shares.
+ */
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const successionObjectType = "succession"
+
+// Define the succession structure, recorded once per executed succession
+type Succession struct {
+	HouseKey             string           `json:"housekey"`
+	Deceased             string           `json:"deceased"`
+	DeathCertificateHash string           `json:"deathcertificatehash"`
+	Heirs                []OwnershipShare `json:"heirs"`
+	ExecutedBy           string           `json:"executedby"`
+	ExecutedAt           string           `json:"executedat"`
+}
+
+/*
+ * registerBeneficiary adds a beneficiary to the house, only the owner can do it
+ * args: house key, beneficiary
+ */
+func (s *SmartContract) registerBeneficiary(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("Beneficiary must not be empty")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, beneficiary := range house.Beneficiaries {
+		if beneficiary == args[1] {
+			return shim.Error(args[1] + " is already a beneficiary of house " + args[0])
+		}
+	}
+	house.Beneficiaries = append(house.Beneficiaries, args[1])
+
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * removeBeneficiary removes a beneficiary from the house, only the owner can do it
+ * args: house key, beneficiary
+ */
+func (s *SmartContract) removeBeneficiary(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	beneficiaries := []string{}
+	for _, beneficiary := range house.Beneficiaries {
+		if beneficiary != args[1] {
+			beneficiaries = append(beneficiaries, beneficiary)
+		}
+	}
+	if len(beneficiaries) == len(house.Beneficiaries) {
+		return shim.Error(args[1] + " is not a beneficiary of house " + args[0])
+	}
+	house.Beneficiaries = beneficiaries
+
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+/*
+ * executeSuccession transfers the house of a deceased owner to its registered beneficiaries
+ * args: house key, hash of the death certificate
+ */
+func (s *SmartContract) executeSuccession(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("Death certificate hash must not be empty")
+	}
+	if err := requireRole(APIstub, roleRegistrar, roleNotary); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(house.Beneficiaries) == 0 {
+		return shim.Error("House " + args[0] + " has no registered beneficiary")
+	}
+
+	executedBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	executedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var succession = Succession{
+		HouseKey:             args[0],
+		Deceased:             house.Owner,
+		DeathCertificateHash: args[1],
+		Heirs:                splitShares(house.Beneficiaries),
+		ExecutedBy:           executedBy,
+		ExecutedAt:           executedAt.Format(timeLayout),
+	}
+
+	house.Beneficiaries = nil
+	if err := transferHouseShares(APIstub, args[0], house, succession.Heirs, reasonInheritance, 0); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	successionKey, err := APIstub.CreateCompositeKey(successionObjectType, []string{args[0], APIstub.GetTxID()})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	successionAsBytes, _ := json.Marshal(succession)
+	if err := APIstub.PutState(successionKey, successionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "successionExecuted", successionAsBytes)
+	return shim.Success(successionAsBytes)
+}
@@ -0,0 +1,158 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Amenity tags
+ * Houses carry amenity tags from a fixed vocabulary, stored lowercased, distinct and sorted,
+ * and indexed in the "tag~key" index for marketplace filtering.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const tagIndex = "tag~key"
+
+// Amenity tags a house can carry
+var amenityTags = []string{"balcony", "cellar", "elevator", "garage", "garden", "parking", "pool", "terrace"}
+
+// Tag query modes
+const (
+	tagMatchAll = "all"
+	tagMatchAny = "any"
+)
+
+// normalizeTags validates the tags and returns them lowercased, distinct and sorted
+func normalizeTags(tags []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, tag := range amenityTags {
+		known[tag] = true
+	}
+
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !known[tag] {
+			return nil, fmt.Errorf("Unknown amenity tag %q, expecting one of %v", tag, amenityTags)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func tagEntries(house House) [][]string {
+	entries := [][]string{}
+	for _, tag := range house.Tags {
+		entries = append(entries, []string{tag})
+	}
+	return entries
+}
+
+/*
+ * setHouseTags replaces the amenity tags of a house, only the owner can do it
+ * args: house key, tags as a comma separated list (empty to clear)
+ */
+func (s *SmartContract) setHouseTags(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	tags, err := normalizeTags(splitList(args[1]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	house.Tags = tags
+	if len(tags) == 0 {
+		house.Tags = nil
+	}
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tagsAsBytes, _ := json.Marshal(tags)
+	return shim.Success(tagsAsBytes)
+}
+
+/*
+ * queryHousesByTags returns the houses carrying all, or any, of the tags
+ * args: mode (all or any), tags
+ */
+func (s *SmartContract) queryHousesByTags(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting at least 2")
+	}
+	if args[0] != tagMatchAll && args[0] != tagMatchAny {
+		return shim.Error("Mode must be \"" + tagMatchAll + "\" or \"" + tagMatchAny + "\"")
+	}
+	tags, err := normalizeTags(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	counts := map[string]int{}
+	for _, tag := range tags {
+		keys, err := queryIndexedHouseKeys(APIstub, tagIndex, []string{tag})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, key := range keys {
+			counts[key]++
+		}
+	}
+
+	keys := []string{}
+	for key, count := range counts {
+		if args[0] == tagMatchAny || count == len(tags) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results, err := getHouseResults(APIstub, keys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsAsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
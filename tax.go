@@ -0,0 +1,415 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Transfer tax
+ * Sales are subject to a transfer tax computed from the banded rate table of the location of the
+ * house, or from the default table: every band taxes the part of the price up to its ceiling at
+ * its rate, in basis points, like the stamp duty. A sale owing tax is not completed at once: the
+ * tax obligation is recorded and the transfer waits until the tax authority records the
+ * settlement of the tax, which completes it. A sale recorded without its price, before sales had
+ * to declare it, is not exempt: its tax is assessed on the valuation of the house. Share transfers
+ * owe the tax assessed on the value of the tokens moved, and wait for its settlement the same way.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	taxRateTableObjectType  = "taxRateTable"
+	taxObligationObjectType = "taxObligation"
+)
+
+// Location of the rate table applying to the locations without a table of their own
+const defaultTaxLocation = "*"
+
+// Tax obligation statuses
+const (
+	taxDue     = "due"
+	taxSettled = "settled"
+)
+
+// Define the tax band structure, the rate applying to the part of the price up to the ceiling (0 for the top band)
+type TaxBand struct {
+	Ceiling int64 `json:"ceiling"`
+	Rate    int64 `json:"rate"`
+}
+
+// Define the tax rate table structure, the bands of a location in increasing order of ceilings
+type TaxRateTable struct {
+	Location string    `json:"location"`
+	Bands    []TaxBand `json:"bands"`
+}
+
+// Define the tax obligation structure, one per sale owing tax. A share transfer (Reason) moves Tokens from the holder to the recipient
+type TaxObligation struct {
+	ID            string `json:"id"`
+	HouseKey      string `json:"housekey"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Reason        string `json:"reason,omitempty"`
+	Tokens        int    `json:"tokens,omitempty"`
+	Price         int64  `json:"price"`
+	AssessedOn    int64  `json:"assessedon,omitempty"`
+	Location      string `json:"location"`
+	Tax           int64  `json:"tax"`
+	Status        string `json:"status"`
+	RecordedAt    string `json:"recordedat"`
+	SettledBy     string `json:"settledby,omitempty"`
+	SettledAt     string `json:"settledat,omitempty"`
+	SettlementRef string `json:"settlementref,omitempty"`
+}
+
+func getTaxRateTable(APIstub shim.ChaincodeStubInterface, location string) (TaxRateTable, bool, error) {
+	tableKey, err := APIstub.CreateCompositeKey(taxRateTableObjectType, []string{location})
+	if err != nil {
+		return TaxRateTable{}, false, err
+	}
+	tableAsBytes, err := APIstub.GetState(tableKey)
+	if err != nil || tableAsBytes == nil {
+		return TaxRateTable{}, false, err
+	}
+	table := TaxRateTable{}
+	err = json.Unmarshal(tableAsBytes, &table)
+	return table, true, err
+}
+
+// bandedTax returns the tax on the price, every band taxing the part of the price between the previous ceiling and its own
+func bandedTax(bands []TaxBand, price int64) int64 {
+	tax, floor := int64(0), int64(0)
+	for _, band := range bands {
+		top := price
+		if band.Ceiling > 0 && band.Ceiling < price {
+			top = band.Ceiling
+		}
+		if top > floor {
+			tax += (top - floor) * band.Rate / wholeShare
+		}
+		if band.Ceiling == 0 || band.Ceiling >= price {
+			break
+		}
+		floor = band.Ceiling
+	}
+	return tax
+}
+
+// taxRateTableOf returns the rate table applying to the house, that of its location or the default one
+func taxRateTableOf(APIstub shim.ChaincodeStubInterface, house House) (TaxRateTable, bool, error) {
+	table, found, err := getTaxRateTable(APIstub, normalizeLocation(house.Location))
+	if err != nil || found {
+		return table, found, err
+	}
+	return getTaxRateTable(APIstub, defaultTaxLocation)
+}
+
+// transferTax returns the transfer tax owed on the sale of the house at the price, with the amount it is assessed on
+// and the location of the table applied. A sale without a price, recorded before prices were required, is assessed
+// on the valuation of the house
+func transferTax(APIstub shim.ChaincodeStubInterface, key string, house House, price int64) (int64, int64, string, error) {
+	table, found, err := taxRateTableOf(APIstub, house)
+	if err != nil || !found {
+		return 0, 0, "", err
+	}
+	base := price
+	if base == 0 {
+		valuation, valued, err := houseValuation(APIstub, key, house)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if !valued {
+			return 0, 0, "", fmt.Errorf("House %s has no recorded sale to assess the tax of a sale without a price on", key)
+		}
+		base = valuation
+	}
+	return bandedTax(table.Bands, base), base, table.Location, nil
+}
+
+// requiresTaxSettlement tells whether the transfer is a sale owing tax, which only recordTaxSettlement can complete
+func requiresTaxSettlement(APIstub shim.ChaincodeStubInterface, key string, house House, reason string, price int64) (bool, error) {
+	if reason != reasonSale {
+		return false, nil
+	}
+	tax, _, _, err := transferTax(APIstub, key, house, price)
+	return tax > 0, err
+}
+
+func getTaxObligation(APIstub shim.ChaincodeStubInterface, id string) (TaxObligation, error) {
+	obligationKey, err := APIstub.CreateCompositeKey(taxObligationObjectType, []string{id})
+	if err != nil {
+		return TaxObligation{}, err
+	}
+	obligationAsBytes, err := APIstub.GetState(obligationKey)
+	if err != nil {
+		return TaxObligation{}, err
+	}
+	if obligationAsBytes == nil {
+		return TaxObligation{}, fmt.Errorf("Tax obligation %s does not exist", id)
+	}
+	obligation := TaxObligation{}
+	err = json.Unmarshal(obligationAsBytes, &obligation)
+	return obligation, err
+}
+
+func putTaxObligation(APIstub shim.ChaincodeStubInterface, obligation TaxObligation) ([]byte, error) {
+	obligationKey, err := APIstub.CreateCompositeKey(taxObligationObjectType, []string{obligation.ID})
+	if err != nil {
+		return nil, err
+	}
+	obligationAsBytes, _ := json.Marshal(obligation)
+	return obligationAsBytes, APIstub.PutState(obligationKey, obligationAsBytes)
+}
+
+// completeSale completes the sale of the house once co-signed, unless it owes tax: the tax obligation
+// is then recorded and returned, the transfer waiting for its settlement
+func completeSale(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string, price int64) ([]byte, error) {
+	tax, base, location, err := transferTax(APIstub, key, house, price)
+	if err != nil {
+		return nil, err
+	}
+	if tax == 0 {
+		return nil, executeTransfer(APIstub, key, house, []OwnershipShare{{Owner: newOwner, Share: wholeShare}}, reasonSale, price)
+	}
+
+	// Fail early, the checks are run again once the tax is settled
+	if err := checkTransferAllowed(APIstub, key, house, newOwner); err != nil {
+		return nil, err
+	}
+	recordedAt, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	var obligation = TaxObligation{
+		ID:         APIstub.GetTxID(),
+		HouseKey:   key,
+		From:       house.Owner,
+		To:         newOwner,
+		Price:      price,
+		AssessedOn: base,
+		Location:   location,
+		Tax:        tax,
+		Status:     taxDue,
+		RecordedAt: recordedAt.Format(timeLayout),
+	}
+	obligationAsBytes, err := putTaxObligation(APIstub, obligation)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(APIstub, "transferTaxDue", obligationAsBytes)
+	return obligationAsBytes, nil
+}
+
+/*
+ * setTaxRateTable replaces the tax bands of a location (* for the default table), for admins. No band removes the table
+ * args: location, bands as a JSON array of {"ceiling": price (0 for the top band), "rate": basis points}
+ */
+func (s *SmartContract) setTaxRateTable(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	location := defaultTaxLocation
+	if args[0] != defaultTaxLocation {
+		if location = normalizeLocation(args[0]); location == "" {
+			return shim.Error("Location must not be empty")
+		}
+	}
+	bands := []TaxBand{}
+	if err := json.Unmarshal([]byte(args[1]), &bands); err != nil {
+		return shim.Error("Bands must be a JSON array of tax bands")
+	}
+	for i, band := range bands {
+		if band.Rate < 0 || band.Rate > wholeShare {
+			return shim.Error(fmt.Sprintf("Rate of band %d must be between 0 and %d basis points", i+1, wholeShare))
+		}
+		if i < len(bands)-1 && (band.Ceiling <= 0 || bands[i+1].Ceiling != 0 && bands[i+1].Ceiling <= band.Ceiling) {
+			return shim.Error("Ceilings must be increasing, only the last band can have no ceiling")
+		}
+	}
+
+	tableKey, err := APIstub.CreateCompositeKey(taxRateTableObjectType, []string{location})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(bands) == 0 {
+		if err := APIstub.DelState(tableKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	tableAsBytes, _ := json.Marshal(TaxRateTable{Location: location, Bands: bands})
+	if err := APIstub.PutState(tableKey, tableAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(tableAsBytes)
+}
+
+// queryTaxRateTables returns the tax rate tables of every location
+func (s *SmartContract) queryTaxRateTables(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(taxRateTableObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	tables := []TaxRateTable{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		table := TaxRateTable{}
+		if err := json.Unmarshal(queryResponse.Value, &table); err != nil {
+			return shim.Error(err.Error())
+		}
+		tables = append(tables, table)
+	}
+
+	tablesAsBytes, _ := json.Marshal(tables)
+	return shim.Success(tablesAsBytes)
+}
+
+/*
+ * recordTaxSettlement records the payment of the tax of a sale or share transfer, for the tax authority, and completes it
+ * args: tax obligation ID, reference of the payment
+ */
+func (s *SmartContract) recordTaxSettlement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleTaxAuthority); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	obligation, err := getTaxObligation(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if obligation.Status != taxDue {
+		return shim.Error("Tax obligation " + obligation.ID + " is " + obligation.Status)
+	}
+	house, err := getHouse(APIstub, obligation.HouseKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if obligation.Reason == reasonShareTransfer {
+		if balance := shareBalance(house, obligation.From); balance < obligation.Tokens {
+			return shim.Error(fmt.Sprintf("%s holds %d share tokens of house %s since the share transfer was recorded, not %d", obligation.From, balance, obligation.HouseKey, obligation.Tokens))
+		}
+		shares := movedShares(house, obligation.From, obligation.To, obligation.Tokens)
+		if err := transferHouseShares(APIstub, obligation.HouseKey, house, shares, reasonShareTransfer, 0); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		if house.Owner != obligation.From {
+			return shim.Error(fmt.Sprintf("House %s changed hands since the sale was recorded", obligation.HouseKey))
+		}
+		shares := []OwnershipShare{{Owner: obligation.To, Share: wholeShare}}
+		if err := executeTransfer(APIstub, obligation.HouseKey, house, shares, reasonSale, obligation.Price); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	settledBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	settledAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	obligation.Status = taxSettled
+	obligation.SettledBy = settledBy
+	obligation.SettledAt = settledAt.Format(timeLayout)
+	obligation.SettlementRef = args[1]
+	obligationAsBytes, err := putTaxObligation(APIstub, obligation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	emitEvent(APIstub, "transferTaxSettled", obligationAsBytes)
+	return shim.Success(obligationAsBytes)
+}
+
+/*
+ * queryTaxDue returns the tax obligations not settled yet, of one house or of all (empty key), with their total
+ * args: house key
+ */
+func (s *SmartContract) queryTaxDue(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(taxObligationObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var due = struct {
+		Total       int64           `json:"total"`
+		Obligations []TaxObligation `json:"obligations"`
+	}{Obligations: []TaxObligation{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		obligation := TaxObligation{}
+		if err := json.Unmarshal(queryResponse.Value, &obligation); err != nil {
+			return shim.Error(err.Error())
+		}
+		if obligation.Status == taxDue && (args[0] == "" || obligation.HouseKey == args[0]) {
+			due.Total += obligation.Tax
+			due.Obligations = append(due.Obligations, obligation)
+		}
+	}
+	sort.Slice(due.Obligations, func(i, j int) bool { return due.Obligations[i].RecordedAt < due.Obligations[j].RecordedAt })
+
+	dueAsBytes, _ := json.Marshal(due)
+	return shim.Success(dueAsBytes)
+}
+
+// queryTaxObligation returns a tax obligation. args: tax obligation ID
+func (s *SmartContract) queryTaxObligation(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	obligation, err := getTaxObligation(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	obligationAsBytes, _ := json.Marshal(obligation)
+	return shim.Success(obligationAsBytes)
+}
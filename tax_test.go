@@ -0,0 +1,59 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Transfer tax tests
+ * The assessment of the sales recorded without a price, run directly on the stub.
+ */
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func TestSaleWithoutPriceIsAssessedOnValuation(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE2", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "changeHouseOwner", "HOUSE1", "bob", reasonSale, "200000")
+	ledger.invoke(t, ledger.owner, "setTaxRateTable", defaultTaxLocation, `[{"ceiling": 0, "rate": 500}]`)
+
+	ledger.inTransaction(func(APIstub shim.ChaincodeStubInterface) {
+		house, err := getHouse(APIstub, "HOUSE1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		required, err := requiresTaxSettlement(APIstub, "HOUSE1", house, reasonSale, 0)
+		if err != nil || !required {
+			t.Errorf("Sale without a price requires tax settlement: %t, %v, expected it to", required, err)
+		}
+		tax, base, _, err := transferTax(APIstub, "HOUSE1", house, 0)
+		if err != nil || tax != 10000 || base != 200000 {
+			t.Errorf("Tax of a sale without a price is %d on %d (%v), expected 10000 on the last sale price of 200000", tax, base, err)
+		}
+
+		if house, err = getHouse(APIstub, "HOUSE2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := requiresTaxSettlement(APIstub, "HOUSE2", house, reasonSale, 0); err == nil {
+			t.Errorf("Sale without a price of a house never sold was not refused")
+		}
+	})
+}
@@ -0,0 +1,422 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Ownership transfers
+ * Every function changing the owner of a house goes through transferHouse, so that
+ * the checks blocking a transfer are enforced in a single place and every transfer
+ * is recorded in the transfer history along with its reason
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	transferObjectType = "transfer"
+	transferIndex      = "reason~period~key~txid"
+)
+
+// Transfer reasons
+const (
+	reasonSale        = "sale"
+	reasonGift        = "gift"
+	reasonInheritance = "inheritance"
+	reasonCourtOrder  = "courtOrder"
+	reasonCorrection  = "correction"
+	reasonForeclosure = "foreclosure"
+	// Share tokens moved by their holder, which are not a sale of the house. See moveShareTokens
+	reasonShareTransfer = "shareTransfer"
+)
+
+var transferReasons = []string{reasonSale, reasonGift, reasonInheritance, reasonCourtOrder, reasonCorrection, reasonForeclosure, reasonShareTransfer}
+
+// Layout of the reporting period of a transfer (one period per month)
+const periodLayout = "2006-01"
+
+// Shares are expressed in basis points, wholeShare being the entire house
+const wholeShare = 10000
+
+// Define the ownership share structure, the part of a house held by one co-owner
+type OwnershipShare struct {
+	Owner string `json:"owner" protobuf:"1"`
+	Share int    `json:"share" protobuf:"2"`
+}
+
+// Define the transfer structure, one entry of the transfer history of a house
+type Transfer struct {
+	TxID      string           `json:"txid"`
+	HouseKey  string           `json:"housekey"`
+	From      string           `json:"from"`
+	To        []OwnershipShare `json:"to"`
+	Reason    string           `json:"reason"`
+	Price     int64            `json:"price"`
+	Timestamp string           `json:"timestamp"`
+	Period    string           `json:"period"`
+}
+
+// splitShares divides the house equally between the owners, the rounding remainder going to the first one
+func splitShares(owners []string) []OwnershipShare {
+	shares := []OwnershipShare{}
+	if len(owners) == 0 {
+		return shares
+	}
+
+	part := wholeShare / len(owners)
+	for _, owner := range owners {
+		shares = append(shares, OwnershipShare{Owner: owner, Share: part})
+	}
+	shares[0].Share += wholeShare - part*len(owners)
+	return shares
+}
+
+// houseShares returns the ownership shares of the house, a sole owner holding the whole house
+func houseShares(house House) []OwnershipShare {
+	if len(house.Shares) == 0 {
+		return []OwnershipShare{{Owner: house.Owner, Share: wholeShare}}
+	}
+	return house.Shares
+}
+
+// parseTransferReason validates the reason and price of a transfer given as arguments
+func parseTransferReason(reason string, price string) (string, int64, error) {
+	valid := false
+	for _, transferReason := range transferReasons {
+		if reason == transferReason {
+			valid = true
+		}
+	}
+	if !valid {
+		return "", 0, fmt.Errorf("Unknown transfer reason %q", reason)
+	}
+	if reason == reasonShareTransfer {
+		return "", 0, fmt.Errorf("Share transfers are made with transferShareTokens")
+	}
+
+	// A sale without its price would escape the co-signature and the tax assessed on it
+	if reason == reasonSale && price == "" {
+		return "", 0, fmt.Errorf("A sale must declare its price")
+	}
+	amount := int64(0)
+	if price != "" {
+		var err error
+		amount, err = strconv.ParseInt(price, 10, 64)
+		if err != nil || amount < 0 {
+			return "", 0, fmt.Errorf("Price must be a positive number")
+		}
+	}
+	if reason == reasonSale && amount == 0 {
+		return "", 0, fmt.Errorf("A sale cannot be at zero price, use the %q reason", reasonGift)
+	}
+	if reason != reasonSale && amount != 0 {
+		return "", 0, fmt.Errorf("Only a sale can carry a price")
+	}
+	return reason, amount, nil
+}
+
+// checkTransferAllowed returns an error when the house cannot currently change hands
+func checkTransferAllowed(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string) error {
+	if newOwner == "" {
+		return fmt.Errorf("New owner of house %s must not be empty", key)
+	}
+	if house.DisputeID != "" {
+		return fmt.Errorf("House %s is in dispute (%s) and cannot be transferred", key, house.DisputeID)
+	}
+
+	// Both parties are screened, the seller being every current co-owner
+	parties := []string{house.Owner, newOwner}
+	for _, share := range house.Shares {
+		parties = append(parties, share.Owner)
+	}
+	for _, party := range parties {
+		if err := checkNotBlocked(APIstub, party); err != nil {
+			return err
+		}
+	}
+	if isMultisigAccount(newOwner) {
+		return checkMultisigParty(APIstub, newOwner)
+	}
+	if isLegalEntity(newOwner) {
+		return checkEntityParty(APIstub, newOwner)
+	}
+	return checkKYCVerified(APIstub, newOwner)
+}
+
+// transferHouse changes the owner of the house after checking the transfer is allowed
+func transferHouse(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string, reason string, price int64) error {
+	return transferHouseShares(APIstub, key, house, []OwnershipShare{{Owner: newOwner, Share: wholeShare}}, reason, price)
+}
+
+// transferHouseShares hands the house over to several co-owners. The first co-owner becomes the registered owner
+func transferHouseShares(APIstub shim.ChaincodeStubInterface, key string, house House, shares []OwnershipShare, reason string, price int64) error {
+	if holder := multisigHolder(house); holder != "" {
+		return fmt.Errorf("House %s is held by the multi-signature account %s, use proposeMultisigTransfer", key, holder)
+	}
+	if err := checkTransferPath(APIstub, key, house, reason, price); err != nil {
+		return err
+	}
+	return executeTransfer(APIstub, key, house, shares, reason, price)
+}
+
+// queueOrExecuteTransfer transfers the house to the new owner, unless the transfer must wait: sales subject to
+// pre-emption rights wait for the pre-emption window, then like every transfer for proceedWithTransfer. It returns
+// the pre-emption notice, co-signature request or tax obligation queued, nil when the transfer was executed
+func queueOrExecuteTransfer(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string, reason string, price int64) ([]byte, error) {
+	if reason == reasonSale && price > 0 {
+		noticeAsBytes, err := notifyPreemption(APIstub, key, house, newOwner, price)
+		if err != nil || noticeAsBytes != nil {
+			return noticeAsBytes, err
+		}
+	}
+	return proceedWithTransfer(APIstub, key, house, newOwner, reason, price)
+}
+
+// proceedWithTransfer transfers the house to the new owner, unless high value sales wait for the co-signature
+// of two registrars, sales owing transfer tax for its settlement. It returns the co-signature request or the tax
+// obligation queued, nil when the transfer was executed
+func proceedWithTransfer(APIstub shim.ChaincodeStubInterface, key string, house House, newOwner string, reason string, price int64) ([]byte, error) {
+	required, err := requiresCosignature(APIstub, price)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		return requestCosignature(APIstub, key, house, newOwner, price)
+	}
+	if required, err = requiresTaxSettlement(APIstub, key, house, reason, price); err != nil {
+		return nil, err
+	}
+	if required {
+		return completeSale(APIstub, key, house, newOwner, price)
+	}
+	return nil, executeTransfer(APIstub, key, house, []OwnershipShare{{Owner: newOwner, Share: wholeShare}}, reason, price)
+}
+
+// checkTransferPath returns an error when the transfer must wait for the co-signature of registrars or the settlement of its tax
+func checkTransferPath(APIstub shim.ChaincodeStubInterface, key string, house House, reason string, price int64) error {
+	required, err := requiresCosignature(APIstub, price)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("Transfer of house %s at price %d requires the co-signature of two registrars, use changeHouseOwner", key, price)
+	}
+	if required, err = requiresTaxSettlement(APIstub, key, house, reason, price); err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("Sale of house %s at price %d owes transfer tax, use changeHouseOwner", key, price)
+	}
+	return nil
+}
+
+// executeTransfer hands the house over once every check passed, save the co-signature policy enforced by the caller
+func executeTransfer(APIstub shim.ChaincodeStubInterface, key string, house House, shares []OwnershipShare, reason string, price int64) error {
+	if len(shares) == 0 {
+		return fmt.Errorf("New owners of house %s must not be empty", key)
+	}
+
+	total := 0
+	for _, share := range shares {
+		if err := checkTransferAllowed(APIstub, key, house, share.Owner); err != nil {
+			return err
+		}
+		total += share.Share
+	}
+	if total != wholeShare {
+		return fmt.Errorf("Shares of house %s must sum to %d, got %d", key, wholeShare, total)
+	}
+	if err := evaluateTransferRules(APIstub, key, house, shares, reason); err != nil {
+		return err
+	}
+	if reason == reasonSale {
+		if err := checkSubsidyClawback(APIstub, key); err != nil {
+			return err
+		}
+		if err := checkRiskDisclosures(APIstub, key, house, shares); err != nil {
+			return err
+		}
+	}
+	// A frozen house only changes hands by the court order lifting its freeze, which is not capped either
+	if reason != reasonCourtOrder {
+		if err := checkNotFrozen(APIstub, key); err != nil {
+			return err
+		}
+		if err := checkOwnershipCaps(APIstub, key, house, shares); err != nil {
+			return err
+		}
+	}
+
+	previousOwner := house.Owner
+	house.Owner = shares[0].Owner
+	house.Shares = shares
+	if len(shares) == 1 {
+		house.Shares = nil
+	}
+	// The listing of the previous owner does not survive the transfer
+	house.AskingPrice = 0
+	if err := putHouse(APIstub, key, house); err != nil {
+		return err
+	}
+
+	if err := recordTransfer(APIstub, key, previousOwner, shares, reason, price); err != nil {
+		return err
+	}
+	if err := chargeTransferFee(APIstub, key, house.Owner, reason, price); err != nil {
+		return err
+	}
+	return recordSalePrice(APIstub, key, house, reason, price)
+}
+
+// recordTransfer appends the transfer to the history of the house and to the reporting index
+func recordTransfer(APIstub shim.ChaincodeStubInterface, key string, from string, to []OwnershipShare, reason string, price int64) error {
+	txTime, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+
+	var transfer = Transfer{
+		TxID:      APIstub.GetTxID(),
+		HouseKey:  key,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		Price:     price,
+		Timestamp: txTime.Format(timeLayout),
+		Period:    txTime.Format(periodLayout),
+	}
+
+	transferKey, err := APIstub.CreateCompositeKey(transferObjectType, []string{transfer.HouseKey, transfer.TxID})
+	if err != nil {
+		return err
+	}
+	transferAsBytes, _ := json.Marshal(transfer)
+	if err := APIstub.PutState(transferKey, transferAsBytes); err != nil {
+		return err
+	}
+
+	indexKey, err := APIstub.CreateCompositeKey(transferIndex, []string{transfer.Reason, transfer.Period, transfer.HouseKey, transfer.TxID})
+	if err != nil {
+		return err
+	}
+	// Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the transfer
+	return APIstub.PutState(indexKey, []byte{0x00})
+}
+
+// queryTransferHistory returns every recorded transfer of a house
+func (s *SmartContract) queryTransferHistory(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	transfers := []Transfer{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		transfer := Transfer{}
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			return shim.Error(err.Error())
+		}
+		transfers = append(transfers, transfer)
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].Timestamp < transfers[j].Timestamp })
+
+	transfersAsBytes, _ := json.Marshal(transfers)
+	return shim.Success(transfersAsBytes)
+}
+
+/*
+ * queryTransferReport counts the transfers per reason and per period, for the registrar
+ * args: first period, last period (both inclusive, formatted YYYY-MM, empty for unbounded)
+ */
+func (s *SmartContract) queryTransferReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type reportLine struct {
+		Reason string `json:"reason"`
+		Period string `json:"period"`
+		Count  int    `json:"count"`
+	}
+
+	report := []reportLine{}
+	for _, reason := range transferReasons {
+		lines, err := countTransfers(APIstub, reason, args[0], args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		periods := []string{}
+		for period := range lines {
+			periods = append(periods, period)
+		}
+		sort.Strings(periods)
+		for _, period := range periods {
+			report = append(report, reportLine{Reason: reason, Period: period, Count: lines[period]})
+		}
+	}
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
+
+// countTransfers counts the transfers of the given reason per period within the bounds
+func countTransfers(APIstub shim.ChaincodeStubInterface, reason string, firstPeriod string, lastPeriod string) (map[string]int, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(transferIndex, []string{reason})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	counts := map[string]int{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		period := attributes[1]
+		if (firstPeriod != "" && period < firstPeriod) || (lastPeriod != "" && period > lastPeriod) {
+			continue
+		}
+		counts[period]++
+	}
+	return counts, nil
+}
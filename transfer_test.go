@@ -0,0 +1,49 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Transfer tests
+ * Sales through the invocation pipeline, with the policies applying to them set by the admin.
+ */
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnpricedSaleAboveCosignThresholdIsRefused(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice")
+	ledger.invoke(t, ledger.owner, "setCosignPolicy", "100000", "48")
+
+	for _, args := range [][]string{{"HOUSE1", "bob"}, {"HOUSE1", "bob", reasonSale}, {"HOUSE1", "bob", reasonSale, ""}} {
+		if message := ledger.refuse(t, ledger.owner, "changeHouseOwner", args...); !strings.Contains(message, "must declare its price") {
+			t.Errorf("changeHouseOwner%v failed with %q, expected the price to be required", args, message)
+		}
+	}
+
+	request := CosignRequest{}
+	if err := json.Unmarshal(ledger.invoke(t, ledger.owner, "changeHouseOwner", "HOUSE1", "bob", reasonSale, "250000"), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != cosignPending || request.Price != 250000 {
+		t.Errorf("Sale above the threshold queued %+v, expected a pending co-signature request at its price", request)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* API versions
+ * A function can be called in the namespace of a version of the API, as in "v2:createHouse".
+ * Calls without a namespace are calls of version 1, the version of the handlers. A later version
+ * changes the arguments of some functions, which are adapted to the handlers before routing,
+ * and keeps the functions it does not change as they are in version 1.
+ */
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the calls without a namespace
+const defaultAPIVersion = "v1"
+
+// versionAdapter converts the arguments of a call of a version into the arguments of the handler
+type versionAdapter func(args []string) ([]string, error)
+
+// Functions changed by every version of the API
+var apiVersions = map[string]map[string]versionAdapter{
+	defaultAPIVersion: {},
+	"v2": {
+		"createHouse":      adaptCreateHouseV2,
+		"changeHouseOwner": adaptChangeHouseOwnerV2,
+	},
+}
+
+// resolveAPIVersion strips the version namespace of the function and adapts the arguments of the call
+func resolveAPIVersion(function string, args []string) (string, []string, error) {
+	separator := strings.Index(function, ":")
+	if separator < 0 {
+		return function, args, nil
+	}
+	version, name := function[:separator], function[separator+1:]
+	adapters, known := apiVersions[version]
+	if !known {
+		versions := []string{}
+		for apiVersion := range apiVersions {
+			versions = append(versions, apiVersion)
+		}
+		sort.Strings(versions)
+		return "", nil, fmt.Errorf("Unknown API version %q, expecting one of %v", version, versions)
+	}
+	adapt, changed := adapters[name]
+	if !changed {
+		return name, args, nil
+	}
+	args, err := adapt(args)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %s", function, err.Error())
+	}
+	return name, args, nil
+}
+
+// decodeStrict decodes a JSON object into the value, rejecting the unknown fields and the fields of the wrong type
+func decodeStrict(value string, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// Define the version 2 house structure, typed fields replacing the positional strings of version 1
+type HouseV2 struct {
+	Year         int64           `json:"year"`
+	SquareFeets  int64           `json:"squarefeets"`
+	Location     string          `json:"location"`
+	Address      json.RawMessage `json:"address"`
+	Owner        string          `json:"owner"`
+	Usage        string          `json:"usage"`
+	Zone         string          `json:"zone"`
+	CadastralRef string          `json:"cadastralref"`
+}
+
+/*
+ * adaptCreateHouseV2 adapts v2:createHouse, which takes the house as a typed JSON object,
+ * with either a location or an address
+ * args: house key, house as a JSON object of year, squarefeets, location or address, owner, usage, zone and cadastralref
+ */
+func adaptCreateHouseV2(args []string) ([]string, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("Incorrect number of arguments. Expecting 2")
+	}
+	house := HouseV2{}
+	if err := decodeStrict(args[1], &house); err != nil {
+		return nil, fmt.Errorf("House must be a JSON object of year, squarefeets, location or address, owner, usage, zone and cadastralref: %s", err.Error())
+	}
+	if house.Year <= 0 || house.SquareFeets <= 0 {
+		return nil, fmt.Errorf("Year and squarefeets must be positive numbers")
+	}
+	if house.Owner == "" {
+		return nil, fmt.Errorf("Owner must not be empty")
+	}
+	location := house.Location
+	if len(house.Address) > 0 {
+		if location != "" {
+			return nil, fmt.Errorf("Location and address are exclusive")
+		}
+		location = string(house.Address)
+	}
+	if location == "" {
+		return nil, fmt.Errorf("Either location or address is required")
+	}
+	return []string{args[0], strconv.FormatInt(house.Year, 10), strconv.FormatInt(house.SquareFeets, 10), location, house.Owner,
+		house.Usage, house.Zone, house.CadastralRef}, nil
+}
+
+// Define the version 2 transfer structure. The reason is required, and so is the price of a sale
+type TransferV2 struct {
+	NewOwner string `json:"newowner"`
+	Reason   string `json:"reason"`
+	Price    *int64 `json:"price"`
+}
+
+/*
+ * adaptChangeHouseOwnerV2 adapts v2:changeHouseOwner, which takes the transfer as a typed JSON object
+ * args: house key, transfer as a JSON object of newowner, reason and price (required for a sale)
+ */
+func adaptChangeHouseOwnerV2(args []string) ([]string, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("Incorrect number of arguments. Expecting 2")
+	}
+	transfer := TransferV2{}
+	if err := decodeStrict(args[1], &transfer); err != nil {
+		return nil, fmt.Errorf("Transfer must be a JSON object of newowner, reason and price: %s", err.Error())
+	}
+	if transfer.NewOwner == "" || transfer.Reason == "" {
+		return nil, fmt.Errorf("Newowner and reason are required")
+	}
+	price := ""
+	if transfer.Price != nil {
+		price = strconv.FormatInt(*transfer.Price, 10)
+	} else if transfer.Reason == reasonSale {
+		return nil, fmt.Errorf("The price of a sale is required")
+	}
+	return []string{args[0], transfer.NewOwner, transfer.Reason, price}, nil
+}
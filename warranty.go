@@ -0,0 +1,434 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Builders and warranties
+ * Registrars keep a registry of builders, and link every house to the builder who constructed it.
+ * The link starts the statutory warranties of the house from its completion date, for the year of
+ * completion defects, two years of equipment defects and ten years of structural defects. Within a
+ * warranty period the owner of the house can file a claim against the builder, see claims.go.
+ * Warranty claims filed before the claim ledger are moved to it by migrateWarrantyClaims.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	builderObjectType      = "builder"
+	houseBuilderObjectType = "houseBuilder"
+	builderHouseIndex      = "builder~key"
+)
+
+// Warranty claims filed before the claim ledger, and their index by builder
+const (
+	legacyWarrantyClaimObjectType = "warrantyClaim"
+	legacyBuilderClaimIndex       = "builder~claim"
+)
+
+// Statuses of the claims of the claim ledger replacing the statuses of the legacy warranty claims
+var legacyClaimStatuses = map[string]string{
+	"filed":        claimFiled,
+	"acknowledged": claimAcknowledged,
+	"rejected":     claimContested,
+	"resolved":     claimAdjudicated,
+}
+
+// Define the legacy warranty claim structure, as filed before the claim ledger
+type LegacyWarrantyClaim struct {
+	ID          string `json:"id"`
+	HouseKey    string `json:"housekey"`
+	Builder     string `json:"builder"`
+	Warranty    string `json:"warranty"`
+	Description string `json:"description"`
+	FiledBy     string `json:"filedby"`
+	FiledAt     string `json:"filedat"`
+	Status      string `json:"status"`
+	Note        string `json:"note,omitempty"`
+	UpdatedAt   string `json:"updatedat,omitempty"`
+}
+
+// Statutory warranties, in years from the completion of the house
+var warrantyPeriods = map[string]int{
+	"completion": 1,
+	"equipment":  2,
+	"structural": 10,
+}
+
+// Define the builder structure. The representative is the identity answering the warranty claims
+type Builder struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	License        string `json:"license"`
+	Representative string `json:"representative"`
+	RegisteredBy   string `json:"registeredby"`
+	RegisteredAt   string `json:"registeredat"`
+}
+
+// Define the warranty structure, a statutory warranty of a house
+type Warranty struct {
+	Kind     string `json:"kind"`
+	StartsOn string `json:"startson"`
+	EndsOn   string `json:"endson"`
+}
+
+// Define the house builder structure, the link of a house to its builder
+type HouseBuilder struct {
+	HouseKey    string     `json:"housekey"`
+	Builder     string     `json:"builder"`
+	CompletedOn string     `json:"completedon"`
+	Warranties  []Warranty `json:"warranties"`
+}
+
+func getBuilder(APIstub shim.ChaincodeStubInterface, id string) (Builder, error) {
+	builderKey, err := APIstub.CreateCompositeKey(builderObjectType, []string{id})
+	if err != nil {
+		return Builder{}, err
+	}
+	builderAsBytes, err := APIstub.GetState(builderKey)
+	if err != nil {
+		return Builder{}, err
+	}
+	if builderAsBytes == nil {
+		return Builder{}, fmt.Errorf("Builder %s does not exist", id)
+	}
+	builder := Builder{}
+	err = json.Unmarshal(builderAsBytes, &builder)
+	return builder, err
+}
+
+func getHouseBuilder(APIstub shim.ChaincodeStubInterface, key string) (HouseBuilder, error) {
+	linkKey, err := APIstub.CreateCompositeKey(houseBuilderObjectType, []string{key})
+	if err != nil {
+		return HouseBuilder{}, err
+	}
+	linkAsBytes, err := APIstub.GetState(linkKey)
+	if err != nil {
+		return HouseBuilder{}, err
+	}
+	if linkAsBytes == nil {
+		return HouseBuilder{}, fmt.Errorf("No builder is recorded for house %s", key)
+	}
+	link := HouseBuilder{}
+	err = json.Unmarshal(linkAsBytes, &link)
+	return link, err
+}
+
+/*
+ * registerBuilder adds or updates a builder of the registry, for registrars
+ * args: builder ID, name, license number, representative
+ */
+func (s *SmartContract) registerBuilder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" || args[3] == "" {
+		return shim.Error("Builder ID, name and representative must not be empty")
+	}
+
+	registeredBy, err := getInvokerID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	registeredAt, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	builderKey, err := APIstub.CreateCompositeKey(builderObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	builderAsBytes, _ := json.Marshal(Builder{
+		ID:             args[0],
+		Name:           args[1],
+		License:        args[2],
+		Representative: args[3],
+		RegisteredBy:   registeredBy,
+		RegisteredAt:   registeredAt.Format(timeLayout),
+	})
+	if err := APIstub.PutState(builderKey, builderAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(builderAsBytes)
+}
+
+/*
+ * linkHouseBuilder records the builder of a house and starts its statutory warranties, for registrars.
+ * The completion date defaults to the completion milestone of the construction project of the house
+ * args: house key, builder ID, completion date (YYYY-MM-DD, or empty)
+ */
+func (s *SmartContract) linkHouseBuilder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if err := requireRole(APIstub, roleRegistrar); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getHouse(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getBuilder(APIstub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	completedOn := args[2]
+	if completedOn == "" {
+		project, err := getConstructionProject(APIstub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if project.Status != constructionCompleted {
+			return shim.Error("Construction of house " + args[0] + " is not completed")
+		}
+		completedAt, _ := time.Parse(timeLayout, project.Milestones[len(project.Milestones)-1].AttestedAt)
+		completedOn = completedAt.Format(dayLayout)
+	}
+	completion, err := time.Parse(dayLayout, completedOn)
+	if err != nil {
+		return shim.Error("Completion date must be formatted YYYY-MM-DD")
+	}
+
+	linkKey, err := APIstub.CreateCompositeKey(houseBuilderObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	linkAsBytes, err := APIstub.GetState(linkKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if linkAsBytes != nil {
+		return shim.Error("A builder is already recorded for house " + args[0])
+	}
+
+	var link = HouseBuilder{HouseKey: args[0], Builder: args[1], CompletedOn: completedOn, Warranties: []Warranty{}}
+	for _, kind := range sortedKeys(warrantyKinds()) {
+		link.Warranties = append(link.Warranties, Warranty{
+			Kind:     kind,
+			StartsOn: completedOn,
+			EndsOn:   completion.AddDate(warrantyPeriods[kind], 0, 0).Format(dayLayout),
+		})
+	}
+	linkAsBytes, _ = json.Marshal(link)
+	if err := APIstub.PutState(linkKey, linkAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := APIstub.CreateCompositeKey(builderHouseIndex, []string{args[1], args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(linkAsBytes)
+}
+
+// runningWarranty returns the builder of the house and its warranty of the kind, an error unless it runs at the time
+func runningWarranty(APIstub shim.ChaincodeStubInterface, key string, kind string, at time.Time) (HouseBuilder, Warranty, error) {
+	link, err := getHouseBuilder(APIstub, key)
+	if err != nil {
+		return HouseBuilder{}, Warranty{}, err
+	}
+	for _, warranty := range link.Warranties {
+		if warranty.Kind != kind {
+			continue
+		}
+		if at.Format(dayLayout) >= warranty.EndsOn {
+			return HouseBuilder{}, Warranty{}, fmt.Errorf("The %s warranty of house %s ended on %s", kind, key, warranty.EndsOn)
+		}
+		return link, warranty, nil
+	}
+	return HouseBuilder{}, Warranty{}, fmt.Errorf("House %s has no %s warranty", key, kind)
+}
+
+func warrantyKinds() map[string]bool {
+	kinds := map[string]bool{}
+	for kind := range warrantyPeriods {
+		kinds[kind] = true
+	}
+	return kinds
+}
+
+// queryBuilder returns a builder with the houses it constructed and the warranty claims filed against it. args: builder ID
+func (s *SmartContract) queryBuilder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	builder, err := getBuilder(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var result = struct {
+		Builder
+		Houses []string `json:"houses"`
+		Claims []Claim  `json:"claims"`
+	}{Builder: builder}
+	if result.Houses, err = queryIndexedHouseKeys(APIstub, builderHouseIndex, []string{args[0]}); err != nil {
+		return shim.Error(err.Error())
+	}
+	if result.Claims, err = indexedClaims(APIstub, liablePartyClaimIndex, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// queryHouseWarranties returns the builder and the statutory warranties of a house. args: house key
+func (s *SmartContract) queryHouseWarranties(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	link, err := getHouseBuilder(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	linkAsBytes, _ := json.Marshal(link)
+	return shim.Success(linkAsBytes)
+}
+
+// claimOfLegacyWarrantyClaim returns the claim of the claim ledger standing for the legacy claim. The last answer of the
+// builder becomes its response, a resolved claim is upheld
+func claimOfLegacyWarrantyClaim(APIstub shim.ChaincodeStubInterface, legacy LegacyWarrantyClaim) (Claim, error) {
+	status, known := legacyClaimStatuses[legacy.Status]
+	if !known {
+		return Claim{}, fmt.Errorf("Warranty claim %s has an unknown status %q", legacy.ID, legacy.Status)
+	}
+	var claim = Claim{
+		ID:          legacy.ID,
+		HouseKey:    legacy.HouseKey,
+		Basis:       claimWarranty,
+		Coverage:    legacy.Warranty,
+		LiableParty: legacy.Builder,
+		Description: legacy.Description,
+		Evidence:    []string{},
+		FiledBy:     legacy.FiledBy,
+		FiledAt:     legacy.FiledAt,
+		Status:      status,
+		Responses:   []ClaimResponse{},
+		Payouts:     []ClaimPayout{},
+	}
+	if legacy.UpdatedAt == "" {
+		return claim, nil
+	}
+	representative := legacy.Builder
+	if builder, err := getBuilder(APIstub, legacy.Builder); err == nil {
+		representative = builder.Representative
+	}
+	responseStatus := status
+	if status == claimAdjudicated {
+		responseStatus = claimAcknowledged
+		claim.Outcome, claim.AdjudicatedBy, claim.AdjudicatedAt = outcomeUpheld, representative, legacy.UpdatedAt
+	}
+	claim.Responses = append(claim.Responses, ClaimResponse{By: representative, Status: responseStatus, Note: legacy.Note, RespondedAt: legacy.UpdatedAt})
+	return claim, nil
+}
+
+/*
+ * migrateWarrantyClaims moves a chunk of the warranty claims filed before the claim ledger to it, for admins.
+ * Migrated claims keep their ID and are indexed by house and by builder
+ * args: maximum number of claims to migrate
+ */
+func (s *SmartContract) migrateWarrantyClaims(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, roleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(legacyWarrantyClaimObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// Migrated claims are deleted, every chunk starts from the first claim left
+	var result = struct {
+		Migrated  int  `json:"migrated"`
+		Remaining bool `json:"remaining"`
+	}{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if result.Migrated == pageSize {
+			result.Remaining = true
+			break
+		}
+		legacy := LegacyWarrantyClaim{}
+		if err := json.Unmarshal(queryResponse.Value, &legacy); err != nil {
+			return shim.Error(err.Error())
+		}
+		claim, err := claimOfLegacyWarrantyClaim(APIstub, legacy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err := putClaim(APIstub, claim); err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, index := range [][]string{{houseClaimIndex, claim.HouseKey}, {liablePartyClaimIndex, claim.LiableParty}} {
+			indexKey, err := APIstub.CreateCompositeKey(index[0], []string{index[1], claim.ID})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if err := APIstub.PutState(indexKey, []byte{0x00}); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		legacyIndexKey, err := APIstub.CreateCompositeKey(legacyBuilderClaimIndex, []string{legacy.Builder, legacy.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.DelState(legacyIndexKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := APIstub.DelState(queryResponse.Key); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
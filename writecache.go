@@ -0,0 +1,263 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Read-your-writes cache
+ * The peer simulates a transaction against the state of the last block: a key written by PutState
+ * or DelState still reads its previous value for the rest of the transaction. Composite operations
+ * reading back what an earlier step wrote, such as creating a house then placing a lien on it in
+ * the same transaction, would see stale state. Invoke wraps the stub in a write cache that passes
+ * writes through to the peer and overlays them on GetState, GetStateByRange and
+ * GetStateByPartialCompositeKey. Not covered, they only see the state of the last block: the
+ * paginated queries, which the peer only runs in read-only transactions anyway, the rich queries
+ * (GetQueryResult), the history of keys and private data.
+ * A simulating cache, used by simulate, keeps the writes and the events to itself. attempt runs a
+ * step of a transaction in a simulating cache, so that a failing step leaves no partial writes.
+ * The cache also holds the state of the transaction kept until it completes: the events emitted
+ * for the outboxes, the number of account entries posted and the batch operation being run.
+ */
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Define the write cache structure, the stub of an invocation with the writes of its transaction, nil for a deleted key.
+// While simulating, writes and events are only recorded
+type writeCacheStub struct {
+	shim.ChaincodeStubInterface
+	writes        map[string][]byte
+	simulating    bool
+	events        []emittedEvent
+	privateWrites map[string]bool
+	emitted       []emittedEvent
+	postedEntries int
+	function      string
+	operation     string
+}
+
+func newWriteCacheStub(APIstub shim.ChaincodeStubInterface) *writeCacheStub {
+	return &writeCacheStub{ChaincodeStubInterface: APIstub, writes: map[string][]byte{}}
+}
+
+// A simulation carries on the postings, the routed function and the batch operation of the transaction it runs in
+func newSimulationStub(APIstub shim.ChaincodeStubInterface) *writeCacheStub {
+	stub := &writeCacheStub{ChaincodeStubInterface: APIstub, writes: map[string][]byte{}, simulating: true, privateWrites: map[string]bool{}}
+	if parent := transactionOf(APIstub); parent != nil {
+		stub.postedEntries = parent.postedEntries
+		stub.function = parent.function
+		stub.operation = parent.operation
+	}
+	return stub
+}
+
+// routedFunction returns the function being run by route, which differs from the function of the proposal
+// for batches, simulations, named and versioned calls
+func routedFunction(APIstub shim.ChaincodeStubInterface) string {
+	if stub := transactionOf(APIstub); stub != nil && stub.function != "" {
+		return stub.function
+	}
+	function, _ := APIstub.GetFunctionAndParameters()
+	return function
+}
+
+// transactionOf returns the write cache holding the state of the transaction, nil for a stub without one
+func transactionOf(APIstub shim.ChaincodeStubInterface) *writeCacheStub {
+	stub, _ := APIstub.(*writeCacheStub)
+	return stub
+}
+
+func (stub *writeCacheStub) GetState(key string) ([]byte, error) {
+	if value, written := stub.writes[key]; written {
+		return value, nil
+	}
+	return stub.ChaincodeStubInterface.GetState(key)
+}
+
+func (stub *writeCacheStub) PutState(key string, value []byte) error {
+	if stub.simulating {
+		stub.writes[key] = append([]byte{}, value...)
+		return nil
+	}
+	if err := stub.ChaincodeStubInterface.PutState(key, value); err != nil {
+		return err
+	}
+	// The value is copied, the caller may reuse its buffer
+	stub.writes[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (stub *writeCacheStub) DelState(key string) error {
+	if !stub.simulating {
+		if err := stub.ChaincodeStubInterface.DelState(key); err != nil {
+			return err
+		}
+	}
+	stub.writes[key] = nil
+	return nil
+}
+
+func (stub *writeCacheStub) SetEvent(name string, payload []byte) error {
+	if stub.simulating {
+		stub.events = append(stub.events, emittedEvent{name: name, payload: payload})
+		return nil
+	}
+	return stub.ChaincodeStubInterface.SetEvent(name, payload)
+}
+
+// Private data writes are not cached, a simulation only records the keys written
+func (stub *writeCacheStub) PutPrivateData(collection string, key string, value []byte) error {
+	if stub.simulating {
+		stub.privateWrites[collection+"/"+key] = true
+		return nil
+	}
+	return stub.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (stub *writeCacheStub) DelPrivateData(collection string, key string) error {
+	if stub.simulating {
+		stub.privateWrites[collection+"/"+key] = true
+		return nil
+	}
+	return stub.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (stub *writeCacheStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	resultsIterator, err := stub.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return stub.overlay(resultsIterator, func(key string) bool {
+		// Range queries do not return composite keys
+		return !strings.HasPrefix(key, compositeKeyNamespace) && key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+
+func (stub *writeCacheStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := stub.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.overlay(resultsIterator, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// Namespace of the composite keys, the first character of their keys
+const compositeKeyNamespace = "\x00"
+
+// overlay returns the results of the iterator with the writes of the keys in the range replacing them, in key order.
+// The results are read into memory, the range and partial composite key queries of a transaction must stay bounded
+func (stub *writeCacheStub) overlay(resultsIterator shim.StateQueryIteratorInterface, inRange func(key string) bool) (shim.StateQueryIteratorInterface, error) {
+	defer resultsIterator.Close()
+
+	values := map[string]*queryresult.KV{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[queryResponse.Key] = queryResponse
+	}
+	for key, value := range stub.writes {
+		if !inRange(key) {
+			continue
+		}
+		if value == nil {
+			delete(values, key)
+		} else {
+			values[key] = &queryresult.KV{Key: key, Value: value}
+		}
+	}
+
+	results := []*queryresult.KV{}
+	for _, result := range values {
+		results = append(results, result)
+	}
+	// Keys are ordered as the peer orders them, byte by byte
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return &cachedIterator{results: results}, nil
+}
+
+// Define the cached iterator structure, the results of a query overlaid with the writes of the transaction
+type cachedIterator struct {
+	results []*queryresult.KV
+}
+
+func (iterator *cachedIterator) HasNext() bool {
+	return len(iterator.results) > 0
+}
+
+func (iterator *cachedIterator) Next() (*queryresult.KV, error) {
+	if len(iterator.results) == 0 {
+		return nil, fmt.Errorf("No more results")
+	}
+	result := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return result, nil
+}
+
+func (iterator *cachedIterator) Close() error {
+	return nil
+}
+
+// attempt runs the step against a simulating cache and applies its writes and events to the stub only when it succeeds
+func attempt(APIstub shim.ChaincodeStubInterface, step func(stub shim.ChaincodeStubInterface) error) error {
+	simulationStub := newSimulationStub(APIstub)
+	if err := step(simulationStub); err != nil {
+		return err
+	}
+	if len(simulationStub.privateWrites) > 0 {
+		// Private data values are not cached, they could not be applied
+		return fmt.Errorf("A step writing private data cannot be attempted")
+	}
+
+	var err error
+
+	for _, key := range sortedKeys(writtenKeys(simulationStub.writes)) {
+		if value := simulationStub.writes[key]; value == nil {
+			err = APIstub.DelState(key)
+		} else {
+			err = APIstub.PutState(key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, event := range simulationStub.events {
+		if err := APIstub.SetEvent(event.name, event.payload); err != nil {
+			return err
+		}
+	}
+	if parent := transactionOf(APIstub); parent != nil {
+		parent.emitted = append(parent.emitted, simulationStub.emitted...)
+		parent.postedEntries = simulationStub.postedEntries
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Read-your-writes cache tests
+ * The cache runs over a stub standing for the peer: its writes are recorded but not visible to its
+ * reads, which see the state of the last block as seeded before the transaction.
+ */
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// Define the block stub structure, a mock stub whose reads do not see the writes of the transaction
+type blockStub struct {
+	*shimtest.MockStub
+	written map[string][]byte
+}
+
+// newBlockStub returns a stub in a transaction, over the state of the last block
+func newBlockStub(t *testing.T, state map[string]string) *blockStub {
+	mockStub := shimtest.NewMockStub("fabhouse", new(SmartContract))
+	mockStub.MockTransactionStart("seed")
+	for key, value := range state {
+		if err := mockStub.PutState(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockStub.MockTransactionEnd("seed")
+	mockStub.MockTransactionStart("tx")
+	return &blockStub{MockStub: mockStub, written: map[string][]byte{}}
+}
+
+func (stub *blockStub) PutState(key string, value []byte) error {
+	stub.written[key] = value
+	return nil
+}
+
+func (stub *blockStub) DelState(key string) error {
+	stub.written[key] = nil
+	return nil
+}
+
+func compositeKey(t *testing.T, objectType string, attributes ...string) string {
+	key, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func expectState(t *testing.T, stub shim.ChaincodeStubInterface, key string, expected string) {
+	t.Helper()
+	value, err := stub.GetState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected == "" && value != nil {
+		t.Errorf("GetState(%q) = %q, expected no value", key, value)
+	} else if expected != "" && !bytes.Equal(value, []byte(expected)) {
+		t.Errorf("GetState(%q) = %q, expected %q", key, value, expected)
+	}
+}
+
+// expectResults checks the keys and values returned by the iterator, in order
+func expectResults(t *testing.T, resultsIterator shim.StateQueryIteratorInterface, expected ...string) {
+	t.Helper()
+	defer resultsIterator.Close()
+	results := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, queryResponse.Key, string(queryResponse.Value))
+	}
+	if !equalStrings(results, expected) {
+		t.Errorf("Results = %q, expected %q", results, expected)
+	}
+}
+
+func TestWriteCacheReadsItsWrites(t *testing.T) {
+	block := newBlockStub(t, map[string]string{"HOUSE1": "old"})
+	stub := newWriteCacheStub(block)
+
+	if err := stub.PutState("HOUSE1", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	buffer := []byte("other")
+	if err := stub.PutState("HOUSE2", buffer); err != nil {
+		t.Fatal(err)
+	}
+	// The caller reusing its buffer does not change the value written
+	copy(buffer, "xxxxx")
+
+	expectState(t, stub, "HOUSE1", "new")
+	expectState(t, stub, "HOUSE2", "other")
+	expectState(t, block, "HOUSE1", "old")
+	if string(block.written["HOUSE1"]) != "new" {
+		t.Errorf("Write of HOUSE1 was not passed to the peer")
+	}
+}
+
+func TestWriteCacheReadsItsDeletes(t *testing.T) {
+	block := newBlockStub(t, map[string]string{"HOUSE1": "old"})
+	stub := newWriteCacheStub(block)
+
+	if err := stub.DelState("HOUSE1"); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, stub, "HOUSE1", "")
+	if value, deleted := block.written["HOUSE1"]; !deleted || value != nil {
+		t.Errorf("Delete of HOUSE1 was not passed to the peer")
+	}
+
+	// A key written again after its deletion reads its new value
+	if err := stub.PutState("HOUSE1", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, stub, "HOUSE1", "again")
+}
+
+func TestWriteCacheOverlaysRanges(t *testing.T) {
+	block := newBlockStub(t, map[string]string{"HOUSE0": "zero", "HOUSE2": "two", "HOUSE4": "four", "OTHER": "other"})
+	stub := newWriteCacheStub(block)
+
+	for key, value := range map[string]string{"HOUSE1": "one", "HOUSE2": "deux", "HOUSE9": "nine", "OTHER1": "other"} {
+		if err := stub.PutState(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stub.DelState("HOUSE0"); err != nil {
+		t.Fatal(err)
+	}
+	// Composite keys are never returned by range queries
+	if err := stub.PutState(compositeKey(t, "lien", "HOUSE1", "order"), []byte("lien")); err != nil {
+		t.Fatal(err)
+	}
+
+	resultsIterator, err := stub.GetStateByRange("HOUSE0", "HOUSE9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, resultsIterator, "HOUSE1", "one", "HOUSE2", "deux", "HOUSE4", "four")
+
+	// The end key is excluded, the keys written after the last one of the block are returned
+	resultsIterator, err = stub.GetStateByRange("HOUSE4", "OTHER2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, resultsIterator, "HOUSE4", "four", "HOUSE9", "nine", "OTHER", "other", "OTHER1", "other")
+}
+
+func TestWriteCacheOverlaysPartialCompositeKeys(t *testing.T) {
+	lien1, lien2, lien3 := compositeKey(t, "lien", "HOUSE1", "a"), compositeKey(t, "lien", "HOUSE1", "b"), compositeKey(t, "lien", "HOUSE1", "c")
+	block := newBlockStub(t, map[string]string{lien1: "a", lien3: "c", compositeKey(t, "lien", "HOUSE2", "a"): "other house"})
+	stub := newWriteCacheStub(block)
+
+	if err := stub.PutState(lien2, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.DelState(lien3); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{compositeKey(t, "lien", "HOUSE10", "a"), compositeKey(t, "lienholder", "HOUSE1"), "HOUSE1"} {
+		if err := stub.PutState(key, []byte("out of the query")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("lien", []string{"HOUSE1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, resultsIterator, lien1, "a", lien2, "b")
+}
+
+func TestSimulationStubKeepsItsWrites(t *testing.T) {
+	block := newBlockStub(t, map[string]string{"HOUSE1": "old"})
+	stub := newSimulationStub(newWriteCacheStub(block))
+
+	if err := stub.PutState("HOUSE1", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.DelState("HOUSE2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.SetEvent("houseChanged", []byte("HOUSE1")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectState(t, stub, "HOUSE1", "new")
+	if len(block.written) != 0 {
+		t.Errorf("Simulation wrote %v to the peer", sortedKeys(writtenKeys(block.written)))
+	}
+	if len(stub.events) != 1 || stub.events[0].name != "houseChanged" {
+		t.Errorf("Events = %v, expected the houseChanged event", stub.events)
+	}
+}
+
+func TestAttemptAppliesOnlySucceedingSteps(t *testing.T) {
+	block := newBlockStub(t, map[string]string{"HOUSE1": "old"})
+	stub := newWriteCacheStub(block)
+
+	err := attempt(stub, func(step shim.ChaincodeStubInterface) error {
+		step.PutState("HOUSE1", []byte("failed"))
+		emitEvent(step, "houseChanged", []byte("failed"))
+		return fmt.Errorf("failure")
+	})
+	if err == nil || err.Error() != "failure" {
+		t.Fatalf("attempt = %v, expected the failure of the step", err)
+	}
+	expectState(t, stub, "HOUSE1", "old")
+	if len(block.written) != 0 || len(stub.emitted) != 0 {
+		t.Errorf("A failing step left writes %v and events %v", sortedKeys(writtenKeys(block.written)), stub.emitted)
+	}
+
+	err = attempt(stub, func(step shim.ChaincodeStubInterface) error {
+		emitEvent(step, "houseChanged", []byte("HOUSE1"))
+		return step.PutState("HOUSE1", []byte("new"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, stub, "HOUSE1", "new")
+	if string(block.written["HOUSE1"]) != "new" || len(stub.emitted) != 1 {
+		t.Errorf("A succeeding step was not applied: writes %v, events %v", sortedKeys(writtenKeys(block.written)), stub.emitted)
+	}
+}
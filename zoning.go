@@ -0,0 +1,274 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Zoning rules
+ * The planning authority maintains one rule per zone, listing the locations the zone lies in. A house
+ * belongs to its location, or to a zone given at creation, which must be one of the zones of its location.
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const zoningRuleObjectType = "zoningRule"
+
+// Define the zoning rule structure. A MaxSquareFeets of 0 and an empty AllowedUsages list mean no restriction
+type ZoningRule struct {
+	Zone           string   `json:"zone"`
+	MaxSquareFeets int      `json:"maxsquarefeets"`
+	AllowedUsages  []string `json:"allowedusages"`
+	Locations      []string `json:"locations,omitempty"`
+}
+
+// zoneOf returns the zone the house is subject to
+func zoneOf(house House) string {
+	if house.Zone != "" {
+		return house.Zone
+	}
+	return house.Location
+}
+
+// checkHouseZone returns an error unless the zone given to the house is one of the zones of its location,
+// so that a house cannot be placed in a zone with laxer rules than its own
+func checkHouseZone(APIstub shim.ChaincodeStubInterface, house House) error {
+	if house.Zone == "" || house.Zone == house.Location {
+		return nil
+	}
+	rule, err := getZoningRule(APIstub, house.Zone)
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		return fmt.Errorf("Zone %s is not defined", house.Zone)
+	}
+	location := normalizeLocation(house.Location)
+	for _, zoneLocation := range rule.Locations {
+		if normalizeLocation(zoneLocation) == location {
+			return nil
+		}
+	}
+	return fmt.Errorf("Zone %s does not lie in %s", house.Zone, house.Location)
+}
+
+func getZoningRule(APIstub shim.ChaincodeStubInterface, zone string) (*ZoningRule, error) {
+	ruleKey, err := APIstub.CreateCompositeKey(zoningRuleObjectType, []string{zone})
+	if err != nil {
+		return nil, err
+	}
+	ruleAsBytes, err := APIstub.GetState(ruleKey)
+	if err != nil {
+		return nil, err
+	}
+	if ruleAsBytes == nil {
+		return nil, nil
+	}
+
+	rule := ZoningRule{}
+	if err := json.Unmarshal(ruleAsBytes, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// validateZoning checks the house against the rule of its zone, if any
+func validateZoning(APIstub shim.ChaincodeStubInterface, house House) error {
+	rule, err := getZoningRule(APIstub, zoneOf(house))
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		return nil
+	}
+
+	if rule.MaxSquareFeets > 0 {
+		squareFeets, err := strconv.Atoi(house.SquareFeets)
+		if err != nil {
+			return fmt.Errorf("Square feets must be a number in zone %s", rule.Zone)
+		}
+		if squareFeets > rule.MaxSquareFeets {
+			return fmt.Errorf("Zone %s allows at most %d square feets, got %d", rule.Zone, rule.MaxSquareFeets, squareFeets)
+		}
+	}
+
+	if len(rule.AllowedUsages) > 0 {
+		for _, usage := range rule.AllowedUsages {
+			if strings.EqualFold(usage, house.Usage) {
+				return nil
+			}
+		}
+		return fmt.Errorf("Usage %q is not allowed in zone %s", house.Usage, rule.Zone)
+	}
+
+	return nil
+}
+
+/*
+ * setZoningRule creates or replaces the rule of a zone
+ * args: zone, maxSquareFeets (0 for no limit), allowed usages as a comma separated list (empty for any),
+ * [locations the zone lies in as a comma separated list]
+ */
+func (s *SmartContract) setZoningRule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	maxSquareFeets, err := strconv.Atoi(args[1])
+	if err != nil || maxSquareFeets < 0 {
+		return shim.Error("Max square feets must be a positive number")
+	}
+
+	var rule = ZoningRule{Zone: args[0], MaxSquareFeets: maxSquareFeets, AllowedUsages: splitList(args[2])}
+	if len(args) > 3 {
+		for _, location := range splitList(args[3]) {
+			canonical, err := resolveLocationAlias(APIstub, location)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			rule.Locations = append(rule.Locations, canonical)
+		}
+	}
+
+	ruleKey, err := APIstub.CreateCompositeKey(zoningRuleObjectType, []string{rule.Zone})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, _ := json.Marshal(rule)
+	if err := APIstub.PutState(ruleKey, ruleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) deleteZoningRule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if err := requireRole(APIstub, rolePlanner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ruleKey, err := APIstub.CreateCompositeKey(zoningRuleObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.DelState(ruleKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (s *SmartContract) queryZoningRule(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	rule, err := getZoningRule(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rule == nil {
+		return shim.Error("No zoning rule for zone " + args[0])
+	}
+
+	ruleAsBytes, _ := json.Marshal(rule)
+	return shim.Success(ruleAsBytes)
+}
+
+func (s *SmartContract) queryAllZoningRules(APIstub shim.ChaincodeStubInterface) sc.Response {
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(zoningRuleObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	rules := []ZoningRule{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		rule := ZoningRule{}
+		if err := json.Unmarshal(queryResponse.Value, &rule); err != nil {
+			return shim.Error(err.Error())
+		}
+		rules = append(rules, rule)
+	}
+
+	rulesAsBytes, _ := json.Marshal(rules)
+	return shim.Success(rulesAsBytes)
+}
+
+/*
+ * renovateHouse changes the surface and usage of a house, subject to the zoning rule of its zone and the ownership
+ * caps of its location, for the owner and the registrars of its location
+ * args: house key, new square feets, new usage
+ */
+func (s *SmartContract) renovateHouse(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	house, err := getHouse(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := requireOwner(APIstub, args[0], house); err != nil {
+		if checkLocationAuthority(APIstub, house.Location) != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := checkNotFrozen(APIstub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := parseSquareFeets(args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	house.SquareFeets = args[1]
+	house.Usage = args[2]
+
+	if err := validateZoning(APIstub, house); err != nil {
+		return shim.Error(err.Error())
+	}
+	// A larger surface counts against the square feet cap of every holder of the house
+	if err := checkOwnershipCaps(APIstub, args[0], house, houseShares(house)); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putHouse(APIstub, args[0], house); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
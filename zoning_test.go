@@ -0,0 +1,44 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+/* Zoning tests
+ * Zones given to houses, checked against the zones the planners defined for their location.
+ */
+import (
+	"strings"
+	"testing"
+)
+
+func TestHouseZoneMustLieInItsLocation(t *testing.T) {
+	ledger := newMockLedger(t)
+	ledger.assignRoles(t, ledger.owner, rolePlanner)
+	ledger.invoke(t, ledger.owner, "setZoningRule", "Paris", "100", "")
+	ledger.invoke(t, ledger.owner, "setZoningRule", "LYON-INDUSTRIAL", "0", "", "Lyon")
+
+	for _, zone := range []string{"UNDEFINED", "LYON-INDUSTRIAL"} {
+		if message := ledger.refuse(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice", "residential", zone); !strings.Contains(message, "Zone "+zone) {
+			t.Errorf("createHouse in zone %s failed with %q, expected the zone to be refused", zone, message)
+		}
+	}
+
+	ledger.invoke(t, ledger.owner, "setZoningRule", "PARIS-INDUSTRIAL", "0", "", "Lyon,paris")
+	ledger.invoke(t, ledger.owner, "createHouse", "HOUSE1", "2004", "1200", "Paris", "alice", "residential", "PARIS-INDUSTRIAL")
+}